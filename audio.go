@@ -0,0 +1,129 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// descriptorTypeCSEndpoint is the type of class-specific endpoint
+	// descriptors, CS_ENDPOINT.
+	descriptorTypeCSEndpoint = 0x25
+	// audioEPGeneral is the subtype of the class-specific AS isochronous
+	// audio data endpoint descriptor, EP_GENERAL.
+	audioEPGeneral = 0x01
+)
+
+// LockDelayUnits is the unit of AudioEndpointDesc.LockDelay.
+type LockDelayUnits uint8
+
+// Lock delay units defined by the USB Audio Class specifications.
+const (
+	LockDelayUndefined    LockDelayUnits = 0
+	LockDelayMilliseconds LockDelayUnits = 1
+	LockDelaySamples      LockDelayUnits = 2
+)
+
+var lockDelayUnitsDescription = map[LockDelayUnits]string{
+	LockDelayUndefined:    "undefined",
+	LockDelayMilliseconds: "ms",
+	LockDelaySamples:      "samples",
+}
+
+// String returns a human-readable lock delay unit.
+func (u LockDelayUnits) String() string {
+	if s, ok := lockDelayUnitsDescription[u]; ok {
+		return s
+	}
+	return fmt.Sprintf("reserved (%d)", uint8(u))
+}
+
+// AudioEndpointDesc is the class-specific AS isochronous audio data
+// endpoint descriptor of a USB Audio Class (UAC) streaming endpoint, found
+// in the extra bytes of the endpoint descriptor, see
+// EndpointDesc.AudioEndpoint.
+type AudioEndpointDesc struct {
+	// Version is the major version of the Audio Class the descriptor
+	// conforms to, 1 or 2, told apart by the descriptor length.
+	Version int
+	// SamplingFrequencyControl is true if the sampling frequency can be set
+	// through the endpoint, with SET_CUR on SAMPLING_FREQ_CONTROL. Only
+	// UAC 1.0 endpoints have this control, UAC 2.0 devices set the sampling
+	// frequency through their clock entities.
+	SamplingFrequencyControl bool
+	// PitchControl is true if the endpoint supports the pitch control.
+	PitchControl bool
+	// MaxPacketsOnly is true if the endpoint must always be sent packets
+	// of MaxPacketSize bytes, padded if needed.
+	MaxPacketsOnly bool
+	// Controls is the raw bmControls field of UAC 2.0 descriptors, 2 bits
+	// per control: pitch, data overrun and data underrun. 0 for UAC 1.0.
+	Controls uint8
+	// LockDelayUnits and LockDelay are the time the endpoint needs to lock
+	// its internal clock recovery circuitry.
+	LockDelayUnits LockDelayUnits
+	LockDelay      uint16
+}
+
+// parseAudioEndpoint parses an AS isochronous audio data endpoint
+// descriptor, b is the entire descriptor.
+func parseAudioEndpoint(b []byte) (*AudioEndpointDesc, error) {
+	if len(b) < 4 || b[1] != descriptorTypeCSEndpoint || b[2] != audioEPGeneral {
+		return nil, fmt.Errorf("not an AS isochronous audio data endpoint descriptor: % x", b)
+	}
+	switch len(b) {
+	case 7:
+		return &AudioEndpointDesc{
+			Version:                  1,
+			SamplingFrequencyControl: b[3]&0x01 != 0,
+			PitchControl:             b[3]&0x02 != 0,
+			MaxPacketsOnly:           b[3]&0x80 != 0,
+			LockDelayUnits:           LockDelayUnits(b[4]),
+			LockDelay:                binary.LittleEndian.Uint16(b[5:7]),
+		}, nil
+	case 8:
+		return &AudioEndpointDesc{
+			Version:        2,
+			PitchControl:   b[4]&0x03 != 0,
+			MaxPacketsOnly: b[3]&0x80 != 0,
+			Controls:       b[4],
+			LockDelayUnits: LockDelayUnits(b[5]),
+			LockDelay:      binary.LittleEndian.Uint16(b[6:8]),
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid AS isochronous audio data endpoint descriptor of %d bytes, want 7 (UAC 1.0) or 8 (UAC 2.0) bytes", len(b))
+}
+
+// AudioEndpoint returns the class-specific AS isochronous audio data
+// endpoint descriptor of a USB Audio Class streaming endpoint, parsed from
+// Extra. It returns an error if the endpoint has no such descriptor, which
+// is the case of feedback endpoints and of endpoints of other classes.
+// The bRefresh and bSynchAddress fields of UAC 1.0 endpoints are part of
+// the standard endpoint descriptor, see EndpointDesc.Refresh.
+func (e EndpointDesc) AudioEndpoint() (*AudioEndpointDesc, error) {
+	descs, err := splitDescriptors(e.Extra)
+	if err != nil {
+		return nil, fmt.Errorf("extra descriptors of %s: %w", e, err)
+	}
+	for _, d := range descs {
+		if len(d) >= 3 && d[1] == descriptorTypeCSEndpoint && d[2] == audioEPGeneral {
+			return parseAudioEndpoint(d)
+		}
+	}
+	return nil, fmt.Errorf("%s has no class-specific audio endpoint descriptor", e)
+}
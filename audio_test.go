@@ -0,0 +1,92 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEndpointDescAudioEndpoint(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc  string
+		extra []byte
+		want  *AudioEndpointDesc
+	}{
+		{
+			// 48kHz speaker, adaptive, with a sampling frequency control.
+			desc:  "UAC 1.0",
+			extra: []byte{0x07, 0x25, 0x01, 0x01, 0x02, 0x02, 0x00},
+			want: &AudioEndpointDesc{
+				Version:                  1,
+				SamplingFrequencyControl: true,
+				LockDelayUnits:           LockDelaySamples,
+				LockDelay:                2,
+			},
+		},
+		{
+			// Asynchronous capture endpoint, padded packets, read-only
+			// data overrun control.
+			desc:  "UAC 2.0",
+			extra: []byte{0x08, 0x25, 0x01, 0x80, 0x04, 0x01, 0x08, 0x00},
+			want: &AudioEndpointDesc{
+				Version:        2,
+				MaxPacketsOnly: true,
+				Controls:       0x04,
+				LockDelayUnits: LockDelayMilliseconds,
+				LockDelay:      8,
+			},
+		},
+		{
+			desc:  "after a vendor descriptor",
+			extra: []byte{0x03, 0xff, 0x00, 0x07, 0x25, 0x01, 0x02, 0x00, 0x00, 0x00},
+			want:  &AudioEndpointDesc{Version: 1, PitchControl: true},
+		},
+	} {
+		ep := EndpointDesc{Address: 0x01, TransferType: TransferTypeIsochronous, MaxPacketSize: 192, Extra: tc.extra}
+		got, err := ep.AudioEndpoint()
+		if err != nil {
+			t.Errorf("%s: AudioEndpoint(): %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: AudioEndpoint(): got %+v, want %+v", tc.desc, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		desc  string
+		extra []byte
+	}{
+		{"no extra descriptors", nil},
+		{"other class-specific descriptor", []byte{0x05, 0x25, 0x02, 0x00, 0x00}},
+		{"truncated", []byte{0x07, 0x25, 0x01, 0x01}},
+		{"bad length", []byte{0x05, 0x25, 0x01, 0x01, 0x00}},
+	} {
+		ep := EndpointDesc{Address: 0x01, TransferType: TransferTypeIsochronous, Extra: tc.extra}
+		if got, err := ep.AudioEndpoint(); err == nil {
+			t.Errorf("%s: AudioEndpoint(): got %+v, want error", tc.desc, got)
+		}
+	}
+
+	if got, want := LockDelayMilliseconds.String(), "ms"; got != want {
+		t.Errorf("LockDelayMilliseconds.String(): got %q, want %q", got, want)
+	}
+	if got, want := LockDelayUnits(7).String(), "reserved (7)"; got != want {
+		t.Errorf("LockDelayUnits(7).String(): got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// bosHeaderLength is the length of the BOS descriptor header, which
+	// is followed by the device capability descriptors.
+	bosHeaderLength = 5
+	// devCapabilityPlatform is the bDevCapabilityType of a platform
+	// capability descriptor.
+	devCapabilityPlatform = 0x05
+	// platformCapabilityHeaderLength is the length of a platform capability
+	// descriptor up to and including its UUID.
+	platformCapabilityHeaderLength = 20
+)
+
+// UUID is a 128-bit universally unique identifier, stored in the byte
+// order used on the wire by USB descriptors: the first three fields are
+// little-endian, as in a Microsoft GUID.
+type UUID [16]byte
+
+// String returns the canonical textual representation of the UUID, e.g.
+// "3408b638-09a9-47a0-8bfd-a0768815b665".
+func (u UUID) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(u[0:4]),
+		binary.LittleEndian.Uint16(u[4:6]),
+		binary.LittleEndian.Uint16(u[6:8]),
+		u[8:10], u[10:16])
+}
+
+// Well-known platform capability UUIDs.
+var (
+	// PlatformUUIDWebUSB identifies the WebUSB platform capability,
+	// {3408b638-09a9-47a0-8bfd-a0768815b665}.
+	PlatformUUIDWebUSB = UUID{0x38, 0xb6, 0x08, 0x34, 0xa9, 0x09, 0xa0, 0x47, 0x8b, 0xfd, 0xa0, 0x76, 0x88, 0x15, 0xb6, 0x65}
+	// PlatformUUIDMSOS20 identifies the Microsoft OS 2.0 descriptors
+	// platform capability, {d8dd60df-4589-4cc7-9cd2-659d9e648a9f}.
+	PlatformUUIDMSOS20 = UUID{0xdf, 0x60, 0xdd, 0xd8, 0x89, 0x45, 0xc7, 0x4c, 0x9c, 0xd2, 0x65, 0x9d, 0x9e, 0x64, 0x8a, 0x9f}
+)
+
+// PlatformCapability is a platform capability descriptor from the BOS
+// descriptor of a device. Platforms like WebUSB and Microsoft OS 2.0 use
+// it to announce their own, vendor-specific descriptors.
+type PlatformCapability struct {
+	// UUID identifies the platform, e.g. PlatformUUIDWebUSB.
+	UUID UUID
+	// Data is the platform-specific payload that follows the UUID.
+	Data []byte
+}
+
+// String returns a human-readable description of the capability.
+func (p PlatformCapability) String() string {
+	return fmt.Sprintf("platform capability %s (% x)", p.UUID, p.Data)
+}
+
+// GetBOSDescriptor reads the raw Binary Object Store (BOS) descriptor of
+// the device, including all of its device capability descriptors. The BOS
+// descriptor was introduced by USB 2.1, devices implementing older
+// specifications usually stall the request, GetBOSDescriptor returns an
+// error wrapping ErrorPipe then.
+func (d *Device) GetBOSDescriptor() ([]byte, error) {
+	hdr := make([]byte, bosHeaderLength)
+	n, err := d.getDescriptor(DescriptorTypeBOS, 0, hdr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the BOS descriptor of %s: %w", d, err)
+	}
+	if n < bosHeaderLength || DescriptorType(hdr[1]) != DescriptorTypeBOS {
+		return nil, fmt.Errorf("device %s returned an invalid BOS descriptor header % x", d, hdr[:n])
+	}
+	l := int(binary.LittleEndian.Uint16(hdr[2:]))
+	if l < bosHeaderLength {
+		return nil, fmt.Errorf("device %s returned an invalid BOS descriptor length %d", d, l)
+	}
+	buf := make([]byte, l)
+	if n, err = d.getDescriptor(DescriptorTypeBOS, 0, buf); err != nil {
+		return nil, fmt.Errorf("failed to read the BOS descriptor of %s: %w", d, err)
+	}
+	return buf[:n], nil
+}
+
+// ParsePlatformCapabilities returns the platform capability descriptors
+// found in bos, a BOS descriptor as returned by GetBOSDescriptor, in the
+// order they appear. Other device capabilities are skipped. The Data of the
+// returned capabilities doesn't alias bos.
+func ParsePlatformCapabilities(bos []byte) ([]PlatformCapability, error) {
+	if len(bos) < bosHeaderLength || DescriptorType(bos[1]) != DescriptorTypeBOS {
+		return nil, fmt.Errorf("not a BOS descriptor: % x", bos)
+	}
+	l := int(binary.LittleEndian.Uint16(bos[2:]))
+	if l < bosHeaderLength || l > len(bos) {
+		return nil, fmt.Errorf("BOS descriptor length %d out of range, got %d bytes", l, len(bos))
+	}
+	descs, err := splitDescriptors(bos[bosHeaderLength:l])
+	if err != nil {
+		return nil, fmt.Errorf("malformed BOS descriptor: %v", err)
+	}
+	var ret []PlatformCapability
+	for _, d := range descs {
+		if len(d) < 3 || DescriptorType(d[1]) != DescriptorTypeDeviceCapability || d[2] != devCapabilityPlatform {
+			continue
+		}
+		if len(d) < platformCapabilityHeaderLength {
+			return nil, fmt.Errorf("platform capability descriptor too short: % x", d)
+		}
+		var p PlatformCapability
+		copy(p.UUID[:], d[4:platformCapabilityHeaderLength])
+		p.Data = append([]byte(nil), d[platformCapabilityHeaderLength:]...)
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// PlatformCapabilities reads the BOS descriptor of the device and returns
+// its platform capabilities, see GetBOSDescriptor and
+// ParsePlatformCapabilities.
+func (d *Device) PlatformCapabilities() ([]PlatformCapability, error) {
+	bos, err := d.GetBOSDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	caps, err := ParsePlatformCapabilities(bos)
+	if err != nil {
+		return nil, fmt.Errorf("device %s: %v", d, err)
+	}
+	return caps, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testBOS is a BOS descriptor with a USB 2.0 extension capability and a
+// WebUSB platform capability: bcdVersion 1.00, bVendorCode 0x01,
+// iLandingPage 1.
+var testBOS = []byte{
+	// BOS header: wTotalLength 36, 2 capabilities.
+	0x05, 0x0f, 0x24, 0x00, 0x02,
+	// USB 2.0 extension, LPM supported.
+	0x07, 0x10, 0x02, 0x02, 0x00, 0x00, 0x00,
+	// WebUSB platform capability.
+	0x18, 0x10, 0x05, 0x00,
+	0x38, 0xb6, 0x08, 0x34, 0xa9, 0x09, 0xa0, 0x47, 0x8b, 0xfd, 0xa0, 0x76, 0x88, 0x15, 0xb6, 0x65,
+	0x00, 0x01, 0x01, 0x01,
+}
+
+func TestParsePlatformCapabilities(t *testing.T) {
+	got, err := ParsePlatformCapabilities(testBOS)
+	if err != nil {
+		t.Fatalf("ParsePlatformCapabilities(): %v", err)
+	}
+	want := []PlatformCapability{{UUID: PlatformUUIDWebUSB, Data: []byte{0x00, 0x01, 0x01, 0x01}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePlatformCapabilities(): got %v, want %v", got, want)
+	}
+	if got, want := PlatformUUIDWebUSB.String(), "3408b638-09a9-47a0-8bfd-a0768815b665"; got != want {
+		t.Errorf("PlatformUUIDWebUSB.String(): got %q, want %q", got, want)
+	}
+	if got, want := PlatformUUIDMSOS20.String(), "d8dd60df-4589-4cc7-9cd2-659d9e648a9f"; got != want {
+		t.Errorf("PlatformUUIDMSOS20.String(): got %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		bos  []byte
+	}{
+		{"empty", nil},
+		{"not a BOS descriptor", []byte{0x05, 0x02, 0x05, 0x00, 0x00}},
+		{"total length past the end", append([]byte{0x05, 0x0f, 0x30, 0x00, 0x02}, testBOS[5:]...)},
+		{"truncated capability", []byte{0x05, 0x0f, 0x0a, 0x00, 0x01, 0x08, 0x10, 0x05, 0x00, 0x38}},
+		{"short platform capability", []byte{0x05, 0x0f, 0x0a, 0x00, 0x01, 0x05, 0x10, 0x05, 0x00, 0x38}},
+	} {
+		if got, err := ParsePlatformCapabilities(tc.bos); err == nil {
+			t.Errorf("%s: ParsePlatformCapabilities(% x): got %v, want error", tc.desc, tc.bos, got)
+		}
+	}
+}
+
+func TestDevicePlatformCapabilities(t *testing.T) {
+	t.Parallel()
+	noBOS := false
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		if !noBOS && req.rType == 0x80 && req.request == 0x06 && req.val == 0x0f00 {
+			return copy(data, testBOS), nil
+		}
+		return 0, ErrorPipe
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	bos, err := dev.GetBOSDescriptor()
+	if err != nil || !bytes.Equal(bos, testBOS) {
+		t.Errorf("%s.GetBOSDescriptor(): got % x, %v, want % x, nil", dev, bos, err, testBOS)
+	}
+	wantReqs := []controlRequest{
+		// the header first, then the entire descriptor.
+		{0x80, 0x06, 0x0f00, 0, make([]byte, 5)},
+		{0x80, 0x06, 0x0f00, 0, make([]byte, len(testBOS))},
+	}
+	if reqs := lib.requests(); !reflect.DeepEqual(reqs, wantReqs) {
+		t.Errorf("control requests: got %v, want %v", reqs, wantReqs)
+	}
+	caps, err := dev.PlatformCapabilities()
+	if err != nil {
+		t.Fatalf("%s.PlatformCapabilities(): %v", dev, err)
+	}
+	if len(caps) != 1 || caps[0].UUID != PlatformUUIDWebUSB {
+		t.Errorf("%s.PlatformCapabilities(): got %v, want a single WebUSB capability", dev, caps)
+	}
+
+	noBOS = true
+	if _, err := dev.PlatformCapabilities(); !errors.Is(err, ErrorPipe) {
+		t.Errorf("%s.PlatformCapabilities() of a device without BOS: got error %v, want %v", dev, err, ErrorPipe)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "fmt"
+
+// BufferProvider supplies the buffers of the transfers of a stream created
+// with WithBufferProvider, instead of gousb allocating them. This allows the
+// transfers to read into, or write from, memory owned by the application,
+// e.g. a memory mapped file, see MmapBuffers.
+//
+// libusb accesses the buffers while the transfers are in flight, outside
+// of the control of the Go runtime. The memory of the buffers must
+// therefore not be managed by the Go runtime: it must not be allocated
+// with make or new, but e.g. with mmap or C.malloc.
+type BufferProvider interface {
+	// Buffer returns a buffer of at least size bytes for a new transfer.
+	// Only the first size bytes are used. The buffer is owned by the
+	// transfer until passed to Release.
+	Buffer(size int) ([]byte, error)
+	// Release is called when the transfer using buf is freed, i.e. when
+	// the stream is closed, or when SetDepth shrinks the stream.
+	Release(buf []byte)
+}
+
+// WithBufferProvider makes the stream use buffers supplied by p for all of
+// its transfers, including the transfers added later by SetDepth.
+// WithBufferProvider takes precedence over WithContiguousBuffers.
+func WithBufferProvider(p BufferProvider) StreamOption {
+	return func(o *streamOptions) {
+		o.bufferProvider = p
+	}
+}
+
+// providedBuffer returns a sharedBuffer holding a buffer of size bytes from
+// p, which is returned to p when the buffer is released.
+func providedBuffer(p BufferProvider, size int) (*sharedBuffer, error) {
+	buf, err := p.Buffer(size)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < size {
+		p.Release(buf)
+		return nil, fmt.Errorf("buffer provider returned a buffer of %d bytes, want at least %d bytes", len(buf), size)
+	}
+	return &sharedBuffer{mem: buf, freeMem: p.Release, users: 1}, nil
+}
+
+// newProvidedTransfer allocates a transfer of the endpoint with a buffer
+// of size bytes from p.
+func (e *endpoint) newProvidedTransfer(p BufferProvider, size int) (*usbTransfer, error) {
+	shared, err := providedBuffer(p, size)
+	if err != nil {
+		return nil, err
+	}
+	// the transfer holds its own reference.
+	defer shared.release()
+	return e.newUSBTransferWithBuffer(size, shared, 0)
+}
@@ -0,0 +1,106 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"sync"
+	"testing"
+)
+
+// sliceProvider is a BufferProvider handing out buffers of a fixed size,
+// recording which of them are in use. Buffers from the Go heap are fine
+// for the fake libusb.
+type sliceProvider struct {
+	size int
+
+	mu       sync.Mutex
+	inUse    map[*byte]bool
+	given    int
+	released int
+}
+
+func (p *sliceProvider) Buffer(size int) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	buf := make([]byte, p.size)
+	p.inUse[&buf[0]] = true
+	p.given++
+	return buf, nil
+}
+
+func (p *sliceProvider) Release(buf []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.inUse, &buf[0])
+	p.released++
+}
+
+func (p *sliceProvider) owns(buf []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inUse[&buf[0]]
+}
+
+func (p *sliceProvider) counts() (given, released int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.given, p.released
+}
+
+func TestStreamWithBufferProvider(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+	if _, err := ep.NewStream(512, 2, WithBufferProvider(&sliceProvider{size: 256, inUse: map[*byte]bool{}})); err == nil {
+		t.Error("NewStream() with a provider of short buffers: got nil error, want non-nil")
+	}
+
+	p := &sliceProvider{size: 512, inUse: map[*byte]bool{}}
+	rs, err := ep.NewStream(512, 2, WithBufferProvider(p), WithContiguousBuffers())
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	if err := rs.SetDepth(3); err != nil {
+		t.Fatalf("SetDepth(3): %v", err)
+	}
+	var fts []*fakeTransfer
+	for i := 0; i < 3; i++ {
+		ft := lib.waitForSubmitted(nil)
+		if !p.owns(ft.buf) {
+			t.Errorf("transfer %d doesn't use a buffer of the provider", i)
+		}
+		fts = append(fts, ft)
+	}
+	if given, released := p.counts(); given != 3 || released != 0 {
+		t.Errorf("provider buffers with 3 transfers: got %d given, %d released, want 3, 0", given, released)
+	}
+
+	rs.Close()
+	fts[0].setStatus(TransferCancelled)
+	if _, err := rs.Read(make([]byte, 512)); err == nil {
+		t.Error("Read() of a cancelled transfer: got nil error, want non-nil")
+	}
+	if given, released := p.counts(); given != 3 || released != 3 {
+		t.Errorf("provider buffers after the stream was drained: got %d given, %d released, want 3, 3", given, released)
+	}
+}
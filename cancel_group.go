@@ -0,0 +1,105 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ErrGroupCancelled is returned by the transfers of a CancelGroup after
+// CancelAll was called. It wraps TransferCancelled.
+var ErrGroupCancelled = fmt.Errorf("transfer group cancelled: %w", TransferCancelled)
+
+// CancelGroup cancels a group of transfers together, e.g. all transfers of
+// all endpoints of a device during a coordinated shutdown. Transfers join
+// a group explicitly with Add, or implicitly when allocated on an endpoint
+// of the group, see SetCancelGroup.
+// A cancelled group stays cancelled: in-flight transfers of the group
+// finish with ErrGroupCancelled, and submitting a transfer of the group
+// afterwards fails with ErrGroupCancelled.
+type CancelGroup struct {
+	mu        sync.Mutex
+	cancelled bool
+	xfers     map[*usbTransfer]bool
+}
+
+// NewCancelGroup returns a new, empty CancelGroup.
+func NewCancelGroup() *CancelGroup {
+	return &CancelGroup{xfers: make(map[*usbTransfer]bool)}
+}
+
+// Add adds a transfer to the group. A transfer can belong to a single
+// group, adding it to another group moves it. Add blocks while the
+// transfer is in flight.
+func (g *CancelGroup) Add(t *Transfer) {
+	t.t.mu.Lock()
+	defer t.t.mu.Unlock()
+	if t.t.xfer == nil {
+		return
+	}
+	if old := t.t.group; old != nil {
+		old.remove(t.t)
+	}
+	g.add(t.t)
+}
+
+// CancelAll cancels all the transfers of the group that are in flight and
+// marks the group as cancelled. CancelAll doesn't wait for the transfers to
+// finish, the owners of the transfers still have to wait for them.
+func (g *CancelGroup) CancelAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cancelled = true
+	for t := range g.xfers {
+		t.cancel()
+	}
+}
+
+// Cancelled returns true if CancelAll was called.
+func (g *CancelGroup) Cancelled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cancelled
+}
+
+func (g *CancelGroup) add(t *usbTransfer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t.group = g
+	g.xfers[t] = true
+}
+
+func (g *CancelGroup) remove(t *usbTransfer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.xfers, t)
+}
+
+// SetCancelGroup adds all transfers allocated afterwards on the endpoint,
+// including the transfers of its streams, to the group g.
+// Passing nil stops adding new transfers to a group.
+func (e *endpoint) SetCancelGroup(g *CancelGroup) {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	e.group = g
+}
+
+func (e *endpoint) cancelGroup() *CancelGroup {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	return e.group
+}
@@ -0,0 +1,104 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCancelGroup(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	g := NewCancelGroup()
+	grouped := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	grouped.SetCancelGroup(g)
+	other := &OutEndpoint{newNullEndpoint(ctx, EndpointDirectionOut)}
+
+	var inGroup []*Transfer
+	for i := 0; i < 3; i++ {
+		xfer, err := grouped.NewTransfer(512)
+		if err != nil {
+			t.Fatalf("NewTransfer(): %v", err)
+		}
+		defer xfer.Free()
+		inGroup = append(inGroup, xfer)
+	}
+	added, err := other.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("NewTransfer(): %v", err)
+	}
+	defer added.Free()
+	g.Add(added)
+	inGroup = append(inGroup, added)
+	outside, err := other.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("NewTransfer(): %v", err)
+	}
+	defer outside.Free()
+
+	for _, xfer := range append(inGroup, outside) {
+		if err := xfer.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+	}
+	// Submit is synchronous, the transfer outside of the group is the last.
+	var outsideFt *fakeTransfer
+	for i := 0; i < len(inGroup)+1; i++ {
+		outsideFt = lib.waitForSubmitted(nil)
+	}
+
+	if g.Cancelled() {
+		t.Errorf("Cancelled() before CancelAll: got true, want false")
+	}
+	g.CancelAll()
+	if !g.Cancelled() {
+		t.Errorf("Cancelled() after CancelAll: got false, want true")
+	}
+	for i, xfer := range inGroup {
+		_, err := xfer.Wait(context.Background())
+		if !errors.Is(err, ErrGroupCancelled) || !errors.Is(err, TransferCancelled) {
+			t.Errorf("transfer #%d: Wait() after CancelAll: got error %v, want %v", i, err, ErrGroupCancelled)
+		}
+	}
+	if !outside.InFlight() {
+		t.Errorf("transfer outside of the group: InFlight() after CancelAll: got false, want true")
+	}
+	outsideFt.setStatus(TransferCompleted)
+	if _, err := outside.Wait(context.Background()); err != nil {
+		t.Errorf("transfer outside of the group: Wait(): %v", err)
+	}
+
+	for i, xfer := range inGroup {
+		if err := xfer.Submit(); !errors.Is(err, ErrGroupCancelled) {
+			t.Errorf("transfer #%d: Submit() after CancelAll: got error %v, want %v", i, err, ErrGroupCancelled)
+		}
+	}
+	if err := outside.Submit(); err != nil {
+		t.Errorf("transfer outside of the group: Submit() after CancelAll: %v", err)
+	}
+	lib.waitForSubmitted(nil).setStatus(TransferCompleted)
+	if _, err := outside.Wait(context.Background()); err != nil {
+		t.Errorf("transfer outside of the group: Wait(): %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// CommandOption configures a request sent with Device.Command.
+type CommandOption func(*commandOptions)
+
+type commandOptions struct {
+	writeTimeout time.Duration
+	readTimeout  time.Duration
+}
+
+// WithCommandWriteTimeout sets the timeout of writing the request of a
+// command. 0, the default, means no timeout.
+func WithCommandWriteTimeout(timeout time.Duration) CommandOption {
+	return func(o *commandOptions) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithCommandReadTimeout sets the timeout of reading the response of a
+// command, measured from the end of the write. 0, the default, means no
+// timeout.
+func WithCommandReadTimeout(timeout time.Duration) CommandOption {
+	return func(o *commandOptions) {
+		o.readTimeout = timeout
+	}
+}
+
+// Command sends a request to the device and reads its response, for
+// devices implementing a command/response protocol over a pair of bulk
+// endpoints. See CommandContext.
+func (d *Device) Command(out *OutEndpoint, in *InEndpoint, request []byte, maxResponse int, opts ...CommandOption) ([]byte, error) {
+	return d.CommandContext(context.Background(), out, in, request, maxResponse, opts...)
+}
+
+// CommandContext writes request to the out endpoint in a single transfer,
+// then reads the response of at most maxResponse bytes from the in
+// endpoint in a single transfer and returns it. The response ends with
+// the first short packet, so it may be shorter than maxResponse. To avoid
+// overflows, maxResponse should be a multiple of the max packet size of
+// the in endpoint, see InEndpoint.Read. Both endpoints must belong to an
+// interface of d.
+// A request that is not entirely written returns an error wrapping
+// io.ErrShortWrite, the response is not read then. A timed out transfer,
+// see WithCommandWriteTimeout and WithCommandReadTimeout, returns an error
+// wrapping TransferTimedOut, together with the part of the response read
+// until then. Cancelling ctx aborts the command.
+func (d *Device) CommandContext(ctx context.Context, out *OutEndpoint, in *InEndpoint, request []byte, maxResponse int, opts ...CommandOption) ([]byte, error) {
+	if d.handle == nil {
+		return nil, fmt.Errorf("Command() called on %s after Close", d)
+	}
+	if out.dev != &d.state || in.dev != &d.state {
+		return nil, fmt.Errorf("Command() called on %s with endpoints %s and %s of another device", d, out, in)
+	}
+	var o commandOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	n, err := out.transferOnceTimeout(ctx, request, o.writeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the request of a command to %s: %w", out, err)
+	}
+	if n < len(request) {
+		return nil, fmt.Errorf("failed to write the request of a command to %s, wrote %d of %d bytes: %w", out, n, len(request), io.ErrShortWrite)
+	}
+	if maxResponse <= 0 {
+		return nil, nil
+	}
+	buf := make([]byte, maxResponse)
+	n, err = in.transferOnceTimeout(ctx, buf, o.readTimeout)
+	if err != nil {
+		return buf[:n], fmt.Errorf("failed to read the response of a command from %s: %w", in, err)
+	}
+	return buf[:n], nil
+}
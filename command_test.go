@@ -0,0 +1,137 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDeviceCommand(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer done()
+	out, err := intf.OutEndpoint(1)
+	if err != nil {
+		t.Fatalf("%s.OutEndpoint(1): %v", intf, err)
+	}
+	in, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+
+	request := []byte("STATUS?")
+	for _, tc := range []struct {
+		desc        string
+		written     int
+		response    []byte
+		readStatus  TransferStatus
+		want        []byte
+		wantErr     error
+		wantReadReq bool
+	}{
+		{
+			desc:        "short response",
+			written:     len(request),
+			response:    []byte("OK 42"),
+			readStatus:  TransferCompleted,
+			want:        []byte("OK 42"),
+			wantReadReq: true,
+		},
+		{
+			desc:    "short write",
+			written: 3,
+			wantErr: io.ErrShortWrite,
+		},
+		{
+			desc:        "response timeout",
+			written:     len(request),
+			response:    []byte("PART"),
+			readStatus:  TransferTimedOut,
+			want:        []byte("PART"),
+			wantErr:     TransferTimedOut,
+			wantReadReq: true,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			type result struct {
+				req                       []byte
+				writeTimeout, readTimeout time.Duration
+			}
+			results := make(chan result, 1)
+			go func() {
+				var r result
+				ft := lib.waitForSubmitted(nil)
+				ft.mu.Lock()
+				r.req = append([]byte(nil), ft.buf...)
+				r.writeTimeout = ft.timeout
+				ft.mu.Unlock()
+				ft.setLength(tc.written)
+				ft.setStatus(TransferCompleted)
+				if tc.wantReadReq {
+					ft = lib.waitForSubmitted(nil)
+					ft.mu.Lock()
+					r.readTimeout = ft.timeout
+					ft.mu.Unlock()
+					ft.setData(tc.response)
+					ft.setStatus(tc.readStatus)
+				}
+				results <- r
+			}()
+
+			got, err := dev.Command(out, in, request, 512, WithCommandWriteTimeout(time.Second), WithCommandReadTimeout(2*time.Second))
+			r := <-results
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Command(): got error %v, want %v", err, tc.wantErr)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("Command(): got response %q, want %q", got, tc.want)
+			}
+			if !bytes.Equal(r.req, request) {
+				t.Errorf("request written: got %q, want %q", r.req, request)
+			}
+			if r.writeTimeout != time.Second {
+				t.Errorf("write timeout: got %v, want 1s", r.writeTimeout)
+			}
+			if tc.wantReadReq && r.readTimeout != 2*time.Second {
+				t.Errorf("read timeout: got %v, want 2s", r.readTimeout)
+			}
+		})
+	}
+
+	other, err := c.OpenDeviceWithVIDPID(0x8888, 0x0002)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x8888, 0x0002): %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Command(out, in, request, 512); err == nil {
+		t.Errorf("%s.Command() with endpoints of %s: got nil error, want non-nil", other, dev)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"sync"
+)
+
+// completionQueue delivers the results of the transfers submitted with an
+// OnComplete function. A single worker goroutine of the Context calls the
+// functions, in completion order, so that no user code runs on the libusb
+// event thread and no goroutine waits for each submission.
+type completionQueue struct {
+	mu sync.Mutex
+	// pending are the completed transfers not delivered yet.
+	pending []*Transfer
+	// outstanding is the number of submissions with an OnComplete
+	// function that were not delivered yet.
+	outstanding int
+	// started is true once the worker runs, closing is set by stop.
+	started, closing bool
+	// wake is signalled when transfers are added to pending.
+	wake chan struct{}
+	// stopped is closed when the worker returns.
+	stopped chan struct{}
+}
+
+func newCompletionQueue() *completionQueue {
+	return &completionQueue{
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+}
+
+// start starts the worker, unless it's already running.
+func (q *completionQueue) start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.started || q.closing {
+		return
+	}
+	q.started = true
+	go q.run()
+}
+
+// submitted counts a submission whose result is delivered by the queue.
+// done must be called if the submission fails.
+func (q *completionQueue) submitted() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.outstanding++
+}
+
+// done uncounts a submission.
+func (q *completionQueue) done() {
+	q.mu.Lock()
+	q.outstanding--
+	q.mu.Unlock()
+	q.signal()
+}
+
+// push queues a completed transfer for delivery. It's called from the
+// completion hook of the transfer and must not block.
+func (q *completionQueue) push(t *Transfer) {
+	q.mu.Lock()
+	q.pending = append(q.pending, t)
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *completionQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the queued results until stop was called and no
+// submission is outstanding.
+func (q *completionQueue) run() {
+	defer close(q.stopped)
+	for range q.wake {
+		for {
+			q.mu.Lock()
+			ts := q.pending
+			q.pending = nil
+			q.mu.Unlock()
+			if len(ts) == 0 {
+				break
+			}
+			for _, t := range ts {
+				t.deliver()
+				q.done()
+			}
+		}
+		q.mu.Lock()
+		exit := q.closing && q.outstanding == 0 && len(q.pending) == 0
+		q.mu.Unlock()
+		if exit {
+			return
+		}
+	}
+}
+
+// stop waits for the delivery of the outstanding results and stops the
+// worker. The transfers in flight must have been cancelled.
+func (q *completionQueue) stop() {
+	q.mu.Lock()
+	q.closing = true
+	started := q.started
+	q.mu.Unlock()
+	if !started {
+		return
+	}
+	q.signal()
+	<-q.stopped
+}
+
+// completed is the completion hook of a Transfer with an OnComplete
+// function, see completionQueue.
+func (t *Transfer) completed() {
+	t.mu.Lock()
+	queued := t.inFlightFn != nil
+	t.mu.Unlock()
+	if queued {
+		t.t.ctx.completions.push(t)
+	}
+}
+
+// deliver collects the result of the completed transfer and passes it to
+// the OnComplete function of the submission.
+func (t *Transfer) deliver() {
+	n, err := t.t.wait(context.Background())
+	t.mu.Lock()
+	fn := t.inFlightFn
+	t.inFlightFn = nil
+	t.mu.Unlock()
+	fn(n, err)
+}
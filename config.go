@@ -17,6 +17,7 @@ package gousb
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -38,8 +39,23 @@ type ConfigDesc struct {
 	// MaxPower is the maximum current the device draws from the USB bus
 	// in this configuration.
 	MaxPower Milliamperes
+	// TotalLength is the wTotalLength field of the configuration
+	// descriptor: the combined length in bytes of the configuration
+	// descriptor and of all the interface, endpoint and class- or
+	// vendor-specific descriptors that follow it.
+	TotalLength int
 	// Interfaces has a list of USB interfaces available in this configuration.
 	Interfaces []InterfaceDesc
+	// InterfaceAssociations lists the groups of interfaces that implement
+	// a single device function on composite devices, ordered by the first
+	// interface number.
+	InterfaceAssociations []InterfaceAssociation
+	// Extra contains the raw class- or vendor-specific descriptors that
+	// follow the configuration descriptor.
+	Extra []byte
+	// ExtraDescriptors contains the descriptors from Extra, as parsed by
+	// the parsers registered through RegisterDescriptorParser.
+	ExtraDescriptors []interface{}
 
 	iConfiguration int // index of a string descriptor describing this configuration
 }
@@ -49,6 +65,133 @@ func (c ConfigDesc) String() string {
 	return fmt.Sprintf("Configuration %d", c.Number)
 }
 
+// NumInterfaces returns the number of interfaces of the configuration,
+// not counting their alternate settings.
+func (c ConfigDesc) NumInterfaces() int {
+	return len(c.Interfaces)
+}
+
+// NumEndpoints returns the number of endpoint descriptors in the
+// configuration, summed over all alternate settings of all interfaces. An
+// endpoint that appears in several alternate settings is counted once per
+// setting, like its descriptors in the configuration blob.
+func (c ConfigDesc) NumEndpoints() int {
+	var ret int
+	for _, intf := range c.Interfaces {
+		for _, alt := range intf.AltSettings {
+			ret += len(alt.Endpoints)
+		}
+	}
+	return ret
+}
+
+// EndpointInfo describes an endpoint together with the interface and the
+// alternate setting that define it.
+type EndpointInfo struct {
+	// Interface is the number of the interface of the endpoint.
+	Interface int
+	// Alternate is the number of the alternate setting of the endpoint.
+	Alternate int
+	// Desc is the endpoint descriptor.
+	Desc EndpointDesc
+}
+
+// endpoints returns all endpoints of all alternate settings of all
+// interfaces of the configuration, ordered by interface number, alternate
+// setting and endpoint address.
+func (c ConfigDesc) endpoints() []EndpointInfo {
+	var ret []EndpointInfo
+	for _, intf := range c.Interfaces {
+		for _, alt := range intf.AltSettings {
+			for _, ep := range alt.Endpoints {
+				ret = append(ret, EndpointInfo{Interface: alt.Number, Alternate: alt.Alternate, Desc: ep})
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if a.Interface != b.Interface {
+			return a.Interface < b.Interface
+		}
+		if a.Alternate != b.Alternate {
+			return a.Alternate < b.Alternate
+		}
+		return a.Desc.Address < b.Desc.Address
+	})
+	return ret
+}
+
+// interfaceAssociations finds the interface association descriptors in the
+// extra bytes of the configuration. Depending on the position of the IAD in
+// the configuration, libusb attaches it to the extra bytes of the config,
+// of an interface or of an endpoint, all of these are searched.
+func (c ConfigDesc) interfaceAssociations() []InterfaceAssociation {
+	extras := [][]byte{c.Extra}
+	for _, intf := range c.Interfaces {
+		for _, alt := range intf.AltSettings {
+			extras = append(extras, alt.Extra)
+			for _, ep := range alt.Endpoints {
+				extras = append(extras, ep.Extra)
+			}
+		}
+	}
+	var ret []InterfaceAssociation
+	for _, extra := range extras {
+		descs, _ := splitDescriptors(extra)
+		for _, d := range descs {
+			if DescriptorType(d[1]) != DescriptorTypeInterfaceAssociation || len(d) < 8 {
+				continue
+			}
+			ret = append(ret, InterfaceAssociation{
+				FirstInterface: int(d[2]),
+				InterfaceCount: int(d[3]),
+				Class:          Class(d[4]),
+				SubClass:       Class(d[5]),
+				Protocol:       Protocol(d[6]),
+				iFunction:      int(d[7]),
+			})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].FirstInterface < ret[j].FirstInterface })
+	return ret
+}
+
+// InterfaceAssociation describes a group of consecutive interfaces
+// implementing a single device function, e.g. the control and streaming
+// interfaces of a video camera. It corresponds to an Interface Association
+// Descriptor (IAD) of a composite device.
+type InterfaceAssociation struct {
+	// FirstInterface is the number of the first interface of the function.
+	FirstInterface int
+	// InterfaceCount is the number of contiguous interfaces of the function.
+	InterfaceCount int
+	// Class is the USB-IF class code of the function.
+	Class Class
+	// SubClass is the USB-IF subclass code of the function.
+	SubClass Class
+	// Protocol is the USB protocol code of the function.
+	Protocol Protocol
+
+	iFunction int // index of a string descriptor describing this function
+}
+
+// String returns the human-readable description of the interface association.
+func (a InterfaceAssociation) String() string {
+	return fmt.Sprintf("Function %s on interfaces %d-%d", a.Class, a.FirstInterface, a.FirstInterface+a.InterfaceCount-1)
+}
+
+// interfaceDesc returns the descriptor of the interface with the given
+// number, or nil if the config has no such interface. Interface numbers are
+// not always contiguous, see intfDesc.
+func (c ConfigDesc) interfaceDesc(num int) *InterfaceDesc {
+	for i := range c.Interfaces {
+		if c.Interfaces[i].Number == num {
+			return &c.Interfaces[i]
+		}
+	}
+	return nil
+}
+
 func (c ConfigDesc) intfDesc(num, alt int) (*InterfaceSetting, error) {
 	// In an ideal world, interfaces in the descriptor would be numbered
 	// contiguously starting from 0, as required by the specification. In the
@@ -114,11 +257,15 @@ func (c *Config) String() string {
 
 // Interface claims and returns an interface on a USB device.
 // num specifies the number of an interface to claim, and alt specifies the
-// alternate setting number for that interface.
-func (c *Config) Interface(num, alt int) (*Interface, error) {
+// alternate setting number for that interface. Options can declare the
+// intended use of the interface, see WithIntent.
+func (c *Config) Interface(num, alt int, opts ...InterfaceOption) (*Interface, error) {
 	if c.dev == nil {
 		return nil, fmt.Errorf("Interface(%d, %d) called on %s after Close", num, alt, c)
 	}
+	if c.dev.handle == nil {
+		return nil, fmt.Errorf("Interface(%d, %d) called on %s after the device was closed", num, alt, c)
+	}
 
 	altInfo, err := c.Desc.intfDesc(num, alt)
 	if err != nil {
@@ -137,7 +284,7 @@ func (c *Config) Interface(num, alt int) (*Interface, error) {
 	}
 
 	// Select an alternate setting if needed (device has multiple alternate settings).
-	if len(c.Desc.Interfaces[num].AltSettings) > 1 {
+	if len(c.Desc.interfaceDesc(num).AltSettings) > 1 {
 		if err := c.dev.ctx.libusb.setAlt(c.dev.handle, uint8(num), uint8(alt)); err != nil {
 			c.dev.ctx.libusb.release(c.dev.handle, uint8(num))
 			return nil, fmt.Errorf("failed to set alternate config %d on interface %d of %s: %v", alt, num, c, err)
@@ -145,8 +292,12 @@ func (c *Config) Interface(num, alt int) (*Interface, error) {
 	}
 
 	c.claimed[num] = true
-	return &Interface{
+	intf := &Interface{
 		Setting: *altInfo,
 		config:  c,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(intf)
+	}
+	return intf, nil
 }
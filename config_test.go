@@ -0,0 +1,186 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterfaceAssociations(t *testing.T) {
+	t.Parallel()
+	// A webcam with a microphone: video control + streaming on interfaces
+	// 0-1 and audio control + streaming on interfaces 2-3.
+	// The IAD of the video function comes right after the config descriptor,
+	// libusb stores it in the config extra bytes. The IAD of the audio
+	// function follows the endpoint of interface 1, libusb stores it in the
+	// endpoint extra bytes, together with a class-specific endpoint
+	// descriptor.
+	cfg := ConfigDesc{
+		Number: 1,
+		Extra: []byte{
+			0x08, 0x0b, 0x00, 0x02, 0x0e, 0x03, 0x00, 0x02,
+		},
+		Interfaces: []InterfaceDesc{{
+			Number: 0,
+			AltSettings: []InterfaceSetting{{
+				Number:   0,
+				Class:    ClassVideo,
+				SubClass: 1,
+				// VC_HEADER (truncated)
+				Extra: []byte{0x05, 0x24, 0x01, 0x00, 0x01},
+			}},
+		}, {
+			Number: 1,
+			AltSettings: []InterfaceSetting{{
+				Number:   1,
+				Class:    ClassVideo,
+				SubClass: 2,
+				Endpoints: map[EndpointAddress]EndpointDesc{
+					0x81: {
+						Address: 0x81,
+						Extra: []byte{
+							// CS_ENDPOINT
+							0x05, 0x25, 0x03, 0x40, 0x00,
+							// IAD of the audio function
+							0x08, 0x0b, 0x02, 0x02, 0x01, 0x01, 0x00, 0x04,
+						},
+					},
+				},
+			}},
+		}, {
+			Number: 2,
+			AltSettings: []InterfaceSetting{{
+				Number:   2,
+				Class:    ClassAudio,
+				SubClass: 1,
+			}},
+		}, {
+			Number: 3,
+			AltSettings: []InterfaceSetting{{
+				Number:   3,
+				Class:    ClassAudio,
+				SubClass: 2,
+			}},
+		}},
+	}
+	want := []InterfaceAssociation{
+		{FirstInterface: 0, InterfaceCount: 2, Class: ClassVideo, SubClass: 3, Protocol: 0, iFunction: 2},
+		{FirstInterface: 2, InterfaceCount: 2, Class: ClassAudio, SubClass: 1, Protocol: 0, iFunction: 4},
+	}
+	if got := cfg.interfaceAssociations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("interfaceAssociations(): got %+v, want %+v", got, want)
+	}
+	if got, want := want[0].String(), "Function video on interfaces 0-1"; got != want {
+		t.Errorf("InterfaceAssociation.String(): got %q, want %q", got, want)
+	}
+
+	if got := (ConfigDesc{Number: 1}).interfaceAssociations(); got != nil {
+		t.Errorf("interfaceAssociations() of a non-composite config: got %+v, want nil", got)
+	}
+}
+
+func TestConfigDescCounts(t *testing.T) {
+	t.Parallel()
+	// The configuration descriptor of a CDC ACM serial adapter.
+	blob := []byte{
+		0x09, 0x02, 0x43, 0x00, 0x02, 0x01, 0x00, 0x80, 0x32, // configuration, 2 interfaces
+		0x09, 0x04, 0x00, 0x00, 0x01, 0x02, 0x02, 0x01, 0x00, // interface 0, CDC control
+		0x05, 0x24, 0x00, 0x10, 0x01, // CDC header
+		0x05, 0x24, 0x01, 0x00, 0x01, // call management
+		0x04, 0x24, 0x02, 0x02, // ACM
+		0x05, 0x24, 0x06, 0x00, 0x01, // union
+		0x07, 0x05, 0x83, 0x03, 0x08, 0x00, 0x10, // endpoint 0x83, interrupt
+		0x09, 0x04, 0x01, 0x00, 0x02, 0x0a, 0x00, 0x00, 0x00, // interface 1, CDC data
+		0x07, 0x05, 0x01, 0x02, 0x40, 0x00, 0x00, // endpoint 0x01, bulk
+		0x07, 0x05, 0x82, 0x02, 0x40, 0x00, 0x00, // endpoint 0x82, bulk
+	}
+	descs, err := splitDescriptors(blob)
+	if err != nil {
+		t.Fatalf("splitDescriptors(): %v", err)
+	}
+	var wantEndpoints int
+	for _, d := range descs {
+		if DescriptorType(d[1]) == DescriptorTypeEndpoint {
+			wantEndpoints++
+		}
+	}
+	wantTotal := int(blob[2]) | int(blob[3])<<8
+	if wantTotal != len(blob) {
+		t.Fatalf("test blob wTotalLength %d doesn't match its length %d", wantTotal, len(blob))
+	}
+
+	ep := func(addr EndpointAddress, tt TransferType, mps int) EndpointDesc {
+		return EndpointDesc{
+			Address:       addr,
+			Number:        int(addr & endpointNumMask),
+			Direction:     EndpointDirection(addr&endpointDirectionMask != 0),
+			TransferType:  tt,
+			MaxPacketSize: mps,
+		}
+	}
+	cfg := ConfigDesc{
+		Number:      1,
+		MaxPower:    100,
+		TotalLength: wantTotal,
+		Interfaces: []InterfaceDesc{
+			{Number: 0, AltSettings: []InterfaceSetting{{
+				Number:    0,
+				Class:     ClassComm,
+				SubClass:  2,
+				Protocol:  1,
+				Extra:     blob[18:37],
+				Endpoints: map[EndpointAddress]EndpointDesc{0x83: ep(0x83, TransferTypeInterrupt, 8)},
+			}}},
+			{Number: 1, AltSettings: []InterfaceSetting{{
+				Number: 1,
+				Class:  ClassData,
+				Endpoints: map[EndpointAddress]EndpointDesc{
+					0x01: ep(0x01, TransferTypeBulk, 64),
+					0x82: ep(0x82, TransferTypeBulk, 64),
+				},
+			}}},
+		},
+	}
+	lib := newFakeLibusb()
+	lib.fakeDevices[newDevicePointer()] = &fakeDevice{devDesc: &DeviceDesc{
+		Bus:     2,
+		Address: 1,
+		Spec:    Version(2, 0),
+		Vendor:  0x5555,
+		Product: 0x0001,
+		Configs: map[int]ConfigDesc{1: cfg},
+	}}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x5555, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x5555, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	active, err := dev.ActiveConfig()
+	if err != nil {
+		t.Fatalf("%s.ActiveConfig(): %v", dev, err)
+	}
+	if active.TotalLength != wantTotal {
+		t.Errorf("TotalLength: got %d, want %d", active.TotalLength, wantTotal)
+	}
+	if got, want := active.NumInterfaces(), int(blob[4]); got != want {
+		t.Errorf("NumInterfaces(): got %d, want %d", got, want)
+	}
+	if got := active.NumEndpoints(); got != wantEndpoints {
+		t.Errorf("NumEndpoints(): got %d, want %d", got, wantEndpoints)
+	}
+}
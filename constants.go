@@ -15,7 +15,16 @@
 
 package gousb
 
-// #include <libusb.h>
+/*
+#include <libusb.h>
+
+// LIBUSB_SPEED_SUPER_PLUS was added in libusb 1.0.22.
+#if LIBUSB_API_VERSION >= 0x01000106
+#define GOUSB_SPEED_SUPER_PLUS LIBUSB_SPEED_SUPER_PLUS
+#else
+#define GOUSB_SPEED_SUPER_PLUS 5
+#endif
+*/
 import "C"
 import "strconv"
 
@@ -103,6 +112,14 @@ const (
 	DescriptorTypeReport    DescriptorType = C.LIBUSB_DT_REPORT
 	DescriptorTypePhysical  DescriptorType = C.LIBUSB_DT_PHYSICAL
 	DescriptorTypeHub       DescriptorType = C.LIBUSB_DT_HUB
+	// DescriptorTypeDeviceQualifier is not defined by libusb.
+	DescriptorTypeDeviceQualifier DescriptorType = 0x06
+	// DescriptorTypeInterfaceAssociation is not defined by older libusb versions.
+	DescriptorTypeInterfaceAssociation DescriptorType = 0x0b
+	// DescriptorTypeBOS and DescriptorTypeDeviceCapability are not defined
+	// by older libusb versions.
+	DescriptorTypeBOS              DescriptorType = 0x0f
+	DescriptorTypeDeviceCapability DescriptorType = 0x10
 )
 
 var descriptorTypeDescription = map[DescriptorType]string{
@@ -115,6 +132,11 @@ var descriptorTypeDescription = map[DescriptorType]string{
 	DescriptorTypeReport:    "HID report",
 	DescriptorTypePhysical:  "physical",
 	DescriptorTypeHub:       "hub",
+
+	DescriptorTypeDeviceQualifier:      "device qualifier",
+	DescriptorTypeInterfaceAssociation: "interface association",
+	DescriptorTypeBOS:                  "BOS",
+	DescriptorTypeDeviceCapability:     "device capability",
 }
 
 func (dt DescriptorType) String() string {
@@ -222,10 +244,31 @@ func (ut UsageType) String() string {
 	return usageTypeDescription[ut]
 }
 
+// endpointAttributes returns the synchronization and usage types encoded in
+// the bmAttributes field of an endpoint descriptor. Both are defined only
+// for isochronous endpoints, for other endpoints the zero values are
+// returned.
+func endpointAttributes(bmAttributes uint8) (IsoSyncType, UsageType) {
+	if TransferType(bmAttributes&transferTypeMask) != TransferTypeIsochronous {
+		return IsoSyncTypeNone, UsageTypeUndefined
+	}
+	sync := IsoSyncType(bmAttributes & isoSyncTypeMask)
+	switch bmAttributes & usageTypeMask {
+	case C.LIBUSB_ISO_USAGE_TYPE_DATA << 4:
+		return sync, IsoUsageTypeData
+	case C.LIBUSB_ISO_USAGE_TYPE_FEEDBACK << 4:
+		return sync, IsoUsageTypeFeedback
+	case C.LIBUSB_ISO_USAGE_TYPE_IMPLICIT << 4:
+		return sync, IsoUsageTypeImplicit
+	}
+	return sync, UsageTypeUndefined
+}
+
 // Control request type bit fields as defined in the USB spec. All values are
 // of uint8 type.  These constants can be used with Device.Control() method to
 // specify the type and destination of the control request, e.g.
-// `dev.Control(ControlOut|ControlVendor|ControlDevice, ...)`.
+// `dev.Control(ControlOut|ControlVendor|ControlDevice, ...)`. See also
+// ControlType for a typed way to build the same value.
 const (
 	ControlIn  = C.LIBUSB_ENDPOINT_IN
 	ControlOut = C.LIBUSB_ENDPOINT_OUT
@@ -242,6 +285,104 @@ const (
 	ControlOther     = C.LIBUSB_RECIPIENT_OTHER
 )
 
+// ControlKind is the type of a control request, as encoded in bits 5-6 of
+// bmRequestType.
+type ControlKind uint8
+
+// Control request types.
+const (
+	ControlKindStandard ControlKind = C.LIBUSB_REQUEST_TYPE_STANDARD
+	ControlKindClass    ControlKind = C.LIBUSB_REQUEST_TYPE_CLASS
+	ControlKindVendor   ControlKind = C.LIBUSB_REQUEST_TYPE_VENDOR
+)
+
+var controlKindDescription = map[ControlKind]string{
+	ControlKindStandard: "standard",
+	ControlKindClass:    "class",
+	ControlKindVendor:   "vendor",
+}
+
+func (k ControlKind) String() string {
+	return controlKindDescription[k]
+}
+
+// ControlRecipient is the recipient of a control request, as encoded in
+// bits 0-4 of bmRequestType.
+type ControlRecipient uint8
+
+// Control request recipients.
+const (
+	ControlRecipientDevice    ControlRecipient = C.LIBUSB_RECIPIENT_DEVICE
+	ControlRecipientInterface ControlRecipient = C.LIBUSB_RECIPIENT_INTERFACE
+	ControlRecipientEndpoint  ControlRecipient = C.LIBUSB_RECIPIENT_ENDPOINT
+	ControlRecipientOther     ControlRecipient = C.LIBUSB_RECIPIENT_OTHER
+)
+
+var controlRecipientDescription = map[ControlRecipient]string{
+	ControlRecipientDevice:    "device",
+	ControlRecipientInterface: "interface",
+	ControlRecipientEndpoint:  "endpoint",
+	ControlRecipientOther:     "other",
+}
+
+func (r ControlRecipient) String() string {
+	return controlRecipientDescription[r]
+}
+
+// ControlType returns the bmRequestType byte of a control request of the
+// given type, recipient and direction, for use with Device.Control, e.g.
+// `dev.Control(ControlType(ControlKindVendor, ControlRecipientInterface, EndpointDirectionIn), ...)`.
+func ControlType(kind ControlKind, recipient ControlRecipient, dir EndpointDirection) uint8 {
+	ret := uint8(kind)&0x60 | uint8(recipient)&0x1f
+	if dir == EndpointDirectionIn {
+		ret |= ControlIn
+	}
+	return ret
+}
+
+// Standard requests and feature selectors, used to implement the higher
+// level operations of gousb.
+const (
+	requestGetStatus     = C.LIBUSB_REQUEST_GET_STATUS
+	requestClearFeature  = C.LIBUSB_REQUEST_CLEAR_FEATURE
+	requestSetFeature    = C.LIBUSB_REQUEST_SET_FEATURE
+	requestGetDescriptor = C.LIBUSB_REQUEST_GET_DESCRIPTOR
+	requestGetInterface  = C.LIBUSB_REQUEST_GET_INTERFACE
+
+	statusRemoteWakeup = 1 << 1
+	statusEndpointHalt = 1 << 0
+)
+
+// FeatureSelector is a standard feature selector, the wValue of the
+// SET_FEATURE and CLEAR_FEATURE requests, see Device.SetFeature.
+type FeatureSelector uint16
+
+// Standard feature selectors, USB 2.0 spec table 9-6.
+const (
+	// FeatureEndpointHalt halts an endpoint, its recipient is an endpoint.
+	FeatureEndpointHalt FeatureSelector = 0
+	// FeatureDeviceRemoteWakeup enables remote wakeup, its recipient is
+	// the device.
+	FeatureDeviceRemoteWakeup FeatureSelector = 1
+	// FeatureTestMode puts a high-speed device in a test mode, its
+	// recipient is the device. It can only be set, the device leaves the
+	// test mode on a power cycle.
+	FeatureTestMode FeatureSelector = 2
+)
+
+var featureSelectorDescription = map[FeatureSelector]string{
+	FeatureEndpointHalt:       "ENDPOINT_HALT",
+	FeatureDeviceRemoteWakeup: "DEVICE_REMOTE_WAKEUP",
+	FeatureTestMode:           "TEST_MODE",
+}
+
+func (f FeatureSelector) String() string {
+	if d, ok := featureSelectorDescription[f]; ok {
+		return d
+	}
+	return "feature " + strconv.Itoa(int(f))
+}
+
 // Speed identifies the speed of the device.
 type Speed int
 
@@ -252,19 +393,38 @@ const (
 	SpeedFull    Speed = C.LIBUSB_SPEED_FULL
 	SpeedHigh    Speed = C.LIBUSB_SPEED_HIGH
 	SpeedSuper   Speed = C.LIBUSB_SPEED_SUPER
+	// SpeedSuperPlus is the speed of USB 3.1 and 3.2 devices connected at
+	// 10 or 20 Gbps. libusb versions older than 1.0.22 report such devices
+	// as SpeedSuper, see Context.CheckSpeedSuperPlus.
+	SpeedSuperPlus Speed = C.GOUSB_SPEED_SUPER_PLUS
 )
 
+// featureSpeedSuperPlus is the reporting of SpeedSuperPlus by
+// libusb_get_device_speed.
+var featureSpeedSuperPlus = libusbFeature{name: "SuperSpeed Plus speed detection", since: LibusbVersion{1, 0, 22, 0}}
+
 var deviceSpeedDescription = map[Speed]string{
 	SpeedUnknown: "unknown",
 	SpeedLow:     "low",
 	SpeedFull:    "full",
 	SpeedHigh:    "high",
 	SpeedSuper:   "super",
+	// SuperSpeed Plus is abbreviated as SuperSpeed+ in the USB specs.
+	SpeedSuperPlus: "super+",
 }
 
 // String returns a human-readable name of the device speed.
 func (s Speed) String() string {
-	return deviceSpeedDescription[s]
+	if d, ok := deviceSpeedDescription[s]; ok {
+		return d
+	}
+	return "unknown speed " + strconv.Itoa(int(s))
+}
+
+// superSpeed returns true for the Enhanced SuperSpeed speeds, SpeedSuper
+// and SpeedSuperPlus, which share the descriptor formats.
+func (s Speed) superSpeed() bool {
+	return s == SpeedSuper || s == SpeedSuperPlus
 }
 
 const (
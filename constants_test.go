@@ -0,0 +1,76 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "testing"
+
+func TestControlType(t *testing.T) {
+	for _, tc := range []struct {
+		kind      ControlKind
+		recipient ControlRecipient
+		dir       EndpointDirection
+		want      uint8
+	}{
+		{ControlKindStandard, ControlRecipientDevice, EndpointDirectionOut, 0x00},
+		{ControlKindStandard, ControlRecipientDevice, EndpointDirectionIn, 0x80},
+		{ControlKindStandard, ControlRecipientInterface, EndpointDirectionIn, 0x81},
+		{ControlKindStandard, ControlRecipientEndpoint, EndpointDirectionOut, 0x02},
+		{ControlKindClass, ControlRecipientDevice, EndpointDirectionOut, 0x20},
+		{ControlKindClass, ControlRecipientInterface, EndpointDirectionIn, 0xa1},
+		{ControlKindClass, ControlRecipientInterface, EndpointDirectionOut, 0x21},
+		{ControlKindClass, ControlRecipientOther, EndpointDirectionIn, 0xa3},
+		{ControlKindVendor, ControlRecipientDevice, EndpointDirectionIn, 0xc0},
+		{ControlKindVendor, ControlRecipientDevice, EndpointDirectionOut, 0x40},
+		{ControlKindVendor, ControlRecipientInterface, EndpointDirectionIn, 0xc1},
+		{ControlKindVendor, ControlRecipientEndpoint, EndpointDirectionOut, 0x42},
+		{ControlKindVendor, ControlRecipientOther, EndpointDirectionOut, 0x43},
+	} {
+		if got := ControlType(tc.kind, tc.recipient, tc.dir); got != tc.want {
+			t.Errorf("ControlType(%s, %s, %s): got 0x%02x, want 0x%02x", tc.kind, tc.recipient, tc.dir, got, tc.want)
+		}
+	}
+	// The typed values match the bit constants.
+	if got, want := ControlType(ControlKindVendor, ControlRecipientInterface, EndpointDirectionIn), uint8(ControlIn|ControlVendor|ControlInterface); got != want {
+		t.Errorf("ControlType(vendor, interface, IN): got 0x%02x, want 0x%02x", got, want)
+	}
+}
+
+func TestSpeed(t *testing.T) {
+	for _, tc := range []struct {
+		raw        int
+		want       Speed
+		wantString string
+		wantSuper  bool
+	}{
+		{0, SpeedUnknown, "unknown", false},
+		{1, SpeedLow, "low", false},
+		{2, SpeedFull, "full", false},
+		{3, SpeedHigh, "high", false},
+		{4, SpeedSuper, "super", true},
+		{5, SpeedSuperPlus, "super+", true},
+		{6, Speed(6), "unknown speed 6", false},
+	} {
+		got := Speed(tc.raw)
+		if got != tc.want {
+			t.Errorf("Speed(%d): got %d, want %d", tc.raw, got, tc.want)
+		}
+		if s := got.String(); s != tc.wantString {
+			t.Errorf("Speed(%d).String(): got %q, want %q", tc.raw, s, tc.wantString)
+		}
+		if s := got.superSpeed(); s != tc.wantSuper {
+			t.Errorf("Speed(%d).superSpeed(): got %v, want %v", tc.raw, s, tc.wantSuper)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"log"
+	"runtime"
+	"time"
+)
+
+// defaultEventTimeout is the event handling period used without
+// WithEventTimeout.
+const defaultEventTimeout = 100 * time.Millisecond
+
+// ContextOption configures a Context created with NewContext.
+type ContextOption func(*contextOptions)
+
+type contextOptions struct {
+	pin  bool
+	cpu  int
+	prio bool
+	nice int
+	// eventTimeout is the event handling period, see WithEventTimeout.
+	eventTimeout time.Duration
+	// recorder, if not nil, records all transfers, see
+	// WithTransferRecorder.
+	recorder *TransferRecorder
+	// debugTransfers is set by WithTransferDebug.
+	debugTransfers bool
+	// replay, if not nil, completes all transfers, see
+	// WithTransferReplay.
+	replay *TransferReplay
+}
+
+// WithEventLoopCPU pins the OS thread running the libusb event loop of the
+// Context to the given CPU. The event loop delivers the completions of all
+// transfers, pinning it to a CPU not used by the application, e.g. one
+// excluded with isolcpus, reduces the latency of transfer completions when
+// the process is busy.
+// Pinning is supported only on Linux and requires no privileges, but the
+// CPU must be in the set of CPUs allowed for the process, see taskset and
+// cpusets. If pinning fails, the event loop runs unpinned, see
+// Context.EventLoopErr.
+func WithEventLoopCPU(cpu int) ContextOption {
+	return func(o *contextOptions) {
+		o.pin = true
+		o.cpu = cpu
+	}
+}
+
+// WithEventLoopPriority sets the nice value of the OS thread running the
+// libusb event loop of the Context, see setpriority(2). Negative values
+// raise the priority of the thread above the rest of the process.
+// Setting priorities is supported only on Linux. Lowering the nice value
+// below the current one requires the CAP_SYS_NICE capability or a
+// sufficient RLIMIT_NICE limit. If the priority can't be set, the event
+// loop keeps the default one, see Context.EventLoopErr.
+func WithEventLoopPriority(nice int) ContextOption {
+	return func(o *contextOptions) {
+		o.prio = true
+		o.nice = nice
+	}
+}
+
+// WithEventTimeout sets the longest time the event loop of the Context
+// waits for libusb events at a time, 100ms by default. The event loop wakes
+// up at least once per period even when there are no events, a longer
+// period reduces the CPU wakeups of an idle Context. Transfer completions
+// are delivered as they happen regardless of the period, and Close
+// interrupts the event loop without waiting for the period to expire, so
+// the period matters for responsiveness only with libusb versions older
+// than 1.0.21, which lack libusb_interrupt_event_handler: with those, Close
+// can block for up to one period. Periods of 0 or less select the default.
+func WithEventTimeout(d time.Duration) ContextOption {
+	return func(o *contextOptions) {
+		o.eventTimeout = d
+	}
+}
+
+// startEventLoop starts the goroutine handling libusb events. If the
+// options modify the event loop thread, the goroutine is locked to its own
+// OS thread, which is terminated when the Context is closed, so that the
+// modified thread is never reused for other goroutines. A failure to modify
+// the thread is logged and returned, the event loop runs regardless.
+func (c *Context) startEventLoop(o *contextOptions) error {
+	timeout := o.eventTimeout
+	if timeout <= 0 {
+		timeout = defaultEventTimeout
+	}
+	if !o.pin && !o.prio {
+		go func() {
+			defer close(c.loopDone)
+			c.libusb.handleEvents(c.ctx, timeout, c.done)
+		}()
+		return nil
+	}
+	setup := make(chan error)
+	go func() {
+		defer close(c.loopDone)
+		runtime.LockOSThread()
+		setup <- setupEventThread(o)
+		c.libusb.handleEvents(c.ctx, timeout, c.done)
+	}()
+	err := <-setup
+	if err != nil {
+		log.Printf("gousb: setting up the event loop thread: %v, continuing with default scheduling", err)
+	}
+	return err
+}
+
+// EventLoopErr returns the error that prevented applying WithEventLoopCPU
+// or WithEventLoopPriority when the Context was created, e.g. missing
+// privileges, or nil if the options were applied or none were given. The
+// Context is fully usable either way, its event loop then runs with the
+// default scheduling.
+func (c *Context) EventLoopErr() error {
+	return c.eventLoopErr
+}
+
+// WithTransferDebug enables checks of the lifecycle of the transfers of the
+// Context, meant for debugging: submitting or waiting for a transfer after
+// it was freed, e.g. with Transfer.Free, panics with a message that
+// includes the stack of the call that freed it, instead of returning an
+// error that is easy to ignore. Freed transfer buffers are also filled with
+// the 0xdb byte, so that data read from a buffer kept past the lifetime of
+// its transfer stands out. Transfers freed because their Device was closed
+// still return ErrDeviceClosed. The checks cost a stack trace per freed
+// transfer and should not be enabled in production.
+func WithTransferDebug() ContextOption {
+	return func(o *contextOptions) {
+		o.debugTransfers = true
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestEventLoopOptions(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc    string
+		opts    []ContextOption
+		wantErr bool
+	}{
+		{desc: "no options"},
+		{desc: "pinned to CPU 0", opts: []ContextOption{WithEventLoopCPU(0)}},
+		{desc: "lower priority", opts: []ContextOption{WithEventLoopPriority(19)}},
+		{desc: "pinned and lower priority", opts: []ContextOption{WithEventLoopCPU(0), WithEventLoopPriority(10)}},
+		{desc: "invalid CPU", opts: []ContextOption{WithEventLoopCPU(-1)}, wantErr: true},
+	} {
+		if runtime.GOOS != "linux" && len(tc.opts) > 0 {
+			tc.wantErr = true
+		}
+		ctx := newContextWithImpl(newFakeLibusb(), tc.opts...)
+		if err := ctx.EventLoopErr(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: event loop setup error: %v, want error: %v", tc.desc, err, tc.wantErr)
+		}
+		// The context is usable even if the setup failed.
+		dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+		if err != nil {
+			t.Errorf("%s: OpenDeviceWithVIDPID(0x9999, 0x0001): %v", tc.desc, err)
+		} else {
+			dev.Close()
+		}
+		if err := ctx.Close(); err != nil {
+			t.Errorf("%s: Context.Close(): %v", tc.desc, err)
+		}
+	}
+}
+
+// blockingEventsLib is a fakeLibusb whose event handling blocks for the
+// whole timeout unless interrupted, like libusb_handle_events_timeout
+// without any events.
+type blockingEventsLib struct {
+	*fakeLibusb
+	timeouts  chan time.Duration
+	interrupt chan struct{}
+}
+
+func (f *blockingEventsLib) handleEvents(_ *libusbContext, timeout time.Duration, done <-chan struct{}) {
+	f.timeouts <- timeout
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		select {
+		case <-f.interrupt:
+		case <-time.After(timeout):
+		}
+	}
+}
+
+func (f *blockingEventsLib) interruptEvents(*libusbContext) {
+	select {
+	case f.interrupt <- struct{}{}:
+	default:
+	}
+}
+
+func TestWithEventTimeout(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		opts []ContextOption
+		want time.Duration
+	}{
+		{nil, defaultEventTimeout},
+		{[]ContextOption{WithEventTimeout(0)}, defaultEventTimeout},
+		{[]ContextOption{WithEventTimeout(time.Millisecond)}, time.Millisecond},
+		{[]ContextOption{WithEventTimeout(time.Hour)}, time.Hour},
+	} {
+		lib := &blockingEventsLib{
+			fakeLibusb: newFakeLibusb(),
+			timeouts:   make(chan time.Duration, 1),
+			interrupt:  make(chan struct{}, 1),
+		}
+		ctx := newContextWithImpl(lib, tc.opts...)
+		if got := <-lib.timeouts; got != tc.want {
+			t.Errorf("event timeout: got %v, want %v", got, tc.want)
+		}
+		// Close must not wait for the event handling period to expire.
+		start := time.Now()
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("Context.Close() with event timeout %v took %v", tc.want, d)
+		}
+	}
+}
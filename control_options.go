@@ -0,0 +1,134 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ControlOption configures a control request sent with
+// Device.ControlContext.
+type ControlOption func(*controlOptions)
+
+type controlOptions struct {
+	retries int
+	backoff time.Duration
+	timeout time.Duration
+	retryOn []error
+}
+
+// defaultRetryOn are the errors of a control request that are retried by
+// default: the device didn't respond in time, or it was temporarily
+// unavailable.
+var defaultRetryOn = []error{ErrorTimeout, ErrorBusy, ErrorInterrupted}
+
+func newControlOptions(d *Device, opts []ControlOption) *controlOptions {
+	o := &controlOptions{
+		timeout: d.ControlTimeout,
+		retryOn: defaultRetryOn,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithControlRetries retries a failed control request up to n more times,
+// waiting backoff before the first retry and doubling the wait before each
+// subsequent one. Only errors matching the retried errors are retried, see
+// WithControlRetryOn.
+func WithControlRetries(n int, backoff time.Duration) ControlOption {
+	return func(o *controlOptions) {
+		o.retries = n
+		o.backoff = backoff
+	}
+}
+
+// WithControlTimeout sets the timeout of each attempt of the control
+// request, overriding Device.ControlTimeout. 0 means no timeout.
+func WithControlTimeout(timeout time.Duration) ControlOption {
+	return func(o *controlOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithControlRetryOn sets the errors that cause a control request to be
+// retried, matched with errors.Is. By default, ErrorTimeout, ErrorBusy and
+// ErrorInterrupted are retried. A stall (ErrorPipe) is not retried by
+// default, as it usually means the device does not support the request.
+func WithControlRetryOn(errs ...error) ControlOption {
+	return func(o *controlOptions) {
+		o.retryOn = errs
+	}
+}
+
+func (o *controlOptions) retriable(err error) bool {
+	for _, r := range o.retryOn {
+		if errors.Is(err, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// ControlContext sends a control request to the device, like Control, with
+// optional retries and a per-attempt timeout configured by opts.
+// Control requests are synchronous, an attempt in progress can't be aborted.
+// Instead, ctx is checked before each attempt and interrupts the wait
+// between attempts, and if ctx has a deadline, the attempt timeout is
+// shortened so that no attempt runs past it. When ctx is done,
+// ControlContext returns ctx.Err().
+// If all attempts fail, the error of the last attempt is returned.
+func (d *Device) ControlContext(ctx context.Context, rType, request uint8, val, idx uint16, data []byte, opts ...ControlOption) (int, error) {
+	if d.handle == nil {
+		return 0, fmt.Errorf("ControlContext() called on %s after Close", d)
+	}
+	o := newControlOptions(d, opts)
+	backoff := o.backoff
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		timeout := o.timeout
+		if deadline, ok := ctx.Deadline(); ok {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return 0, context.DeadlineExceeded
+			}
+			if timeout == 0 || left < timeout {
+				timeout = left
+			}
+		}
+		// libusb rounds the timeout down to milliseconds, and treats 0
+		// as no timeout at all.
+		if timeout > 0 && timeout < minDeadlineTimeout {
+			timeout = minDeadlineTimeout
+		}
+		n, err := d.ctx.libusb.control(d.handle, timeout, rType, request, val, idx, data)
+		if err == nil || attempt >= o.retries || !o.retriable(err) {
+			return n, err
+		}
+		debug.Printf("%s: control request 0x%02x failed (attempt %d of %d), retrying: %v", d, request, attempt+1, o.retries+1, err)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestControlContextRetries(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc      string
+		failures  []error
+		opts      []ControlOption
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			desc:      "no retries",
+			failures:  []error{ErrorTimeout},
+			wantErr:   ErrorTimeout,
+			wantCalls: 1,
+		},
+		{
+			desc:      "succeeds after retries",
+			failures:  []error{ErrorTimeout, ErrorBusy},
+			opts:      []ControlOption{WithControlRetries(3, time.Millisecond)},
+			wantCalls: 3,
+		},
+		{
+			desc:      "retries exhausted",
+			failures:  []error{ErrorTimeout, ErrorTimeout, ErrorTimeout, ErrorTimeout},
+			opts:      []ControlOption{WithControlRetries(2, time.Millisecond)},
+			wantErr:   ErrorTimeout,
+			wantCalls: 3,
+		},
+		{
+			desc:      "stall is not retried",
+			failures:  []error{ErrorPipe},
+			opts:      []ControlOption{WithControlRetries(2, time.Millisecond)},
+			wantErr:   ErrorPipe,
+			wantCalls: 1,
+		},
+		{
+			desc:     "custom retried errors",
+			failures: []error{ErrorPipe},
+			opts: []ControlOption{
+				WithControlRetries(2, time.Millisecond),
+				WithControlRetryOn(ErrorPipe),
+				WithControlTimeout(time.Second),
+			},
+			wantCalls: 2,
+		},
+	} {
+		lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+		failures := tc.failures
+		lib.reply = func(_ controlRequest, data []byte) (int, error) {
+			if len(failures) > 0 {
+				err := failures[0]
+				failures = failures[1:]
+				return 0, err
+			}
+			return len(data), nil
+		}
+		c := newContextWithImpl(lib)
+		dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+		if err != nil {
+			t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+		}
+		_, err = dev.ControlContext(context.Background(), ControlIn|ControlVendor|ControlDevice, 1, 0, 0, make([]byte, 4), tc.opts...)
+		if err != tc.wantErr {
+			t.Errorf("%s: ControlContext(): got error %v, want %v", tc.desc, err, tc.wantErr)
+		}
+		if got := len(lib.requests()); got != tc.wantCalls {
+			t.Errorf("%s: ControlContext(): sent %d requests, want %d", tc.desc, got, tc.wantCalls)
+		}
+		dev.Close()
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}
+}
+
+func TestControlContextCancelDuringRetry(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lib.reply = func(controlRequest, []byte) (int, error) {
+		// cancel during the first attempt, ControlContext must not wait
+		// for the backoff to expire.
+		cancel()
+		return 0, ErrorTimeout
+	}
+	start := time.Now()
+	_, err = dev.ControlContext(ctx, ControlIn|ControlVendor|ControlDevice, 1, 0, 0, make([]byte, 4), WithControlRetries(5, time.Hour))
+	if err != context.Canceled {
+		t.Errorf("ControlContext(): got error %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("ControlContext() returned after %v, want it to return promptly", elapsed)
+	}
+	if got := len(lib.requests()); got != 1 {
+		t.Errorf("ControlContext(): sent %d requests, want 1", got)
+	}
+}
+
+func TestControlContextShortDeadline(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	// less than a millisecond left before the deadline must not turn
+	// into no timeout at all.
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Microsecond)
+	defer cancel()
+	dev.ControlContext(ctx, ControlIn|ControlVendor|ControlDevice, 1, 0, 0, make([]byte, 4), WithControlTimeout(time.Second))
+	dev.ControlContext(context.Background(), ControlIn|ControlVendor|ControlDevice, 1, 0, 0, make([]byte, 4), WithControlTimeout(100*time.Microsecond))
+	lib.ctrlMu.Lock()
+	defer lib.ctrlMu.Unlock()
+	for _, timeout := range lib.timeouts {
+		if timeout < time.Millisecond {
+			t.Errorf("ControlContext(): control request sent with timeout %v, want at least 1ms", timeout)
+		}
+	}
+}
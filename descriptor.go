@@ -0,0 +1,95 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// DescriptorParser parses a single class- or vendor-specific descriptor.
+// The slice passed to the parser contains the entire descriptor, including
+// the bLength and bDescriptorType fields.
+type DescriptorParser func([]byte) (interface{}, error)
+
+var descriptorParsers = struct {
+	sync.RWMutex
+	m map[DescriptorType]DescriptorParser
+}{
+	m: make(map[DescriptorType]DescriptorParser),
+}
+
+// RegisterDescriptorParser registers a parser for the descriptors of type dt
+// found in the extra bytes of configuration, interface and endpoint
+// descriptors (e.g. UVC or UAC class-specific descriptors). The values
+// returned by the parser are stored in the ExtraDescriptors field of the
+// corresponding ConfigDesc, InterfaceSetting or EndpointDesc.
+// Parsers are invoked when the device descriptors are read during device
+// enumeration, so RegisterDescriptorParser should be called before that,
+// typically from an init function. Registering a parser for a type that
+// already has one replaces the previous parser. Passing a nil parser removes
+// the registration.
+func RegisterDescriptorParser(dt DescriptorType, p DescriptorParser) {
+	descriptorParsers.Lock()
+	defer descriptorParsers.Unlock()
+	if p == nil {
+		delete(descriptorParsers.m, dt)
+		return
+	}
+	descriptorParsers.m[dt] = p
+}
+
+// splitDescriptors splits a blob of concatenated descriptors into individual
+// descriptors, using the bLength field of each.
+func splitDescriptors(b []byte) ([][]byte, error) {
+	var ret [][]byte
+	for len(b) > 0 {
+		l := int(b[0])
+		if l < 2 || l > len(b) {
+			return ret, fmt.Errorf("malformed descriptor with length %d, %d bytes available", l, len(b))
+		}
+		ret = append(ret, b[:l])
+		b = b[l:]
+	}
+	return ret, nil
+}
+
+// parseExtra runs the registered descriptor parsers over the descriptors
+// contained in extra. Descriptors without a registered parser are skipped.
+// Errors are logged and the offending descriptors are skipped, a broken
+// class-specific descriptor should not prevent the use of the device.
+func parseExtra(extra []byte) []interface{} {
+	descs, err := splitDescriptors(extra)
+	if err != nil {
+		log.Printf("gousb: %v, ignoring remaining extra descriptor data", err)
+	}
+	descriptorParsers.RLock()
+	defer descriptorParsers.RUnlock()
+	var ret []interface{}
+	for _, d := range descs {
+		p, ok := descriptorParsers.m[DescriptorType(d[1])]
+		if !ok {
+			continue
+		}
+		v, err := p(d)
+		if err != nil {
+			log.Printf("gousb: parsing descriptor of type 0x%02x failed: %v", d[1], err)
+			continue
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// uvcInputHeader is a subset of the UVC VideoStreaming Input Header descriptor.
+type uvcInputHeader struct {
+	NumFormats     int
+	EndpointAddr   EndpointAddress
+	TerminalLink   int
+	StillCapMethod int
+}
+
+// parseUVCStreaming is an example parser for UVC class-specific
+// VideoStreaming interface descriptors (type CS_INTERFACE, 0x24).
+func parseUVCStreaming(b []byte) (interface{}, error) {
+	const vsInputHeader = 0x01
+	if len(b) < 3 {
+		return nil, errors.New("descriptor too short")
+	}
+	if b[2] != vsInputHeader {
+		// not an input header, keep the raw bytes.
+		return b, nil
+	}
+	if len(b) < 13 {
+		return nil, errors.New("VS_INPUT_HEADER too short")
+	}
+	return uvcInputHeader{
+		NumFormats:     int(b[3]),
+		EndpointAddr:   EndpointAddress(b[6]),
+		TerminalLink:   int(b[8]),
+		StillCapMethod: int(b[9]),
+	}, nil
+}
+
+func TestParseExtra(t *testing.T) {
+	const csInterface = DescriptorType(0x24)
+	RegisterDescriptorParser(csInterface, parseUVCStreaming)
+	defer RegisterDescriptorParser(csInterface, nil)
+
+	for _, tc := range []struct {
+		desc  string
+		extra []byte
+		want  []interface{}
+	}{
+		{
+			desc: "no extra descriptors",
+		},
+		{
+			desc: "input header and a format descriptor",
+			extra: []byte{
+				// VS_INPUT_HEADER
+				0x0e, 0x24, 0x01, 0x01, 0x4f, 0x00, 0x81, 0x00, 0x03, 0x02, 0x01, 0x00, 0x01, 0x00,
+				// VS_FORMAT_MJPEG (truncated to 5 bytes for the test)
+				0x05, 0x24, 0x06, 0x01, 0x01,
+			},
+			want: []interface{}{
+				uvcInputHeader{NumFormats: 1, EndpointAddr: 0x81, TerminalLink: 3, StillCapMethod: 2},
+				[]byte{0x05, 0x24, 0x06, 0x01, 0x01},
+			},
+		},
+		{
+			desc: "descriptor without a registered parser is skipped",
+			extra: []byte{
+				0x04, 0x99, 0x00, 0x00,
+				0x05, 0x24, 0x06, 0x01, 0x01,
+			},
+			want: []interface{}{
+				[]byte{0x05, 0x24, 0x06, 0x01, 0x01},
+			},
+		},
+		{
+			desc: "parser error skips the descriptor",
+			extra: []byte{
+				// VS_INPUT_HEADER too short
+				0x04, 0x24, 0x01, 0x01,
+			},
+		},
+		{
+			desc: "malformed length stops parsing",
+			extra: []byte{
+				0x05, 0x24, 0x06, 0x01, 0x01,
+				0x10, 0x24, 0x06,
+			},
+			want: []interface{}{
+				[]byte{0x05, 0x24, 0x06, 0x01, 0x01},
+			},
+		},
+	} {
+		if got := parseExtra(tc.extra); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parseExtra(%v): got %v, want %v", tc.desc, tc.extra, got, tc.want)
+		}
+	}
+}
@@ -16,10 +16,14 @@
 package gousb
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
 	"time"
+	"unicode/utf16"
+	"unsafe"
 )
 
 // DeviceDesc is a representation of a USB device descriptor.
@@ -29,6 +33,7 @@ type DeviceDesc struct {
 	Address int   // The address of the device on the bus
 	Speed   Speed // The negotiated operating speed for the device
 	Port    int   // The usb port on which the device was detected
+	Path    []int // The usb ports from the root hub to the device, the last one is Port
 
 	// Version information
 	Spec   BCD // USB Specification Release Number
@@ -69,6 +74,21 @@ func (d *DeviceDesc) sortedConfigIds() []int {
 	return cfgs
 }
 
+// hasInterface returns true if match returns true for an interface
+// alternate setting of any configuration of the device.
+func (d *DeviceDesc) hasInterface(match func(InterfaceSetting) bool) bool {
+	for _, cfg := range d.Configs {
+		for _, intf := range cfg.Interfaces {
+			for _, alt := range intf.AltSettings {
+				if match(alt) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (d *DeviceDesc) cfgDesc(cfgNum int) (*ConfigDesc, error) {
 	desc, ok := d.Configs[cfgNum]
 	if !ok {
@@ -85,6 +105,9 @@ func (d *DeviceDesc) cfgDesc(cfgNum int) (*ConfigDesc, error) {
 type Device struct {
 	handle *libusbDevHandle
 	ctx    *Context
+	// dev is the reference to the libusb device held by devices created
+	// through Context.WrapDevice or Device.Clone, released on Close.
+	dev *libusbDevice
 
 	// Embed the device information for easy access
 	Desc *DeviceDesc
@@ -94,9 +117,24 @@ type Device struct {
 	// Claimed config
 	mu      sync.Mutex
 	claimed *Config
+	// activeCfg caches the descriptor of the active config, see
+	// ActiveConfig.
+	activeCfg *ConfigDesc
 
 	// Handle AutoDetach in this library
 	autodetach bool
+
+	// sched orders the transfer submissions, see SetTransferScheduling.
+	sched scheduler
+	// state is shared with the endpoints and transfers of the device.
+	state deviceState
+}
+
+// deviceState tracks whether a Device was closed, so that its endpoints
+// and transfers never pass the stale libusb handle of a closed device to
+// libusb. It is protected by the xferMu of the Context.
+type deviceState struct {
+	closed bool
 }
 
 // String represents a human readable representation of the device.
@@ -104,6 +142,116 @@ func (d *Device) String() string {
 	return fmt.Sprintf("vid=%s,pid=%s,bus=%d,addr=%d", d.Desc.Vendor, d.Desc.Product, d.Desc.Bus, d.Desc.Address)
 }
 
+// Handle returns the underlying libusb_device_handle of the device.
+//
+// This is an advanced and unsafe escape hatch for calling libusb functions
+// that are not wrapped by gousb from the caller's own CGo code. The returned
+// pointer should be converted to *C.libusb_device_handle.
+// The handle remains owned by the Device: it must not be closed by the caller
+// and it becomes invalid as soon as Device.Close is called. Calling libusb
+// functions that change the state of the device (e.g. claiming interfaces
+// or changing the active configuration) bypasses gousb's bookkeeping and
+// may cause gousb to misbehave.
+// Handle returns nil if the device was already closed.
+func (d *Device) Handle() unsafe.Pointer {
+	return unsafe.Pointer(d.handle)
+}
+
+// DeviceState is the state of a Device, as reported by Device.State.
+type DeviceState int
+
+// Device states.
+const (
+	// DeviceOpen means the device is open and attached.
+	DeviceOpen DeviceState = iota
+	// DeviceClosed means the device was closed through Device.Close.
+	DeviceClosed
+	// DeviceDisconnected means the device is open, but it was
+	// physically disconnected. It needs to be closed and, once reattached,
+	// opened again.
+	DeviceDisconnected
+)
+
+var deviceStateDescription = map[DeviceState]string{
+	DeviceOpen:         "open",
+	DeviceClosed:       "closed",
+	DeviceDisconnected: "disconnected",
+}
+
+// String returns a human-readable device state.
+func (s DeviceState) String() string {
+	return deviceStateDescription[s]
+}
+
+// IsOpen returns true if the device was not closed yet. IsOpen only checks
+// the state kept by gousb and doesn't communicate with the device, use
+// State to detect a device that was disconnected while open.
+func (d *Device) IsOpen() bool {
+	return d.handle != nil
+}
+
+// USBVersion returns the USB specification release the device complies
+// with, decoded from bcdUSB of its device descriptor, e.g. 2.10 for a
+// bcdUSB of 0x0210. The version tells what the device is capable of, not
+// how it's connected: a USB 3.2 device plugged into a USB 2.0 port still
+// reports 3.20, see the Speed of DeviceDesc for the negotiated link speed.
+// Devices of version 2.10 or newer have a BOS descriptor, see
+// GetBOSDescriptor, which lists their SuperSpeed and other capabilities.
+func (d *Device) USBVersion() BCD {
+	return d.Desc.Spec
+}
+
+// State returns the state of the device. For an open device, State probes
+// the device with a lightweight query of its active configuration to
+// distinguish an attached device from a disconnected one. State returns
+// an error if the probe fails for any other reason.
+func (d *Device) State() (DeviceState, error) {
+	if d.handle == nil {
+		return DeviceClosed, nil
+	}
+	if _, err := d.ctx.libusb.getConfig(d.handle); err == ErrorNoDevice {
+		return DeviceDisconnected, nil
+	} else if err != nil {
+		return DeviceOpen, fmt.Errorf("failed to probe the device %s: %v", d, err)
+	}
+	return DeviceOpen, nil
+}
+
+// Clone opens a second, independent handle to the same device. The returned
+// Device has its own config and interface claims and it can be closed
+// independently of d, e.g. to let separate goroutines use different
+// interfaces of a composite device with independent lifecycles. The clone
+// starts with the ControlTimeout and auto-detach settings of d.
+// The underlying device is shared: an interface claimed through one
+// handle can't be claimed through the other one at the same time, libusb
+// reports such claims as busy. Changing the active configuration through
+// one handle also affects the other one.
+// The clone must be closed separately, before the Context.
+func (d *Device) Clone() (*Device, error) {
+	if d.handle == nil {
+		return nil, fmt.Errorf("Clone() called on %s after Close", d)
+	}
+	dev := d.ctx.libusb.getDevice(d.handle)
+	d.ctx.libusb.reference(dev)
+	handle, err := d.ctx.libusb.open(dev)
+	if err != nil {
+		d.ctx.libusb.dereference(dev)
+		return nil, fmt.Errorf("failed to open a second handle to %s: %v", d, err)
+	}
+	o := &Device{
+		handle:         handle,
+		ctx:            d.ctx,
+		dev:            dev,
+		Desc:           d.Desc,
+		ControlTimeout: d.ControlTimeout,
+		autodetach:     d.autodetach,
+	}
+	d.ctx.mu.Lock()
+	d.ctx.devices[o] = true
+	d.ctx.mu.Unlock()
+	return o, nil
+}
+
 // Reset performs a USB port reset to reinitialize a device.
 func (d *Device) Reset() error {
 	if d.handle == nil {
@@ -114,20 +262,102 @@ func (d *Device) Reset() error {
 	if d.claimed != nil {
 		return fmt.Errorf("can't reset device %s while it has an active configuration %s", d, d.claimed)
 	}
+	d.activeCfg = nil
 	return d.ctx.libusb.reset(d.handle)
 }
 
-// ActiveConfigNum returns the config id of the active configuration.
+// ActiveConfigNum returns the config id of the active configuration, the
+// bConfigurationValue reported by libusb_get_configuration. Unlike
+// ActiveConfig, it doesn't look up the configuration descriptor.
 // The value corresponds to the ConfigInfo.Config field of one of the
 // ConfigInfos of this Device.
 func (d *Device) ActiveConfigNum() (int, error) {
 	if d.handle == nil {
-		return 0, fmt.Errorf("ActiveConfig() called on %s after Close", d)
+		return 0, fmt.Errorf("ActiveConfigNum() called on %s after Close", d)
 	}
 	ret, err := d.ctx.libusb.getConfig(d.handle)
 	return int(ret), err
 }
 
+// ActiveConfig returns the descriptor of the active configuration, with all
+// its interfaces, alternate settings and endpoints. The descriptor is looked
+// up on the first call and cached afterwards, the cache is invalidated when
+// gousb changes the active configuration (see Config) or resets the device.
+// Changes of the configuration made outside of gousb are not detected.
+// ActiveConfig returns an error if the device is not configured, i.e. its
+// active configuration value is 0.
+func (d *Device) ActiveConfig() (*ConfigDesc, error) {
+	if d.handle == nil {
+		return nil, fmt.Errorf("ActiveConfig() called on %s after Close", d)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.activeCfg == nil {
+		cfgNum, err := d.ctx.libusb.getConfig(d.handle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query active config of the device %s: %v", d, err)
+		}
+		if cfgNum == 0 {
+			return nil, fmt.Errorf("device %s is not configured", d)
+		}
+		desc, err := d.Desc.cfgDesc(int(cfgNum))
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %v", d, err)
+		}
+		d.activeCfg = desc
+	}
+	desc := *d.activeCfg
+	return &desc, nil
+}
+
+// Endpoints returns a flat list of the endpoints of all interfaces and
+// alternate settings of the active configuration, see ActiveConfig.
+// The endpoints are ordered by interface number, alternate setting and
+// endpoint address. An endpoint address appears once for each alternate
+// setting that defines it.
+func (d *Device) Endpoints() ([]EndpointInfo, error) {
+	cfg, err := d.ActiveConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.endpoints(), nil
+}
+
+// InterfaceClaimed returns whether the interface with the given number is
+// currently claimed by this handle through the Interface method of the
+// claimed Config. Claims made by other handles or processes are not
+// reported.
+func (d *Device) InterfaceClaimed(iface int) bool {
+	d.mu.Lock()
+	cfg := d.claimed
+	d.mu.Unlock()
+	if cfg == nil {
+		return false
+	}
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.claimed[iface]
+}
+
+// ClaimedInterfaces returns the numbers of the interfaces currently claimed
+// by this handle, in ascending order. See InterfaceClaimed.
+func (d *Device) ClaimedInterfaces() []int {
+	d.mu.Lock()
+	cfg := d.claimed
+	d.mu.Unlock()
+	if cfg == nil {
+		return nil
+	}
+	cfg.mu.Lock()
+	var ret []int
+	for num := range cfg.claimed {
+		ret = append(ret, num)
+	}
+	cfg.mu.Unlock()
+	sort.Ints(ret)
+	return ret
+}
+
 // Config returns a USB device set to use a particular config.
 // The cfgNum provided is the config id (not the index) of the configuration to
 // set, which corresponds to the ConfigInfo.Config field.
@@ -160,12 +390,16 @@ func (d *Device) Config(cfgNum int) (*Config, error) {
 	if activeCfgNum, err := d.ActiveConfigNum(); err != nil {
 		return nil, fmt.Errorf("failed to query active config of the device %s: %v", d, err)
 	} else if cfgNum != activeCfgNum {
+		d.mu.Lock()
+		d.activeCfg = nil
+		d.mu.Unlock()
 		if err := d.ctx.libusb.setConfig(d.handle, uint8(cfgNum)); err != nil {
 			return nil, fmt.Errorf("failed to set active config %d for the device %s: %v", cfgNum, d, err)
 		}
 	}
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	d.activeCfg = desc
 	d.claimed = cfg
 	return cfg, nil
 }
@@ -194,6 +428,68 @@ func (d *Device) DefaultInterface() (intf *Interface, done func(), err error) {
 	}, nil
 }
 
+// SelectConfigurationWith activates the first configuration of the device,
+// in the order of configuration values, that has an interface alternate
+// setting for which match returns true, e.g. one created with
+// MatchInterfaceClass. The configuration is claimed and set as active as
+// with Config, and must be Close()d after use. This finds the right
+// configuration of composite devices that offer their functions in
+// different configurations, without knowing the configuration values.
+// An error is returned if no configuration matches.
+func (d *Device) SelectConfigurationWith(match func(InterfaceSetting) bool) (*Config, error) {
+	if d.handle == nil {
+		return nil, fmt.Errorf("SelectConfigurationWith() called on %s after Close", d)
+	}
+	for _, num := range d.Desc.sortedConfigIds() {
+		for _, intf := range d.Desc.Configs[num].Interfaces {
+			for _, alt := range intf.AltSettings {
+				if match(alt) {
+					return d.Config(num)
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no configuration of %s has a matching interface, available configs: %v", d, d.Desc.sortedConfigIds())
+}
+
+// MatchInterfaceClass returns a matcher of interface settings with the
+// given class, subclass and protocol, for SelectConfigurationWith.
+func MatchInterfaceClass(class, subClass Class, protocol Protocol) func(InterfaceSetting) bool {
+	return func(s InterfaceSetting) bool {
+		return s.Class == class && s.SubClass == subClass && s.Protocol == protocol
+	}
+}
+
+// WithInterface claims the config cfgNum and the interface num with
+// alternate setting alt, and calls fn with the claimed interface. The
+// interface and the config are released when fn returns, even if fn
+// panics, in which case the panic is propagated after the release.
+// The returned error is the error returned by fn. If releasing the config
+// fails, its error is returned as well: wrapped around the error of fn,
+// if there was one.
+// Setting the config honors SetAutoDetach, like Config does.
+func (d *Device) WithInterface(cfgNum, num, alt int, fn func(*Interface) error) (err error) {
+	cfg, err := d.Config(cfgNum)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := cfg.Close(); cerr != nil {
+			if err != nil {
+				err = fmt.Errorf("%w (releasing %s also failed: %v)", err, cfg, cerr)
+			} else {
+				err = cerr
+			}
+		}
+	}()
+	intf, err := cfg.Interface(num, alt)
+	if err != nil {
+		return err
+	}
+	defer intf.Close()
+	return fn(intf)
+}
+
 // Control sends a control request to the device.
 func (d *Device) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
 	if d.handle == nil {
@@ -202,18 +498,298 @@ func (d *Device) Control(rType, request uint8, val, idx uint16, data []byte) (in
 	return d.ctx.libusb.control(d.handle, d.ControlTimeout, rType, request, val, idx, data)
 }
 
-// Close closes the device.
+// ControlStream sends a large payload to the device as a sequence of
+// control OUT requests of up to blockSize bytes each, as used by firmware
+// download protocols like USB DFU. Each request carries the block number,
+// starting from 0, in its wValue field. After all the data was sent, a final
+// zero-length request with the next block number signals the end of the
+// download.
+// If progress is not nil, it is called after each block with the total
+// number of payload bytes sent so far. The context is checked before each
+// block, cancelling it stops the download before the next block.
+// ControlStream returns the number of payload bytes sent.
+func (d *Device) ControlStream(ctx context.Context, rType, request uint8, idx uint16, data []byte, blockSize int, progress func(sent int)) (int, error) {
+	if blockSize <= 0 {
+		return 0, fmt.Errorf("ControlStream(): invalid block size %d, must be positive", blockSize)
+	}
+	if rType&ControlIn != 0 {
+		return 0, fmt.Errorf("ControlStream(): request type 0x%02x is not an OUT request", rType)
+	}
+	sent := 0
+	for block := 0; ; block++ {
+		if block > 0xffff {
+			return sent, fmt.Errorf("ControlStream(): payload of %d bytes needs more than 65536 blocks of %d bytes", len(data), blockSize)
+		}
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		end := sent + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		n, err := d.Control(rType, request, uint16(block), idx, data[sent:end])
+		if err != nil {
+			return sent, fmt.Errorf("ControlStream(): block %d: %v", block, err)
+		}
+		if n != end-sent {
+			return sent + n, fmt.Errorf("ControlStream(): block %d: sent %d bytes, want %d", block, n, end-sent)
+		}
+		if end == sent {
+			// zero-length block, end of download.
+			return sent, nil
+		}
+		sent = end
+		if progress != nil {
+			progress(sent)
+		}
+	}
+}
+
+// getDescriptor reads the descriptor of type dt with index idx into buf
+// using a standard GET_DESCRIPTOR request.
+func (d *Device) getDescriptor(dt DescriptorType, idx uint8, buf []byte) (int, error) {
+	return d.Control(ControlType(ControlKindStandard, ControlRecipientDevice, EndpointDirectionIn), requestGetDescriptor, uint16(dt)<<8|uint16(idx), 0, buf)
+}
+
+// DeviceQualifier is the device qualifier descriptor of a high-speed capable
+// device. It describes how the device would operate at the other speed:
+// at full speed for a device operating at high speed, and vice versa.
+type DeviceQualifier struct {
+	// Spec is the USB specification release number.
+	Spec BCD
+	// Class, SubClass and Protocol identify the device class at the other
+	// speed.
+	Class    Class
+	SubClass Class
+	Protocol Protocol
+	// MaxControlPacketSize is the max packet size of the control endpoint
+	// at the other speed.
+	MaxControlPacketSize int
+	// NumConfigs is the number of configurations at the other speed.
+	NumConfigs int
+}
+
+// GetDeviceQualifier reads the device qualifier descriptor of the device.
+// Devices that support only full speed don't have a device qualifier and
+// stall the request, GetDeviceQualifier returns an error wrapping ErrorPipe
+// in that case.
+func (d *Device) GetDeviceQualifier() (*DeviceQualifier, error) {
+	buf := make([]byte, 10)
+	n, err := d.getDescriptor(DescriptorTypeDeviceQualifier, 0, buf)
+	if err == ErrorPipe {
+		return nil, fmt.Errorf("device %s has no device qualifier descriptor, it is probably a full-speed only device: %w", d, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the device qualifier descriptor of %s: %v", d, err)
+	}
+	return parseDeviceQualifier(buf[:n])
+}
+
+func parseDeviceQualifier(b []byte) (*DeviceQualifier, error) {
+	if len(b) < 10 || int(b[0]) < 10 {
+		return nil, fmt.Errorf("device qualifier descriptor too short: %d bytes", len(b))
+	}
+	if dt := DescriptorType(b[1]); dt != DescriptorTypeDeviceQualifier {
+		return nil, fmt.Errorf("got descriptor type 0x%02x, want device qualifier (0x%02x)", uint8(dt), uint8(DescriptorTypeDeviceQualifier))
+	}
+	return &DeviceQualifier{
+		Spec:                 BCD(uint16(b[2]) | uint16(b[3])<<8),
+		Class:                Class(b[4]),
+		SubClass:             Class(b[5]),
+		Protocol:             Protocol(b[6]),
+		MaxControlPacketSize: int(b[7]),
+		NumConfigs:           int(b[8]),
+	}, nil
+}
+
+// GetHIDReportDescriptor reads the report descriptor of the HID interface
+// number iface of the active configuration, using the class-specific
+// GET_DESCRIPTOR request addressed to the interface. The length of the
+// report descriptor is taken from the HID descriptor found in the extra
+// bytes of the first alternate setting of the interface.
+// The returned bytes are not parsed, the layout of the reports they describe
+// is defined by the HID specification.
+func (d *Device) GetHIDReportDescriptor(iface int) ([]byte, error) {
+	cfg, err := d.ActiveConfig()
+	if err != nil {
+		return nil, err
+	}
+	var alt *InterfaceSetting
+	for _, intf := range cfg.Interfaces {
+		if intf.Number == iface && len(intf.AltSettings) > 0 {
+			alt = &intf.AltSettings[0]
+			break
+		}
+	}
+	if alt == nil {
+		return nil, fmt.Errorf("interface %d not found in the active config %d of %s", iface, cfg.Number, d)
+	}
+	l, err := hidReportLength(alt.Extra)
+	if err != nil {
+		return nil, fmt.Errorf("interface %d of %s: %v", iface, d, err)
+	}
+	buf := make([]byte, l)
+	n, err := d.Control(ControlType(ControlKindStandard, ControlRecipientInterface, EndpointDirectionIn), requestGetDescriptor, uint16(DescriptorTypeReport)<<8, uint16(iface), buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the HID report descriptor of interface %d of %s: %v", iface, d, err)
+	}
+	return buf[:n], nil
+}
+
+// hidReportLength returns the length of the first report descriptor listed
+// in the HID descriptor contained in extra.
+func hidReportLength(extra []byte) (int, error) {
+	descs, _ := splitDescriptors(extra)
+	for _, d := range descs {
+		if DescriptorType(d[1]) != DescriptorTypeHID {
+			continue
+		}
+		// bLength, bDescriptorType, bcdHID, bCountryCode, bNumDescriptors,
+		// followed by bNumDescriptors (bDescriptorType, wDescriptorLength).
+		if len(d) < 6 {
+			return 0, fmt.Errorf("HID descriptor too short: %d bytes", len(d))
+		}
+		num := int(d[5])
+		for i := 0; i < num && 6+3*i+3 <= len(d); i++ {
+			sub := d[6+3*i:]
+			if DescriptorType(sub[0]) == DescriptorTypeReport {
+				return int(sub[1]) | int(sub[2])<<8, nil
+			}
+		}
+		return 0, errors.New("HID descriptor lists no report descriptor")
+	}
+	return 0, errors.New("no HID descriptor found, not a HID interface")
+}
+
+// RemoteWakeupEnabled returns true if the remote wakeup function of the
+// device is enabled, as reported by a standard GET_STATUS request.
+// Remote wakeup can be enabled only on devices that declare support for it,
+// see ConfigDesc.RemoteWakeup.
+func (d *Device) RemoteWakeupEnabled() (bool, error) {
+	status := make([]byte, 2)
+	n, err := d.Control(ControlType(ControlKindStandard, ControlRecipientDevice, EndpointDirectionIn), requestGetStatus, 0, 0, status)
+	if err != nil {
+		return false, fmt.Errorf("GET_STATUS on %s failed: %v", d, err)
+	}
+	if n != len(status) {
+		return false, fmt.Errorf("GET_STATUS on %s returned %d bytes, want %d", d, n, len(status))
+	}
+	return status[0]&statusRemoteWakeup != 0, nil
+}
+
+// SetRemoteWakeup enables or disables the remote wakeup function of
+// the device using a standard SET_FEATURE or CLEAR_FEATURE request with
+// the DEVICE_REMOTE_WAKEUP feature selector.
+func (d *Device) SetRemoteWakeup(enable bool) error {
+	set := d.ClearFeature
+	if enable {
+		set = d.SetFeature
+	}
+	if err := set(ControlRecipientDevice, FeatureDeviceRemoteWakeup, 0); err != nil {
+		return fmt.Errorf("SetRemoteWakeup(%v): %v", enable, err)
+	}
+	return nil
+}
+
+// SetFeature sends a standard SET_FEATURE request with the feature
+// selector to the recipient identified by index: 0 for the device, the
+// interface number for an interface and the endpoint address for an
+// endpoint. For FeatureTestMode, the high byte of index is the test
+// selector. SetFeature(ControlRecipientEndpoint, FeatureEndpointHalt, addr)
+// stalls an endpoint, e.g. to test the error handling of the host side.
+func (d *Device) SetFeature(recipient ControlRecipient, selector FeatureSelector, index uint16) error {
+	return d.featureRequest(requestSetFeature, "SET_FEATURE", recipient, selector, index)
+}
+
+// ClearFeature sends a standard CLEAR_FEATURE request with the feature
+// selector to the recipient identified by index, see SetFeature.
+func (d *Device) ClearFeature(recipient ControlRecipient, selector FeatureSelector, index uint16) error {
+	return d.featureRequest(requestClearFeature, "CLEAR_FEATURE", recipient, selector, index)
+}
+
+// ClearHalt clears the halt condition of the endpoint with the given
+// address, resuming the endpoint after a stall. Besides sending the
+// standard CLEAR_FEATURE(ENDPOINT_HALT) request, which resets the data
+// toggle of the device, libusb resets the data toggle of the host, so that
+// both sides stay in sync. Use ClearFeature to send the raw request only.
+func (d *Device) ClearHalt(addr EndpointAddress) error {
+	if d.handle == nil {
+		return fmt.Errorf("ClearHalt(%s) called on %s after Close", addr, d)
+	}
+	if err := d.ctx.libusb.clearHalt(d.handle, uint8(addr)); err != nil {
+		return fmt.Errorf("failed to clear the halt of endpoint %s on %s: %w", addr, d, err)
+	}
+	return nil
+}
+
+func (d *Device) featureRequest(req uint8, name string, recipient ControlRecipient, selector FeatureSelector, index uint16) error {
+	switch recipient {
+	case ControlRecipientDevice, ControlRecipientInterface, ControlRecipientEndpoint:
+	default:
+		return fmt.Errorf("%s(%s) on %s: invalid recipient %s for a standard request", name, selector, d, recipient)
+	}
+	if _, err := d.Control(ControlType(ControlKindStandard, recipient, EndpointDirectionOut), req, uint16(selector), index, nil); err != nil {
+		return fmt.Errorf("%s(%s) for %s %d on %s failed: %v", name, selector, recipient, index, d, err)
+	}
+	return nil
+}
+
+// Close closes the device. All transfers of the device in flight, including
+// the transfers of streams, are cancelled and Close waits for them to
+// finish. The transfers and streams of the device return ErrDeviceClosed
+// afterwards, but they are not freed: Transfer.Free and the Close of
+// streams still need to be called to release their memory. Interfaces that
+// are still claimed are released, which reattaches the kernel drivers if
+// auto-detach was enabled, see SetAutoDetach. Configs and interfaces of
+// the device can still be closed afterwards, without effect.
+// Close is idempotent. Errors encountered while tearing down the device are
+// returned together, errors.Is and errors.As match any of them, and the
+// device is closed regardless.
 func (d *Device) Close() error {
 	if d.handle == nil {
 		return nil
 	}
+	errs := d.ctx.closeTransfers(&d.state)
+
 	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.claimed != nil {
-		return fmt.Errorf("can't release the device %s, it has an open config %d", d, d.claimed.Desc.Number)
+	cfg := d.claimed
+	d.claimed = nil
+	d.activeCfg = nil
+	d.mu.Unlock()
+	if cfg != nil {
+		cfg.mu.Lock()
+		for num := range cfg.claimed {
+			// the interfaces of an unplugged device are gone already.
+			if err := d.ctx.libusb.release(d.handle, uint8(num)); err != nil && err != ErrorNoDevice {
+				errs = append(errs, fmt.Errorf("failed to release interface %d: %w", num, err))
+			}
+			delete(cfg.claimed, num)
+		}
+		cfg.mu.Unlock()
 	}
+
 	d.ctx.closeDev(d)
 	d.handle = nil
+	if len(errs) > 0 {
+		return fmt.Errorf("errors while closing the device %s: %w", d, errorList(errs))
+	}
+	return nil
+}
+
+// CancelAllTransfers cancels all transfers of the device that are in
+// flight, on all endpoints and streams, e.g. to stop a misbehaving device
+// at once. The transfers are cancelled asynchronously: their waits, as well
+// as the reads and writes of the streams they belong to, return
+// TransferCancelled. Transfers submitted after CancelAllTransfers returns
+// are not affected, use Close to stop the device for good.
+// Control transfers, which are synchronous, are not cancelled.
+func (d *Device) CancelAllTransfers() error {
+	if d.handle == nil {
+		return fmt.Errorf("CancelAllTransfers() called on %s after Close", d)
+	}
+	if errs := d.ctx.cancelDeviceTransfers(&d.state); len(errs) > 0 {
+		return fmt.Errorf("failed to cancel transfers of %s: %v", d, errs)
+	}
 	return nil
 }
 
@@ -275,6 +851,81 @@ func (d *Device) InterfaceDescription(cfgNum, intfNum, altNum int) (string, erro
 	return d.GetStringDescriptor(alt.iInterface)
 }
 
+// stringDescriptor reads the string descriptor with index descIndex in the
+// language langID and decodes it from UTF-16. A langID of 0 selects the first
+// language supported by the device.
+func (d *Device) stringDescriptor(descIndex int, langID uint16) (string, error) {
+	if d.handle == nil {
+		return "", fmt.Errorf("reading string descriptor %d of %s after Close", descIndex, d)
+	}
+	// string descriptor index value of 0 indicates no string descriptor.
+	if descIndex == 0 {
+		return "", nil
+	}
+	if descIndex < 0 || descIndex > 0xff {
+		return "", fmt.Errorf("invalid string descriptor index %d", descIndex)
+	}
+	if langID == 0 {
+		langs, err := d.stringLanguages()
+		if err != nil {
+			return "", err
+		}
+		if len(langs) == 0 {
+			return "", fmt.Errorf("%s does not report any string descriptor languages", d)
+		}
+		langID = langs[0]
+	}
+	buf := make([]byte, 0xff)
+	n, err := d.Control(ControlType(ControlKindStandard, ControlRecipientDevice, EndpointDirectionIn), requestGetDescriptor, uint16(DescriptorTypeString)<<8|uint16(descIndex), langID, buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read string descriptor %d in language %#04x of %s: %w", descIndex, langID, d, err)
+	}
+	b, err := stringDescriptorData(buf[:n])
+	if err != nil {
+		return "", fmt.Errorf("string descriptor %d in language %#04x of %s: %v", descIndex, langID, d, err)
+	}
+	u := make([]uint16, len(b)/2)
+	for j := range u {
+		u[j] = uint16(b[2*j]) | uint16(b[2*j+1])<<8
+	}
+	return string(utf16.Decode(u)), nil
+}
+
+// stringLanguages reads the LANGID codes supported by the device from the
+// string descriptor with index 0.
+func (d *Device) stringLanguages() ([]uint16, error) {
+	buf := make([]byte, 0xff)
+	n, err := d.getDescriptor(DescriptorTypeString, 0, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the string languages of %s: %w", d, err)
+	}
+	b, err := stringDescriptorData(buf[:n])
+	if err != nil {
+		return nil, fmt.Errorf("string languages of %s: %v", d, err)
+	}
+	langs := make([]uint16, len(b)/2)
+	for j := range langs {
+		langs[j] = uint16(b[2*j]) | uint16(b[2*j+1])<<8
+	}
+	return langs, nil
+}
+
+// stringDescriptorData validates the header of a string descriptor and
+// returns its payload.
+func stringDescriptorData(b []byte) ([]byte, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("descriptor too short: got %d bytes, want at least 2", len(b))
+	}
+	if dt := DescriptorType(b[1]); dt != DescriptorTypeString {
+		return nil, fmt.Errorf("got descriptor type %s, want %s", dt, DescriptorTypeString)
+	}
+	l := int(b[0])
+	if l < 2 || l > len(b) || l%2 != 0 {
+		return nil, fmt.Errorf("invalid descriptor length %d, read %d bytes", l, len(b))
+	}
+	return b[2:l], nil
+}
+
 // SetAutoDetach enables/disables automatic kernel driver detachment.
 // When autodetach is enabled gousb will automatically detach the kernel driver
 // on the interface and reattach it when releasing the interface.
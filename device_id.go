@@ -0,0 +1,254 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DeviceID identifies a device across reconnects and restarts of the
+// application, by the location the device is plugged into and by its
+// identity. Unlike the device address, which changes every time the device
+// is enumerated, a DeviceID stays the same as long as the device is
+// connected to the same port, which makes it suitable for storing a device
+// choice in a configuration file.
+type DeviceID struct {
+	// Bus is the bus on which the device is connected.
+	Bus int
+	// Path are the port numbers from the root hub to the device, see
+	// DeviceDesc.Path. Path is empty for root hubs.
+	Path []int
+	// Vendor and Product are the vendor and product IDs of the device.
+	Vendor  ID
+	Product ID
+	// Serial is the serial number of the device. It's empty if the device
+	// doesn't report one, or if it's not known, e.g. in an ID from
+	// DeviceDesc.ID. An empty Serial matches devices with any serial number.
+	Serial string
+}
+
+// String returns the ID in the form "bus-port.port...:vid:pid[:serial]",
+// e.g. "1-2.4:0bda:8153" or "3-1:1050:0407:0123456". The location part uses
+// the same format as Linux sysfs device names. The returned string can be
+// converted back with ParseDeviceID.
+func (id DeviceID) String() string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(id.Bus))
+	for i, p := range id.Path {
+		if i == 0 {
+			b.WriteByte('-')
+		} else {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.Itoa(p))
+	}
+	fmt.Fprintf(&b, ":%s:%s", id.Vendor, id.Product)
+	if id.Serial != "" {
+		b.WriteByte(':')
+		b.WriteString(id.Serial)
+	}
+	return b.String()
+}
+
+// ParseDeviceID parses a device ID in the format returned by
+// DeviceID.String. Everything after the product ID is the serial number,
+// which may itself contain colons.
+func ParseDeviceID(s string) (DeviceID, error) {
+	var id DeviceID
+	parts := strings.SplitN(s, ":", 4)
+	if len(parts) < 3 {
+		return id, fmt.Errorf("invalid device ID %q, want bus-port.port...:vid:pid[:serial]", s)
+	}
+	loc := parts[0]
+	bus, ports := loc, ""
+	if i := strings.IndexByte(loc, '-'); i >= 0 {
+		bus, ports = loc[:i], loc[i+1:]
+		if ports == "" {
+			return id, fmt.Errorf("invalid device ID %q: empty port path", s)
+		}
+	}
+	var err error
+	if id.Bus, err = parseIDNumber(bus, 10, 8); err != nil {
+		return id, fmt.Errorf("invalid bus number in device ID %q: %v", s, err)
+	}
+	if ports != "" {
+		for _, p := range strings.Split(ports, ".") {
+			n, err := parseIDNumber(p, 10, 8)
+			if err != nil {
+				return id, fmt.Errorf("invalid port number in device ID %q: %v", s, err)
+			}
+			id.Path = append(id.Path, n)
+		}
+	}
+	vid, err := parseIDNumber(parts[1], 16, 16)
+	if err != nil {
+		return id, fmt.Errorf("invalid vendor ID in device ID %q: %v", s, err)
+	}
+	pid, err := parseIDNumber(parts[2], 16, 16)
+	if err != nil {
+		return id, fmt.Errorf("invalid product ID in device ID %q: %v", s, err)
+	}
+	id.Vendor, id.Product = ID(vid), ID(pid)
+	if len(parts) == 4 {
+		if parts[3] == "" {
+			return id, fmt.Errorf("invalid device ID %q: empty serial number", s)
+		}
+		id.Serial = parts[3]
+	}
+	return id, nil
+}
+
+// parseIDNumber parses an unsigned number of one of the DeviceID fields.
+func parseIDNumber(s string, base, bits int) (int, error) {
+	if s == "" {
+		return 0, errors.New("empty number")
+	}
+	n, err := strconv.ParseUint(s, base, bits)
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// matches returns true if the descriptor describes a device at the location
+// and with the vendor and product of the ID. The serial number is not
+// checked, since it can be read only from an opened device.
+func (id DeviceID) matches(desc *DeviceDesc) bool {
+	if desc.Bus != id.Bus || desc.Vendor != id.Vendor || desc.Product != id.Product || len(desc.Path) != len(id.Path) {
+		return false
+	}
+	for i, p := range id.Path {
+		if desc.Path[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// ID returns the DeviceID of the described device. The serial number is
+// left empty, use Device.ID to include it.
+func (d *DeviceDesc) ID() DeviceID {
+	return DeviceID{
+		Bus:     d.Bus,
+		Path:    append([]int(nil), d.Path...),
+		Vendor:  d.Vendor,
+		Product: d.Product,
+	}
+}
+
+// ID returns the DeviceID of the device, including its serial number, if
+// the device has one.
+func (d *Device) ID() (DeviceID, error) {
+	id := d.Desc.ID()
+	if d.Desc.iSerialNumber == 0 {
+		return id, nil
+	}
+	serial, err := d.SerialNumber()
+	if err != nil {
+		return id, fmt.Errorf("failed to read the serial number of %s: %v", d, err)
+	}
+	id.Serial = serial
+	return id, nil
+}
+
+// OpenByID opens the device identified by id, e.g. an ID stored with
+// DeviceID.String and read back with ParseDeviceID. If id has a serial
+// number, the device at the location of the ID must report the same serial
+// number. If no such device is connected, OpenByID returns an error
+// wrapping ErrDeviceNotFound. A returned Device must be closed.
+func (c *Context) OpenByID(id DeviceID) (*Device, error) {
+	dev, err := c.OpenDeviceWithMatcher(id.matches)
+	if err != nil {
+		return nil, fmt.Errorf("device %s: %w", id, err)
+	}
+	if id.Serial == "" {
+		return dev, nil
+	}
+	got, err := dev.ID()
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	if got.Serial != id.Serial {
+		dev.Close()
+		return nil, fmt.Errorf("device %s: %w, found serial number %q at its location", id, ErrDeviceNotFound, got.Serial)
+	}
+	return dev, nil
+}
+
+// usbfsRoots are the directories in which Linux creates the usbfs device
+// nodes, /dev/bus/usb/BBB/DDD, or /proc/bus/usb/BBB/DDD on old kernels.
+var usbfsRoots = []string{"/dev/bus/usb", "/proc/bus/usb"}
+
+// parseDevicePath returns the bus number and device address encoded in a
+// usbfs device node path, e.g. "/dev/bus/usb/001/005". Relative paths
+// consisting only of the bus and address, e.g. "001/005", are accepted as
+// well.
+func parseDevicePath(p string) (bus, addr int, err error) {
+	clean := path.Clean(p)
+	dir, addrStr := path.Split(clean)
+	dir = path.Clean(dir)
+	root, busStr := path.Split(dir)
+	root = path.Clean(root)
+	if path.IsAbs(clean) {
+		found := false
+		for _, r := range usbfsRoots {
+			if root == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, 0, fmt.Errorf("invalid device path %q, want %s/BBB/DDD", p, usbfsRoots[0])
+		}
+	} else if root != "." {
+		return 0, 0, fmt.Errorf("invalid device path %q, want %s/BBB/DDD or BBB/DDD", p, usbfsRoots[0])
+	}
+	if bus, err = parseIDNumber(busStr, 10, 8); err != nil {
+		return 0, 0, fmt.Errorf("invalid bus number in device path %q: %v", p, err)
+	}
+	if addr, err = parseIDNumber(addrStr, 10, 8); err != nil {
+		return 0, 0, fmt.Errorf("invalid device address in device path %q: %v", p, err)
+	}
+	if bus == 0 || addr == 0 || addr > 127 {
+		return 0, 0, fmt.Errorf("invalid device path %q: bus %d, address %d out of range", p, bus, addr)
+	}
+	return bus, addr, nil
+}
+
+// OpenDevicePath opens the device with the Linux usbfs device node p, e.g.
+// "/dev/bus/usb/001/005" as passed by udev in DEVNAME. The bus number and
+// address are parsed from the path and matched against the enumerated
+// devices, the device node itself isn't accessed. If no device with that
+// bus and address is connected, OpenDevicePath returns an error wrapping
+// ErrDeviceNotFound. A returned Device must be closed.
+func (c *Context) OpenDevicePath(p string) (*Device, error) {
+	bus, addr, err := parseDevicePath(p)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := c.OpenDeviceWithMatcher(func(desc *DeviceDesc) bool {
+		return desc.Bus == bus && desc.Address == addr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("device path %s (bus %d, address %d): %w", p, bus, addr, err)
+	}
+	return dev, nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDeviceIDString(t *testing.T) {
+	for _, tc := range []struct {
+		id   DeviceID
+		want string
+	}{
+		{
+			id:   DeviceID{Bus: 1, Path: []int{2, 4}, Vendor: 0x0bda, Product: 0x8153},
+			want: "1-2.4:0bda:8153",
+		},
+		{
+			id:   DeviceID{Bus: 3, Path: []int{1}, Vendor: 0x1050, Product: 0x0407, Serial: "0123456"},
+			want: "3-1:1050:0407:0123456",
+		},
+		{
+			id:   DeviceID{Bus: 2, Vendor: 0x1d6b, Product: 0x0003},
+			want: "2:1d6b:0003",
+		},
+		{
+			id:   DeviceID{Bus: 1, Path: []int{1, 2, 3, 4, 5, 6, 7}, Vendor: 0xffff, Product: 0x0001, Serial: "a:b:c"},
+			want: "1-1.2.3.4.5.6.7:ffff:0001:a:b:c",
+		},
+	} {
+		got := tc.id.String()
+		if got != tc.want {
+			t.Errorf("%#v.String(): got %q, want %q", tc.id, got, tc.want)
+		}
+		parsed, err := ParseDeviceID(got)
+		if err != nil {
+			t.Errorf("ParseDeviceID(%q): %v", got, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, tc.id) {
+			t.Errorf("ParseDeviceID(%q): got %#v, want %#v", got, parsed, tc.id)
+		}
+	}
+}
+
+func TestParseDeviceIDErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"1-2",
+		"1-2:0bda",
+		"x-2:0bda:8153",
+		"1-:0bda:8153",
+		"1-2.:0bda:8153",
+		"1-2.x:0bda:8153",
+		"1-2:0bda:81530",
+		"1-2:zzzz:8153",
+		"1-2:0bda:8153:",
+		"256-1:0bda:8153",
+	} {
+		if id, err := ParseDeviceID(s); err == nil {
+			t.Errorf("ParseDeviceID(%q): got %#v, want error", s, id)
+		}
+	}
+}
+
+func TestOpenByID(t *testing.T) {
+	t.Parallel()
+	ctx := newContextWithImpl(newFakeLibusb())
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		id          string
+		wantProduct ID
+		wantErr     error
+	}{
+		{id: "1-1:9999:0001", wantProduct: 0x0001},
+		{id: "1-2:8888:0002", wantProduct: 0x0002},
+		{id: "1-2:8888:0002:01234567", wantProduct: 0x0002},
+		{id: "1-2:8888:0002:76543210", wantErr: ErrDeviceNotFound},
+		{id: "1-3:8888:0002", wantErr: ErrDeviceNotFound},
+		{id: "1-2.1:8888:0002", wantErr: ErrDeviceNotFound},
+		{id: "2-2:8888:0002", wantErr: ErrDeviceNotFound},
+	} {
+		id, err := ParseDeviceID(tc.id)
+		if err != nil {
+			t.Fatalf("ParseDeviceID(%q): %v", tc.id, err)
+		}
+		dev, err := ctx.OpenByID(id)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("OpenByID(%s): got error %v, want %v", id, err, tc.wantErr)
+			}
+			if dev != nil {
+				dev.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("OpenByID(%s): %v", id, err)
+			continue
+		}
+		if dev.Desc.Product != tc.wantProduct {
+			t.Errorf("OpenByID(%s): opened %s, want product %s", id, dev, tc.wantProduct)
+		}
+		dev.Close()
+	}
+}
+
+func TestDeviceIDOfDevice(t *testing.T) {
+	t.Parallel()
+	ctx := newContextWithImpl(newFakeLibusb())
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		vid, pid ID
+		want     string
+	}{
+		{0x9999, 0x0001, "1-1:9999:0001"},
+		{0x8888, 0x0002, "1-2:8888:0002:01234567"},
+	} {
+		dev, err := ctx.OpenDeviceWithVIDPID(tc.vid, tc.pid)
+		if err != nil || dev == nil {
+			t.Fatalf("OpenDeviceWithVIDPID(%s, %s): %v, %v", tc.vid, tc.pid, dev, err)
+		}
+		id, err := dev.ID()
+		if err != nil {
+			t.Errorf("%s.ID(): %v", dev, err)
+		} else if got := id.String(); got != tc.want {
+			t.Errorf("%s.ID(): got %q, want %q", dev, got, tc.want)
+		}
+		// The ID of a device can be used to open the same device again.
+		again, err := ctx.OpenByID(id)
+		if err != nil {
+			t.Errorf("OpenByID(%s): %v", id, err)
+		} else {
+			again.Close()
+		}
+		dev.Close()
+	}
+}
+
+func TestParseDevicePath(t *testing.T) {
+	for _, tc := range []struct {
+		path      string
+		bus, addr int
+		wantErr   bool
+	}{
+		{path: "/dev/bus/usb/001/005", bus: 1, addr: 5},
+		{path: "/dev/bus/usb/3/127", bus: 3, addr: 127},
+		{path: "/dev/bus/usb//002/010/", bus: 2, addr: 10},
+		{path: "/proc/bus/usb/004/001", bus: 4, addr: 1},
+		{path: "001/005", bus: 1, addr: 5},
+		{path: "", wantErr: true},
+		{path: "/dev/bus/usb/001", wantErr: true},
+		{path: "/dev/usb/001/005", wantErr: true},
+		{path: "/sys/bus/usb/devices/1-2", wantErr: true},
+		{path: "usb/001/005", wantErr: true},
+		{path: "/dev/bus/usb/001/abc", wantErr: true},
+		{path: "/dev/bus/usb/x01/005", wantErr: true},
+		{path: "/dev/bus/usb/000/005", wantErr: true},
+		{path: "/dev/bus/usb/001/000", wantErr: true},
+		{path: "/dev/bus/usb/001/128", wantErr: true},
+		{path: "/dev/bus/usb/256/005", wantErr: true},
+	} {
+		bus, addr, err := parseDevicePath(tc.path)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseDevicePath(%q): got error %v, want error: %v", tc.path, err, tc.wantErr)
+			continue
+		}
+		if bus != tc.bus || addr != tc.addr {
+			t.Errorf("parseDevicePath(%q): got bus %d, address %d, want %d, %d", tc.path, bus, addr, tc.bus, tc.addr)
+		}
+	}
+}
+
+func TestOpenDevicePath(t *testing.T) {
+	ctx := newContextWithImpl(newFakeLibusb())
+	defer ctx.Close()
+
+	dev, err := ctx.OpenDevicePath("/dev/bus/usb/001/002")
+	if err != nil {
+		t.Fatalf("OpenDevicePath(/dev/bus/usb/001/002): %v", err)
+	}
+	defer dev.Close()
+	if got, want := dev.Desc.Product, ID(0x0002); got != want {
+		t.Errorf("OpenDevicePath(/dev/bus/usb/001/002): got product %s, want %s", got, want)
+	}
+
+	if _, err := ctx.OpenDevicePath("/dev/bus/usb/001/099"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("OpenDevicePath(/dev/bus/usb/001/099): got error %v, want %v", err, ErrDeviceNotFound)
+	}
+	if _, err := ctx.OpenDevicePath("/dev/null"); err == nil || errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("OpenDevicePath(/dev/null): got error %v, want a path parsing error", err)
+	}
+}
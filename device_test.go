@@ -15,9 +15,14 @@
 package gousb
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestClaimAndRelease(t *testing.T) {
@@ -130,22 +135,13 @@ func TestClaimAndRelease(t *testing.T) {
 	if err := cfg.Close(); err == nil {
 		t.Fatalf("%s.Close(): got nil, want non nil, because the Interface was not released.", cfg)
 	}
-	if err := dev.Close(); err == nil {
-		t.Fatalf("%s.Close(): got nil, want non nil, because the Config was not released.", cfg)
-	}
 
 	intf.Close()
 	if err := cfg.Close(); err == nil {
 		t.Fatalf("%s.Close(): got nil, want non nil, because the Interface was not released.", cfg)
 	}
-	if err := dev.Close(); err == nil {
-		t.Fatalf("%s.Close(): got nil, want non nil, because the Config was not released.", dev)
-	}
 
 	intf2.Close()
-	if err := dev.Close(); err == nil {
-		t.Fatalf("%s.Close(): got nil, want non nil, because the Config was not released.", dev)
-	}
 
 	if err := dev.Reset(); err == nil {
 		t.Fatalf("%s.Reset(): got nil, want non nil, because Device is still has an active Config.", dev)
@@ -244,3 +240,941 @@ func TestAutoDetachFailure(t *testing.T) {
 		t.Fatalf("%s.Config(1) got nil, but want no nil because interface fails to detach", dev)
 	}
 }
+
+func TestDeviceHandle(t *testing.T) {
+	t.Parallel()
+	c := newContextWithImpl(newFakeLibusb())
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close: %v", err)
+		}
+	}()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	if got, want := dev.Handle(), unsafe.Pointer(dev.handle); got != want || got == nil {
+		t.Errorf("%s.Handle(): got %p, want %p (non-nil)", dev, got, want)
+	}
+	if err := dev.Close(); err != nil {
+		t.Fatalf("%s.Close(): %v", dev, err)
+	}
+	if got := dev.Handle(); got != nil {
+		t.Errorf("%s.Handle() after Close: got %p, want nil", dev, got)
+	}
+}
+
+func TestControlStream(t *testing.T) {
+	t.Parallel()
+	const (
+		rType   = ControlOut | ControlClass | ControlInterface
+		request = 0x01 // DFU_DNLOAD
+		intf    = 2
+	)
+	payload := make([]byte, 10)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	for _, tc := range []struct {
+		desc      string
+		blockSize int
+		want      []controlRequest
+		progress  []int
+	}{
+		{
+			desc:      "last block short",
+			blockSize: 4,
+			want: []controlRequest{
+				{rType, request, 0, intf, []byte{0, 1, 2, 3}},
+				{rType, request, 1, intf, []byte{4, 5, 6, 7}},
+				{rType, request, 2, intf, []byte{8, 9}},
+				{rType, request, 3, intf, nil},
+			},
+			progress: []int{4, 8, 10},
+		},
+		{
+			desc:      "payload aligned to blocks",
+			blockSize: 5,
+			want: []controlRequest{
+				{rType, request, 0, intf, []byte{0, 1, 2, 3, 4}},
+				{rType, request, 1, intf, []byte{5, 6, 7, 8, 9}},
+				{rType, request, 2, intf, nil},
+			},
+			progress: []int{5, 10},
+		},
+	} {
+		lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+		c := newContextWithImpl(lib)
+		dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+		if err != nil {
+			t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+		}
+		var progress []int
+		n, err := dev.ControlStream(context.Background(), rType, request, intf, payload, tc.blockSize, func(sent int) {
+			progress = append(progress, sent)
+		})
+		if err != nil {
+			t.Errorf("%s: ControlStream(): %v", tc.desc, err)
+		}
+		if n != len(payload) {
+			t.Errorf("%s: ControlStream(): sent %d bytes, want %d", tc.desc, n, len(payload))
+		}
+		if got := lib.requests(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: ControlStream() requests: got %v, want %v", tc.desc, got, tc.want)
+		}
+		if !reflect.DeepEqual(progress, tc.progress) {
+			t.Errorf("%s: ControlStream() progress: got %v, want %v", tc.desc, progress, tc.progress)
+		}
+		dev.Close()
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}
+}
+
+func TestControlStreamCancel(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := dev.ControlStream(ctx, ControlOut|ControlVendor|ControlDevice, 1, 0, make([]byte, 100), 10, func(sent int) {
+		if sent == 30 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("ControlStream(): got error %v, want %v", err, context.Canceled)
+	}
+	if n != 30 {
+		t.Errorf("ControlStream(): sent %d bytes, want 30", n)
+	}
+	if got := len(lib.requests()); got != 3 {
+		t.Errorf("ControlStream(): sent %d requests, want 3", got)
+	}
+}
+
+func TestRemoteWakeup(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	enabled := false
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		switch req.request {
+		case requestGetStatus:
+			data[0], data[1] = 0x01, 0x00 // self powered
+			if enabled {
+				data[0] |= 0x02
+			}
+			return 2, nil
+		case requestSetFeature:
+			enabled = true
+		case requestClearFeature:
+			enabled = false
+		}
+		return 0, nil
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	if got, err := dev.RemoteWakeupEnabled(); err != nil || got {
+		t.Errorf("%s.RemoteWakeupEnabled(): got %v, %v, want false, nil", dev, got, err)
+	}
+	if err := dev.SetRemoteWakeup(true); err != nil {
+		t.Errorf("%s.SetRemoteWakeup(true): %v", dev, err)
+	}
+	if got, err := dev.RemoteWakeupEnabled(); err != nil || !got {
+		t.Errorf("%s.RemoteWakeupEnabled(): got %v, %v, want true, nil", dev, got, err)
+	}
+	if err := dev.SetRemoteWakeup(false); err != nil {
+		t.Errorf("%s.SetRemoteWakeup(false): %v", dev, err)
+	}
+
+	want := []controlRequest{
+		{0x80, 0x00, 0, 0, []byte{0, 0}}, // GET_STATUS
+		{0x00, 0x03, 1, 0, nil},          // SET_FEATURE(DEVICE_REMOTE_WAKEUP)
+		{0x80, 0x00, 0, 0, []byte{0, 0}}, // GET_STATUS
+		{0x00, 0x01, 1, 0, nil},          // CLEAR_FEATURE(DEVICE_REMOTE_WAKEUP)
+	}
+	if got := lib.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("control requests: got %v, want %v", got, want)
+	}
+}
+
+// configLib is a fakeLibusb with a configurable active config value that
+// counts the getConfig calls.
+type configLib struct {
+	*fakeLibusb
+
+	cfgMu    sync.Mutex
+	cfg      uint8
+	getCalls int
+}
+
+func (f *configLib) getConfig(*libusbDevHandle) (uint8, error) {
+	f.cfgMu.Lock()
+	defer f.cfgMu.Unlock()
+	f.getCalls++
+	return f.cfg, nil
+}
+
+func (f *configLib) setConfig(h *libusbDevHandle, cfg uint8) error {
+	if err := f.fakeLibusb.setConfig(h, cfg); err != nil {
+		return err
+	}
+	f.cfgMu.Lock()
+	defer f.cfgMu.Unlock()
+	f.cfg = cfg
+	return nil
+}
+
+func (f *configLib) calls() int {
+	f.cfgMu.Lock()
+	defer f.cfgMu.Unlock()
+	return f.getCalls
+}
+
+func TestActiveConfig(t *testing.T) {
+	t.Parallel()
+	lib := &configLib{fakeLibusb: newFakeLibusb()}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	if desc, err := dev.ActiveConfig(); err == nil {
+		t.Errorf("%s.ActiveConfig() on an unconfigured device: got %v, want an error", dev, desc)
+	}
+
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+	calls := lib.calls()
+	for i := 0; i < 3; i++ {
+		desc, err := dev.ActiveConfig()
+		if err != nil {
+			t.Fatalf("%s.ActiveConfig(): %v", dev, err)
+		}
+		if want := dev.Desc.Configs[1]; !reflect.DeepEqual(*desc, want) {
+			t.Errorf("%s.ActiveConfig(): got %+v, want %+v", dev, *desc, want)
+		}
+	}
+	if got := lib.calls(); got != calls {
+		t.Errorf("getConfig calls during ActiveConfig(): got %d, want the cached config to be used", got-calls)
+	}
+}
+
+// disconnectLib is a fakeLibusb that reports all devices as disconnected
+// once disconnected is set.
+type disconnectLib struct {
+	*fakeLibusb
+	disconnected bool
+}
+
+func (f *disconnectLib) getConfig(h *libusbDevHandle) (uint8, error) {
+	if f.disconnected {
+		return 0, ErrorNoDevice
+	}
+	return f.fakeLibusb.getConfig(h)
+}
+
+func TestDeviceState(t *testing.T) {
+	t.Parallel()
+	lib := &disconnectLib{fakeLibusb: newFakeLibusb()}
+	c := newContextWithImpl(lib)
+	if !c.IsAlive() {
+		t.Error("Context.IsAlive(): got false, want true")
+	}
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+
+	check := func(desc string, wantOpen bool, wantState DeviceState) {
+		t.Helper()
+		if got := dev.IsOpen(); got != wantOpen {
+			t.Errorf("%s: %s.IsOpen(): got %v, want %v", desc, dev, got, wantOpen)
+		}
+		if got, err := dev.State(); err != nil || got != wantState {
+			t.Errorf("%s: %s.State(): got %s, %v, want %s, nil", desc, dev, got, err, wantState)
+		}
+	}
+	check("attached device", true, DeviceOpen)
+	lib.disconnected = true
+	check("disconnected device", true, DeviceDisconnected)
+	dev.Close()
+	check("closed device", false, DeviceClosed)
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Context.Close(): %v", err)
+	}
+	if c.IsAlive() {
+		t.Error("Context.IsAlive() after Close: got true, want false")
+	}
+}
+
+func TestWithInterface(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x8888, 0x0002)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x8888, 0x0002): %v", err)
+	}
+	defer dev.Close()
+
+	claimed := func() bool {
+		lib.mu.Lock()
+		defer lib.mu.Unlock()
+		for _, c := range lib.claims {
+			for _, v := range c {
+				if v {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	var got *Interface
+	if err := dev.WithInterface(1, 0, 0, func(intf *Interface) error {
+		got = intf
+		if !claimed() {
+			t.Error("interface not claimed while the callback is running")
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("WithInterface(): %v", err)
+	}
+	if got == nil || got.Setting.Number != 0 {
+		t.Errorf("WithInterface(): callback got interface %v, want interface 0", got)
+	}
+
+	errFn := errors.New("callback failed")
+	if err := dev.WithInterface(1, 0, 0, func(*Interface) error { return errFn }); err != errFn {
+		t.Errorf("WithInterface() with a failing callback: got error %v, want %v", err, errFn)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("WithInterface() with a panicking callback: recovered %v, want boom", r)
+			}
+		}()
+		dev.WithInterface(1, 0, 0, func(*Interface) error { panic("boom") })
+	}()
+
+	if err := dev.WithInterface(1, 5, 0, func(*Interface) error { return nil }); err == nil {
+		t.Error("WithInterface() with a non-existent interface: got nil error, want non-nil")
+	}
+
+	if claimed() {
+		t.Error("interfaces still claimed after WithInterface() returned")
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.claimed != nil {
+		t.Errorf("config %s still claimed after WithInterface() returned", dev.claimed)
+	}
+}
+
+func TestGetDeviceQualifier(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	stall := false
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		if stall {
+			return 0, ErrorPipe
+		}
+		return copy(data, []byte{0x0a, 0x06, 0x00, 0x02, 0xef, 0x02, 0x01, 0x40, 0x01, 0x00}), nil
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	got, err := dev.GetDeviceQualifier()
+	if err != nil {
+		t.Fatalf("%s.GetDeviceQualifier(): %v", dev, err)
+	}
+	want := &DeviceQualifier{
+		Spec:                 Version(2, 0),
+		Class:                ClassMiscellaneous,
+		SubClass:             2,
+		Protocol:             1,
+		MaxControlPacketSize: 64,
+		NumConfigs:           1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s.GetDeviceQualifier(): got %+v, want %+v", dev, got, want)
+	}
+	wantReq := controlRequest{0x80, 0x06, 0x0600, 0, make([]byte, 10)}
+	if reqs := lib.requests(); len(reqs) != 1 || !reflect.DeepEqual(reqs[0], wantReq) {
+		t.Errorf("control requests: got %v, want [%v]", reqs, wantReq)
+	}
+
+	stall = true
+	if _, err := dev.GetDeviceQualifier(); !errors.Is(err, ErrorPipe) {
+		t.Errorf("%s.GetDeviceQualifier() on a full-speed device: got error %v, want an error wrapping %v", dev, err, ErrorPipe)
+	}
+}
+
+func TestDeviceUSBVersion(t *testing.T) {
+	for _, tc := range []struct {
+		bcdUSB       BCD
+		major, minor uint8
+		str          string
+		bos          bool
+	}{
+		{0x0110, 1, 10, "1.10", false},
+		{0x0200, 2, 0, "2.00", false},
+		{0x0201, 2, 1, "2.01", false},
+		{0x0210, 2, 10, "2.10", true},
+		{0x0300, 3, 0, "3.00", true},
+		{0x0320, 3, 20, "3.20", true},
+	} {
+		d := &Device{Desc: &DeviceDesc{Spec: tc.bcdUSB}}
+		v := d.USBVersion()
+		if v.Major() != tc.major || v.Minor() != tc.minor || v.String() != tc.str {
+			t.Errorf("USBVersion() for bcdUSB %#04x: got %d, %d, %q, want %d, %d, %q", uint16(tc.bcdUSB), v.Major(), v.Minor(), v.String(), tc.major, tc.minor, tc.str)
+		}
+		if got := v >= Version(2, 10); got != tc.bos {
+			t.Errorf("USBVersion() >= 2.10 for bcdUSB %#04x: got %v, want %v", uint16(tc.bcdUSB), got, tc.bos)
+		}
+	}
+}
+
+func TestDeviceClone(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	dev, err := c.OpenDeviceWithVIDPID(0x8888, 0x0002)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x8888, 0x0002): %v", err)
+	}
+	dev.ControlTimeout = time.Second
+	clone, err := dev.Clone()
+	if err != nil {
+		t.Fatalf("%s.Clone(): %v", dev, err)
+	}
+	if clone.handle == dev.handle {
+		t.Errorf("%s.Clone(): got the same libusb handle %p, want a new one", dev, clone.handle)
+	}
+	if got, want := lib.getDevice(clone.handle), lib.getDevice(dev.handle); got != want {
+		t.Errorf("%s.Clone(): libusb device %p, want %p", dev, got, want)
+	}
+	if clone.Desc != dev.Desc || clone.ControlTimeout != dev.ControlTimeout {
+		t.Errorf("%s.Clone(): got desc %v, timeout %v, want %v, %v", dev, clone.Desc, clone.ControlTimeout, dev.Desc, dev.ControlTimeout)
+	}
+
+	// Use different interfaces through the two handles at the same time.
+	var wg sync.WaitGroup
+	claimed := make(chan struct{})
+	release := make(chan struct{})
+	for i, d := range []*Device{dev, clone} {
+		wg.Add(1)
+		go func(d *Device, num int) {
+			defer wg.Done()
+			if err := d.WithInterface(1, num, 0, func(intf *Interface) error {
+				claimed <- struct{}{}
+				<-release
+				return nil
+			}); err != nil {
+				t.Errorf("%s.WithInterface(1, %d, 0): %v", d, num, err)
+			}
+		}(d, i)
+	}
+	// Both interfaces are claimed before either is released.
+	<-claimed
+	<-claimed
+	close(release)
+	wg.Wait()
+
+	if err := dev.Close(); err != nil {
+		t.Errorf("%s.Close(): %v", dev, err)
+	}
+	if _, err := clone.Manufacturer(); err != nil {
+		t.Errorf("%s.Manufacturer() after closing the original device: %v", clone, err)
+	}
+	if err := clone.Close(); err != nil {
+		t.Errorf("%s.Close(): %v", clone, err)
+	}
+}
+
+func TestDeviceEndpoints(t *testing.T) {
+	t.Parallel()
+	c := newContextWithImpl(newFakeLibusb())
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x8888, 0x0002)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x8888, 0x0002): %v", err)
+	}
+	defer dev.Close()
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+
+	eps, err := dev.Endpoints()
+	if err != nil {
+		t.Fatalf("%s.Endpoints(): %v", dev, err)
+	}
+	type epID struct {
+		intf, alt     int
+		addr          EndpointAddress
+		maxPacketSize int
+	}
+	var got []epID
+	for _, ep := range eps {
+		got = append(got, epID{ep.Interface, ep.Alternate, ep.Desc.Address, ep.Desc.MaxPacketSize})
+	}
+	want := []epID{
+		{1, 0, 0x05, 3 * 1024},
+		{1, 0, 0x86, 3 * 1024},
+		{1, 1, 0x05, 2 * 1024},
+		{1, 1, 0x86, 2 * 1024},
+		{1, 2, 0x05, 1024},
+		{1, 2, 0x86, 1024},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s.Endpoints(): got %v, want %v", dev, got, want)
+	}
+}
+
+func TestInterfaceClaimed(t *testing.T) {
+	t.Parallel()
+	c := newContextWithImpl(newFakeLibusb())
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	if dev.InterfaceClaimed(0) {
+		t.Errorf("%s.InterfaceClaimed(0) before Config: got true, want false", dev)
+	}
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+	intf, err := cfg.Interface(0, 0)
+	if err != nil {
+		t.Fatalf("%s.Interface(0, 0): %v", cfg, err)
+	}
+	if !dev.InterfaceClaimed(0) {
+		t.Errorf("%s.InterfaceClaimed(0) after claim: got false, want true", dev)
+	}
+	if dev.InterfaceClaimed(1) {
+		t.Errorf("%s.InterfaceClaimed(1): got true, want false", dev)
+	}
+	if got, want := dev.ClaimedInterfaces(), []int{0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("%s.ClaimedInterfaces(): got %v, want %v", dev, got, want)
+	}
+
+	intf.Close()
+	if dev.InterfaceClaimed(0) {
+		t.Errorf("%s.InterfaceClaimed(0) after release: got true, want false", dev)
+	}
+	if got := dev.ClaimedInterfaces(); len(got) != 0 {
+		t.Errorf("%s.ClaimedInterfaces() after release: got %v, want none", dev, got)
+	}
+}
+
+func TestDeviceCloseWithActiveStreams(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	in, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	out, err := intf.OutEndpoint(1)
+	if err != nil {
+		t.Fatalf("%s.OutEndpoint(1): %v", intf, err)
+	}
+	rs, err := in.NewStream(512, 3)
+	if err != nil {
+		t.Fatalf("%s.NewStream(): %v", in, err)
+	}
+	ws, err := out.NewStream(512, 2)
+	if err != nil {
+		t.Fatalf("%s.NewStream(): %v", out, err)
+	}
+	if _, err := ws.Write(make([]byte, 512)); err != nil {
+		t.Fatalf("WriteStream.Write(): %v", err)
+	}
+	// A standalone transfer, in flight too.
+	xfer, err := in.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("%s.NewTransfer(): %v", in, err)
+	}
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		lib.waitForSubmitted(nil)
+	}
+
+	// Nothing completes the transfers, Close has to cancel them.
+	if err := dev.Close(); err != nil {
+		t.Errorf("%s.Close() with active streams: %v", dev, err)
+	}
+	if err := dev.Close(); err != nil {
+		t.Errorf("%s.Close() for the second time: %v", dev, err)
+	}
+	lib.mu.Lock()
+	for _, ft := range lib.ts {
+		ft.mu.Lock()
+		if !ft.finished {
+			t.Errorf("transfer still in flight after %s.Close()", dev)
+		}
+		ft.mu.Unlock()
+	}
+	for d, claims := range lib.claims {
+		for num, claimed := range claims {
+			if claimed {
+				t.Errorf("interface %d of device %p still claimed after Close", num, d)
+			}
+		}
+	}
+	lib.mu.Unlock()
+
+	// Everything obtained from the device fails gracefully.
+	if _, err := rs.Read(make([]byte, 512)); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("ReadStream.Read() after %s.Close(): got error %v, want %v", dev, err, ErrDeviceClosed)
+	}
+	if _, err := ws.Write(make([]byte, 512)); err == nil {
+		t.Errorf("WriteStream.Write() after %s.Close(): got nil error, want non-nil", dev)
+	}
+	if _, err := xfer.Wait(context.Background()); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Transfer.Wait() after %s.Close(): got error %v, want %v", dev, err, ErrDeviceClosed)
+	}
+	if err := xfer.Submit(); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Transfer.Submit() after %s.Close(): got error %v, want %v", dev, err, ErrDeviceClosed)
+	}
+
+	// The owners free the transfers.
+	rs.Close()
+	ws.Close()
+	xfer.Free()
+	lib.mu.Lock()
+	if n := len(lib.ts); n != 0 {
+		t.Errorf("transfers after the streams were closed: got %d, want 0", n)
+	}
+	lib.mu.Unlock()
+	done()
+}
+
+func TestDeviceCloseReleaseError(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	_, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer done()
+	lib.mu.Lock()
+	lib.releaseErr = ErrorIO
+	lib.mu.Unlock()
+	err = dev.Close()
+	if !errors.Is(err, ErrorIO) {
+		t.Errorf("%s.Close() with a failing release: got error %v, want %v", dev, err, ErrorIO)
+	}
+	var libErr Error
+	if !errors.As(err, &libErr) || libErr != ErrorIO {
+		t.Errorf("errors.As(%v, *Error): got %v, want %v", err, libErr, ErrorIO)
+	}
+	if err := dev.Close(); err != nil {
+		t.Errorf("%s.Close() for the second time: %v", dev, err)
+	}
+}
+
+func TestCancelAllTransfers(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer done()
+	in, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	out, err := intf.OutEndpoint(1)
+	if err != nil {
+		t.Fatalf("%s.OutEndpoint(1): %v", intf, err)
+	}
+	rs, err := in.NewStream(512, 2)
+	if err != nil {
+		t.Fatalf("%s.NewStream(): %v", in, err)
+	}
+	defer rs.Close()
+	xfer, err := out.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("%s.NewTransfer(): %v", out, err)
+	}
+	defer xfer.Free()
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	// A transfer that doesn't belong to the device.
+	other := &InEndpoint{newNullEndpoint(c, EndpointDirectionIn)}
+	otherXfer, err := other.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("NewTransfer(): %v", err)
+	}
+	defer otherXfer.Free()
+	if err := otherXfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	var otherFt *fakeTransfer
+	for i := 0; i < 4; i++ {
+		ft := lib.waitForSubmitted(nil)
+		if ft.ep.Address == other.Desc.Address {
+			otherFt = ft
+		}
+	}
+
+	if err := dev.CancelAllTransfers(); err != nil {
+		t.Fatalf("%s.CancelAllTransfers(): %v", dev, err)
+	}
+	if _, err := xfer.Wait(context.Background()); !errors.Is(err, TransferCancelled) {
+		t.Errorf("Transfer.Wait() after CancelAllTransfers(): got error %v, want %v", err, TransferCancelled)
+	}
+	if _, err := rs.Read(make([]byte, 512)); !errors.Is(err, TransferCancelled) {
+		t.Errorf("ReadStream.Read() after CancelAllTransfers(): got error %v, want %v", err, TransferCancelled)
+	}
+	if !otherXfer.InFlight() {
+		t.Errorf("transfer of another endpoint was cancelled by %s.CancelAllTransfers()", dev)
+	}
+	otherFt.setStatus(TransferCompleted)
+	if _, err := otherXfer.Wait(context.Background()); err != nil {
+		t.Errorf("Wait() of a transfer of another endpoint: %v", err)
+	}
+
+	// The device can be used again.
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit() after CancelAllTransfers(): %v", err)
+	}
+	ft := lib.waitForSubmitted(nil)
+	ft.setLength(512)
+	ft.setStatus(TransferCompleted)
+	if n, err := xfer.Wait(context.Background()); n != 512 || err != nil {
+		t.Errorf("Wait() after CancelAllTransfers(): got %d, %v, want 512, nil", n, err)
+	}
+}
+
+func TestSelectConfigurationWith(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	lib.fakeDevices[newDevicePointer()] = &fakeDevice{
+		devDesc: &DeviceDesc{
+			Bus:     2,
+			Address: 1,
+			Port:    1,
+			Path:    []int{1},
+			Spec:    Version(2, 0),
+			Vendor:  ID(0x5555),
+			Product: ID(0x0002),
+			Configs: map[int]ConfigDesc{
+				1: {
+					Number: 1,
+					Interfaces: []InterfaceDesc{{
+						Number:      0,
+						AltSettings: []InterfaceSetting{{Number: 0, Alternate: 0, Class: ClassVendorSpec}},
+					}},
+				},
+				2: {
+					Number: 2,
+					Interfaces: []InterfaceDesc{{
+						Number:      0,
+						AltSettings: []InterfaceSetting{{Number: 0, Alternate: 0, Class: ClassVendorSpec}},
+					}, {
+						Number:      1,
+						AltSettings: []InterfaceSetting{{Number: 1, Alternate: 0, Class: ClassComm, SubClass: 0x02, Protocol: 0x01}},
+					}},
+				},
+			},
+		},
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x5555, 0x0002)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x5555, 0x0002): %v", err)
+	}
+	defer dev.Close()
+
+	if cfg, err := dev.SelectConfigurationWith(MatchInterfaceClass(ClassAudio, 0x01, 0x00)); err == nil {
+		cfg.Close()
+		t.Errorf("%s.SelectConfigurationWith(audio): got config %d, want error", dev, cfg.Desc.Number)
+	}
+	cfg, err := dev.SelectConfigurationWith(MatchInterfaceClass(ClassComm, 0x02, 0x01))
+	if err != nil {
+		t.Fatalf("%s.SelectConfigurationWith(CDC ACM): %v", dev, err)
+	}
+	defer cfg.Close()
+	if cfg.Desc.Number != 2 {
+		t.Errorf("%s.SelectConfigurationWith(CDC ACM): got config %d, want 2", dev, cfg.Desc.Number)
+	}
+	if got, err := dev.ActiveConfigNum(); err != nil || got != 2 {
+		t.Errorf("%s.ActiveConfigNum(): got %d, %v, want 2, nil", dev, got, err)
+	}
+}
+
+func TestGetHIDReportDescriptor(t *testing.T) {
+	t.Parallel()
+	report := []byte{0x05, 0x01, 0x09, 0x06, 0xa1, 0x01, 0xc0}
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		return copy(data, report), nil
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	// Put a HID descriptor on interface 0, listing a physical descriptor
+	// before the report descriptor.
+	cfg, err := dev.ActiveConfig()
+	if err != nil {
+		t.Fatalf("%s.ActiveConfig(): %v", dev, err)
+	}
+	alt := cfg.Interfaces[0].AltSettings[0]
+	alt.Class = ClassHID
+	alt.Extra = []byte{0x0c, 0x21, 0x11, 0x01, 0x00, 0x02, 0x23, 0x10, 0x00, 0x22, byte(len(report)), 0x00}
+	cfg.Interfaces = []InterfaceDesc{{Number: 0, AltSettings: []InterfaceSetting{alt}}}
+	dev.activeCfg = cfg
+
+	got, err := dev.GetHIDReportDescriptor(0)
+	if err != nil {
+		t.Fatalf("%s.GetHIDReportDescriptor(0): %v", dev, err)
+	}
+	if !bytes.Equal(got, report) {
+		t.Errorf("%s.GetHIDReportDescriptor(0): got %x, want %x", dev, got, report)
+	}
+	wantReq := controlRequest{0x81, 0x06, 0x2200, 0, make([]byte, len(report))}
+	if reqs := lib.requests(); len(reqs) != 1 || !reflect.DeepEqual(reqs[0], wantReq) {
+		t.Errorf("control requests: got %v, want [%v]", reqs, wantReq)
+	}
+
+	if _, err := dev.GetHIDReportDescriptor(1); err == nil {
+		t.Errorf("%s.GetHIDReportDescriptor(1) for a missing interface: got nil error, want non-nil", dev)
+	}
+	alt.Extra = nil
+	cfg.Interfaces[0].AltSettings = []InterfaceSetting{alt}
+	if _, err := dev.GetHIDReportDescriptor(0); err == nil {
+		t.Errorf("%s.GetHIDReportDescriptor(0) without a HID descriptor: got nil error, want non-nil", dev)
+	}
+}
+
+func TestFeatureRequests(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	lib.reply = func(controlRequest, []byte) (int, error) { return 0, nil }
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	if err := dev.SetFeature(ControlRecipientDevice, FeatureTestMode, 0x0400); err != nil {
+		t.Errorf("SetFeature(device, TEST_MODE, 0x0400): %v", err)
+	}
+	if err := dev.SetFeature(ControlRecipientEndpoint, FeatureEndpointHalt, 0x82); err != nil {
+		t.Errorf("SetFeature(endpoint, ENDPOINT_HALT, 0x82): %v", err)
+	}
+	if err := dev.ClearHalt(0x82); err != nil {
+		t.Errorf("ClearHalt(0x82): %v", err)
+	}
+	if err := dev.ClearFeature(ControlRecipientEndpoint, FeatureEndpointHalt, 0x82); err != nil {
+		t.Errorf("ClearFeature(endpoint, ENDPOINT_HALT, 0x82): %v", err)
+	}
+	if err := dev.ClearFeature(ControlRecipientInterface, 0, 1); err != nil {
+		t.Errorf("ClearFeature(interface, 0, 1): %v", err)
+	}
+	if err := dev.SetFeature(ControlRecipientOther, FeatureEndpointHalt, 0); err == nil {
+		t.Error("SetFeature(other, ...): got nil error, want an error")
+	}
+
+	want := []controlRequest{
+		{0x00, 0x03, 2, 0x0400, nil}, // SET_FEATURE(TEST_MODE), Test_K
+		{0x02, 0x03, 0, 0x82, nil},   // SET_FEATURE(ENDPOINT_HALT)
+		{0x02, 0x01, 0, 0x82, nil},   // CLEAR_FEATURE(ENDPOINT_HALT)
+		{0x01, 0x01, 0, 1, nil},      // CLEAR_FEATURE on interface 1
+	}
+	if got := lib.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("control requests: got %v, want %v", got, want)
+	}
+
+	// ClearHalt goes through libusb, which also resets the data toggle
+	// of the host, instead of sending a raw control request.
+	lib.mu.Lock()
+	if want := []EndpointAddress{0x82}; !reflect.DeepEqual(lib.halts, want) {
+		t.Errorf("cleared halts: got %v, want %v", lib.halts, want)
+	}
+	lib.clearHaltErr = ErrorPipe
+	lib.mu.Unlock()
+	if err := dev.ClearHalt(0x82); !errors.Is(err, ErrorPipe) {
+		t.Errorf("ClearHalt(0x82) with a failing device: got error %v, want %v", err, ErrorPipe)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// HexBytes is a byte slice that is marshaled to JSON as a hexadecimal
+// string, which is easier to compare with USB protocol traces than the
+// base64 encoding used for []byte.
+type HexBytes []byte
+
+// MarshalJSON implements json.Marshaler.
+func (b HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *HexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	d, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid hex bytes %q: %v", s, err)
+	}
+	*b = d
+	return nil
+}
+
+// DescriptorDump is the complete descriptor set of a device, as returned
+// by Device.DumpDescriptors, in a form suitable for JSON.
+type DescriptorDump struct {
+	Device  DeviceDump   `json:"device"`
+	Configs []ConfigDump `json:"configs"`
+	// BOS is the raw BOS descriptor, read only from devices implementing
+	// USB 2.1 or newer.
+	BOS                  HexBytes                 `json:"bos,omitempty"`
+	PlatformCapabilities []PlatformCapabilityDump `json:"platform_capabilities,omitempty"`
+	// Errors lists the descriptors that couldn't be read, e.g. string
+	// descriptors the device failed to return. The rest of the dump is
+	// valid regardless.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// DeviceDump is the device descriptor part of a DescriptorDump.
+type DeviceDump struct {
+	Bus                  int    `json:"bus"`
+	Address              int    `json:"address"`
+	Port                 int    `json:"port"`
+	Path                 []int  `json:"path,omitempty"`
+	Speed                string `json:"speed"`
+	USBVersion           string `json:"usb_version"`
+	DeviceVersion        string `json:"device_version"`
+	Vendor               string `json:"vendor_id"`
+	Product              string `json:"product_id"`
+	Class                uint8  `json:"class"`
+	SubClass             uint8  `json:"subclass"`
+	Protocol             uint8  `json:"protocol"`
+	MaxControlPacketSize int    `json:"max_control_packet_size"`
+	ManufacturerName     string `json:"manufacturer,omitempty"`
+	ProductName          string `json:"product,omitempty"`
+	SerialNumber         string `json:"serial_number,omitempty"`
+}
+
+// ConfigDump is a configuration descriptor in a DescriptorDump.
+type ConfigDump struct {
+	Number       int             `json:"number"`
+	Description  string          `json:"description,omitempty"`
+	TotalLength  int             `json:"total_length"`
+	SelfPowered  bool            `json:"self_powered"`
+	RemoteWakeup bool            `json:"remote_wakeup"`
+	MaxPowerMA   int             `json:"max_power_ma"`
+	Interfaces   []InterfaceDump `json:"interfaces"`
+	Extra        HexBytes        `json:"extra,omitempty"`
+}
+
+// InterfaceDump is an interface in a DescriptorDump, with all of its
+// alternate settings.
+type InterfaceDump struct {
+	Number      int              `json:"number"`
+	AltSettings []AltSettingDump `json:"alt_settings"`
+}
+
+// AltSettingDump is an interface descriptor in a DescriptorDump.
+type AltSettingDump struct {
+	Alternate   int            `json:"alternate"`
+	Description string         `json:"description,omitempty"`
+	Class       uint8          `json:"class"`
+	SubClass    uint8          `json:"subclass"`
+	Protocol    uint8          `json:"protocol"`
+	Endpoints   []EndpointDump `json:"endpoints,omitempty"`
+	Extra       HexBytes       `json:"extra,omitempty"`
+}
+
+// EndpointDump is an endpoint descriptor in a DescriptorDump.
+type EndpointDump struct {
+	Address       uint8    `json:"address"`
+	Direction     string   `json:"direction"`
+	TransferType  string   `json:"transfer_type"`
+	MaxPacketSize int      `json:"max_packet_size"`
+	PollInterval  int64    `json:"poll_interval_us,omitempty"`
+	IsoSyncType   string   `json:"iso_sync_type,omitempty"`
+	UsageType     string   `json:"usage_type,omitempty"`
+	Extra         HexBytes `json:"extra,omitempty"`
+}
+
+// PlatformCapabilityDump is a platform capability of the BOS descriptor
+// in a DescriptorDump.
+type PlatformCapabilityDump struct {
+	UUID string   `json:"uuid"`
+	Data HexBytes `json:"data"`
+}
+
+// DumpDescriptors reads all the descriptors of the device: the device,
+// configuration, interface and endpoint descriptors, the strings they
+// reference and, for devices implementing USB 2.1 or newer, the BOS
+// descriptor. The result can be marshaled with json.Marshal, e.g. to
+// attach it to a bug report. Descriptors that can't be read are listed in
+// the Errors of the dump instead of failing the whole dump.
+func (d *Device) DumpDescriptors() (*DescriptorDump, error) {
+	if d.handle == nil {
+		return nil, fmt.Errorf("DumpDescriptors() called on %s after Close", d)
+	}
+	ret := &DescriptorDump{}
+	str := func(what string, idx int) string {
+		s, err := d.GetStringDescriptor(idx)
+		if err != nil {
+			ret.Errors = append(ret.Errors, fmt.Sprintf("%s (string descriptor %d): %v", what, idx, err))
+		}
+		return s
+	}
+
+	desc := d.Desc
+	ret.Device = DeviceDump{
+		Bus:                  desc.Bus,
+		Address:              desc.Address,
+		Port:                 desc.Port,
+		Path:                 desc.Path,
+		Speed:                desc.Speed.String(),
+		USBVersion:           desc.Spec.String(),
+		DeviceVersion:        desc.Device.String(),
+		Vendor:               desc.Vendor.String(),
+		Product:              desc.Product.String(),
+		Class:                uint8(desc.Class),
+		SubClass:             uint8(desc.SubClass),
+		Protocol:             uint8(desc.Protocol),
+		MaxControlPacketSize: desc.MaxControlPacketSize,
+		ManufacturerName:     str("manufacturer", desc.iManufacturer),
+		ProductName:          str("product", desc.iProduct),
+		SerialNumber:         str("serial number", desc.iSerialNumber),
+	}
+
+	var cfgNums []int
+	for n := range desc.Configs {
+		cfgNums = append(cfgNums, n)
+	}
+	sort.Ints(cfgNums)
+	for _, n := range cfgNums {
+		c := desc.Configs[n]
+		cd := ConfigDump{
+			Number:       c.Number,
+			Description:  str(fmt.Sprintf("configuration %d", c.Number), c.iConfiguration),
+			TotalLength:  c.TotalLength,
+			SelfPowered:  c.SelfPowered,
+			RemoteWakeup: c.RemoteWakeup,
+			MaxPowerMA:   int(c.MaxPower),
+			Interfaces:   []InterfaceDump{},
+			Extra:        c.Extra,
+		}
+		for _, intf := range c.Interfaces {
+			id := InterfaceDump{Number: intf.Number, AltSettings: []AltSettingDump{}}
+			for _, alt := range intf.AltSettings {
+				ad := AltSettingDump{
+					Alternate:   alt.Alternate,
+					Description: str(fmt.Sprintf("configuration %d interface %d alternate setting %d", c.Number, alt.Number, alt.Alternate), alt.iInterface),
+					Class:       uint8(alt.Class),
+					SubClass:    uint8(alt.SubClass),
+					Protocol:    uint8(alt.Protocol),
+					Extra:       alt.Extra,
+				}
+				var addrs []int
+				for a := range alt.Endpoints {
+					addrs = append(addrs, int(a))
+				}
+				sort.Ints(addrs)
+				for _, a := range addrs {
+					ep := alt.Endpoints[EndpointAddress(a)]
+					ed := EndpointDump{
+						Address:       uint8(ep.Address),
+						Direction:     ep.Direction.String(),
+						TransferType:  ep.TransferType.String(),
+						MaxPacketSize: ep.MaxPacketSize,
+						PollInterval:  ep.PollInterval.Microseconds(),
+						Extra:         ep.Extra,
+					}
+					if ep.TransferType == TransferTypeIsochronous {
+						ed.IsoSyncType = ep.IsoSyncType.String()
+					}
+					if ep.TransferType == TransferTypeIsochronous || ep.TransferType == TransferTypeInterrupt {
+						ed.UsageType = ep.UsageType.String()
+					}
+					ad.Endpoints = append(ad.Endpoints, ed)
+				}
+				id.AltSettings = append(id.AltSettings, ad)
+			}
+			cd.Interfaces = append(cd.Interfaces, id)
+		}
+		ret.Configs = append(ret.Configs, cd)
+	}
+
+	if d.USBVersion() >= Version(2, 10) {
+		bos, err := d.GetBOSDescriptor()
+		if err != nil {
+			ret.Errors = append(ret.Errors, err.Error())
+		} else {
+			ret.BOS = bos
+			caps, err := ParsePlatformCapabilities(bos)
+			if err != nil {
+				ret.Errors = append(ret.Errors, err.Error())
+			}
+			for _, p := range caps {
+				ret.PlatformCapabilities = append(ret.PlatformCapabilities, PlatformCapabilityDump{UUID: p.UUID.String(), Data: p.Data})
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDumpDescriptors(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		if req.rType == 0x80 && req.request == 0x06 && req.val == 0x0f00 {
+			return copy(data, testBOS), nil
+		}
+		return 0, ErrorPipe
+	}
+	lib.fakeDevices[newDevicePointer()] = &fakeDevice{
+		devDesc: &DeviceDesc{
+			Bus:                  2,
+			Address:              5,
+			Port:                 3,
+			Path:                 []int{1, 3},
+			Speed:                SpeedHigh,
+			Spec:                 Version(2, 10),
+			Device:               Version(1, 2),
+			Vendor:               0x5555,
+			Product:              0x0001,
+			MaxControlPacketSize: 64,
+			iManufacturer:        1,
+			iProduct:             2,
+			iSerialNumber:        3,
+			Configs: map[int]ConfigDesc{1: {
+				Number:         1,
+				TotalLength:    32,
+				MaxPower:       100,
+				iConfiguration: 4,
+				Interfaces: []InterfaceDesc{{
+					Number: 0,
+					AltSettings: []InterfaceSetting{{
+						Class:      ClassHID,
+						Extra:      []byte{0x09, 0x21, 0x11, 0x01, 0x00, 0x01, 0x22, 0x34, 0x00},
+						iInterface: 5,
+						Endpoints: map[EndpointAddress]EndpointDesc{
+							0x81: {
+								Address:       0x81,
+								Number:        1,
+								Direction:     EndpointDirectionIn,
+								TransferType:  TransferTypeInterrupt,
+								MaxPacketSize: 8,
+								PollInterval:  time.Millisecond,
+							},
+						},
+					}},
+				}},
+			}},
+		},
+		strDesc: map[int]string{1: "Example", 2: "Keyboard", 4: "Default"},
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x5555, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x5555, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	dump, err := dev.DumpDescriptors()
+	if err != nil {
+		t.Fatalf("%s.DumpDescriptors(): %v", dev, err)
+	}
+	got, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		t.Fatalf("json.MarshalIndent(): %v", err)
+	}
+	want := `{
+  "device": {
+    "bus": 2,
+    "address": 5,
+    "port": 3,
+    "path": [
+      1,
+      3
+    ],
+    "speed": "high",
+    "usb_version": "2.10",
+    "device_version": "1.02",
+    "vendor_id": "5555",
+    "product_id": "0001",
+    "class": 0,
+    "subclass": 0,
+    "protocol": 0,
+    "max_control_packet_size": 64,
+    "manufacturer": "Example",
+    "product": "Keyboard"
+  },
+  "configs": [
+    {
+      "number": 1,
+      "description": "Default",
+      "total_length": 32,
+      "self_powered": false,
+      "remote_wakeup": false,
+      "max_power_ma": 100,
+      "interfaces": [
+        {
+          "number": 0,
+          "alt_settings": [
+            {
+              "alternate": 0,
+              "class": 3,
+              "subclass": 0,
+              "protocol": 0,
+              "endpoints": [
+                {
+                  "address": 129,
+                  "direction": "IN",
+                  "transfer_type": "interrupt",
+                  "max_packet_size": 8,
+                  "poll_interval_us": 1000,
+                  "usage_type": "undefined usage"
+                }
+              ],
+              "extra": "092111010001223400"
+            }
+          ]
+        }
+      ]
+    }
+  ],
+  "bos": "050f240002071002020000001810050038b60834a909a0478bfda0768815b66500010101",
+  "platform_capabilities": [
+    {
+      "uuid": "3408b638-09a9-47a0-8bfd-a0768815b665",
+      "data": "00010101"
+    }
+  ],
+  "errors": [
+    "serial number (string descriptor 3): invalid string descriptor index 3",
+    "configuration 1 interface 0 alternate setting 0 (string descriptor 5): invalid string descriptor index 5"
+  ]
+}`
+	if string(got) != want {
+		t.Errorf("DumpDescriptors() JSON:\n%s\nwant:\n%s", got, want)
+	}
+
+	var back DescriptorDump
+	if err := json.Unmarshal(got, &back); err != nil {
+		t.Fatalf("json.Unmarshal(): %v", err)
+	}
+	if !bytes.Equal(back.BOS, testBOS) {
+		t.Errorf("BOS after a JSON round trip: got % x, want % x", []byte(back.BOS), testBOS)
+	}
+	if !reflect.DeepEqual(&back, dump) {
+		t.Errorf("DescriptorDump after a JSON round trip: got %+v, want %+v", back, *dump)
+	}
+}
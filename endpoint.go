@@ -17,8 +17,12 @@ package gousb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -55,6 +59,20 @@ type EndpointDesc struct {
 	IsoSyncType IsoSyncType
 	// UsageType is the isochronous or interrupt endpoint usage type, as defined by USB spec.
 	UsageType UsageType
+	// Refresh and SynchAddress are the bRefresh and bSynchAddress fields
+	// that extend the endpoint descriptors of USB Audio Class 1.0 devices,
+	// 0 for other endpoints. Refresh is the exponent of the feedback rate
+	// of a synchronization endpoint, which reports every 2^Refresh frames.
+	// SynchAddress is the address of the synchronization endpoint of an
+	// adaptive or asynchronous data endpoint. See also AudioEndpoint.
+	Refresh      uint8
+	SynchAddress EndpointAddress
+	// Extra contains the raw class- or vendor-specific descriptors that
+	// follow the endpoint descriptor.
+	Extra []byte
+	// ExtraDescriptors contains the descriptors from Extra, as parsed by
+	// the parsers registered through RegisterDescriptorParser.
+	ExtraDescriptors []interface{}
 }
 
 // String returns the human-readable description of the endpoint.
@@ -71,13 +89,115 @@ func (e EndpointDesc) String() string {
 	return strings.Join(ret, " ")
 }
 
+// IsoPacketCount returns the number of isochronous packets used by
+// a transfer with a buffer of bufLen bytes on this endpoint. The buffer is
+// split into packets of MaxPacketSize bytes, trailing bytes that do not fill
+// an entire packet are not used by the transfer. A buffer smaller than
+// MaxPacketSize uses a single, smaller packet.
+// IsoPacketCount returns 0 for non-isochronous endpoints.
+func (e EndpointDesc) IsoPacketCount(bufLen int) int {
+	if e.TransferType != TransferTypeIsochronous {
+		return 0
+	}
+	n, _ := e.isoPackets(bufLen)
+	return n
+}
+
+// isoPackets returns the number and size of iso packets for a transfer
+// with a buffer of bufLen bytes.
+func (e EndpointDesc) isoPackets(bufLen int) (count, size int) {
+	size = e.MaxPacketSize
+	if bufLen < size {
+		size = bufLen
+	}
+	if size <= 0 {
+		return 1, size
+	}
+	return bufLen / size, size
+}
+
+// EndpointStats contains the statistics of transfers finished on
+// an endpoint.
+type EndpointStats struct {
+	// Transfers is the number of finished transfers, regardless of status.
+	Transfers int64
+	// Bytes is the total number of bytes transferred.
+	Bytes int64
+	// Errors is the number of transfers that finished with a status other
+	// than TransferCompleted or TransferTimedOut.
+	Errors int64
+	// Timeouts is the number of transfers that timed out.
+	Timeouts int64
+}
+
+// endpointStats collects EndpointStats, updated on transfer completion.
+type endpointStats struct {
+	mu sync.Mutex
+	s  EndpointStats
+}
+
+func (s *endpointStats) record(n int, status TransferStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.Transfers++
+	s.s.Bytes += int64(n)
+	switch status {
+	case TransferCompleted:
+	case TransferTimedOut:
+		s.s.Timeouts++
+	default:
+		s.s.Errors++
+	}
+}
+
 type endpoint struct {
 	h *libusbDevHandle
+	// dev is the state of the device of the endpoint.
+	dev *deviceState
 
 	InterfaceSetting
 	Desc EndpointDesc
 
 	ctx *Context
+
+	stats endpointStats
+	// latency is the latency histogram, see SetLatencyHistogram.
+	latency latencyHistogram
+	// queueDelay is the queue delay histogram, see SetQueueDelayHistogram.
+	queueDelay latencyHistogram
+	// adaptive derives the timeout of Read and Write transfers from their
+	// latencies, see SetAdaptiveTimeout.
+	adaptive adaptiveTimeout
+
+	// limitMu protects maxSize, chunk, group, libusbTimeout, waitTimeout
+	// and timeoutRetries.
+	limitMu sync.Mutex
+	// maxSize is the maximum size of a single transfer, 0 means unlimited.
+	maxSize int
+	// chunk is true if Read/Write buffers larger than maxSize are split
+	// into multiple transfers.
+	chunk bool
+	// group, if not nil, is the CancelGroup of new transfers.
+	group *CancelGroup
+	// libusbTimeout and waitTimeout are the timeouts of Read and Write
+	// transfers, see SetLibusbTimeout and SetWaitTimeout. 0 means none.
+	libusbTimeout, waitTimeout time.Duration
+	// timeoutRetries is the number of times a transfer that timed out
+	// without moving any data is retried, see SetTimeoutRetries.
+	timeoutRetries int
+
+	// sched is the transfer scheduler of the device, prio is the endpoint
+	// priority, accessed atomically.
+	sched *scheduler
+	prio  int32
+	// fired is the number of transfers of Fire in flight, accessed
+	// atomically.
+	fired int32
+
+	// intent selects the stream defaults, see WithIntent.
+	intent Intent
+	// intf is the interface the endpoint was opened from, see Validate.
+	intf *Interface
 }
 
 // String returns a human-readable description of the endpoint.
@@ -85,12 +205,315 @@ func (e *endpoint) String() string {
 	return e.Desc.String()
 }
 
+// Stats returns a snapshot of the statistics of all transfers finished on
+// the endpoint, including transfers of streams created for the endpoint.
+func (e *endpoint) Stats() EndpointStats {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+	return e.stats.s
+}
+
+// ResetStats zeroes the endpoint statistics and returns the values from
+// before the reset. Reading and resetting are done atomically, no transfer
+// is lost between the two.
+func (e *endpoint) ResetStats() EndpointStats {
+	e.stats.mu.Lock()
+	defer e.stats.mu.Unlock()
+	ret := e.stats.s
+	e.stats.s = EndpointStats{}
+	return ret
+}
+
+// SetMaxTransferSize sets the maximum buffer size of a single transfer on
+// the endpoint. By default, and if n is 0, the transfer size is not limited.
+// Once a limit is set, creating transfers or streams with larger buffers
+// fails with an error, as do Read and Write calls with larger buffers,
+// unless chunking was enabled with SetTransferChunking. This protects
+// against accidental huge allocations and against oversized submissions
+// that some backends reject with an invalid parameter error.
+func (e *endpoint) SetMaxTransferSize(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid maximum transfer size %d, must be >= 0", n)
+	}
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	e.maxSize = n
+	return nil
+}
+
+// SetTransferChunking controls what happens to a Read or Write with a buffer
+// larger than the maximum transfer size set with SetMaxTransferSize. If
+// enabled, the buffer is split into multiple consecutive transfers, each at
+// most the maximum size. An IN read stops early after a short transfer.
+// If disabled, which is the default, such a Read or Write returns an error.
+func (e *endpoint) SetTransferChunking(enabled bool) {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	e.chunk = enabled
+}
+
+// SetLibusbTimeout sets the libusb timeout of the transfers issued by Read
+// and Write on the endpoint, and by their variants, 0 for no timeout, the
+// default. When the timeout expires, libusb ends the transfer with the
+// TransferTimedOut status, keeping the data transferred so far: the Read
+// or Write returns the partial length with an error wrapping
+// TransferTimedOut, and the transfer can simply be issued again. A short
+// libusb timeout suits polling loops that need to regain control
+// periodically without giving up on the device.
+//
+// The libusb timeout is independent of the wait timeout, see
+// SetWaitTimeout, and of the context passed to ReadContext or
+// WriteContext: the first of the three to expire ends the transfer.
+// Neither timeout applies to streams, which have their own transfers.
+func (e *endpoint) SetLibusbTimeout(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid libusb timeout %v, must be >= 0", d)
+	}
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	e.libusbTimeout = d
+	return nil
+}
+
+// SetWaitTimeout sets how long Read and Write on the endpoint, and their
+// variants, wait for each transfer to complete, 0 for no limit, the
+// default. The wait timeout is enforced on the Go side, like the deadline
+// of a context passed to ReadContext: when it expires, the transfer is
+// cancelled and the call returns an error wrapping
+// context.DeadlineExceeded. Unlike a libusb timeout, see SetLibusbTimeout,
+// it covers the whole wait, including the time libusb needs to process the
+// cancellation, and the data of a cancelled IN transfer may be lost.
+//
+// Combining the two gives a short libusb timeout, after which the caller
+// resubmits, with a longer bound on the patience of the application, or a
+// long libusb timeout with a short wait timeout, which gives up on a
+// transfer the device is slow to complete. The wait timeout applies to each
+// transfer separately, a Read split into chunks, see SetTransferChunking,
+// can take longer in total.
+func (e *endpoint) SetWaitTimeout(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid wait timeout %v, must be >= 0", d)
+	}
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	e.waitTimeout = d
+	return nil
+}
+
+// SetTimeoutRetries makes Read and Write on the endpoint, and their
+// variants, retry a transfer up to n more times if it ends with the
+// TransferTimedOut status without transferring any data, 0 to disable
+// retries, the default. A bulk transfer that times out with nothing sent
+// or received means the device answered every attempt with a NAK: it is
+// busy, not broken, and the transfer can be resubmitted immediately.
+// Combined with a short libusb timeout, see SetLibusbTimeout, this smooths
+// over a device that is briefly unable to keep up.
+//
+// Only such timeouts are retried. A transfer that timed out after moving
+// some data returns the partial length with the error, as without retries,
+// and stalls, errors, cancellation and the expiry of the wait timeout, see
+// SetWaitTimeout, are never retried. If all attempts time out, the error of
+// the last one is returned.
+func (e *endpoint) SetTimeoutRetries(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid number of timeout retries %d, must be >= 0", n)
+	}
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	e.timeoutRetries = n
+	return nil
+}
+
+// retries returns the number of timeout retries of Read and Write.
+func (e *endpoint) retries() int {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	return e.timeoutRetries
+}
+
+// timeouts returns the libusb and wait timeouts of Read and Write.
+func (e *endpoint) timeouts() (libusbTimeout, waitTimeout time.Duration) {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	return e.libusbTimeout, e.waitTimeout
+}
+
+// transferLimits returns the maximum transfer size and chunking setting.
+func (e *endpoint) transferLimits() (maxSize int, chunk bool) {
+	e.limitMu.Lock()
+	defer e.limitMu.Unlock()
+	return e.maxSize, e.chunk
+}
+
+// BufferForDuration returns the size of a buffer that holds d worth of data
+// on an isochronous or interrupt endpoint, e.g. 20ms of audio: the number of
+// service intervals of PollInterval in d, rounded up, times MaxPacketSize,
+// which includes the additional transactions of high-bandwidth endpoints.
+// The size is a multiple of MaxPacketSize, as required by the streams of
+// isochronous endpoints, and is suitable for NewStream and NewTransfer:
+//
+//	size, err := ep.BufferForDuration(20 * time.Millisecond)
+//	...
+//	stream, err := ep.NewStream(size, 4) // 80ms of data in flight
+//
+// The packet size and interval are those of the active alternate setting
+// of the interface, see Interface.SetAltSetting. BufferForDuration returns
+// an error for bulk and control endpoints, which have no service interval,
+// and for durations that are not positive.
+func (e *endpoint) BufferForDuration(d time.Duration) (int, error) {
+	desc, err := e.transferDesc()
+	if err != nil {
+		return 0, err
+	}
+	if desc.TransferType != TransferTypeIsochronous && desc.TransferType != TransferTypeInterrupt {
+		return 0, fmt.Errorf("BufferForDuration: %s has no service interval", e)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("BufferForDuration: invalid duration %v, must be positive", d)
+	}
+	if desc.PollInterval <= 0 || desc.MaxPacketSize <= 0 {
+		return 0, fmt.Errorf("BufferForDuration: %s has no usable interval (%v) or packet size (%d)", e, desc.PollInterval, desc.MaxPacketSize)
+	}
+	intervals := (d + desc.PollInterval - 1) / desc.PollInterval
+	return int(intervals) * desc.MaxPacketSize, nil
+}
+
+// newUSBTransfer allocates a new transfer for the endpoint. The results of
+// the transfer are recorded in the endpoint statistics.
+func (e *endpoint) newUSBTransfer(size int) (*usbTransfer, error) {
+	return e.newUSBTransferWithBuffer(size, nil, 0)
+}
+
+// newUSBTransferWithBuffer is like newUSBTransfer, but if shared is not
+// nil, the transfer buffer is taken from shared, see
+// newUSBTransferWithBuffer.
+func (e *endpoint) newUSBTransferWithBuffer(size int, shared *sharedBuffer, offset int) (*usbTransfer, error) {
+	if max, _ := e.transferLimits(); max > 0 && size > max {
+		return nil, fmt.Errorf("transfer size %d exceeds the maximum transfer size %d set for endpoint %s", size, max, e.Desc.Address)
+	}
+	desc, err := e.transferDesc()
+	if err != nil {
+		return nil, err
+	}
+	t, err := newUSBTransferWithBuffer(e.ctx, e.h, e.dev, desc, size, shared, offset)
+	if err != nil {
+		return nil, err
+	}
+	t.stats = &e.stats
+	t.latency = &e.latency
+	t.queueDelay = &e.queueDelay
+	if g := e.cancelGroup(); g != nil {
+		g.add(t)
+	}
+	if e.sched != nil {
+		t.sched = e.sched
+		t.priority = e.priority
+	}
+	return t, nil
+}
+
+// transferDesc returns the descriptor new transfers of the endpoint are
+// built from: the descriptor of the endpoint in the active alternate
+// setting of its interface, which differs from Desc after
+// Interface.SetAltSetting.
+func (e *endpoint) transferDesc() (*EndpointDesc, error) {
+	if e.intf == nil {
+		return &e.Desc, nil
+	}
+	s := e.intf.currentSetting()
+	if s.Alternate == e.InterfaceSetting.Alternate {
+		return &e.Desc, nil
+	}
+	desc, ok := s.Endpoints[e.Desc.Address]
+	if !ok {
+		return nil, fmt.Errorf("endpoint %s doesn't exist in the active alternate setting %d of interface %d, available endpoints: %v", e, s.Alternate, s.Number, s.sortedEndpointIds())
+	}
+	return &desc, nil
+}
+
 func (e *endpoint) transfer(ctx context.Context, buf []byte) (int, error) {
-	t, err := newUSBTransfer(e.ctx, e.h, &e.Desc, len(buf))
+	max, chunk := e.transferLimits()
+	if max == 0 || len(buf) <= max || !chunk {
+		return e.transferOnce(ctx, buf)
+	}
+	var done int
+	for done < len(buf) {
+		next := buf[done:]
+		if len(next) > max {
+			next = next[:max]
+		}
+		n, err := e.transferOnce(ctx, next)
+		done += n
+		if err != nil {
+			return done, err
+		}
+		if n < len(next) {
+			// short transfer, the device has no more data for now.
+			break
+		}
+	}
+	return done, nil
+}
+
+// transferOnce performs a single transfer of buf, retrying it if it times
+// out without transferring any data, see SetTimeoutRetries.
+func (e *endpoint) transferOnce(ctx context.Context, buf []byte) (int, error) {
+	retries := e.retries()
+	for attempt := 0; ; attempt++ {
+		n, err := e.transferAttempt(ctx, buf)
+		if n > 0 || attempt >= retries || ctx.Err() != nil || !errors.Is(err, TransferTimedOut) {
+			return n, err
+		}
+		debug.Printf("%s: transfer timed out with no data (attempt %d of %d), retrying", e, attempt+1, retries+1)
+	}
+}
+
+// transferAttempt performs a single attempt of the transfer of buf, subject
+// to the libusb, adaptive and wait timeouts of the endpoint.
+func (e *endpoint) transferAttempt(ctx context.Context, buf []byte) (int, error) {
+	timeout, wait := e.timeouts()
+	if at := e.adaptive.timeout(); at > 0 && (timeout == 0 || at < timeout) {
+		timeout = at
+	}
+	if !e.adaptive.enabled() {
+		return e.transferOnceWait(ctx, buf, timeout, wait)
+	}
+	start := time.Now()
+	n, err := e.transferOnceWait(ctx, buf, timeout, wait)
+	if err == nil || errors.Is(err, TransferTimedOut) {
+		e.adaptive.record(time.Since(start))
+	}
+	return n, err
+}
+
+// transferOnceWait performs a single transfer of buf with the given libusb
+// timeout, for at most wait, 0 meaning no limit.
+func (e *endpoint) transferOnceWait(ctx context.Context, buf []byte, timeout, wait time.Duration) (int, error) {
+	if wait == 0 {
+		return e.transferOnceTimeout(ctx, buf, timeout)
+	}
+	wctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	n, err := e.transferOnceTimeout(wctx, buf, timeout)
+	if err != nil && ctx.Err() == nil && wctx.Err() == context.DeadlineExceeded {
+		return n, fmt.Errorf("%s: transfer not completed within the wait timeout of %v: %w", e, wait, context.DeadlineExceeded)
+	}
+	return n, err
+}
+
+// transferOnceTimeout performs a single transfer of buf with the given
+// libusb timeout, 0 meaning no timeout.
+func (e *endpoint) transferOnceTimeout(ctx context.Context, buf []byte, timeout time.Duration) (int, error) {
+	t, err := e.newUSBTransfer(len(buf))
 	if err != nil {
 		return 0, err
 	}
 	defer t.free()
+	if timeout > 0 {
+		if err := t.setTimeout(timeout); err != nil {
+			return 0, err
+		}
+	}
 	if e.Desc.Direction == EndpointDirectionOut {
 		copy(t.data(), buf)
 	}
@@ -103,10 +526,34 @@ func (e *endpoint) transfer(ctx context.Context, buf []byte) (int, error) {
 	if e.Desc.Direction == EndpointDirectionIn {
 		copy(buf, t.data())
 	}
+	return n, e.transferError(err)
+}
+
+// transferError converts the transfer status errors returned by wait into
+// an EndpointError of the endpoint. Other errors are returned unchanged.
+func (e *endpoint) transferError(err error) error {
+	var st TransferStatus
+	if err == nil || !errors.As(err, &st) {
+		return err
+	}
+	op := "write"
+	if e.Desc.Direction == EndpointDirectionIn {
+		op = "read"
+	}
+	return &EndpointError{Endpoint: e.Desc.Address, Op: op, Status: st}
+}
+
+func (e *endpoint) newTransfer(size int, opts ...TransferOption) (*Transfer, error) {
+	t, err := e.newUSBTransfer(size)
 	if err != nil {
-		return n, err
+		return nil, err
 	}
-	return n, nil
+	var o transferOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	t.singleOwner = o.singleOwner
+	return &Transfer{t: t}, nil
 }
 
 // InEndpoint represents an IN endpoint open for transfer.
@@ -144,10 +591,269 @@ func (e *InEndpoint) Read(buf []byte) (int, error) {
 // If that happens, Read will return an error signaling an overflow.
 // See http://libusb.sourceforge.net/api-1.0/libusb_packetoverflow.html
 // for more details.
+// By default, transfers are not subject to any timeout, ReadContext blocks
+// until the device sends data or the context is done, see SetLibusbTimeout,
+// SetWaitTimeout and SetAdaptiveTimeout to bound the transfers. This makes
+// ReadContext suitable for event-driven interrupt endpoints, like HID
+// buttons, that might not send anything for a long time. To avoid allocating a new
+// transfer for each event, use a stream with a single transfer, created with
+// NewStream(size, 1).
+// On isochronous endpoints, the data of the successful iso packets is
+// returned contiguously in buf and the returned length counts only those
+// bytes, the data of failed packets is dropped. If some, but not all,
+// packets failed, the error has the TransferPartial status. If all of them
+// failed, the status is that of the first failed packet.
 func (e *InEndpoint) ReadContext(ctx context.Context, buf []byte) (int, error) {
 	return e.transfer(ctx, buf)
 }
 
+// ReadAtLeast reads from the endpoint into buf until it has read at least
+// min bytes, issuing as many transfers as needed, like io.ReadAtLeast.
+// It returns the number of bytes copied and an error if fewer bytes were
+// read. If min is greater than the length of buf, ReadAtLeast returns
+// io.ErrShortBuffer. See ReadAtLeastContext.
+func (e *InEndpoint) ReadAtLeast(buf []byte, min int) (int, error) {
+	return e.ReadAtLeastContext(context.Background(), buf, min)
+}
+
+// ReadAtLeastContext is like ReadAtLeast, the passed context bounds all the
+// transfers together. Each transfer reads into the remaining part of buf,
+// which should therefore be kept a multiple of EndpointDesc.MaxPacketSize
+// after each short transfer to avoid overflows, see Read. Reading stops at
+// the first transfer that doesn't complete successfully; its error is
+// returned together with the number of bytes read so far, unless min bytes
+// were already read.
+func (e *InEndpoint) ReadAtLeastContext(ctx context.Context, buf []byte, min int) (int, error) {
+	if len(buf) < min {
+		return 0, io.ErrShortBuffer
+	}
+	var n int
+	for n < min {
+		m, err := e.transfer(ctx, buf[n:])
+		n += m
+		if err != nil {
+			if n >= min {
+				err = nil
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadExact reads exactly len(buf) bytes from the endpoint in a single
+// read, see Read. Unlike Read, which accepts a short read, ReadExact treats
+// a read that ends before buf is full as an error, like libusb transfers
+// with the LIBUSB_TRANSFER_SHORT_NOT_OK flag: it returns the number of
+// bytes read and an error wrapping io.ErrUnexpectedEOF. Use it for
+// protocols where a short packet means the device sent an incomplete
+// message. To fill buf from several shorter reads instead, use
+// ReadAtLeast(buf, len(buf)). See ReadExactContext.
+func (e *InEndpoint) ReadExact(buf []byte) (int, error) {
+	return e.ReadExactContext(context.Background(), buf)
+}
+
+// ReadExactContext is like ReadExact, the passed context controls the
+// cancellation of the read, see ReadContext.
+func (e *InEndpoint) ReadExactContext(ctx context.Context, buf []byte) (int, error) {
+	n, err := e.transfer(ctx, buf)
+	if err != nil {
+		return n, err
+	}
+	if n < len(buf) {
+		return n, fmt.Errorf("short read of %d bytes from %s, want %d: %w", n, e, len(buf), io.ErrUnexpectedEOF)
+	}
+	return n, nil
+}
+
+// NewTransfer allocates a single reusable read transfer with a buffer of
+// the given size. See Transfer and TransferOption for details.
+func (e *InEndpoint) NewTransfer(size int, opts ...TransferOption) (*Transfer, error) {
+	return e.newTransfer(size, opts...)
+}
+
+// Frames reads the endpoint continuously and delivers the result of each
+// transfer as a separate frame on the returned channel, preserving the
+// boundaries between reports. This is intended for interrupt endpoints,
+// e.g. HID devices, where each transfer carries one report and reports
+// can be shorter than MaxPacketSize. Each frame is a new slice owned by
+// the receiver.
+// The channel is closed when ctx is done or when a read fails. Reading
+// stops while the receiver is not consuming frames.
+func (e *InEndpoint) Frames(ctx context.Context) <-chan []byte {
+	desc, err := e.transferDesc()
+	if err != nil {
+		debug.Printf("%s.Frames(): %v", e, err)
+		return closedFrames()
+	}
+	return e.frames(ctx, "Frames", desc.MaxPacketSize, func(data []byte) [][]byte {
+		return [][]byte{data}
+	})
+}
+
+// ReportFrames is like Frames, but splits the data of each transfer into
+// separate frames of reportSize bytes. Some HID devices coalesce several
+// reports into one interrupt transfer when they are polled slower than
+// they produce reports, ReportFrames delivers each of these reports
+// separately. reportSize is the size of the input reports, including the
+// report ID byte of devices using report IDs, e.g. as computed from the
+// report descriptor by HIDInputReportSize. If a transfer doesn't end at a
+// report boundary, its trailing bytes are delivered as a last, shorter
+// frame. A transfer without data delivers no frames.
+func (e *InEndpoint) ReportFrames(ctx context.Context, reportSize int) <-chan []byte {
+	if reportSize <= 0 {
+		debug.Printf("%s.ReportFrames(): invalid report size %d", e, reportSize)
+		return closedFrames()
+	}
+	desc, err := e.transferDesc()
+	if err != nil {
+		debug.Printf("%s.ReportFrames(): %v", e, err)
+		return closedFrames()
+	}
+	// The transfer holds at least a full packet, and whole reports.
+	size := (desc.MaxPacketSize + reportSize - 1) / reportSize * reportSize
+	return e.frames(ctx, "ReportFrames", size, func(data []byte) [][]byte {
+		var ret [][]byte
+		for len(data) > reportSize {
+			ret = append(ret, data[:reportSize])
+			data = data[reportSize:]
+		}
+		if len(data) > 0 {
+			ret = append(ret, data)
+		}
+		return ret
+	})
+}
+
+// closedFrames returns the channel of Frames and ReportFrames when reading
+// can't start.
+func closedFrames() <-chan []byte {
+	ch := make(chan []byte)
+	close(ch)
+	return ch
+}
+
+// frames reads the endpoint continuously with transfers of size bytes and
+// delivers frames of the data of each transfer, as returned by split, on
+// the returned channel. name is the name of the calling method.
+func (e *InEndpoint) frames(ctx context.Context, name string, size int, split func([]byte) [][]byte) <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		t, err := e.newUSBTransfer(size)
+		if err != nil {
+			debug.Printf("%s.%s(): %v", e, name, err)
+			return
+		}
+		defer t.free()
+		for {
+			if err := t.submit(); err != nil {
+				debug.Printf("%s.%s(): %v", e, name, err)
+				return
+			}
+			n, err := t.wait(ctx)
+			if err != nil {
+				debug.Printf("%s.%s(): %v", e, name, err)
+				return
+			}
+			for _, f := range split(t.data()[:n]) {
+				frame := make([]byte, len(f))
+				copy(frame, f)
+				select {
+				case ch <- frame:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// IsoPacketData is the data of a single isochronous packet, see
+// ReadIsoPackets.
+type IsoPacketData struct {
+	// Data is the part of the transfer buffer holding the data of the
+	// packet, ActualLength bytes long.
+	Data []byte
+	// Status is the completion status of the packet.
+	Status TransferStatus
+}
+
+// ReadIsoPackets reads an isochronous endpoint continuously, keeping count
+// transfers of size bytes in flight, and calls fn with the packets of each
+// completed transfer, in order. Unlike Read and streams, the packet data is
+// not compacted or copied: each IsoPacketData refers directly to the part
+// of the transfer buffer where the device placed the packet, and failed or
+// short packets are reported with their own status and length.
+//
+// The packets passed to fn are valid only until fn returns. The transfer is
+// resubmitted as soon as fn returns and the device overwrites the buffer,
+// so neither the slice nor the packet data may be retained or accessed
+// afterwards, data that needs to outlive the call must be copied. fn must
+// return quickly: while it runs, only count-1 transfers are queued and the
+// device drops packets if all of them complete.
+//
+// ReadIsoPackets returns when ctx is done, with the error of the context,
+// when fn returns an error, which is returned, or when a transfer fails.
+// All transfers are cancelled and freed before ReadIsoPackets returns.
+func (e *InEndpoint) ReadIsoPackets(ctx context.Context, size, count int, fn func(packets []IsoPacketData) error) (err error) {
+	if e.Desc.TransferType != TransferTypeIsochronous {
+		return fmt.Errorf("ReadIsoPackets() called on %s, which is not an isochronous endpoint", e)
+	}
+	if count < 1 {
+		return fmt.Errorf("invalid transfer count %d, must be at least 1", count)
+	}
+	ts := make([]*usbTransfer, 0, count)
+	defer func() {
+		for _, t := range ts {
+			t.cancel()
+			t.wait(context.Background())
+			t.free()
+		}
+	}()
+	for i := 0; i < count; i++ {
+		t, err := e.newUSBTransfer(size)
+		if err != nil {
+			return err
+		}
+		t.rawIso = true
+		ts = append(ts, t)
+		if err := t.submit(); err != nil {
+			return err
+		}
+	}
+	var views []IsoPacketData
+	for i := 0; ; i = (i + 1) % count {
+		t := ts[i]
+		if _, err := t.wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		views = views[:0]
+		off := 0
+		for _, p := range t.pkts {
+			end := off + p.ActualLength
+			if end > len(t.buf) {
+				end = len(t.buf)
+			}
+			views = append(views, IsoPacketData{Data: t.buf[off:end:end], Status: p.Status})
+			off += p.Length
+			if off > len(t.buf) {
+				off = len(t.buf)
+			}
+		}
+		if err := fn(views); err != nil {
+			return err
+		}
+		if err := t.submit(); err != nil {
+			return err
+		}
+	}
+}
+
 // OutEndpoint represents an OUT endpoint open for transfer.
 type OutEndpoint struct {
 	*endpoint
@@ -169,3 +875,53 @@ func (e *OutEndpoint) Write(buf []byte) (int, error) {
 func (e *OutEndpoint) WriteContext(ctx context.Context, buf []byte) (int, error) {
 	return e.transfer(ctx, buf)
 }
+
+// maxFiredTransfers is the number of transfers of Fire that can be in
+// flight on an endpoint at the same time.
+const maxFiredTransfers = 64
+
+// Fire sends data to an OUT endpoint without waiting for the result, e.g.
+// for periodic heartbeats where the application does not need to know
+// whether the write succeeded. Fire copies data into a new transfer and
+// returns as soon as the transfer is submitted, data can be reused
+// immediately. The transfer is freed by gousb once the device completes
+// it, its result is recorded in the endpoint statistics only.
+// Fire returns an error if the transfer could not be submitted, or if 64
+// fired transfers of the endpoint are still in flight, e.g. because the
+// device stopped accepting data.
+// Closing the Device or the Context cancels and frees the fired transfers
+// still in flight.
+func (e *OutEndpoint) Fire(data []byte) error {
+	if atomic.AddInt32(&e.fired, 1) > maxFiredTransfers {
+		atomic.AddInt32(&e.fired, -1)
+		return fmt.Errorf("%s.Fire(): %d fired transfers are still in flight", e, maxFiredTransfers)
+	}
+	t, err := e.newUSBTransfer(len(data))
+	if err != nil {
+		atomic.AddInt32(&e.fired, -1)
+		return err
+	}
+	copy(t.data(), data)
+	// The completion worker of the Context collects the result and frees
+	// the transfer, no goroutine waits for it.
+	xfer := &Transfer{t: t}
+	xfer.OnComplete(func(_ int, err error) {
+		if err != nil {
+			debug.Printf("%s.Fire(): %v", e, err)
+		}
+		t.free()
+		atomic.AddInt32(&e.fired, -1)
+	})
+	if err := xfer.Submit(); err != nil {
+		t.free()
+		atomic.AddInt32(&e.fired, -1)
+		return err
+	}
+	return nil
+}
+
+// NewTransfer allocates a single reusable write transfer with a buffer of
+// the given size. See Transfer and TransferOption for details.
+func (e *OutEndpoint) NewTransfer(size int, opts ...TransferOption) (*Transfer, error) {
+	return e.newTransfer(size, opts...)
+}
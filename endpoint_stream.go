@@ -14,10 +14,38 @@
 
 package gousb
 
-func (e *endpoint) newStream(size, count int) (*stream, error) {
-	var ts []transferIntf
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// drainReadTimeout is the timeout of each read issued by WithDrain. A read
+// that times out means the device has no more stale data queued.
+const drainReadTimeout = 10 * time.Millisecond
+
+func (e *endpoint) newStream(size, count int, opts streamOptions) (*stream, error) {
+	// newTransfer allocates a transfer using size bytes of shared at
+	// offset, or a new buffer if shared is nil.
+	newTransfer := func(shared *sharedBuffer, offset int) (*usbTransfer, error) {
+		if p := opts.bufferProvider; p != nil {
+			return e.newProvidedTransfer(p, size)
+		}
+		return e.newUSBTransferWithBuffer(size, shared, offset)
+	}
+	var shared *sharedBuffer
+	if opts.contiguousBuffers && opts.bufferProvider == nil && size > 0 && count > 0 {
+		var err error
+		if shared, err = newSharedBuffer(e.ctx, size*count); err != nil {
+			return nil, err
+		}
+		// the transfers hold their own references.
+		defer shared.release()
+	}
+	var ts []*usbTransfer
 	for i := 0; i < count; i++ {
-		t, err := newUSBTransfer(e.ctx, e.h, &e.Desc, size)
+		t, err := newTransfer(shared, i*size)
 		if err != nil {
 			for _, t := range ts {
 				t.free()
@@ -26,7 +54,43 @@ func (e *endpoint) newStream(size, count int) (*stream, error) {
 		}
 		ts = append(ts, t)
 	}
-	return newStream(ts), nil
+	var thread *osThread
+	if opts.dedicatedThread {
+		thread = newOSThread(opts.threadSetup)
+	}
+	var sc *streamContext
+	if opts.ctx != nil {
+		sc = newStreamContext(opts.ctx)
+	}
+	// wrap applies the stream options to a transfer of the stream.
+	wrap := func(u *usbTransfer) transferIntf {
+		var t transferIntf = u
+		if sc != nil {
+			t = newContextTransfer(sc, u)
+		}
+		if thread != nil {
+			t = thread.wrap(t)
+		}
+		if opts.zeroBuffers {
+			t = &zeroingTransfer{transferIntf: t, in: e.Desc.Direction == EndpointDirectionIn}
+		}
+		return t
+	}
+	wrapped := make([]transferIntf, len(ts))
+	for i, t := range ts {
+		wrapped[i] = wrap(t)
+	}
+	alloc := func() (transferIntf, error) {
+		t, err := newTransfer(nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(t), nil
+	}
+	s := newStream(wrapped)
+	s.alloc = alloc
+	s.closed = func() bool { return e.ctx.deviceClosed(e.dev) }
+	return s, nil
 }
 
 // NewStream prepares a new read stream that will keep reading data from
@@ -37,9 +101,18 @@ func (e *endpoint) newStream(size, count int) (*stream, error) {
 // the latency between subsequent transfers and increases reading throughput.
 // Similarly to InEndpoint.Read, the size of the buffer should be a multiple
 // of EndpointDesc.MaxPacketSize to avoid overflows, see documentation
-// in InEndpoint.Read for more details.
-func (e *InEndpoint) NewStream(size, count int) (*ReadStream, error) {
-	s, err := e.newStream(size, count)
+// in InEndpoint.Read for more details. For isochronous and interrupt
+// endpoints, BufferForDuration computes the size that holds a given
+// duration of data, e.g. for audio or video capture.
+// The stream can be further configured with StreamOptions.
+func (e *InEndpoint) NewStream(size, count int, opts ...StreamOption) (*ReadStream, error) {
+	o := newStreamOptions(opts)
+	if o.drain > 0 {
+		if err := e.drain(size, o.drain); err != nil {
+			return nil, err
+		}
+	}
+	s, err := e.newStream(size, count, o)
 	if err != nil {
 		return nil, err
 	}
@@ -47,15 +120,75 @@ func (e *InEndpoint) NewStream(size, count int) (*ReadStream, error) {
 	return &ReadStream{s: s}, nil
 }
 
+// drain reads and discards data from the endpoint with transfers of size
+// bytes, until a read returns no data or times out, or until timeout
+// elapses. See WithDrain.
+func (e *InEndpoint) drain(size int, timeout time.Duration) error {
+	t, err := e.newUSBTransfer(size)
+	if err != nil {
+		return err
+	}
+	defer t.free()
+	deadline := time.Now().Add(timeout)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil
+		}
+		if left > drainReadTimeout {
+			left = drainReadTimeout
+		}
+		// libusb timeouts have a millisecond resolution and 0 means
+		// no timeout.
+		if left < time.Millisecond {
+			left = time.Millisecond
+		}
+		if err := t.setTimeout(left); err != nil {
+			return err
+		}
+		if err := t.submit(); err != nil {
+			return err
+		}
+		n, err := t.wait(context.Background())
+		if errors.Is(err, TransferTimedOut) || (err == nil && n == 0) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to drain stale data from %s: %w", e, e.transferError(err))
+		}
+	}
+}
+
 // NewStream prepares a new write stream that will write data in the
 // background. Size defines a buffer size for a single write transaction and
 // count defines how many transactions may be active at any time. By buffering
 // the writes, a Stream reduces the latency between subsequent transfers and
 // increases writing throughput.
-func (e *OutEndpoint) NewStream(size, count int) (*WriteStream, error) {
-	s, err := e.newStream(size, count)
+// For isochronous endpoints, size must be a multiple of
+// EndpointDesc.MaxPacketSize, each transfer carries size/MaxPacketSize
+// iso packets. The data of a Write that doesn't fill a transfer entirely is
+// sent in fewer packets, the last one possibly shorter. To avoid
+// underruns, the transfers queued should cover the time needed to produce
+// the next chunk of data, e.g. for a full-speed audio endpoint with one
+// packet per 1ms frame, 4 transfers of 8 packets each keep 32ms of audio
+// queued, see BufferForDuration to size the transfers by their duration.
+// See WriteStream.Underruns and WithLowWaterMark.
+// The stream can be further configured with StreamOptions.
+func (e *OutEndpoint) NewStream(size, count int, opts ...StreamOption) (*WriteStream, error) {
+	desc, err := e.transferDesc()
+	if err != nil {
+		return nil, err
+	}
+	if desc.TransferType == TransferTypeIsochronous && desc.MaxPacketSize > 0 && size%desc.MaxPacketSize != 0 {
+		return nil, fmt.Errorf("buffer size %d of an isochronous stream on %s must be a multiple of the max packet size %d", size, e, desc.MaxPacketSize)
+	}
+	o := newStreamOptions(opts)
+	if o.lowWater > count {
+		return nil, fmt.Errorf("low-water mark %d of a stream on %s exceeds the number of transfers %d", o.lowWater, e, count)
+	}
+	s, err := e.newStream(size, count, o)
 	if err != nil {
 		return nil, err
 	}
-	return &WriteStream{s: s}, nil
+	return &WriteStream{s: s, lowWater: o.lowWater, onLowWater: o.onLowWater}, nil
 }
@@ -14,7 +14,16 @@
 
 package gousb
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
 
 func TestEndpointReadStream(t *testing.T) {
 	t.Parallel()
@@ -105,9 +114,11 @@ func TestEndpointWriteStream(t *testing.T) {
 				return
 			}
 			xfr.setData(make([]byte, len(xfr.buf)))
+			xfr.mu.Lock()
+			total += xfr.length
+			xfr.mu.Unlock()
 			xfr.setStatus(TransferCompleted)
 			num++
-			total += xfr.length
 		}
 	}()
 
@@ -157,3 +168,542 @@ func TestEndpointWriteStream(t *testing.T) {
 		t.Errorf("received transfers: got %d, want %d", num, wantXfers)
 	}
 }
+
+func TestEndpointIsoWriteStream(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close: %v", err)
+		}
+	}()
+
+	// 48kHz 16-bit stereo audio, 192 bytes per 1ms frame.
+	const pktSize = 192
+	ep := &OutEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x01,
+		Number:        1,
+		Direction:     EndpointDirectionOut,
+		MaxPacketSize: pktSize,
+		TransferType:  TransferTypeIsochronous,
+		PollInterval:  time.Millisecond,
+	}}}
+	if _, err := ep.NewStream(pktSize+1, 3); err == nil {
+		t.Errorf("%s.NewStream(%d, 3): got nil error, want non-nil", ep, pktSize+1)
+	}
+
+	// The fake device keeps two transfers queued and completes the oldest
+	// one when the third arrives, as if it consumed one transfer worth of
+	// data while the host queued another one. drain completes all queued
+	// transfers, as if the host stopped sending data for a while.
+	var pktLens [][]int
+	done := make(chan struct{})
+	drain := make(chan chan struct{})
+	simDone := make(chan struct{})
+	go func() {
+		defer close(simDone)
+		var pending []*fakeTransfer
+		queue := func(ft *fakeTransfer) {
+			ft.mu.Lock()
+			pktLens = append(pktLens, ft.isoPktLens)
+			ft.mu.Unlock()
+			pending = append(pending, ft)
+		}
+		for {
+			select {
+			case ft := <-lib.submitted:
+				queue(ft)
+				if len(pending) == 3 {
+					pending[0].setStatus(TransferCompleted)
+					pending = pending[1:]
+				}
+			case reply := <-drain:
+				for more := true; more; {
+					select {
+					case ft := <-lib.submitted:
+						queue(ft)
+					default:
+						more = false
+					}
+				}
+				for _, ft := range pending {
+					ft.setStatus(TransferCompleted)
+				}
+				pending = nil
+				close(reply)
+			case <-done:
+				return
+			}
+		}
+	}()
+	drainAll := func() {
+		reply := make(chan struct{})
+		drain <- reply
+		<-reply
+	}
+
+	const xferSize = 4 * pktSize
+	stream, err := ep.NewStream(xferSize, 3)
+	if err != nil {
+		t.Fatalf("%s.NewStream(%d, 3): %v", ep, xferSize, err)
+	}
+	if n, err := stream.Write(make([]byte, 10*xferSize)); n != 10*xferSize || err != nil {
+		t.Fatalf("stream.Write(%d bytes): got %d, %v, want %d, nil", 10*xferSize, n, err, 10*xferSize)
+	}
+	if got := stream.Underruns(); got != 0 {
+		t.Errorf("stream.Underruns() during sustained playback: got %d, want 0", got)
+	}
+
+	// The device consumes all queued data before the next write.
+	drainAll()
+	if n, err := stream.Write(make([]byte, 200)); n != 200 || err != nil {
+		t.Fatalf("stream.Write(200 bytes): got %d, %v, want 200, nil", n, err)
+	}
+	if got := stream.Underruns(); got != 1 {
+		t.Errorf("stream.Underruns() after the queue ran dry: got %d, want 1", got)
+	}
+	drainAll()
+	if err := stream.Close(); err != nil {
+		t.Fatalf("stream.Close: %v", err)
+	}
+	close(done)
+	<-simDone
+
+	if got, want := stream.Written(), 10*xferSize+200; got != want {
+		t.Errorf("stream.Written(): got %d, want %d", got, want)
+	}
+	if got, want := len(pktLens), 11; got != want {
+		t.Fatalf("submitted transfers: got %d, want %d", got, want)
+	}
+	for i, l := range pktLens[:10] {
+		if want := []int{192, 192, 192, 192}; !reflect.DeepEqual(l, want) {
+			t.Errorf("transfer #%d: iso packet lengths %v, want %v", i, l, want)
+		}
+	}
+	if want := []int{192, 8}; !reflect.DeepEqual(pktLens[10], want) {
+		t.Errorf("last transfer: iso packet lengths %v, want %v", pktLens[10], want)
+	}
+}
+
+func TestEndpointStreamSetDepth(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close: %v", err)
+		}
+	}()
+	allocated := func() int {
+		lib.mu.Lock()
+		defer lib.mu.Unlock()
+		return len(lib.ts)
+	}
+	submitted := func(n int) []*fakeTransfer {
+		var ret []*fakeTransfer
+		for i := 0; i < n; i++ {
+			ret = append(ret, lib.waitForSubmitted(nil))
+		}
+		return ret
+	}
+	complete := func(fts []*fakeTransfer) {
+		for _, ft := range fts {
+			ft.setData(make([]byte, len(ft.buf)))
+			ft.setStatus(TransferCompleted)
+		}
+	}
+
+	iep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x82,
+		Number:        2,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+	rs, err := iep.NewStream(512, 2)
+	if err != nil {
+		t.Fatalf("%s.NewStream(512, 2): %v", iep, err)
+	}
+	inFlight := submitted(2)
+	if got := rs.Utilization(); got != 0 {
+		t.Errorf("ReadStream.Utilization() with all transfers waiting for the device: got %v, want 0", got)
+	}
+	if err := rs.SetDepth(4); err != nil {
+		t.Fatalf("ReadStream.SetDepth(4): %v", err)
+	}
+	inFlight = append(inFlight, submitted(2)...)
+	if got := allocated(); got != 4 {
+		t.Errorf("transfers after ReadStream.SetDepth(4): got %d, want 4", got)
+	}
+	complete(inFlight[:2])
+	if got := rs.Utilization(); got != 0.5 {
+		t.Errorf("ReadStream.Utilization() with half of the transfers completed: got %v, want 0.5", got)
+	}
+	complete(inFlight[2:])
+	if got := rs.Utilization(); got != 1 {
+		t.Errorf("ReadStream.Utilization() with all transfers completed: got %v, want 1", got)
+	}
+	if err := rs.SetDepth(1); err != nil {
+		t.Fatalf("ReadStream.SetDepth(1): %v", err)
+	}
+	buf := make([]byte, 512)
+	for i := 0; i < 4; i++ {
+		if n, err := rs.Read(buf); n != 512 || err != nil {
+			t.Fatalf("ReadStream.Read(): got %d, %v, want 512, nil", n, err)
+		}
+	}
+	last := submitted(1)
+	if got := allocated(); got != 1 {
+		t.Errorf("transfers after ReadStream.SetDepth(1): got %d, want 1", got)
+	}
+	rs.Close()
+	complete(last)
+	for {
+		if _, err := rs.Read(buf); err != nil {
+			break
+		}
+	}
+
+	oep := &OutEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x01,
+		Number:        1,
+		Direction:     EndpointDirectionOut,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+	ws, err := oep.NewStream(512, 1)
+	if err != nil {
+		t.Fatalf("%s.NewStream(512, 1): %v", oep, err)
+	}
+	if err := ws.SetDepth(3); err != nil {
+		t.Fatalf("WriteStream.SetDepth(3): %v", err)
+	}
+	if n, err := ws.Write(make([]byte, 3*512)); n != 3*512 || err != nil {
+		t.Fatalf("WriteStream.Write(): got %d, %v, want %d, nil", n, err, 3*512)
+	}
+	inFlight = submitted(3)
+	if got := ws.Utilization(); got != 1 {
+		t.Errorf("WriteStream.Utilization() with all transfers waiting for the device: got %v, want 1", got)
+	}
+	complete(inFlight)
+	if got := ws.Utilization(); got != 0 {
+		t.Errorf("WriteStream.Utilization() with all transfers completed: got %v, want 0", got)
+	}
+	if err := ws.SetDepth(1); err != nil {
+		t.Fatalf("WriteStream.SetDepth(1): %v", err)
+	}
+	if n, err := ws.Write(make([]byte, 512)); n != 512 || err != nil {
+		t.Fatalf("WriteStream.Write(): got %d, %v, want 512, nil", n, err)
+	}
+	complete(submitted(1))
+	if got := allocated(); got != 1 {
+		t.Errorf("transfers after WriteStream.SetDepth(1): got %d, want 1", got)
+	}
+	if err := ws.Close(); err != nil {
+		t.Fatalf("WriteStream.Close(): %v", err)
+	}
+	if got, want := ws.Written(), 4*512; got != want {
+		t.Errorf("WriteStream.Written(): got %d, want %d", got, want)
+	}
+	if err := ws.SetDepth(2); err == nil {
+		t.Error("WriteStream.SetDepth() after Close: got nil error, want non-nil")
+	}
+}
+
+func TestEndpointReadStreamResults(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close: %v", err)
+		}
+	}()
+
+	goodTransfers := 20
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var num int
+		for {
+			xfr := lib.waitForSubmitted(done)
+			if xfr == nil {
+				return
+			}
+			if num < goodTransfers {
+				xfr.setData(make([]byte, len(xfr.buf)))
+				xfr.setStatus(TransferCompleted)
+			} else {
+				xfr.setStatus(TransferError)
+			}
+			num++
+		}
+	}()
+
+	dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(9999, 0001): %v", err)
+	}
+	defer dev.Close()
+	intf, intfDone, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer intfDone()
+	ep, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	stream, err := ep.NewStream(512, 4)
+	if err != nil {
+		t.Fatalf("%s.NewStream(512, 4): %v", ep, err)
+	}
+	defer stream.Close()
+
+	results := stream.Results()
+	if again := stream.Results(); again != results {
+		t.Errorf("second Results(): got a different channel")
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		good     int
+		errs     []error
+		released int
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for res := range results {
+				if res.Err != nil {
+					mu.Lock()
+					errs = append(errs, res.Err)
+					mu.Unlock()
+					continue
+				}
+				if res.N != 512 || len(res.Data) != res.N || res.Status != TransferCompleted {
+					t.Errorf("got result with N=%d, len(Data)=%d, Status=%s, want 512, 512, %s", res.N, len(res.Data), res.Status, TransferCompleted)
+				}
+				mu.Lock()
+				good++
+				mu.Unlock()
+				if err := stream.Release(res.Data); err != nil {
+					t.Errorf("Release(): %v", err)
+				}
+				mu.Lock()
+				released++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if good != goodTransfers || released != goodTransfers {
+		t.Errorf("got %d good results, %d released, want %d", good, released, goodTransfers)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], TransferError) {
+		t.Errorf("error results: got %v, want a single %s", errs, TransferError)
+	}
+	if err := stream.Release(make([]byte, 512)); err == nil {
+		t.Errorf("Release() of a foreign buffer: got nil error, want non-nil")
+	}
+	if _, err := stream.Read(make([]byte, 512)); err == nil {
+		t.Errorf("Read() after Results(): got nil error, want non-nil")
+	}
+}
+
+func TestEndpointWriteStreamBuffers(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close: %v", err)
+		}
+	}()
+	ep := &OutEndpoint{newNullEndpoint(ctx, EndpointDirectionOut)}
+	stream, err := ep.NewStream(512, 2)
+	if err != nil {
+		t.Fatalf("%s.NewStream(512, 2): %v", ep, err)
+	}
+
+	// submit fills a buffer with the value v and submits its first n bytes,
+	// returning the transfer seen by the device.
+	submit := func(buf []byte, n int, v byte) *fakeTransfer {
+		t.Helper()
+		for i := range buf[:n] {
+			buf[i] = v
+		}
+		if err := stream.SubmitBuffer(buf[:n]); err != nil {
+			t.Fatalf("SubmitBuffer(%d bytes): %v", n, err)
+		}
+		ft := lib.waitForSubmitted(nil)
+		ft.mu.Lock()
+		defer ft.mu.Unlock()
+		if &ft.buf[0] != &buf[0] {
+			t.Errorf("SubmitBuffer(): the device got a copy of the buffer, want the buffer itself")
+		}
+		if ft.length != n || !bytes.Equal(ft.buf[:n], bytes.Repeat([]byte{v}, n)) {
+			t.Errorf("SubmitBuffer(): the device got %d bytes %v, want %d bytes of %d", ft.length, ft.buf[:ft.length], n, v)
+		}
+		return ft
+	}
+
+	b1, err := stream.NextBuffer(context.Background())
+	if err != nil {
+		t.Fatalf("NextBuffer(): %v", err)
+	}
+	b2, err := stream.NextBuffer(context.Background())
+	if err != nil {
+		t.Fatalf("NextBuffer(): %v", err)
+	}
+	if len(b1) != 512 || len(b2) != 512 || &b1[0] == &b2[0] {
+		t.Fatalf("NextBuffer(): got buffers of %d and %d bytes starting at %p and %p, want two distinct buffers of 512 bytes", len(b1), len(b2), &b1[0], &b2[0])
+	}
+	if err := stream.SubmitBuffer(make([]byte, 10)); err == nil {
+		t.Error("SubmitBuffer() of a foreign buffer: got nil error, want non-nil")
+	}
+	if err := stream.SubmitBuffer(b1[10:20]); err == nil {
+		t.Error("SubmitBuffer() of a buffer not starting at the beginning: got nil error, want non-nil")
+	}
+	ft1 := submit(b1, 100, 1)
+	ft2 := submit(b2, 200, 2)
+	if err := stream.SubmitBuffer(b1[:100]); err == nil {
+		t.Error("SubmitBuffer() of a buffer in flight: got nil error, want non-nil")
+	}
+
+	// Both buffers are in flight, the next one is available only after
+	// the first transfer completes.
+	got := make(chan []byte)
+	go func() {
+		b, err := stream.NextBuffer(context.Background())
+		if err != nil {
+			t.Errorf("NextBuffer(): %v", err)
+		}
+		got <- b
+	}()
+	select {
+	case <-got:
+		t.Fatal("NextBuffer() returned a buffer while all transfers were in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	ft1.setStatus(TransferCompleted)
+	b3 := <-got
+	if &b3[0] != &b1[0] {
+		t.Errorf("NextBuffer() after the first transfer completed: got the buffer at %p, want the buffer of the first transfer at %p", &b3[0], &b1[0])
+	}
+	ft3 := submit(b3, 50, 3)
+
+	ft2.setStatus(TransferCompleted)
+	ft3.setStatus(TransferCompleted)
+	if err := stream.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+	if got, want := stream.Written(), 350; got != want {
+		t.Errorf("Written(): got %d, want %d", got, want)
+	}
+
+	// Buffers not submitted are released by Close.
+	stream, err = ep.NewStream(512, 2)
+	if err != nil {
+		t.Fatalf("%s.NewStream(512, 2): %v", ep, err)
+	}
+	b, err := stream.NextBuffer(context.Background())
+	if err != nil {
+		t.Fatalf("NextBuffer(): %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+	if err := stream.SubmitBuffer(b); err == nil {
+		t.Error("SubmitBuffer() after Close(): got nil error, want non-nil")
+	}
+	if _, err := stream.NextBuffer(context.Background()); err != io.ErrClosedPipe {
+		t.Errorf("NextBuffer() after Close(): got error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestEndpointReadStreamDrain(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc     string
+		stale    int
+		last     TransferStatus
+		wantErr  error
+		wantRead bool
+	}{
+		{
+			desc:     "stale data, then timeout",
+			stale:    3,
+			last:     TransferTimedOut,
+			wantRead: true,
+		},
+		{
+			desc:     "no stale data",
+			last:     TransferTimedOut,
+			wantRead: true,
+		},
+		{
+			desc:    "stall while draining",
+			stale:   1,
+			last:    TransferStall,
+			wantErr: TransferStall,
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			lib := newFakeLibusb()
+			ctx := newContextWithImpl(lib)
+			defer ctx.Close()
+			ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+			drained := make(chan []time.Duration, 1)
+			go func() {
+				var timeouts []time.Duration
+				for i := 0; i <= tc.stale; i++ {
+					ft := lib.waitForSubmitted(nil)
+					ft.mu.Lock()
+					timeouts = append(timeouts, ft.timeout)
+					ft.mu.Unlock()
+					if i < tc.stale {
+						ft.setData(bytes.Repeat([]byte{0xee}, 512))
+						ft.setStatus(TransferCompleted)
+						continue
+					}
+					ft.setStatus(tc.last)
+				}
+				drained <- timeouts
+				if !tc.wantRead {
+					return
+				}
+				ft := lib.waitForSubmitted(nil)
+				ft.setData(bytes.Repeat([]byte{0x11}, 512))
+				ft.setStatus(TransferCompleted)
+			}()
+
+			stream, err := ep.NewStream(512, 1, WithDrain(time.Second))
+			timeouts := <-drained
+			for _, d := range timeouts {
+				if d != drainReadTimeout {
+					t.Errorf("drain transfer timeout: got %v, want %v", d, drainReadTimeout)
+				}
+			}
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("NewStream(WithDrain): got error %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer stream.Close()
+			buf := make([]byte, 512)
+			n, err := stream.Read(buf)
+			if err != nil {
+				t.Fatalf("stream.Read: %v", err)
+			}
+			if want := bytes.Repeat([]byte{0x11}, 512); !bytes.Equal(buf[:n], want) {
+				t.Errorf("stream.Read: got %x..., want fresh data %x...", buf[:4], want[:4])
+			}
+		})
+	}
+}
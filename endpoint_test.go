@@ -15,7 +15,12 @@
 package gousb
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -334,7 +339,7 @@ func TestReadContext(t *testing.T) {
 		ft.setData([]byte{1, 2, 3, 4, 5})
 		done()
 	}()
-	if got, err := iep.ReadContext(rCtx, buf); err != TransferCancelled {
+	if got, err := iep.ReadContext(rCtx, buf); !errors.Is(err, TransferCancelled) {
 		t.Errorf("%s.Read: got error %v, want %v", iep, err, TransferCancelled)
 	} else if want := 5; got != want {
 		t.Errorf("%s.Read: got %d bytes, want %d (partial read success)", iep, got, want)
@@ -350,9 +355,890 @@ func TestReadContext(t *testing.T) {
 		ft.setLength(5)
 		done()
 	}()
-	if got, err := oep.WriteContext(wCtx, buf); err != TransferCancelled {
+	if got, err := oep.WriteContext(wCtx, buf); !errors.Is(err, TransferCancelled) {
 		t.Errorf("%s.Write: got error %v, want %v", oep, err, TransferCancelled)
 	} else if want := 5; got != want {
 		t.Errorf("%s.Write: got %d bytes, want %d (partial write success)", oep, got, want)
 	}
 }
+
+func TestInterruptReadNoTimeout(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x83,
+		Number:        3,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 8,
+		TransferType:  TransferTypeInterrupt,
+		PollInterval:  10 * time.Millisecond,
+	}}}
+	stream, err := ep.NewStream(8, 1)
+	if err != nil {
+		t.Fatalf("%s.NewStream(8, 1): %v", ep, err)
+	}
+	defer stream.Close()
+
+	// The button is pressed after a delay much longer than the poll interval.
+	const delay = 200 * time.Millisecond
+	go func() {
+		ft := lib.waitForSubmitted(nil)
+		time.Sleep(delay)
+		ft.setData([]byte{1})
+		ft.setStatus(TransferCompleted)
+	}()
+	buf := make([]byte, 8)
+	start := time.Now()
+	if got, err := stream.ReadContext(context.Background(), buf); err != nil {
+		t.Fatalf("stream.ReadContext(): got error %v, want nil", err)
+	} else if got != 1 {
+		t.Errorf("stream.ReadContext(): got %d bytes, want 1", got)
+	}
+	if got := time.Since(start); got < delay {
+		t.Errorf("stream.ReadContext() returned after %v, before the data was available (%v)", got, delay)
+	}
+
+	// The transfer was resubmitted, but the button is never pressed again.
+	lib.waitForSubmitted(nil)
+	rCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := stream.ReadContext(rCtx, buf); err != TransferCancelled {
+		t.Errorf("stream.ReadContext(): got error %v, want %v", err, TransferCancelled)
+	}
+}
+
+func TestEndpointStats(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x82,
+		Number:        2,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+	results := []struct {
+		n      int
+		status TransferStatus
+	}{
+		{100, TransferCompleted},
+		{200, TransferCompleted},
+		{0, TransferTimedOut},
+		{10, TransferError},
+		{0, TransferStall},
+	}
+	done := make(chan struct{})
+	go func() {
+		for _, r := range results {
+			ft := lib.waitForSubmitted(nil)
+			ft.setData(make([]byte, r.n))
+			ft.setStatus(r.status)
+		}
+		close(done)
+	}()
+	// Stats are read concurrently with the transfers completing.
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				ep.Stats()
+			}
+		}
+	}()
+	buf := make([]byte, 512)
+	for range results {
+		ep.Read(buf)
+	}
+	<-done
+
+	want := EndpointStats{Transfers: 5, Bytes: 310, Errors: 2, Timeouts: 1}
+	if got := ep.Stats(); got != want {
+		t.Errorf("%s.Stats(): got %+v, want %+v", ep, got, want)
+	}
+	if got := ep.ResetStats(); got != want {
+		t.Errorf("%s.ResetStats(): got %+v, want %+v", ep, got, want)
+	}
+	if got := ep.Stats(); got != (EndpointStats{}) {
+		t.Errorf("%s.Stats() after reset: got %+v, want all zeros", ep, got)
+	}
+}
+
+func TestEndpointTransferError(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	iep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x82,
+		Number:        2,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+	oep := &OutEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x01,
+		Number:        1,
+		Direction:     EndpointDirectionOut,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+
+	for _, tc := range []struct {
+		op     func([]byte) (int, error)
+		status TransferStatus
+		want   EndpointError
+	}{
+		{iep.Read, TransferStall, EndpointError{Endpoint: 0x82, Op: "read", Status: TransferStall}},
+		{oep.Write, TransferNoDevice, EndpointError{Endpoint: 0x01, Op: "write", Status: TransferNoDevice}},
+	} {
+		go func(st TransferStatus) {
+			lib.waitForSubmitted(nil).setStatus(st)
+		}(tc.status)
+		_, err := tc.op(make([]byte, 512))
+		var te *EndpointError
+		if !errors.As(err, &te) {
+			t.Fatalf("got error %v of type %T, want an *EndpointError", err, err)
+		}
+		if *te != tc.want {
+			t.Errorf("got EndpointError %+v, want %+v", *te, tc.want)
+		}
+		if !errors.Is(err, tc.status) {
+			t.Errorf("errors.Is(%v, %v): got false, want true", err, tc.status)
+		}
+		var st TransferStatus
+		if !errors.As(err, &st) || st != tc.status {
+			t.Errorf("errors.As(%v, TransferStatus): got %v, want %v", err, st, tc.status)
+		}
+	}
+}
+
+func TestEndpointMaxTransferSize(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &OutEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x01,
+		Number:        1,
+		Direction:     EndpointDirectionOut,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+	if err := ep.SetMaxTransferSize(-1); err == nil {
+		t.Error("SetMaxTransferSize(-1): got nil error, want non-nil")
+	}
+	if err := ep.SetMaxTransferSize(100); err != nil {
+		t.Fatalf("SetMaxTransferSize(100): %v", err)
+	}
+
+	if n, err := ep.Write(make([]byte, 250)); err == nil {
+		t.Errorf("Write(250 bytes) without chunking: got %d, nil, want an error", n)
+	}
+	if _, err := ep.NewTransfer(250); err == nil {
+		t.Error("NewTransfer(250): got nil error, want non-nil")
+	}
+
+	ep.SetTransferChunking(true)
+	sizes := make(chan int, 3)
+	go func() {
+		for i := 0; i < 3; i++ {
+			ft := lib.waitForSubmitted(nil)
+			sizes <- len(ft.buf)
+			ft.setData(ft.buf)
+			ft.setStatus(TransferCompleted)
+		}
+	}()
+	n, err := ep.Write(make([]byte, 250))
+	if err != nil || n != 250 {
+		t.Errorf("Write(250 bytes) with chunking: got %d, %v, want 250, nil", n, err)
+	}
+	for _, want := range []int{100, 100, 50} {
+		if got := <-sizes; got != want {
+			t.Errorf("chunk size: got %d, want %d", got, want)
+		}
+	}
+}
+
+func TestEndpointFrames(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 64,
+		TransferType:  TransferTypeInterrupt,
+	}}}
+
+	want := [][]byte{{1}, {2, 3, 4}, {}, {5, 6}}
+	go func() {
+		for _, f := range want {
+			ft := lib.waitForSubmitted(nil)
+			ft.setData(f)
+			ft.setStatus(TransferCompleted)
+		}
+		// The next read fails, which closes the channel.
+		lib.waitForSubmitted(nil).setStatus(TransferError)
+	}()
+	var got [][]byte
+	for f := range ep.Frames(context.Background()) {
+		got = append(got, f)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Frames(): got %v, want %v", got, want)
+	}
+
+	// Cancelling the context stops the reads.
+	cctx, cancel := context.WithCancel(context.Background())
+	frames := ep.Frames(cctx)
+	lib.waitForSubmitted(nil)
+	cancel()
+	if f, ok := <-frames; ok {
+		t.Errorf("Frames() after cancel: got frame %v, want closed channel", f)
+	}
+}
+
+func TestEndpointReportFrames(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 12,
+		TransferType:  TransferTypeInterrupt,
+	}}}
+
+	transfers := [][]byte{
+		// three coalesced 4-byte reports.
+		{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0},
+		{},
+		{4, 1, 1, 1},
+		// a truncated last report.
+		{5, 2, 2, 2, 6},
+	}
+	go func() {
+		for _, d := range transfers {
+			ft := lib.waitForSubmitted(nil)
+			ft.setData(d)
+			ft.setStatus(TransferCompleted)
+		}
+		lib.waitForSubmitted(nil).setStatus(TransferError)
+	}()
+	var got [][]byte
+	for f := range ep.ReportFrames(context.Background(), 4) {
+		got = append(got, f)
+	}
+	want := [][]byte{{1, 0, 0, 0}, {2, 0, 0, 0}, {3, 0, 0, 0}, {4, 1, 1, 1}, {5, 2, 2, 2}, {6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReportFrames(4): got %v, want %v", got, want)
+	}
+
+	if _, ok := <-ep.ReportFrames(context.Background(), 0); ok {
+		t.Error("ReportFrames(0): got a frame, want closed channel")
+	}
+}
+
+func TestEndpointFire(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &OutEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x01,
+		Number:        1,
+		Direction:     EndpointDirectionOut,
+		MaxPacketSize: 64,
+		TransferType:  TransferTypeInterrupt,
+	}}}
+
+	data := []byte{1, 2, 3}
+	if err := ep.Fire(data); err != nil {
+		t.Fatalf("Fire(): %v", err)
+	}
+	// Fire returns before the transfer completes, the caller's buffer can
+	// be reused right away.
+	data[0] = 0xff
+	ft := lib.waitForSubmitted(nil)
+	ft.mu.Lock()
+	got := append([]byte(nil), ft.buf...)
+	ft.mu.Unlock()
+	if want := []byte{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fired transfer data: got %v, want %v", got, want)
+	}
+	ft.setLength(3)
+	ft.setStatus(TransferCompleted)
+
+	// The transfer is freed by gousb once it completes.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lib.mu.Lock()
+		n := len(lib.ts)
+		lib.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fired transfer was not freed after completion")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, want := ep.Stats(), (EndpointStats{Transfers: 1, Bytes: 3}); got != want {
+		t.Errorf("Stats(): got %+v, want %+v", got, want)
+	}
+}
+
+func TestEndpointFireLimit(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		// The fired transfers cancelled by Device.Close are freed.
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer done()
+	out, err := intf.OutEndpoint(1)
+	if err != nil {
+		t.Fatalf("%s.OutEndpoint(1): %v", intf, err)
+	}
+
+	var fts []*fakeTransfer
+	for i := 0; i < maxFiredTransfers; i++ {
+		if err := out.Fire([]byte{byte(i)}); err != nil {
+			t.Fatalf("Fire() #%d: %v", i, err)
+		}
+		fts = append(fts, lib.waitForSubmitted(nil))
+	}
+	if err := out.Fire([]byte{0}); err == nil {
+		t.Errorf("Fire() with %d fired transfers in flight: got nil error, want non-nil", maxFiredTransfers)
+	}
+	// A completed transfer makes room for another one.
+	fts[0].setStatus(TransferCompleted)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&out.fired) == maxFiredTransfers {
+		if time.Now().After(deadline) {
+			t.Fatalf("fired transfer was not released after completion")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := out.Fire([]byte{0}); err != nil {
+		t.Errorf("Fire() after a fired transfer completed: %v", err)
+	}
+	if err := dev.Close(); err != nil {
+		t.Errorf("%s.Close(): %v", dev, err)
+	}
+}
+
+func TestEndpointAfterDeviceClose(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer done()
+	in, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	out, err := intf.OutEndpoint(1)
+	if err != nil {
+		t.Fatalf("%s.OutEndpoint(1): %v", intf, err)
+	}
+	xfer, err := in.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("%s.NewTransfer(512): %v", in, err)
+	}
+	defer xfer.Free()
+	if err := dev.Close(); err != nil {
+		t.Fatalf("%s.Close(): %v", dev, err)
+	}
+
+	buf := make([]byte, 512)
+	if _, err := in.Read(buf); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("%s.Read(): got error %v, want %v", in, err, ErrDeviceClosed)
+	}
+	if _, err := out.Write(buf); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("%s.Write(): got error %v, want %v", out, err, ErrDeviceClosed)
+	}
+	if _, err := in.NewStream(512, 2); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("%s.NewStream(): got error %v, want %v", in, err, ErrDeviceClosed)
+	}
+	if err := xfer.Submit(); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Submit() of a transfer allocated before Close: got error %v, want %v", err, ErrDeviceClosed)
+	}
+	if _, err := xfer.Wait(context.Background()); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Wait() of a transfer allocated before Close: got error %v, want %v", err, ErrDeviceClosed)
+	}
+}
+
+func TestReadIsoPackets(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := newIsoInEndpoint(ctx) // MaxPacketSize 192
+	results := []IsoPacket{
+		{Length: 192, ActualLength: 100, Status: TransferCompleted},
+		{Length: 192, ActualLength: 192, Status: TransferCompleted},
+		{Length: 192, ActualLength: 0, Status: TransferError},
+		{Length: 192, ActualLength: 50, Status: TransferCompleted},
+	}
+	bufs := make(chan []byte, 10)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for num := 0; ; num++ {
+			ft := lib.waitForSubmitted(done)
+			if ft == nil {
+				return
+			}
+			// Each packet is filled with its index and the transfer number,
+			// at its offset in the buffer.
+			data := make([]byte, len(ft.buf))
+			for i := range data {
+				data[i] = byte(i/192<<4 | num&0x0f)
+			}
+			ft.mu.Lock()
+			ft.isoResults = results
+			ft.mu.Unlock()
+			ft.setData(data)
+			bufs <- ft.buf
+			ft.setStatus(TransferCompleted)
+		}
+	}()
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := ep.ReadIsoPackets(context.Background(), 4*192, 2, func(pkts []IsoPacketData) error {
+		buf := <-bufs
+		if len(pkts) != len(results) {
+			t.Fatalf("got %d packets, want %d", len(pkts), len(results))
+		}
+		for i, p := range pkts {
+			if len(p.Data) != results[i].ActualLength || p.Status != results[i].Status {
+				t.Errorf("call %d, packet %d: got %d bytes with status %s, want %d bytes with status %s", calls, i, len(p.Data), p.Status, results[i].ActualLength, results[i].Status)
+			}
+			if len(p.Data) == 0 {
+				continue
+			}
+			// The packet refers to the transfer buffer, at the packet offset.
+			if &p.Data[0] != &buf[i*192] {
+				t.Errorf("call %d, packet %d: data is not the transfer buffer at offset %d", calls, i, i*192)
+			}
+			if want := byte(i<<4 | calls&0x0f); p.Data[0] != want {
+				t.Errorf("call %d, packet %d: got data %02x, want %02x", calls, i, p.Data[0], want)
+			}
+		}
+		calls++
+		if calls == 5 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Errorf("ReadIsoPackets(): got error %v, want %v", err, errStop)
+	}
+	if calls != 5 {
+		t.Errorf("ReadIsoPackets(): fn called %d times, want 5", calls)
+	}
+	if got, want := ep.Stats().Bytes, int64(5*342); got < want {
+		t.Errorf("Stats().Bytes: got %d, want at least %d", got, want)
+	}
+
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ep.ReadIsoPackets(cctx, 4*192, 2, func([]IsoPacketData) error { return nil }); err != context.Canceled {
+		t.Errorf("ReadIsoPackets() with a cancelled context: got error %v, want %v", err, context.Canceled)
+	}
+	bulk := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	if err := bulk.ReadIsoPackets(context.Background(), 512, 2, func([]IsoPacketData) error { return nil }); err == nil {
+		t.Errorf("ReadIsoPackets() on a bulk endpoint: got nil error, want non-nil")
+	}
+}
+
+func TestEndpointReadExact(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc    string
+		exact   bool
+		length  int
+		want    int
+		wantErr error
+	}{
+		{desc: "full read", exact: true, length: 512, want: 512},
+		{desc: "short read", exact: true, length: 100, want: 100, wantErr: io.ErrUnexpectedEOF},
+		{desc: "short Read", exact: false, length: 100, want: 100},
+	} {
+		lib := newFakeLibusb()
+		ctx := newContextWithImpl(lib)
+		ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+		go func() {
+			ft := lib.waitForSubmitted(nil)
+			ft.setData(bytes.Repeat([]byte{0xaa}, tc.length))
+			ft.setStatus(TransferCompleted)
+		}()
+		read := ep.Read
+		if tc.exact {
+			read = ep.ReadExact
+		}
+		buf := make([]byte, 512)
+		got, err := read(buf)
+		if got != tc.want || !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: got %d, %v, want %d, %v", tc.desc, got, err, tc.want, tc.wantErr)
+		}
+		if !bytes.Equal(buf[:got], bytes.Repeat([]byte{0xaa}, got)) {
+			t.Errorf("%s: data doesn't match the data of the transfer", tc.desc)
+		}
+		if err := ctx.Close(); err != nil {
+			t.Errorf("%s: Context.Close(): %v", tc.desc, err)
+		}
+	}
+}
+
+func TestEndpointReadAtLeast(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		desc     string
+		lengths  []int
+		statuses []TransferStatus
+		min      int
+		want     int
+		wantErr  error
+	}{
+		{
+			desc:     "data across three transfers",
+			lengths:  []int{100, 200, 300},
+			statuses: []TransferStatus{TransferCompleted, TransferCompleted, TransferCompleted},
+			min:      500,
+			want:     600,
+		},
+		{
+			desc:     "single transfer",
+			lengths:  []int{512},
+			statuses: []TransferStatus{TransferCompleted},
+			min:      100,
+			want:     512,
+		},
+		{
+			desc:     "stall in the middle",
+			lengths:  []int{100, 0},
+			statuses: []TransferStatus{TransferCompleted, TransferStall},
+			min:      500,
+			want:     100,
+			wantErr:  TransferStall,
+		},
+	} {
+		lib := newFakeLibusb()
+		ctx := newContextWithImpl(lib)
+		ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+		var data []byte
+		go func() {
+			for i, l := range tc.lengths {
+				ft := lib.waitForSubmitted(nil)
+				chunk := make([]byte, l)
+				for j := range chunk {
+					chunk[j] = byte(i + 1)
+				}
+				data = append(data, chunk...)
+				ft.setData(chunk)
+				ft.setStatus(tc.statuses[i])
+			}
+		}()
+		buf := make([]byte, 1024)
+		got, err := ep.ReadAtLeast(buf, tc.min)
+		if got != tc.want || !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: ReadAtLeast(%d): got %d, %v, want %d, %v", tc.desc, tc.min, got, err, tc.want, tc.wantErr)
+		}
+		if !bytes.Equal(buf[:got], data[:got]) {
+			t.Errorf("%s: ReadAtLeast(%d): data doesn't match the data of the transfers", tc.desc, tc.min)
+		}
+		if err := ctx.Close(); err != nil {
+			t.Errorf("%s: Context.Close(): %v", tc.desc, err)
+		}
+	}
+
+	ctx := newContextWithImpl(newFakeLibusb())
+	defer ctx.Close()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	if _, err := ep.ReadAtLeast(make([]byte, 10), 20); err != io.ErrShortBuffer {
+		t.Errorf("ReadAtLeast() with a short buffer: got error %v, want %v", err, io.ErrShortBuffer)
+	}
+}
+
+func TestEndpointBufferForDuration(t *testing.T) {
+	t.Parallel()
+	ep := func(tt TransferType, mps int, interval time.Duration) *InEndpoint {
+		return &InEndpoint{&endpoint{Desc: EndpointDesc{
+			Address:       0x81,
+			Number:        1,
+			Direction:     EndpointDirectionIn,
+			MaxPacketSize: mps,
+			TransferType:  tt,
+			PollInterval:  interval,
+		}}}
+	}
+	for _, tc := range []struct {
+		desc string
+		ep   *InEndpoint
+		d    time.Duration
+		want int
+	}{
+		// 48kHz 16-bit stereo, 192 bytes per 1ms frame.
+		{"full-speed audio, 20ms", ep(TransferTypeIsochronous, 192, time.Millisecond), 20 * time.Millisecond, 20 * 192},
+		{"full-speed audio, partial frame", ep(TransferTypeIsochronous, 192, time.Millisecond), 2500 * time.Microsecond, 3 * 192},
+		{"full-speed audio, shorter than a frame", ep(TransferTypeIsochronous, 192, time.Millisecond), time.Microsecond, 192},
+		// High-bandwidth high-speed video, 3x1024 bytes per microframe.
+		{"high-speed video, 1ms", ep(TransferTypeIsochronous, 3072, 125*time.Microsecond), time.Millisecond, 8 * 3072},
+		// HID mouse, 8 bytes every 10ms.
+		{"interrupt, 100ms", ep(TransferTypeInterrupt, 8, 10*time.Millisecond), 100 * time.Millisecond, 80},
+	} {
+		got, err := tc.ep.BufferForDuration(tc.d)
+		if err != nil {
+			t.Errorf("%s: BufferForDuration(%v): %v", tc.desc, tc.d, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: BufferForDuration(%v): got %d, want %d", tc.desc, tc.d, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		desc string
+		ep   *InEndpoint
+		d    time.Duration
+	}{
+		{"bulk", ep(TransferTypeBulk, 512, 0), time.Second},
+		{"zero duration", ep(TransferTypeIsochronous, 192, time.Millisecond), 0},
+		{"negative duration", ep(TransferTypeInterrupt, 8, time.Millisecond), -time.Millisecond},
+		{"no interval", ep(TransferTypeInterrupt, 8, 0), time.Second},
+	} {
+		if got, err := tc.ep.BufferForDuration(tc.d); err == nil {
+			t.Errorf("%s: BufferForDuration(%v): got %d, want error", tc.desc, tc.d, got)
+		}
+	}
+}
+
+func TestEndpointTimeouts(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+	if err := ep.SetLibusbTimeout(-time.Second); err == nil {
+		t.Error("SetLibusbTimeout(-1s): got nil error, want non-nil")
+	}
+	if err := ep.SetWaitTimeout(-time.Second); err == nil {
+		t.Error("SetWaitTimeout(-1s): got nil error, want non-nil")
+	}
+	buf := make([]byte, 512)
+
+	// A libusb timeout alone: the transfer carries it and libusb reports
+	// the timeout with the partial data.
+	if err := ep.SetLibusbTimeout(50 * time.Millisecond); err != nil {
+		t.Fatalf("SetLibusbTimeout(50ms): %v", err)
+	}
+	go func() {
+		ft := lib.waitForSubmitted(nil)
+		ft.mu.Lock()
+		timeout := ft.timeout
+		ft.mu.Unlock()
+		if timeout != 50*time.Millisecond {
+			t.Errorf("libusb timeout of the transfer: got %v, want 50ms", timeout)
+		}
+		ft.setData([]byte{1, 2, 3})
+		ft.setStatus(TransferTimedOut)
+	}()
+	if n, err := ep.Read(buf); n != 3 || !errors.Is(err, TransferTimedOut) {
+		t.Errorf("Read() with a libusb timeout: got %d, %v, want 3, an error wrapping %v", n, err, TransferTimedOut)
+	}
+
+	// A wait timeout alone: the transfer has no libusb timeout and is
+	// cancelled when the wait timeout expires.
+	if err := ep.SetLibusbTimeout(0); err != nil {
+		t.Fatalf("SetLibusbTimeout(0): %v", err)
+	}
+	if err := ep.SetWaitTimeout(20 * time.Millisecond); err != nil {
+		t.Fatalf("SetWaitTimeout(20ms): %v", err)
+	}
+	submitted := make(chan *fakeTransfer, 1)
+	go func() {
+		submitted <- lib.waitForSubmitted(nil)
+	}()
+	start := time.Now()
+	if _, err := ep.Read(buf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Read() with a wait timeout: got %v, want an error wrapping %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("Read() with a wait timeout of 20ms returned after %v", d)
+	}
+	ft := <-submitted
+	ft.mu.Lock()
+	if ft.timeout != 0 || ft.status != TransferCancelled {
+		t.Errorf("transfer after the wait timeout: got libusb timeout %v, status %s, want 0, %s", ft.timeout, ft.status, TransferCancelled)
+	}
+	ft.mu.Unlock()
+
+	// The wait timeout doesn't hide the cancellation of the caller's
+	// context, nor a transfer completing in time.
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ep.ReadContext(cctx, buf); errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, TransferCancelled) {
+		t.Errorf("ReadContext(cancelled context): got %v, want an error wrapping %v", err, TransferCancelled)
+	}
+	lib.waitForSubmitted(nil)
+	if err := ep.SetWaitTimeout(time.Minute); err != nil {
+		t.Fatalf("SetWaitTimeout(1m): %v", err)
+	}
+	go func() {
+		ft := lib.waitForSubmitted(nil)
+		ft.setData([]byte{4, 5})
+		ft.setStatus(TransferCompleted)
+	}()
+	if n, err := ep.Read(buf); n != 2 || err != nil {
+		t.Errorf("Read() completing within the wait timeout: got %d, %v, want 2, nil", n, err)
+	}
+}
+
+func TestEndpointTimeoutRetries(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+	if err := ep.SetTimeoutRetries(-1); err == nil {
+		t.Error("SetTimeoutRetries(-1): got nil error, want non-nil")
+	}
+	if err := ep.SetLibusbTimeout(10 * time.Millisecond); err != nil {
+		t.Fatalf("SetLibusbTimeout(10ms): %v", err)
+	}
+	type result struct {
+		data   []byte
+		status TransferStatus
+	}
+	timedOut := result{status: TransferTimedOut}
+	for _, tc := range []struct {
+		desc    string
+		retries int
+		results []result
+		wantN   int
+		wantErr error
+	}{
+		{
+			desc:    "timeouts then success",
+			retries: 3,
+			results: []result{timedOut, timedOut, timedOut, {[]byte{1, 2, 3}, TransferCompleted}},
+			wantN:   3,
+		},
+		{
+			desc:    "retries exhausted",
+			retries: 2,
+			results: []result{timedOut, timedOut, timedOut},
+			wantErr: TransferTimedOut,
+		},
+		{
+			desc:    "no retries",
+			results: []result{timedOut},
+			wantErr: TransferTimedOut,
+		},
+		{
+			desc:    "partial data",
+			retries: 3,
+			results: []result{{[]byte{1}, TransferTimedOut}},
+			wantN:   1,
+			wantErr: TransferTimedOut,
+		},
+		{
+			desc:    "stall",
+			retries: 3,
+			results: []result{timedOut, {nil, TransferStall}},
+			wantErr: TransferStall,
+		},
+	} {
+		if err := ep.SetTimeoutRetries(tc.retries); err != nil {
+			t.Fatalf("%s: SetTimeoutRetries(%d): %v", tc.desc, tc.retries, err)
+		}
+		done := make(chan struct{})
+		go func(results []result) {
+			defer close(done)
+			for _, r := range results {
+				ft := lib.waitForSubmitted(nil)
+				ft.setData(r.data)
+				ft.setStatus(r.status)
+			}
+		}(tc.results)
+		n, err := ep.Read(make([]byte, 512))
+		<-done
+		if n != tc.wantN || (tc.wantErr == nil) != (err == nil) || (tc.wantErr != nil && !errors.Is(err, tc.wantErr)) {
+			t.Errorf("%s: Read(): got %d, %v, want %d, %v", tc.desc, n, err, tc.wantN, tc.wantErr)
+		}
+		// all attempts were made synchronously within Read.
+		select {
+		case ft := <-lib.submitted:
+			t.Errorf("%s: Read() submitted more than %d transfers", tc.desc, len(tc.results))
+			ft.setStatus(TransferCancelled)
+		default:
+		}
+	}
+}
@@ -16,7 +16,9 @@
 package gousb
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 )
 
 // #include <libusb.h>
@@ -75,6 +77,14 @@ var errorString = map[Error]string{
 	ErrorOther:        "unknown error",
 }
 
+// ErrDeviceNotFound is returned by Context.OpenDeviceWithMatcher when no
+// device matches.
+var ErrDeviceNotFound = errors.New("no matching device found")
+
+// ErrDeviceClosed is returned when a transfer is allocated or submitted on
+// an endpoint of a Device that was closed, see Device.Close.
+var ErrDeviceClosed = errors.New("the device was closed")
+
 // TransferStatus contains information about the result of a transfer.
 type TransferStatus uint8
 
@@ -87,6 +97,10 @@ const (
 	TransferStall     TransferStatus = C.LIBUSB_TRANSFER_STALL
 	TransferNoDevice  TransferStatus = C.LIBUSB_TRANSFER_NO_DEVICE
 	TransferOverflow  TransferStatus = C.LIBUSB_TRANSFER_OVERFLOW
+	// TransferPartial is not a libusb status. It is reported by gousb for
+	// isochronous transfers where some, but not all, packets failed. The
+	// data of the successful packets is still returned.
+	TransferPartial TransferStatus = 0x80
 )
 
 var transferStatusDescription = map[TransferStatus]string{
@@ -97,6 +111,7 @@ var transferStatusDescription = map[TransferStatus]string{
 	TransferStall:     "halt condition detected (endpoint stalled) or control request not supported",
 	TransferNoDevice:  "device was disconnected",
 	TransferOverflow:  "device sent more data than requested",
+	TransferPartial:   "some isochronous packets of the transfer failed",
 }
 
 // String returns a human-readable transfer status.
@@ -108,3 +123,146 @@ func (ts TransferStatus) String() string {
 func (ts TransferStatus) Error() string {
 	return ts.String()
 }
+
+// TransferStatusError is returned for transfers that fail with a status
+// that has a known remedy. It adds guidance to the status, and it unwraps to
+// the TransferStatus, so errors.Is(err, TransferStall) still works.
+type TransferStatusError struct {
+	// Status is the status of the failed transfer.
+	Status TransferStatus
+	// Hint describes the likely cause and the suggested action.
+	Hint string
+}
+
+// Error implements the error interface.
+func (e *TransferStatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Hint)
+}
+
+// Unwrap returns the transfer status.
+func (e *TransferStatusError) Unwrap() error {
+	return e.Status
+}
+
+// Errors returned in place of the corresponding TransferStatus values.
+var (
+	// ErrStall means that the endpoint is halted.
+	ErrStall = &TransferStatusError{TransferStall, "clear the halt condition of the endpoint (CLEAR_FEATURE(ENDPOINT_HALT)) before retrying; on the control endpoint, the device does not support the request"}
+	// ErrOverflow means that the device sent more data than requested.
+	ErrOverflow = &TransferStatusError{TransferOverflow, "use a transfer buffer that is a multiple of the endpoint max packet size, see http://libusb.sourceforge.net/api-1.0/libusb_packetoverflow.html"}
+	// ErrTransfer means the transfer failed at the bus level.
+	ErrTransfer = &TransferStatusError{TransferError, "low-level USB error, check the cable, the hubs and the power supply of the device"}
+)
+
+var transferStatusErrors = map[TransferStatus]error{
+	TransferStall:    ErrStall,
+	TransferOverflow: ErrOverflow,
+	TransferError:    ErrTransfer,
+}
+
+// statusError returns the error for a failed transfer status.
+func statusError(st TransferStatus) error {
+	if err, ok := transferStatusErrors[st]; ok {
+		return err
+	}
+	return st
+}
+
+// EndpointError is returned by endpoint Read and Write operations when
+// a transfer doesn't complete successfully. It carries the endpoint and the
+// operation that failed, together with the transfer status.
+// EndpointError unwraps to the status, so it can be checked with
+// errors.Is(err, TransferStall), or the status can be extracted with
+// errors.As.
+type EndpointError struct {
+	// Endpoint is the address of the endpoint used by the transfer.
+	Endpoint EndpointAddress
+	// Op is the operation that failed, "read" or "write".
+	Op string
+	// Status is the status of the failed transfer.
+	Status TransferStatus
+}
+
+// Error implements the error interface.
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("%s on endpoint %s: %s", e.Op, e.Endpoint, statusError(e.Status))
+}
+
+// Unwrap returns the error for the transfer status, either
+// a *TransferStatusError or the TransferStatus itself.
+func (e *EndpointError) Unwrap() error {
+	return statusError(e.Status)
+}
+
+// SubmitError is returned when libusb refuses to submit a transfer. It
+// carries the endpoint and the length of the transfer, together with the
+// libusb error, which it unwraps to, so errors.Is(err, ErrorNoDevice) still
+// works.
+type SubmitError struct {
+	// Endpoint is the address of the endpoint of the transfer. It's 0 for
+	// transfers wrapped with Context.WrapTransfer.
+	Endpoint EndpointAddress
+	// Length is the length of the transfer buffer in bytes.
+	Length int
+	// Err is the error returned by libusb_submit_transfer.
+	Err error
+}
+
+// submitErrorHints describe the likely cause of the common submit errors
+// and the suggested action.
+var submitErrorHints = map[Error]string{
+	ErrorNoDevice:     "the device was disconnected, close it and open it again after it's reconnected",
+	ErrorBusy:         "the transfer is already in flight, wait for it to complete before submitting it again",
+	ErrorNoMem:        "the system ran out of memory for transfers, use fewer or smaller transfers in flight, see SetMaxConcurrentTransfers, or raise the usbfs memory limit (usbcore.usbfs_memory_mb on Linux)",
+	ErrorInvalidParam: "the transfer size or type is not supported by the operating system or the host controller",
+	ErrorNotSupported: "the transfer type or flags are not supported by the operating system",
+}
+
+// Error implements the error interface.
+func (e *SubmitError) Error() string {
+	msg := fmt.Sprintf("submitting a transfer of %d bytes on endpoint %s: %v", e.Length, e.Endpoint, e.Err)
+	if le, ok := e.Err.(Error); ok {
+		if hint, ok := submitErrorHints[le]; ok {
+			msg += ": " + hint
+		}
+	}
+	return msg
+}
+
+// Unwrap returns the libusb error.
+func (e *SubmitError) Unwrap() error {
+	return e.Err
+}
+
+// errorList is a list of errors returned together, e.g. by Device.Close.
+// errors.Is and errors.As match any of the errors of the list.
+type errorList []error
+
+// Error implements the error interface.
+func (l errorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is returns true if any error of the list matches target.
+func (l errorList) Is(target error) bool {
+	for _, err := range l {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the list that matches target.
+func (l errorList) As(target interface{}) bool {
+	for _, err := range l {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
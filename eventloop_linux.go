@@ -0,0 +1,57 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+/*
+#define _GNU_SOURCE
+#include <sched.h>
+#include <sys/resource.h>
+#include <sys/syscall.h>
+#include <unistd.h>
+
+static int gousb_pin_thread(int cpu) {
+	cpu_set_t set;
+	CPU_ZERO(&set);
+	CPU_SET(cpu, &set);
+	return sched_setaffinity(0, sizeof(set), &set);
+}
+
+static int gousb_set_thread_nice(int nice) {
+	// On Linux, setpriority with a thread ID applies to that thread only.
+	return setpriority(PRIO_PROCESS, syscall(SYS_gettid), nice);
+}
+*/
+import "C"
+
+import "fmt"
+
+// setupEventThread applies the event loop options to the calling thread.
+func setupEventThread(o *contextOptions) error {
+	if o.pin {
+		if o.cpu < 0 || o.cpu >= C.CPU_SETSIZE {
+			return fmt.Errorf("invalid CPU %d", o.cpu)
+		}
+		if r, err := C.gousb_pin_thread(C.int(o.cpu)); r != 0 {
+			return fmt.Errorf("sched_setaffinity(CPU %d): %v", o.cpu, err)
+		}
+	}
+	if o.prio {
+		if r, err := C.gousb_set_thread_nice(C.int(o.nice)); r != 0 {
+			return fmt.Errorf("setpriority(%d): %v", o.nice, err)
+		}
+	}
+	return nil
+}
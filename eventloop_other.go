@@ -0,0 +1,29 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package gousb
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setupEventThread applies the event loop options to the calling thread.
+func setupEventThread(o *contextOptions) error {
+	return fmt.Errorf("event loop CPU and priority are not supported on %s", runtime.GOOS)
+}
@@ -19,6 +19,8 @@ type fakeDevice struct {
 	devDesc *DeviceDesc
 	strDesc map[int]string
 	alt     uint8
+	// cfg is the active configuration, 0 means the default config 1.
+	cfg uint8
 }
 
 var fakeDevices = []fakeDevice{
@@ -30,6 +32,7 @@ var fakeDevices = []fakeDevice{
 			Bus:      1,
 			Address:  1,
 			Port:     1,
+			Path:     []int{1},
 			Spec:     Version(2, 0),
 			Device:   Version(1, 0),
 			Vendor:   ID(0x9999),
@@ -74,6 +77,7 @@ var fakeDevices = []fakeDevice{
 			Bus:      1,
 			Address:  2,
 			Port:     2,
+			Path:     []int{2},
 			Spec:     Version(2, 0),
 			Device:   Version(1, 3),
 			Vendor:   ID(0x8888),
@@ -195,6 +199,7 @@ var fakeDevices = []fakeDevice{
 			Bus:      1,
 			Address:  3,
 			Port:     3,
+			Path:     []int{3},
 			Spec:     Version(2, 0),
 			Device:   Version(1, 0),
 			Vendor:   ID(0x1111),
@@ -40,10 +40,20 @@ type fakeTransfer struct {
 	length int
 	// ep is the endpoint that this transfer was created for.
 	ep *EndpointDesc
+	// completedAt is the time the last submission finished.
+	completedAt time.Time
 	// isoPackets is the number of isochronous transfers performed in a single libusb transfer
 	isoPackets int
 	// maxLength is the maximum number of bytes this transfer could contain
 	maxLength int
+	// isoPktLens are the iso packet lengths set by the last setLength.
+	isoPktLens []int
+	// isoResults are the iso packet descriptors returned by isoPackets.
+	isoResults []IsoPacket
+	// timeout is the transfer timeout set by setTimeout.
+	timeout time.Duration
+	// hook is the function set by setCompletionHook.
+	hook func()
 }
 
 func (t *fakeTransfer) setData(d []byte) {
@@ -67,13 +77,19 @@ func (t *fakeTransfer) setLength(n int) {
 
 func (t *fakeTransfer) setStatus(st TransferStatus) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	if t.finished {
+		t.mu.Unlock()
 		return
 	}
 	t.status = st
 	t.finished = true
+	t.completedAt = time.Now()
 	t.done <- struct{}{}
+	hook := t.hook
+	t.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
 }
 
 // fakeLibusb implements a fake libusb stack that pretends to have a number of
@@ -96,10 +112,25 @@ type fakeLibusb struct {
 	handles map[*libusbDevHandle]*libusbDevice
 	// claims is a map of devices to a set of claimed interfaces
 	claims map[*libusbDevice]map[uint8]bool
+	// version is the libusb version reported by getVersion.
+	version LibusbVersion
+	// buffers is the number of buffers allocated with allocBuffer and
+	// not freed yet.
+	buffers int
+	// hotplugCbs are the callbacks registered with registerHotplug.
+	hotplugCbs  map[int]func(*libusbDevice, bool)
+	nextHotplug int
+	// halts are the endpoints passed to clearHalt, clearHaltErr is its
+	// result.
+	halts        []EndpointAddress
+	clearHaltErr error
+	// releaseErr is the result of release.
+	releaseErr error
 }
 
-func (f *fakeLibusb) init() (*libusbContext, error)                       { return newContextPointer(), nil }
-func (f *fakeLibusb) handleEvents(c *libusbContext, done <-chan struct{}) { <-done }
+func (f *fakeLibusb) init() (*libusbContext, error)                                        { return newContextPointer(), nil }
+func (f *fakeLibusb) handleEvents(c *libusbContext, _ time.Duration, done <-chan struct{}) { <-done }
+func (f *fakeLibusb) interruptEvents(*libusbContext)                                       {}
 func (f *fakeLibusb) getDevices(*libusbContext) ([]*libusbDevice, error) {
 	ret := make([]*libusbDevice, 0, len(fakeDevices))
 	for d := range f.fakeDevices {
@@ -116,10 +147,15 @@ func (f *fakeLibusb) exit(*libusbContext) error {
 		}
 		return fmt.Errorf("fakeLibusb has %d remaining transfers that should have been freed", got)
 	}
+	if f.buffers > 0 {
+		return fmt.Errorf("fakeLibusb has %d remaining buffers that should have been freed", f.buffers)
+	}
 	return nil
 }
 
 func (f *fakeLibusb) setDebug(*libusbContext, int) {}
+func (f *fakeLibusb) getVersion() LibusbVersion    { return f.version }
+func (f *fakeLibusb) reference(d *libusbDevice)    {}
 func (f *fakeLibusb) dereference(d *libusbDevice)  {}
 func (f *fakeLibusb) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
 	if dev, ok := f.fakeDevices[d]; ok {
@@ -140,11 +176,29 @@ func (f *fakeLibusb) close(h *libusbDevHandle) {
 	defer f.mu.Unlock()
 	delete(f.handles, h)
 }
+func (f *fakeLibusb) getDevice(h *libusbDevHandle) *libusbDevice {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.handles[h]
+}
 func (f *fakeLibusb) reset(*libusbDevHandle) error { return nil }
+func (f *fakeLibusb) clearHalt(_ *libusbDevHandle, ep uint8) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.halts = append(f.halts, EndpointAddress(ep))
+	return f.clearHaltErr
+}
 func (f *fakeLibusb) control(*libusbDevHandle, time.Duration, uint8, uint8, uint16, uint16, []byte) (int, error) {
 	return 0, errors.New("not implemented")
 }
-func (f *fakeLibusb) getConfig(*libusbDevHandle) (uint8, error) { return 1, nil }
+func (f *fakeLibusb) getConfig(d *libusbDevHandle) (uint8, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if dev, ok := f.fakeDevices[f.handles[d]]; ok && dev.cfg != 0 {
+		return dev.cfg, nil
+	}
+	return 1, nil
+}
 func (f *fakeLibusb) setConfig(d *libusbDevHandle, cfg uint8) error {
 	debug.Printf("setConfig(%p, %d)\n", d, cfg)
 	f.mu.Lock()
@@ -152,9 +206,11 @@ func (f *fakeLibusb) setConfig(d *libusbDevHandle, cfg uint8) error {
 	if len(f.claims[f.handles[d]]) != 0 {
 		return fmt.Errorf("can't set device config while interfaces are claimed: %v", f.claims[f.handles[d]])
 	}
-	if cfg != 1 {
+	dev := f.fakeDevices[f.handles[d]]
+	if _, ok := dev.devDesc.Configs[int(cfg)]; !ok {
 		return fmt.Errorf("device doesn't have config number %d", cfg)
 	}
+	dev.cfg = cfg
 	return nil
 }
 func (f *fakeLibusb) getStringDesc(d *libusbDevHandle, index int) (string, error) {
@@ -184,15 +240,16 @@ func (f *fakeLibusb) claim(d *libusbDevHandle, intf uint8) error {
 	c[intf] = true
 	return nil
 }
-func (f *fakeLibusb) release(d *libusbDevHandle, intf uint8) {
+func (f *fakeLibusb) release(d *libusbDevHandle, intf uint8) error {
 	debug.Printf("release(%p, %d)\n", d, intf)
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	c := f.claims[f.handles[d]]
 	if c == nil {
-		return
+		return f.releaseErr
 	}
 	c[intf] = false
+	return f.releaseErr
 }
 func (f *fakeLibusb) setAlt(d *libusbDevHandle, intf, alt uint8) error {
 	debug.Printf("setAlt(%p, %d, %d)\n", d, intf, alt)
@@ -225,9 +282,47 @@ func (f *fakeLibusb) alloc(_ *libusbDevHandle, ep *EndpointDesc, isoPackets int,
 		isoPackets: isoPackets,
 		maxLength:  maxLen,
 		done:       done,
+		finished:   true, // not in flight until submitted
+	}
+	return t, nil
+}
+func (f *fakeLibusb) allocBuffer(size int) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buffers++
+	return make([]byte, size), nil
+}
+func (f *fakeLibusb) freeBuffer([]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.buffers--
+}
+func (f *fakeLibusb) allocWithBuffer(_ *libusbDevHandle, ep *EndpointDesc, isoPackets int, buf []byte, done chan struct{}) (*libusbTransfer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	maxLen := ep.MaxPacketSize
+	if isoPackets > 0 {
+		maxLen = isoPackets * ep.MaxPacketSize
+	}
+	if len(buf) > maxLen {
+		buf = buf[:maxLen]
+	}
+	t := newFakeTransferPointer()
+	f.ts[t] = &fakeTransfer{
+		buf:        buf,
+		ep:         ep,
+		isoPackets: isoPackets,
+		maxLength:  maxLen,
+		done:       done,
+		finished:   true, // not in flight until submitted
 	}
 	return t, nil
 }
+func (f *fakeLibusb) freeTransfer(t *libusbTransfer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.ts, t)
+}
 func (f *fakeLibusb) cancel(t *libusbTransfer) error {
 	f.mu.Lock()
 	ft := f.ts[t]
@@ -268,6 +363,137 @@ func (f *fakeLibusb) setIsoPacketLengths(t *libusbTransfer, length uint32) {
 	f.ts[t].maxLength = maxLen
 }
 
+func (f *fakeLibusb) setLength(t *libusbTransfer, length int, isoPackets []int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ft := f.ts[t]
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.length = length
+	ft.maxLength = length
+	ft.isoPktLens = isoPackets
+}
+
+func (f *fakeLibusb) timeout(t *libusbTransfer) time.Duration {
+	f.mu.Lock()
+	ft := f.ts[t]
+	f.mu.Unlock()
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.timeout
+}
+
+func (f *fakeLibusb) setCompletionHook(t *libusbTransfer, fn func()) {
+	f.mu.Lock()
+	ft := f.ts[t]
+	f.mu.Unlock()
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.hook = fn
+}
+
+func (f *fakeLibusb) completionTime(t *libusbTransfer) time.Time {
+	f.mu.Lock()
+	ft := f.ts[t]
+	f.mu.Unlock()
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.completedAt
+}
+
+func (f *fakeLibusb) setTimeout(t *libusbTransfer, d time.Duration) {
+	f.mu.Lock()
+	ft := f.ts[t]
+	f.mu.Unlock()
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.timeout = d
+}
+
+func (f *fakeLibusb) isoPackets(t *libusbTransfer, dst []IsoPacket) []IsoPacket {
+	f.mu.Lock()
+	ft := f.ts[t]
+	f.mu.Unlock()
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return append(dst, ft.isoResults...)
+}
+
+func (f *fakeLibusb) status(t *libusbTransfer) TransferStatus {
+	f.mu.Lock()
+	ft := f.ts[t]
+	f.mu.Unlock()
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.status
+}
+
+func (f *fakeLibusb) wrap(t *libusbTransfer, done chan struct{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ft := f.ts[t]
+	if ft == nil {
+		return fmt.Errorf("wrap(%p): unknown transfer", t)
+	}
+	if ft.done != nil {
+		return fmt.Errorf("wrap(%p): transfer is already managed by gousb", t)
+	}
+	ft.done = done
+	return nil
+}
+
+func (f *fakeLibusb) unwrap(t *libusbTransfer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ft := f.ts[t]; ft != nil {
+		ft.done = nil
+	}
+}
+
+// rawTransfer returns a transfer that was not allocated through gousb,
+// as if created by the user with libusb_alloc_transfer.
+func (f *fakeLibusb) rawTransfer(ep *EndpointDesc, bufLen int) *libusbTransfer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := newFakeTransferPointer()
+	f.ts[t] = &fakeTransfer{
+		buf:       make([]byte, bufLen),
+		ep:        ep,
+		maxLength: bufLen,
+		finished:  true,
+	}
+	return t
+}
+
+func (f *fakeLibusb) getParent(*libusbDevice) *libusbDevice { return nil }
+
+func (f *fakeLibusb) registerHotplug(_ *libusbContext, cb func(*libusbDevice, bool)) (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := f.nextHotplug
+	f.nextHotplug++
+	f.hotplugCbs[id] = cb
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.hotplugCbs, id)
+	}, nil
+}
+
+// hotplug simulates a hotplug event of a device in fakeDevices, calling
+// the registered callbacks like the libusb event loop does.
+func (f *fakeLibusb) hotplug(dev *libusbDevice, arrived bool) {
+	f.mu.Lock()
+	var cbs []func(*libusbDevice, bool)
+	for _, cb := range f.hotplugCbs {
+		cbs = append(cbs, cb)
+	}
+	f.mu.Unlock()
+	for _, cb := range cbs {
+		cb(dev, arrived)
+	}
+}
+
 // waitForSubmitted can be used by tests to define custom behavior of the transfers submitted on the USB bus.
 func (f *fakeLibusb) waitForSubmitted(done <-chan struct{}) *fakeTransfer {
 	select {
@@ -286,6 +512,48 @@ func (f *fakeLibusb) empty() bool {
 	return len(f.submitted) == 0
 }
 
+// controlRequest is a control request recorded by fakeControlLib.
+type controlRequest struct {
+	rType, request uint8
+	val, idx       uint16
+	data           []byte
+}
+
+// fakeControlLib is a fakeLibusb that records all control requests and
+// responds to them using the reply function.
+type fakeControlLib struct {
+	*fakeLibusb
+
+	ctrlMu sync.Mutex
+	reqs   []controlRequest
+	// timeouts are the timeouts of the recorded control requests.
+	timeouts []time.Duration
+	// reply is called for each control request. It can fill the data of
+	// IN requests and it returns the results of the control call.
+	// If reply is nil, control requests succeed and transfer all data.
+	reply func(req controlRequest, data []byte) (int, error)
+}
+
+func (f *fakeControlLib) control(_ *libusbDevHandle, timeout time.Duration, rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	req := controlRequest{rType, request, val, idx, append([]byte(nil), data...)}
+	f.ctrlMu.Lock()
+	f.reqs = append(f.reqs, req)
+	f.timeouts = append(f.timeouts, timeout)
+	reply := f.reply
+	f.ctrlMu.Unlock()
+	if reply == nil {
+		return len(data), nil
+	}
+	return reply(req, data)
+}
+
+// requests returns all the control requests recorded so far.
+func (f *fakeControlLib) requests() []controlRequest {
+	f.ctrlMu.Lock()
+	defer f.ctrlMu.Unlock()
+	return append([]controlRequest(nil), f.reqs...)
+}
+
 func newFakeLibusb() *fakeLibusb {
 	fl := &fakeLibusb{
 		fakeDevices: make(map[*libusbDevice]*fakeDevice),
@@ -293,6 +561,8 @@ func newFakeLibusb() *fakeLibusb {
 		submitted:   make(chan *fakeTransfer, 10),
 		handles:     make(map[*libusbDevHandle]*libusbDevice),
 		claims:      make(map[*libusbDevice]map[uint8]bool),
+		version:     LibusbVersion{1, 0, 26, 11724},
+		hotplugCbs:  make(map[int]func(*libusbDevice, bool)),
 	}
 	for _, d := range fakeDevices {
 		// libusb does not export a way to allocate a new libusb_device struct
@@ -0,0 +1,64 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// IsFeedback returns true for isochronous feedback endpoints. The packets
+// of a feedback endpoint don't carry data, but the rate at which the data
+// endpoint it belongs to consumes or produces samples, see ParseFeedback.
+// Endpoints with IsoUsageTypeImplicit carry data and are not feedback
+// endpoints.
+func (e EndpointDesc) IsFeedback() bool {
+	return e.TransferType == TransferTypeIsochronous && e.UsageType == IsoUsageTypeFeedback
+}
+
+// ParseFeedback decodes a packet of an isochronous feedback endpoint. It
+// returns the number of samples per frame (1ms) for full-speed devices or
+// per microframe (125µs) for high-speed devices. The format is selected by
+// the length of the packet: 3 bytes hold a 10.14 fixed point value, as used
+// by full-speed devices, 4 bytes hold a 16.16 fixed point value, as used by
+// high-speed devices.
+func ParseFeedback(pkt []byte) (float64, error) {
+	switch len(pkt) {
+	case 3:
+		v := uint32(pkt[0]) | uint32(pkt[1])<<8 | uint32(pkt[2])<<16
+		return float64(v) / (1 << 14), nil
+	case 4:
+		return float64(binary.LittleEndian.Uint32(pkt)) / (1 << 16), nil
+	}
+	return 0, fmt.Errorf("invalid feedback packet of %d bytes, want 3 or 4 bytes", len(pkt))
+}
+
+// ReadFeedback reads a single packet from an isochronous feedback endpoint
+// and returns the decoded rate, see ParseFeedback. Reading a feedback
+// endpoint with Read or a ReadStream returns the raw packets, gluing the
+// values of consecutive packets together.
+func (e *InEndpoint) ReadFeedback(ctx context.Context) (float64, error) {
+	if !e.Desc.IsFeedback() {
+		return 0, fmt.Errorf("endpoint %s is not an isochronous feedback endpoint", e)
+	}
+	buf := make([]byte, e.Desc.MaxPacketSize)
+	n, err := e.transfer(ctx, buf)
+	if err != nil {
+		return 0, err
+	}
+	return ParseFeedback(buf[:n])
+}
@@ -0,0 +1,124 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEndpointAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		bmAttributes uint8
+		wantSync     IsoSyncType
+		wantUsage    UsageType
+	}{
+		{0x01, IsoSyncTypeNone, IsoUsageTypeData},
+		{0x05, IsoSyncTypeAsync, IsoUsageTypeData},
+		{0x09, IsoSyncTypeAdaptive, IsoUsageTypeData},
+		{0x0d, IsoSyncTypeSync, IsoUsageTypeData},
+		// UAC feedback endpoint of an asynchronous playback interface.
+		{0x11, IsoSyncTypeNone, IsoUsageTypeFeedback},
+		{0x25, IsoSyncTypeAsync, IsoUsageTypeImplicit},
+		{0x31, IsoSyncTypeNone, UsageTypeUndefined},
+		{0x02, IsoSyncTypeNone, UsageTypeUndefined},
+		{0x13, IsoSyncTypeNone, UsageTypeUndefined},
+	} {
+		sync, usage := endpointAttributes(tc.bmAttributes)
+		if sync != tc.wantSync || usage != tc.wantUsage {
+			t.Errorf("endpointAttributes(0x%02x): got %s, %s, want %s, %s", tc.bmAttributes, sync, usage, tc.wantSync, tc.wantUsage)
+		}
+	}
+}
+
+func TestParseFeedback(t *testing.T) {
+	for _, tc := range []struct {
+		pkt  []byte
+		want float64
+	}{
+		// 48 samples per 1ms frame, 48kHz at full speed.
+		{[]byte{0x00, 0x00, 0x0c}, 48},
+		// 44.1 samples per frame, 10.14 rounded down.
+		{[]byte{0x66, 0x06, 0x0b}, 722534.0 / (1 << 14)},
+		// 6 samples per 125µs microframe, 48kHz at high speed.
+		{[]byte{0x00, 0x00, 0x06, 0x00}, 6},
+		{[]byte{0x00, 0x80, 0x05, 0x00}, 5.5},
+	} {
+		got, err := ParseFeedback(tc.pkt)
+		if err != nil {
+			t.Errorf("ParseFeedback(%v): %v", tc.pkt, err)
+			continue
+		}
+		if math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("ParseFeedback(%v): got %v, want %v", tc.pkt, got, tc.want)
+		}
+	}
+	for _, pkt := range [][]byte{nil, {1, 2}, {1, 2, 3, 4, 5}} {
+		if _, err := ParseFeedback(pkt); err == nil {
+			t.Errorf("ParseFeedback(%v): got nil error, want non-nil", pkt)
+		}
+	}
+}
+
+func TestReadFeedback(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	desc := EndpointDesc{
+		Address:       0x82,
+		Number:        2,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 3,
+		TransferType:  TransferTypeIsochronous,
+		PollInterval:  time.Millisecond,
+		UsageType:     IsoUsageTypeFeedback,
+	}
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: desc}}
+	if !desc.IsFeedback() {
+		t.Errorf("%s.IsFeedback(): got false, want true", desc)
+	}
+	if size, count := ep.StreamDefaults(); size != 3 || count != 2 {
+		t.Errorf("StreamDefaults() of a feedback endpoint: got %d, %d, want 3, 2", size, count)
+	}
+
+	go func() {
+		ft := lib.waitForSubmitted(nil)
+		ft.setData([]byte{0x00, 0x00, 0x0c})
+		ft.setStatus(TransferCompleted)
+	}()
+	got, err := ep.ReadFeedback(context.Background())
+	if err != nil {
+		t.Fatalf("ReadFeedback(): %v", err)
+	}
+	if got != 48 {
+		t.Errorf("ReadFeedback(): got %v, want 48", got)
+	}
+
+	data := newIsoInEndpoint(ctx)
+	if data.Desc.IsFeedback() {
+		t.Errorf("%s.IsFeedback(): got true, want false", data.Desc)
+	}
+	if _, err := data.ReadFeedback(context.Background()); err == nil {
+		t.Error("ReadFeedback() on a data endpoint: got nil error, want non-nil")
+	}
+}
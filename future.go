@@ -0,0 +1,105 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"sync"
+)
+
+// TransferFuture is a handle of a single transfer started by
+// InEndpoint.SubmitRead or OutEndpoint.SubmitWrite. The transfer runs in
+// the background, its result is collected with Wait. Any number of futures
+// can be outstanding at the same time, on one or more endpoints, and they
+// can be waited for in any order.
+type TransferFuture struct {
+	e   *endpoint
+	t   *usbTransfer
+	buf []byte
+
+	// mu serializes Wait calls and protects the fields below.
+	mu sync.Mutex
+	// done is true after the transfer finished and was freed.
+	done bool
+	n    int
+	err  error
+}
+
+func (e *endpoint) submitFuture(buf []byte) (*TransferFuture, error) {
+	t, err := e.newUSBTransfer(len(buf))
+	if err != nil {
+		return nil, err
+	}
+	if e.Desc.Direction == EndpointDirectionOut {
+		copy(t.data(), buf)
+	}
+	if err := t.submit(); err != nil {
+		t.free()
+		return nil, err
+	}
+	return &TransferFuture{e: e, t: t, buf: buf}, nil
+}
+
+// SubmitRead starts a single read transfer from the endpoint into buf and
+// returns without waiting for it to finish. buf must not be used until
+// Wait of the returned future returns. The same rules for the size of buf
+// apply as for Read.
+func (e *InEndpoint) SubmitRead(buf []byte) (*TransferFuture, error) {
+	return e.submitFuture(buf)
+}
+
+// SubmitWrite starts a single write transfer of data to the endpoint and
+// returns without waiting for it to finish. The data is copied, data can be
+// reused as soon as SubmitWrite returns.
+func (e *OutEndpoint) SubmitWrite(data []byte) (*TransferFuture, error) {
+	return e.submitFuture(data)
+}
+
+// Wait blocks until the transfer finishes and returns the number of bytes
+// transferred and the transfer error, which are the same as those returned
+// by ReadContext and WriteContext. For read transfers, the data is in the
+// buffer passed to SubmitRead when Wait returns. If ctx is done before the
+// transfer finishes, the transfer is cancelled. Wait can be called
+// repeatedly, after the first Wait returns all calls return the same
+// results.
+func (f *TransferFuture) Wait(ctx context.Context) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return f.n, f.err
+	}
+	n, err := f.t.wait(ctx)
+	if f.e.Desc.Direction == EndpointDirectionIn {
+		copy(f.buf, f.t.data()[:n])
+	}
+	f.t.free()
+	f.n, f.err, f.done = n, f.e.transferError(err), true
+	return f.n, f.err
+}
+
+// IsDone returns true if the transfer finished, in which case Wait returns
+// without blocking. IsDone doesn't block and can be called concurrently
+// with Wait.
+func (f *TransferFuture) IsDone() bool {
+	return f.t.completed()
+}
+
+// Cancel aborts the transfer. The transfer is cancelled asynchronously,
+// Wait still needs to be called to collect the result and to release the
+// transfer.
+func (f *TransferFuture) Cancel() error {
+	return f.t.cancel()
+}
@@ -0,0 +1,126 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTransferFuture(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+	const count = 4
+	var futures []*TransferFuture
+	var bufs [][]byte
+	fts := map[*fakeTransfer]int{}
+	for i := 0; i < count; i++ {
+		buf := make([]byte, 512)
+		f, err := ep.SubmitRead(buf)
+		if err != nil {
+			t.Fatalf("SubmitRead(): %v", err)
+		}
+		futures = append(futures, f)
+		bufs = append(bufs, buf)
+		fts[lib.waitForSubmitted(nil)] = i
+	}
+	for i, f := range futures {
+		if f.IsDone() {
+			t.Errorf("future %d: IsDone() before the transfer completed: got true, want false", i)
+		}
+	}
+	// Complete the transfers, each with data identifying its future.
+	for ft, i := range fts {
+		ft.setData(bytes.Repeat([]byte{byte(i + 1)}, 10*(i+1)))
+		ft.setStatus(TransferCompleted)
+	}
+	// Collect the results out of order.
+	for _, i := range []int{2, 0, 3, 1} {
+		n, err := futures[i].Wait(context.Background())
+		if err != nil {
+			t.Errorf("future %d: Wait(): %v", i, err)
+		}
+		if want := 10 * (i + 1); n != want {
+			t.Errorf("future %d: Wait(): got %d bytes, want %d", i, n, want)
+		}
+		if want := bytes.Repeat([]byte{byte(i + 1)}, n); !bytes.Equal(bufs[i][:n], want) {
+			t.Errorf("future %d: buffer after Wait(): got %v, want %v", i, bufs[i][:n], want)
+		}
+		if !futures[i].IsDone() {
+			t.Errorf("future %d: IsDone() after Wait(): got false, want true", i)
+		}
+		// The results are kept by the future.
+		if n2, err2 := futures[i].Wait(context.Background()); n2 != n || err2 != err {
+			t.Errorf("future %d: second Wait(): got %d, %v, want %d, %v", i, n2, err2, n, err)
+		}
+	}
+
+	// A cancelled future reports the cancellation as an endpoint error.
+	f, err := ep.SubmitRead(make([]byte, 512))
+	if err != nil {
+		t.Fatalf("SubmitRead(): %v", err)
+	}
+	lib.waitForSubmitted(nil)
+	if err := f.Cancel(); err != nil {
+		t.Errorf("Cancel(): %v", err)
+	}
+	var epErr *EndpointError
+	if _, err := f.Wait(context.Background()); !errors.As(err, &epErr) || epErr.Status != TransferCancelled {
+		t.Errorf("Wait() after Cancel(): got error %v, want an EndpointError with status %s", err, TransferCancelled)
+	}
+}
+
+func TestTransferFutureWrite(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &OutEndpoint{newNullEndpoint(ctx, EndpointDirectionOut)}
+
+	data := []byte{1, 2, 3, 4, 5}
+	f, err := ep.SubmitWrite(data)
+	if err != nil {
+		t.Fatalf("SubmitWrite(): %v", err)
+	}
+	// The data is copied, the caller can reuse its buffer.
+	copy(data, []byte{9, 9, 9, 9, 9})
+	ft := lib.waitForSubmitted(nil)
+	ft.mu.Lock()
+	got := append([]byte(nil), ft.buf[:len(data)]...)
+	ft.mu.Unlock()
+	if want := []byte{1, 2, 3, 4, 5}; !bytes.Equal(got, want) {
+		t.Errorf("SubmitWrite(): the device got %v, want %v", got, want)
+	}
+	ft.setData(got)
+	ft.setStatus(TransferCompleted)
+	if n, err := f.Wait(context.Background()); n != len(data) || err != nil {
+		t.Errorf("Wait(): got %d, %v, want %d, nil", n, err, len(data))
+	}
+}
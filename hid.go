@@ -0,0 +1,113 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "fmt"
+
+// HID report descriptor item types and tags, HID 1.11 section 6.2.2.
+const (
+	hidItemMain   = 0
+	hidItemGlobal = 1
+	hidItemLong   = 0xfe
+
+	hidMainInput = 0x8
+
+	hidGlobalReportSize  = 0x7
+	hidGlobalReportID    = 0x8
+	hidGlobalReportCount = 0x9
+	hidGlobalPush        = 0xa
+	hidGlobalPop         = 0xb
+)
+
+// HIDInputReportSize returns the size in bytes of the input reports
+// described by the HID report descriptor reportDesc, as returned by
+// Device.GetHIDReportDescriptor. If the descriptor uses report IDs, the
+// returned size includes the report ID byte prefixing each report.
+// HIDInputReportSize returns an error if the descriptor is malformed, or if
+// the input reports with different IDs differ in size, in which case
+// the reports can't be split at fixed offsets, e.g. by
+// InEndpoint.ReportFrames.
+func HIDInputReportSize(reportDesc []byte) (int, error) {
+	type globals struct {
+		size, count, id uint32
+	}
+	var (
+		cur    globals
+		stack  []globals
+		usesID bool
+		order  []uint32
+		bits   = make(map[uint32]uint32)
+	)
+	for i := 0; i < len(reportDesc); {
+		prefix := reportDesc[i]
+		if prefix == hidItemLong {
+			if i+1 >= len(reportDesc) {
+				return 0, fmt.Errorf("long item at offset %d is truncated", i)
+			}
+			i += 3 + int(reportDesc[i+1])
+			continue
+		}
+		size := int(prefix & 0x3)
+		if size == 3 {
+			size = 4
+		}
+		if i+1+size > len(reportDesc) {
+			return 0, fmt.Errorf("item 0x%02x at offset %d is truncated", prefix, i)
+		}
+		var val uint32
+		for j := size - 1; j >= 0; j-- {
+			val = val<<8 | uint32(reportDesc[i+1+j])
+		}
+		i += 1 + size
+
+		typ, tag := (prefix>>2)&0x3, prefix>>4
+		switch {
+		case typ == hidItemGlobal && tag == hidGlobalReportSize:
+			cur.size = val
+		case typ == hidItemGlobal && tag == hidGlobalReportCount:
+			cur.count = val
+		case typ == hidItemGlobal && tag == hidGlobalReportID:
+			cur.id = val
+			usesID = true
+		case typ == hidItemGlobal && tag == hidGlobalPush:
+			stack = append(stack, cur)
+		case typ == hidItemGlobal && tag == hidGlobalPop:
+			if len(stack) == 0 {
+				return 0, fmt.Errorf("pop item at offset %d without a matching push", i-1-size)
+			}
+			cur, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		case typ == hidItemMain && tag == hidMainInput:
+			if _, ok := bits[cur.id]; !ok {
+				order = append(order, cur.id)
+			}
+			bits[cur.id] += cur.size * cur.count
+		}
+	}
+	if len(order) == 0 {
+		return 0, fmt.Errorf("report descriptor doesn't describe any input reports")
+	}
+	reportBits := bits[order[0]]
+	for _, id := range order[1:] {
+		if bits[id] != reportBits {
+			return 0, fmt.Errorf("input report %d is %d bits long, report %d is %d bits long; reports differ in size", order[0], reportBits, id, bits[id])
+		}
+	}
+	ret := int((reportBits + 7) / 8)
+	if usesID {
+		ret++
+	}
+	return ret, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "testing"
+
+func TestHIDInputReportSize(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		report  []byte
+		want    int
+		wantErr bool
+	}{
+		{
+			desc: "boot mouse",
+			report: []byte{
+				0x05, 0x01, 0x09, 0x02, 0xa1, 0x01, 0x09, 0x01,
+				0xa1, 0x00, 0x05, 0x09, 0x19, 0x01, 0x29, 0x03,
+				0x15, 0x00, 0x25, 0x01, 0x95, 0x03, 0x75, 0x01,
+				0x81, 0x02, 0x95, 0x01, 0x75, 0x05, 0x81, 0x01,
+				0x05, 0x01, 0x09, 0x30, 0x09, 0x31, 0x15, 0x81,
+				0x25, 0x7f, 0x75, 0x08, 0x95, 0x02, 0x81, 0x06,
+				0xc0, 0xc0,
+			},
+			want: 3,
+		},
+		{
+			desc: "report IDs, output reports ignored",
+			report: []byte{
+				0x85, 0x01, 0x75, 0x08, 0x95, 0x04, 0x81, 0x02,
+				0x91, 0x02, 0x91, 0x02,
+				0x85, 0x02, 0x75, 0x10, 0x95, 0x02, 0x81, 0x02,
+			},
+			want: 5,
+		},
+		{
+			desc: "push and pop",
+			report: []byte{
+				0x75, 0x08, 0x95, 0x02, 0xa4, 0x75, 0x01, 0x95,
+				0x08, 0x81, 0x02, 0xb4, 0x81, 0x02,
+			},
+			want: 3,
+		},
+		{
+			desc: "report IDs of different sizes",
+			report: []byte{
+				0x85, 0x01, 0x75, 0x08, 0x95, 0x04, 0x81, 0x02,
+				0x85, 0x02, 0x95, 0x02, 0x81, 0x02,
+			},
+			wantErr: true,
+		},
+		{
+			desc:    "truncated item",
+			report:  []byte{0x75, 0x08, 0x96, 0x02},
+			wantErr: true,
+		},
+		{
+			desc:    "no input reports",
+			report:  []byte{0x75, 0x08, 0x95, 0x02, 0x91, 0x02},
+			wantErr: true,
+		},
+	} {
+		got, err := HIDInputReportSize(tc.report)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: HIDInputReportSize(): got error %v, want error: %v", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: HIDInputReportSize(): got %d, want %d", tc.desc, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// defaultNotificationBuffer is the capacity of the channel returned by
+// Notifications without WithNotificationBuffer.
+const defaultNotificationBuffer = 16
+
+// DeviceEventType is the kind of a DeviceEvent.
+type DeviceEventType int
+
+// Device events.
+const (
+	// DeviceArrived means that a device was connected.
+	DeviceArrived DeviceEventType = iota + 1
+	// DeviceLeft means that a device was disconnected.
+	DeviceLeft
+)
+
+var deviceEventTypeDescription = map[DeviceEventType]string{
+	DeviceArrived: "arrived",
+	DeviceLeft:    "left",
+}
+
+func (t DeviceEventType) String() string {
+	if d, ok := deviceEventTypeDescription[t]; ok {
+		return d
+	}
+	return fmt.Sprintf("unknown device event %d", int(t))
+}
+
+// DeviceEvent is a device arrival or departure delivered by Notifications.
+type DeviceEvent struct {
+	// Type is the kind of the event.
+	Type DeviceEventType
+	// Desc is the descriptor of the device. It's read while the event is
+	// delivered, without reading the descriptor of the parent hub, so
+	// Desc.Parent is always nil. A device that arrived can be opened
+	// e.g. with Context.OpenByID(Desc.ID()).
+	Desc *DeviceDesc
+}
+
+// String returns a human-readable description of the event.
+func (e DeviceEvent) String() string {
+	return fmt.Sprintf("%s %s", e.Desc, e.Type)
+}
+
+// NotificationOption configures the channel returned by Notifications.
+type NotificationOption func(*notificationOptions)
+
+type notificationOptions struct {
+	buffer int
+	block  bool
+}
+
+// WithNotificationBuffer sets the capacity of the notification channel,
+// 16 by default.
+func WithNotificationBuffer(n int) NotificationOption {
+	return func(o *notificationOptions) {
+		o.buffer = n
+	}
+}
+
+// WithBlockingNotifications makes Notifications wait for the receiver
+// when the channel is full, instead of dropping the event. Events are
+// delivered on the libusb event loop of the Context, which also completes
+// all transfers: a receiver that falls behind then stalls the transfers of
+// all devices of the Context until it catches up. Without this option,
+// events that don't fit into the channel are dropped, and the receiver can
+// find the current state of the bus with Context.ForEachDevice.
+func WithBlockingNotifications() NotificationOption {
+	return func(o *notificationOptions) {
+		o.block = true
+	}
+}
+
+// notifier delivers hotplug events to one channel returned by
+// Notifications.
+type notifier struct {
+	c     *Context
+	block bool
+	// quit is closed when the notifier is stopped, unblocking a pending
+	// delivery.
+	quit     chan struct{}
+	stopOnce sync.Once
+	// deregister removes the libusb hotplug callback.
+	deregister func()
+
+	// mu protects ch and closed, held during delivery.
+	mu     sync.Mutex
+	ch     chan DeviceEvent
+	closed bool
+}
+
+// deliver sends the event of a device arrival or departure to the channel.
+func (n *notifier) deliver(dev *libusbDevice, arrived bool) {
+	desc, err := n.c.libusb.getDeviceDesc(dev)
+	if err != nil {
+		debug.Printf("Notifications(): reading the descriptor of a hotplugged device: %v", err)
+		return
+	}
+	ev := DeviceEvent{Type: DeviceLeft, Desc: desc}
+	if arrived {
+		ev.Type = DeviceArrived
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	if n.block {
+		select {
+		case n.ch <- ev:
+		case <-n.quit:
+		}
+		return
+	}
+	select {
+	case n.ch <- ev:
+	default:
+		debug.Printf("Notifications(): channel full, dropped event %s", ev)
+	}
+}
+
+// stop deregisters the notifier and closes its channel.
+func (n *notifier) stop() {
+	n.stopOnce.Do(func() {
+		close(n.quit)
+		n.deregister()
+		n.mu.Lock()
+		n.closed = true
+		close(n.ch)
+		n.mu.Unlock()
+		n.c.mu.Lock()
+		delete(n.c.notifiers, n)
+		n.c.mu.Unlock()
+	})
+}
+
+// Notifications returns a channel delivering an event for every device
+// that is connected to or disconnected from the system, so that an
+// application can follow all devices in a single select loop. Devices
+// already connected when Notifications is called are not reported, use
+// ForEachDevice to find them. Each call returns its own channel, which is
+// closed when ctx is done or the Context is closed.
+// When the channel is full, events are dropped, unless the channel was
+// requested WithBlockingNotifications.
+// Notifications requires hotplug support in libusb and in the operating
+// system, an error wrapping ErrorNotSupported is returned otherwise.
+func (c *Context) Notifications(ctx context.Context, opts ...NotificationOption) (<-chan DeviceEvent, error) {
+	if c.ctx == nil {
+		return nil, errors.New("Notifications called on a closed or uninitialized Context")
+	}
+	o := notificationOptions{buffer: defaultNotificationBuffer}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.buffer < 0 {
+		return nil, fmt.Errorf("invalid notification buffer size %d, must be >= 0", o.buffer)
+	}
+	n := &notifier{
+		c:     c,
+		block: o.block,
+		quit:  make(chan struct{}),
+		ch:    make(chan DeviceEvent, o.buffer),
+	}
+	deregister, err := c.libusb.registerHotplug(c.ctx, n.deliver)
+	if err != nil {
+		return nil, fmt.Errorf("registering for hotplug events: %w", err)
+	}
+	n.deregister = deregister
+	c.mu.Lock()
+	c.notifiers[n] = true
+	c.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-n.quit:
+		}
+		n.stop()
+	}()
+	return n.ch, nil
+}
+
+// stopNotifiers stops the notifiers of the Context, see Close.
+func (c *Context) stopNotifiers() {
+	c.mu.Lock()
+	ns := make([]*notifier, 0, len(c.notifiers))
+	for n := range c.notifiers {
+		ns = append(ns, n)
+	}
+	c.mu.Unlock()
+	for _, n := range ns {
+		n.stop()
+	}
+}
+
+// PowerEventType is the kind of a PowerEvent.
+type PowerEventType int
+
+// Power events.
+const (
+	// BusSuspended means that the bus was suspended, e.g. because the
+	// host is going to sleep.
+	BusSuspended PowerEventType = iota + 1
+	// BusResumed means that the bus resumed from suspend.
+	BusResumed
+)
+
+// PowerEvent is a suspend or resume of a bus, see PowerEvents.
+type PowerEvent struct {
+	// Type is the kind of the event.
+	Type PowerEventType
+	// Bus is the number of the bus that was suspended or resumed.
+	Bus int
+}
+
+// PowerEvents is meant to deliver the suspend and resume events of the
+// buses of the Context, so that streams can be paused before a suspend and
+// restarted cleanly afterwards. No libusb backend reports such events, so
+// on all platforms PowerEvents currently returns an error wrapping
+// ErrUnsupported.
+//
+// Without power events, a suspend typically shows up as failures of the
+// transfers in flight, e.g. with TransferNoDevice or TransferError, and
+// devices that don't survive the suspend are reported as DeviceLeft and,
+// after the resume, DeviceArrived by Notifications. Applications that need to resume streaming should treat
+// these errors as a reason to reopen the device, instead of as fatal.
+func (c *Context) PowerEvents(ctx context.Context) (<-chan PowerEvent, error) {
+	return nil, fmt.Errorf("PowerEvents: %w, libusb doesn't report bus suspend and resume events", ErrUnsupported)
+}
@@ -0,0 +1,133 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNotifications(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := c.Notifications(ctx)
+	if err != nil {
+		t.Fatalf("Notifications(): %v", err)
+	}
+	dropping, err := c.Notifications(context.Background(), WithNotificationBuffer(1))
+	if err != nil {
+		t.Fatalf("Notifications(WithNotificationBuffer(1)): %v", err)
+	}
+
+	desc := &DeviceDesc{Bus: 3, Address: 7, Vendor: 0x5555, Product: 0x0001}
+	dev := newDevicePointer()
+	lib.fakeDevices[dev] = &fakeDevice{devDesc: desc}
+	lib.hotplug(dev, true)
+	lib.hotplug(dev, false)
+	delete(lib.fakeDevices, dev)
+
+	want := []DeviceEvent{
+		{Type: DeviceArrived, Desc: desc},
+		{Type: DeviceLeft, Desc: desc},
+	}
+	for _, w := range want {
+		select {
+		case got := <-ch:
+			if !reflect.DeepEqual(got, w) {
+				t.Errorf("Notifications(): got event %v, want %v", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Notifications(): no event received, want %v", w)
+		}
+	}
+	// The second event didn't fit into the channel and was dropped.
+	if got := <-dropping; !reflect.DeepEqual(got, want[0]) {
+		t.Errorf("Notifications(WithNotificationBuffer(1)): got event %v, want %v", got, want[0])
+	}
+	select {
+	case got := <-dropping:
+		t.Errorf("Notifications(WithNotificationBuffer(1)): got event %v, want the event to be dropped", got)
+	default:
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("Notifications(): got an event after the context was cancelled, want closed channel")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Context.Close(): %v", err)
+	}
+	if _, ok := <-dropping; ok {
+		t.Error("Notifications(): got an event after Context.Close, want closed channel")
+	}
+	lib.mu.Lock()
+	n := len(lib.hotplugCbs)
+	lib.mu.Unlock()
+	if n != 0 {
+		t.Errorf("hotplug callbacks registered after Context.Close: %d, want 0", n)
+	}
+}
+
+func TestBlockingNotifications(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer c.Close()
+
+	ch, err := c.Notifications(context.Background(), WithNotificationBuffer(0), WithBlockingNotifications())
+	if err != nil {
+		t.Fatalf("Notifications(): %v", err)
+	}
+	desc := &DeviceDesc{Bus: 3, Address: 7, Vendor: 0x5555, Product: 0x0001}
+	dev := newDevicePointer()
+	lib.fakeDevices[dev] = &fakeDevice{devDesc: desc}
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		lib.hotplug(dev, true)
+		lib.hotplug(dev, false)
+	}()
+	for _, want := range []DeviceEventType{DeviceArrived, DeviceLeft} {
+		if got := <-ch; got.Type != want {
+			t.Errorf("Notifications(): got event %v, want type %s", got, want)
+		}
+	}
+	<-delivered
+
+	// A delivery blocked on a full channel is released by Close.
+	go lib.hotplug(dev, true)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Context.Close(): %v", err)
+	}
+	for range ch {
+	}
+}
+
+func TestPowerEventsUnsupported(t *testing.T) {
+	t.Parallel()
+	c := newContextWithImpl(newFakeLibusb())
+	defer c.Close()
+	if ch, err := c.PowerEvents(context.Background()); !errors.Is(err, ErrUnsupported) || ch != nil {
+		t.Errorf("PowerEvents(): got %v, %v, want nil channel and an error wrapping %v", ch, err, ErrUnsupported)
+	}
+}
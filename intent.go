@@ -0,0 +1,116 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+// Intent declares how the endpoints of an interface will be used. It
+// selects the defaults used by NewDefaultStream, see WithIntent.
+type Intent int
+
+// Intents supported by WithIntent.
+const (
+	// IntentNone is the intent of interfaces claimed without WithIntent,
+	// it uses the same defaults as IntentInteractive.
+	IntentNone Intent = iota
+	// IntentStreaming favors throughput: many transfers in flight, each
+	// with a large buffer.
+	IntentStreaming
+	// IntentInteractive favors latency, for request/response protocols: a
+	// single transfer with a buffer of one packet.
+	IntentInteractive
+)
+
+var intentDescription = map[Intent]string{
+	IntentNone:        "none",
+	IntentStreaming:   "streaming",
+	IntentInteractive: "interactive",
+}
+
+// String returns the name of the intent.
+func (i Intent) String() string {
+	if s, ok := intentDescription[i]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// InterfaceOption configures an interface claimed with Config.Interface.
+type InterfaceOption func(*Interface)
+
+// WithIntent declares the intended use of the endpoints of the interface,
+// which sets the defaults of the streams created with NewDefaultStream on
+// these endpoints. The defaults depend on the endpoint, MPS is its
+// MaxPacketSize:
+//
+//	IntentStreaming, bulk endpoints:        8 transfers of 32*MPS bytes
+//	IntentStreaming, interrupt endpoints:   4 transfers of MPS bytes
+//	IntentStreaming, isochronous endpoints: 8 transfers of 8*MPS bytes
+//	IntentInteractive and IntentNone:       1 transfer of MPS bytes
+//	isochronous feedback endpoints:         2 transfers of MPS bytes
+//
+// Streams created with NewStream are not affected.
+func WithIntent(intent Intent) InterfaceOption {
+	return func(i *Interface) {
+		i.intent = intent
+	}
+}
+
+// streamDefaults returns the default transfer size and count of streams on
+// endpoint desc for the given intent, see WithIntent.
+func streamDefaults(desc EndpointDesc, intent Intent) (size, count int) {
+	mps := desc.MaxPacketSize
+	if mps <= 0 {
+		mps = 64
+	}
+	if desc.IsFeedback() {
+		// each transfer carries a single value, the device updates it at
+		// most once per poll interval.
+		return mps, 2
+	}
+	if intent != IntentStreaming {
+		return mps, 1
+	}
+	switch desc.TransferType {
+	case TransferTypeInterrupt:
+		return mps, 4
+	case TransferTypeIsochronous:
+		return 8 * mps, 8
+	default:
+		return 32 * mps, 8
+	}
+}
+
+// StreamDefaults returns the transfer size and count used by
+// NewDefaultStream on the endpoint, as selected by the intent of its
+// interface, see WithIntent.
+func (e *endpoint) StreamDefaults() (size, count int) {
+	return streamDefaults(e.Desc, e.intent)
+}
+
+// NewDefaultStream is like NewStream, with the transfer size and count
+// selected by the intent of the interface, see WithIntent and
+// StreamDefaults.
+func (e *InEndpoint) NewDefaultStream(opts ...StreamOption) (*ReadStream, error) {
+	size, count := e.StreamDefaults()
+	return e.NewStream(size, count, opts...)
+}
+
+// NewDefaultStream is like NewStream, with the transfer size and count
+// selected by the intent of the interface, see WithIntent and
+// StreamDefaults.
+func (e *OutEndpoint) NewDefaultStream(opts ...StreamOption) (*WriteStream, error) {
+	size, count := e.StreamDefaults()
+	return e.NewStream(size, count, opts...)
+}
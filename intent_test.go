@@ -0,0 +1,103 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "testing"
+
+func TestStreamDefaults(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		intent      Intent
+		typ         TransferType
+		size, count int
+	}{
+		{IntentNone, TransferTypeBulk, 512, 1},
+		{IntentInteractive, TransferTypeBulk, 512, 1},
+		{IntentInteractive, TransferTypeIsochronous, 512, 1},
+		{IntentStreaming, TransferTypeBulk, 16384, 8},
+		{IntentStreaming, TransferTypeInterrupt, 512, 4},
+		{IntentStreaming, TransferTypeIsochronous, 4096, 8},
+	} {
+		desc := EndpointDesc{MaxPacketSize: 512, TransferType: tc.typ}
+		if size, count := streamDefaults(desc, tc.intent); size != tc.size || count != tc.count {
+			t.Errorf("streamDefaults(%s endpoint, %s): got %d, %d, want %d, %d", tc.typ, tc.intent, size, count, tc.size, tc.count)
+		}
+	}
+}
+
+func TestInterfaceWithIntent(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		opts        []InterfaceOption
+		size, count int
+	}{
+		{nil, 512, 1},
+		{[]InterfaceOption{WithIntent(IntentInteractive)}, 512, 1},
+		{[]InterfaceOption{WithIntent(IntentStreaming)}, 16384, 8},
+	} {
+		lib := newFakeLibusb()
+		ctx := newContextWithImpl(lib)
+		dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+		if err != nil {
+			t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+		}
+		cfg, err := dev.Config(1)
+		if err != nil {
+			t.Fatalf("%s.Config(1): %v", dev, err)
+		}
+		intf, err := cfg.Interface(0, 0, tc.opts...)
+		if err != nil {
+			t.Fatalf("%s.Interface(0, 0): %v", cfg, err)
+		}
+		in, err := intf.InEndpoint(2)
+		if err != nil {
+			t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+		}
+		out, err := intf.OutEndpoint(1)
+		if err != nil {
+			t.Fatalf("%s.OutEndpoint(1): %v", intf, err)
+		}
+		if size, count := in.StreamDefaults(); size != tc.size || count != tc.count {
+			t.Errorf("%s.StreamDefaults() with intent %s: got %d, %d, want %d, %d", in, intf.intent, size, count, tc.size, tc.count)
+		}
+		rs, err := in.NewDefaultStream()
+		if err != nil {
+			t.Fatalf("%s.NewDefaultStream(): %v", in, err)
+		}
+		if got := len(rs.s.all); got != tc.count {
+			t.Errorf("%s.NewDefaultStream() with intent %s: got %d transfers, want %d", in, intf.intent, got, tc.count)
+		}
+		ws, err := out.NewDefaultStream()
+		if err != nil {
+			t.Fatalf("%s.NewDefaultStream(): %v", out, err)
+		}
+		if got := len(ws.s.all); got != tc.count {
+			t.Errorf("%s.NewDefaultStream() with intent %s: got %d transfers, want %d", out, intf.intent, got, tc.count)
+		}
+		if err := ws.Close(); err != nil {
+			t.Errorf("WriteStream.Close(): %v", err)
+		}
+		if err := dev.Close(); err != nil {
+			t.Errorf("%s.Close(): %v", dev, err)
+		}
+		// Closed after the device, the read stream frees its transfers
+		// without being read.
+		rs.Close()
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}
+}
@@ -18,6 +18,8 @@ package gousb
 import (
 	"fmt"
 	"sort"
+	"sync"
+	"time"
 )
 
 // InterfaceDesc contains information about a USB interface, extracted from
@@ -51,6 +53,12 @@ type InterfaceSetting struct {
 	// Endpoints enumerates the endpoints available on this interface with
 	// this alternate setting.
 	Endpoints map[EndpointAddress]EndpointDesc
+	// Extra contains the raw class- or vendor-specific descriptors that
+	// follow the interface descriptor.
+	Extra []byte
+	// ExtraDescriptors contains the descriptors from Extra, as parsed by
+	// the parsers registered through RegisterDescriptorParser.
+	ExtraDescriptors []interface{}
 
 	iInterface int // index of a string descriptor describing this interface.
 }
@@ -70,25 +78,63 @@ func (a InterfaceSetting) String() string {
 	return fmt.Sprintf("Interface %d alternate setting %d (available endpoints: %v)", a.Number, a.Alternate, a.sortedEndpointIds())
 }
 
+// isoBandwidth returns the isochronous bandwidth reserved by the alternate
+// setting, in bytes per second: the sum of MaxPacketSize per PollInterval
+// over all isochronous endpoints of the setting.
+func (a InterfaceSetting) isoBandwidth() int64 {
+	var ret int64
+	for _, ep := range a.Endpoints {
+		if ep.TransferType != TransferTypeIsochronous || ep.PollInterval <= 0 {
+			continue
+		}
+		ret += int64(ep.MaxPacketSize) * int64(time.Second) / int64(ep.PollInterval)
+	}
+	return ret
+}
+
 // Interface is a representation of a claimed interface with a particular setting.
 // To access device endpoints use InEndpoint() and OutEndpoint() methods.
 // The interface should be Close()d after use.
 type Interface struct {
+	// Setting is the active alternate setting of the interface. It changes
+	// with SetAltSetting and SelectAltForBandwidth and must not be read
+	// concurrently with them.
 	Setting InterfaceSetting
+	// settingMu protects Setting against the reads of the endpoints of the
+	// interface, see currentSetting.
+	settingMu sync.RWMutex
 
 	config *Config
+	// intent is the intended use of the endpoints, see WithIntent.
+	intent Intent
 }
 
 func (i *Interface) String() string {
 	return fmt.Sprintf("%s,if=%d,alt=%d", i.config, i.Setting.Number, i.Setting.Alternate)
 }
 
+// Description reads the string descriptor describing the current alternate
+// setting of the interface in the language langID, e.g. 0x0409 for English
+// (United States). A langID of 0 selects the first language supported by the
+// device. Unlike GetStringDescriptor, the string is returned without
+// conversion to ASCII. If the setting has no description, Description
+// returns an empty string.
+func (i *Interface) Description(langID uint16) (string, error) {
+	if i.config == nil {
+		return "", fmt.Errorf("Description(%#04x) called on a closed interface", langID)
+	}
+	return i.config.dev.stringDescriptor(i.currentSetting().iInterface, langID)
+}
+
 // Close releases the interface.
 func (i *Interface) Close() {
 	if i.config == nil {
 		return
 	}
-	i.config.dev.ctx.libusb.release(i.config.dev.handle, uint8(i.Setting.Number))
+	// The interface was already released if the device was closed.
+	if h := i.config.dev.handle; h != nil {
+		i.config.dev.ctx.libusb.release(h, uint8(i.Setting.Number))
+	}
 	i.config.mu.Lock()
 	defer i.config.mu.Unlock()
 	delete(i.config.claimed, i.Setting.Number)
@@ -105,7 +151,11 @@ func (i *Interface) openEndpoint(epAddr EndpointAddress) (*endpoint, error) {
 		InterfaceSetting: i.Setting,
 		Desc:             ep,
 		h:                i.config.dev.handle,
+		dev:              &i.config.dev.state,
 		ctx:              i.config.dev.ctx,
+		sched:            &i.config.dev.sched,
+		intent:           i.intent,
+		intf:             i,
 	}, nil
 }
 
@@ -136,3 +186,100 @@ func (i *Interface) OutEndpoint(epNum int) (*OutEndpoint, error) {
 		endpoint: ep,
 	}, nil
 }
+
+// SelectAltForBandwidth switches the interface to the alternate setting
+// with the smallest isochronous bandwidth that is at least bytesPerSecond.
+// The bandwidth of an alternate setting is computed from the MaxPacketSize
+// and PollInterval of its isochronous endpoints. Selecting the smallest
+// sufficient setting avoids reserving more of the bus than the stream needs,
+// as recommended for UVC and UAC devices.
+// SelectAltForBandwidth returns the selected setting, which also becomes the
+// Setting of the interface. Endpoints opened before the call follow the
+// switch as described in SetAltSetting. An error is returned if no
+// alternate setting provides the requested bandwidth.
+func (i *Interface) SelectAltForBandwidth(bytesPerSecond int) (InterfaceSetting, error) {
+	if i.config == nil {
+		return InterfaceSetting{}, fmt.Errorf("SelectAltForBandwidth(%d) called on %s after Close", bytesPerSecond, i)
+	}
+	h := i.config.dev.handle
+	if h == nil {
+		return InterfaceSetting{}, fmt.Errorf("SelectAltForBandwidth(%d) called on %s after the device was closed", bytesPerSecond, i)
+	}
+	desc := i.config.Desc.interfaceDesc(i.Setting.Number)
+	if desc == nil {
+		return InterfaceSetting{}, fmt.Errorf("descriptor of %s not found", i)
+	}
+	var (
+		best    *InterfaceSetting
+		bestBW  int64
+		largest int64
+	)
+	for n := range desc.AltSettings {
+		alt := &desc.AltSettings[n]
+		bw := alt.isoBandwidth()
+		if bw > largest {
+			largest = bw
+		}
+		if bw < int64(bytesPerSecond) {
+			continue
+		}
+		if best == nil || bw < bestBW || (bw == bestBW && alt.Alternate < best.Alternate) {
+			best, bestBW = alt, bw
+		}
+	}
+	if best == nil {
+		return InterfaceSetting{}, fmt.Errorf("%s: no alternate setting provides %d bytes/s, the largest available isochronous bandwidth is %d bytes/s", i, bytesPerSecond, largest)
+	}
+	if best.Alternate != i.Setting.Alternate {
+		if err := i.setAlt(h, best); err != nil {
+			return InterfaceSetting{}, err
+		}
+	}
+	return i.Setting, nil
+}
+
+// SetAltSetting switches the interface to the alternate setting alt, which
+// then becomes the Setting of the interface. Endpoints of the interface
+// follow the switch: transfers allocated on endpoints opened before the
+// call, including the transfers of new streams, are built from the
+// descriptor of the endpoint in the new setting, e.g. with its
+// MaxPacketSize, which alternate settings of isochronous interfaces
+// typically differ in. The Desc of such endpoints still describes the
+// previous setting, open the endpoints again to inspect the new
+// descriptors. Transfers of an endpoint that doesn't exist in the new
+// setting fail to allocate.
+// Transfers and streams allocated before the switch keep their buffers and
+// packet layout and should be closed before calling SetAltSetting.
+func (i *Interface) SetAltSetting(alt int) error {
+	if i.config == nil {
+		return fmt.Errorf("SetAltSetting(%d) called on %s after Close", alt, i)
+	}
+	h := i.config.dev.handle
+	if h == nil {
+		return fmt.Errorf("SetAltSetting(%d) called on %s after the device was closed", alt, i)
+	}
+	setting, err := i.config.Desc.intfDesc(i.Setting.Number, alt)
+	if err != nil {
+		return fmt.Errorf("descriptor of interface (%d, %d) in %s: %v", i.Setting.Number, alt, i.config, err)
+	}
+	return i.setAlt(h, setting)
+}
+
+// setAlt selects the alternate setting on the device and makes it the
+// Setting of the interface.
+func (i *Interface) setAlt(h *libusbDevHandle, setting *InterfaceSetting) error {
+	if err := i.config.dev.ctx.libusb.setAlt(h, uint8(setting.Number), uint8(setting.Alternate)); err != nil {
+		return fmt.Errorf("failed to set alternate setting %d on %s: %v", setting.Alternate, i, err)
+	}
+	i.settingMu.Lock()
+	defer i.settingMu.Unlock()
+	i.Setting = *setting
+	return nil
+}
+
+// currentSetting returns the active alternate setting of the interface.
+func (i *Interface) currentSetting() InterfaceSetting {
+	i.settingMu.RLock()
+	defer i.settingMu.RUnlock()
+	return i.Setting
+}
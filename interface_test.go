@@ -0,0 +1,329 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func isoAltSetting(alt, maxPacketSize int, interval time.Duration) InterfaceSetting {
+	s := InterfaceSetting{
+		Number:    0,
+		Alternate: alt,
+		Class:     ClassVideo,
+	}
+	if maxPacketSize > 0 {
+		s.Endpoints = map[EndpointAddress]EndpointDesc{
+			0x81: {
+				Address:       0x81,
+				Number:        1,
+				Direction:     EndpointDirectionIn,
+				MaxPacketSize: maxPacketSize,
+				TransferType:  TransferTypeIsochronous,
+				PollInterval:  interval,
+			},
+		}
+	}
+	return s
+}
+
+func TestSelectAltForBandwidth(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	fd := &fakeDevice{
+		devDesc: &DeviceDesc{
+			Bus:     2,
+			Address: 1,
+			Port:    1,
+			Path:    []int{1},
+			Spec:    Version(2, 0),
+			Vendor:  ID(0x5555),
+			Product: ID(0x0001),
+			Configs: map[int]ConfigDesc{1: {
+				Number: 1,
+				Interfaces: []InterfaceDesc{{
+					Number: 0,
+					AltSettings: []InterfaceSetting{
+						isoAltSetting(0, 0, 0),
+						isoAltSetting(1, 1024, 125*time.Microsecond),
+						isoAltSetting(2, 3*1024, 125*time.Microsecond),
+						isoAltSetting(3, 512, time.Millisecond),
+					},
+				}},
+			}},
+		},
+	}
+	lib.fakeDevices[newDevicePointer()] = fd
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x5555, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x5555, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+	intf, err := cfg.Interface(0, 0)
+	if err != nil {
+		t.Fatalf("%s.Interface(0, 0): %v", cfg, err)
+	}
+	defer intf.Close()
+
+	for _, tc := range []struct {
+		bytesPerSecond int
+		wantAlt        int
+	}{
+		{400000, 3},
+		{512000, 3},
+		{512001, 1},
+		{8192000, 1},
+		{10000000, 2},
+		{0, 0},
+	} {
+		got, err := intf.SelectAltForBandwidth(tc.bytesPerSecond)
+		if err != nil {
+			t.Errorf("%s.SelectAltForBandwidth(%d): %v", intf, tc.bytesPerSecond, err)
+			continue
+		}
+		if got.Alternate != tc.wantAlt {
+			t.Errorf("%s.SelectAltForBandwidth(%d): got alt %d, want %d", intf, tc.bytesPerSecond, got.Alternate, tc.wantAlt)
+		}
+		if intf.Setting.Alternate != tc.wantAlt {
+			t.Errorf("Setting.Alternate after SelectAltForBandwidth(%d): got %d, want %d", tc.bytesPerSecond, intf.Setting.Alternate, tc.wantAlt)
+		}
+		lib.mu.Lock()
+		alt := fd.alt
+		lib.mu.Unlock()
+		if int(alt) != tc.wantAlt {
+			t.Errorf("device alt setting after SelectAltForBandwidth(%d): got %d, want %d", tc.bytesPerSecond, alt, tc.wantAlt)
+		}
+	}
+
+	if got, err := intf.SelectAltForBandwidth(30000000); err == nil {
+		t.Errorf("%s.SelectAltForBandwidth(30000000): got alt %d, want error", intf, got.Alternate)
+	}
+	if intf.Setting.Alternate != 0 {
+		t.Errorf("Setting.Alternate after a failed SelectAltForBandwidth: got %d, want 0", intf.Setting.Alternate)
+	}
+}
+
+func TestSetAltSettingMaxPacketSize(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	fd := &fakeDevice{
+		devDesc: &DeviceDesc{
+			Bus:     2,
+			Address: 1,
+			Port:    1,
+			Path:    []int{1},
+			Spec:    Version(2, 0),
+			Vendor:  ID(0x5555),
+			Product: ID(0x0001),
+			Configs: map[int]ConfigDesc{1: {
+				Number: 1,
+				Interfaces: []InterfaceDesc{{
+					Number: 0,
+					AltSettings: []InterfaceSetting{
+						isoAltSetting(0, 0, 0),
+						isoAltSetting(1, 192, time.Millisecond),
+						isoAltSetting(2, 1024, time.Millisecond),
+					},
+				}},
+			}},
+		},
+	}
+	lib.fakeDevices[newDevicePointer()] = fd
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x5555, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x5555, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+	intf, err := cfg.Interface(0, 1)
+	if err != nil {
+		t.Fatalf("%s.Interface(0, 1): %v", cfg, err)
+	}
+	defer intf.Close()
+	ep, err := intf.InEndpoint(1)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(1): %v", intf, err)
+	}
+
+	for _, tc := range []struct {
+		alt      int
+		wantSize int
+	}{
+		{1, 192},
+		{2, 1024},
+		{1, 192},
+	} {
+		if err := intf.SetAltSetting(tc.alt); err != nil {
+			t.Fatalf("%s.SetAltSetting(%d): %v", intf, tc.alt, err)
+		}
+		lib.mu.Lock()
+		alt := fd.alt
+		lib.mu.Unlock()
+		if int(alt) != tc.alt {
+			t.Errorf("device alt setting after SetAltSetting(%d): got %d, want %d", tc.alt, alt, tc.alt)
+		}
+		if got := intf.Setting.Endpoints[0x81].MaxPacketSize; got != tc.wantSize {
+			t.Errorf("Setting max packet size after SetAltSetting(%d): got %d, want %d", tc.alt, got, tc.wantSize)
+		}
+		x, err := ep.NewTransfer(4096)
+		if err != nil {
+			t.Fatalf("NewTransfer() with alt setting %d: %v", tc.alt, err)
+		}
+		if got := x.t.isoPktSize; got != tc.wantSize {
+			t.Errorf("iso packet size of a transfer with alt setting %d: got %d, want %d", tc.alt, got, tc.wantSize)
+		}
+		if got, want := x.t.isoPackets, 4096/tc.wantSize; got != want {
+			t.Errorf("iso packets of a transfer with alt setting %d: got %d, want %d", tc.alt, got, want)
+		}
+		x.Free()
+		// Frames reads a packet of the active alt setting per transfer.
+		ctx, cancel := context.WithCancel(context.Background())
+		frames := ep.Frames(ctx)
+		ft := lib.waitForSubmitted(nil)
+		ft.mu.Lock()
+		got := len(ft.buf)
+		ft.mu.Unlock()
+		if got != tc.wantSize {
+			t.Errorf("Frames() transfer size with alt setting %d: got %d, want %d", tc.alt, got, tc.wantSize)
+		}
+		cancel()
+		for range frames {
+		}
+	}
+
+	if err := intf.SetAltSetting(0); err != nil {
+		t.Fatalf("%s.SetAltSetting(0): %v", intf, err)
+	}
+	if x, err := ep.NewTransfer(4096); err == nil {
+		x.Free()
+		t.Error("NewTransfer() with alt setting 0 without endpoints: got nil error, want an error")
+	}
+	if _, ok := <-ep.Frames(context.Background()); ok {
+		t.Error("Frames() with alt setting 0 without endpoints: got a frame, want a closed channel")
+	}
+	if _, ok := <-ep.ReportFrames(context.Background(), 8); ok {
+		t.Error("ReportFrames() with alt setting 0 without endpoints: got a frame, want a closed channel")
+	}
+	if err := intf.SetAltSetting(5); err == nil {
+		t.Error("SetAltSetting(5): got nil error, want an error")
+	}
+	if intf.Setting.Alternate != 0 {
+		t.Errorf("Setting.Alternate after a failed SetAltSetting: got %d, want 0", intf.Setting.Alternate)
+	}
+}
+
+// stringDesc encodes s as a USB string descriptor.
+func stringDesc(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := []byte{byte(2 + 2*len(u)), byte(DescriptorTypeString)}
+	for _, c := range u {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return b
+}
+
+func TestInterfaceDescription(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	// Descriptors served by the device, keyed by wValue and wIndex.
+	descs := map[[2]uint16][]byte{
+		{0x0300, 0}:      {6, byte(DescriptorTypeString), 0x07, 0x04, 0x09, 0x04},
+		{0x0306, 0x0407}: stringDesc("Steuerung"),
+		{0x0306, 0x0409}: stringDesc("Control €"),
+	}
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		b, ok := descs[[2]uint16{req.val, req.idx}]
+		if req.request != requestGetDescriptor || !ok {
+			return 0, ErrorPipe
+		}
+		return copy(data, b), nil
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x8888, 0x0002)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x8888, 0x0002): %v", err)
+	}
+	defer dev.Close()
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+	intf, err := cfg.Interface(0, 0)
+	if err != nil {
+		t.Fatalf("%s.Interface(0, 0): %v", cfg, err)
+	}
+
+	rType := ControlType(ControlKindStandard, ControlRecipientDevice, EndpointDirectionIn)
+	for _, tc := range []struct {
+		langID uint16
+		want   string
+		reqs   []controlRequest
+	}{
+		{
+			langID: 0x0409,
+			want:   "Control €",
+			reqs:   []controlRequest{{rType, requestGetDescriptor, 0x0306, 0x0409, make([]byte, 0xff)}},
+		},
+		{
+			langID: 0,
+			want:   "Steuerung",
+			reqs: []controlRequest{
+				{rType, requestGetDescriptor, 0x0300, 0, make([]byte, 0xff)},
+				{rType, requestGetDescriptor, 0x0306, 0x0407, make([]byte, 0xff)},
+			},
+		},
+	} {
+		before := len(lib.requests())
+		got, err := intf.Description(tc.langID)
+		if err != nil {
+			t.Fatalf("%s.Description(%#04x): %v", intf, tc.langID, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s.Description(%#04x): got %q, want %q", intf, tc.langID, got, tc.want)
+		}
+		if reqs := lib.requests()[before:]; !reflect.DeepEqual(reqs, tc.reqs) {
+			t.Errorf("%s.Description(%#04x) requests: got %v, want %v", intf, tc.langID, reqs, tc.reqs)
+		}
+	}
+	if _, err := intf.Description(0x0c0a); !errors.Is(err, ErrorPipe) {
+		t.Errorf("%s.Description(0x0c0a): got error %v, want %v", intf, err, ErrorPipe)
+	}
+
+	intf.Close()
+	if _, err := intf.Description(0x0409); err == nil {
+		t.Errorf("Description(0x0409) on a closed interface: got nil error, want non-nil")
+	}
+}
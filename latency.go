@@ -0,0 +1,299 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the latency histogram buckets.
+// The last bucket of a histogram collects everything above the last bound.
+var latencyBounds = [...]time.Duration{
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyBucket is a bucket of a LatencyHistogram.
+type LatencyBucket struct {
+	// UpperBound is the longest latency counted in the bucket. The last
+	// bucket of a histogram has an UpperBound of math.MaxInt64.
+	UpperBound time.Duration
+	// Count is the number of transfers with latency above the bound of the
+	// previous bucket and up to UpperBound.
+	Count int64
+}
+
+// LatencyHistogram is the distribution of the submit-to-complete latencies
+// of the transfers of an endpoint, see Endpoint.SetLatencyHistogram.
+type LatencyHistogram struct {
+	// Buckets are the buckets of the histogram, ordered by UpperBound.
+	Buckets []LatencyBucket
+	// Count is the total number of transfers in the histogram.
+	Count int64
+}
+
+// Percentile returns the upper bound of the bucket containing the p-th
+// percentile of the latencies, e.g. Percentile(99) for p99. The result is
+// as precise as the buckets of the histogram. Percentile returns 0 for an
+// empty histogram.
+func (h LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for _, b := range h.Buckets {
+		n += b.Count
+		if n >= rank {
+			return b.UpperBound
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1].UpperBound
+}
+
+// latencyHistogram collects the latencies of the transfers of an endpoint.
+// It uses fixed buckets updated atomically, recording a latency doesn't
+// allocate or take locks.
+type latencyHistogram struct {
+	enabled int32
+	counts  [len(latencyBounds) + 1]int64
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+func (h *latencyHistogram) isEnabled() bool {
+	return h != nil && atomic.LoadInt32(&h.enabled) != 0
+}
+
+// start returns the submission timestamp of a transfer, the zero time if
+// the histogram is disabled.
+func (h *latencyHistogram) start() time.Time {
+	if !h.isEnabled() {
+		return time.Time{}
+	}
+	return h.clock()
+}
+
+// record adds the latency of a transfer submitted at start.
+func (h *latencyHistogram) record(start time.Time) {
+	if start.IsZero() || !h.isEnabled() {
+		return
+	}
+	h.add(h.clock().Sub(start))
+}
+
+// recordUntil adds the latency of a transfer submitted at start and
+// completed at end. A zero end, an unknown completion time, is skipped.
+func (h *latencyHistogram) recordUntil(start, end time.Time) {
+	if start.IsZero() || end.IsZero() || !h.isEnabled() {
+		return
+	}
+	h.add(end.Sub(start))
+}
+
+// add adds a single latency to the histogram.
+func (h *latencyHistogram) add(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+}
+
+func (h *latencyHistogram) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	ret := LatencyHistogram{Buckets: make([]LatencyBucket, len(h.counts))}
+	for i := range h.counts {
+		b := LatencyBucket{UpperBound: math.MaxInt64, Count: atomic.LoadInt64(&h.counts[i])}
+		if i < len(latencyBounds) {
+			b.UpperBound = latencyBounds[i]
+		}
+		ret.Buckets[i] = b
+		ret.Count += b.Count
+	}
+	return ret
+}
+
+// SetLatencyHistogram enables or disables the collection of the latency
+// histogram of the endpoint. The latency of a transfer is the time from its
+// submission until gousb observes its completion, for transfers of streams
+// this includes the time a completed transfer waits for the stream to be
+// read. Enabling the histogram clears it. Only transfers submitted while
+// the histogram is enabled are recorded.
+func (e *endpoint) SetLatencyHistogram(enabled bool) {
+	e.latency.setEnabled(enabled)
+}
+
+// LatencyHistogram returns a snapshot of the latency histogram of the
+// endpoint, see SetLatencyHistogram.
+func (e *endpoint) LatencyHistogram() LatencyHistogram {
+	return e.latency.snapshot()
+}
+
+// SetQueueDelayHistogram enables or disables the collection of the queue
+// delay histogram of the endpoint. The queue delay of a transfer is the
+// time from its submission until libusb reports its completion. Unlike the
+// latency, see SetLatencyHistogram, it doesn't include the time until
+// gousb observes the completion, e.g. while a completed transfer waits for
+// its stream to be read. libusb doesn't report when the data of a transfer
+// starts moving, so the queue delay also includes the time of the transfer
+// on the bus: comparing it to the time the data needs at the bus speed
+// tells whether transfers wait for the bus. A queue delay much shorter
+// than the latency means the transfers wait for the application instead.
+// Enabling the histogram clears it. Only transfers submitted while the
+// histogram is enabled are recorded.
+func (e *endpoint) SetQueueDelayHistogram(enabled bool) {
+	e.queueDelay.setEnabled(enabled)
+}
+
+// QueueDelayHistogram returns a snapshot of the queue delay histogram of
+// the endpoint, see SetQueueDelayHistogram.
+func (e *endpoint) QueueDelayHistogram() LatencyHistogram {
+	return e.queueDelay.snapshot()
+}
+
+// setEnabled enables, clearing the histogram, or disables the histogram.
+func (h *latencyHistogram) setEnabled(enabled bool) {
+	if !enabled {
+		atomic.StoreInt32(&h.enabled, 0)
+		return
+	}
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt32(&h.enabled, 1)
+}
+
+const (
+	// adaptiveWindow is the number of recent transfers whose latencies
+	// drive the adaptive timeout.
+	adaptiveWindow = 100
+	// adaptiveMinSamples is the number of latencies needed before the
+	// adaptive timeout is applied.
+	adaptiveMinSamples = 10
+)
+
+// adaptiveTimeout derives a transfer timeout from the latencies of the
+// recent transfers of an endpoint, see SetAdaptiveTimeout.
+type adaptiveTimeout struct {
+	mu sync.Mutex
+	// margin is added to the p99 latency, 0 if the adaptive timeout is
+	// disabled.
+	margin time.Duration
+	// window holds the last n latencies, next is the slot of the next one.
+	window [adaptiveWindow]time.Duration
+	n      int
+	next   int
+}
+
+func (a *adaptiveTimeout) setMargin(margin time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.margin = margin
+	a.n, a.next = 0, 0
+}
+
+func (a *adaptiveTimeout) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.margin > 0
+}
+
+// record adds the latency of a transfer to the window, replacing the
+// oldest one once the window is full.
+func (a *adaptiveTimeout) record(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.margin == 0 {
+		return
+	}
+	a.window[a.next] = d
+	a.next = (a.next + 1) % len(a.window)
+	if a.n < len(a.window) {
+		a.n++
+	}
+}
+
+// timeout returns the p99 of the latencies in the window plus the margin,
+// rounded up to a millisecond, the resolution of libusb timeouts. It
+// returns 0 while the adaptive timeout is disabled or has too few samples.
+func (a *adaptiveTimeout) timeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.margin == 0 || a.n < adaptiveMinSamples {
+		return 0
+	}
+	lat := make([]time.Duration, a.n)
+	copy(lat, a.window[:a.n])
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	rank := int(math.Ceil(0.99*float64(a.n))) - 1
+	d := lat[rank] + a.margin
+	return (d + time.Millisecond - 1) / time.Millisecond * time.Millisecond
+}
+
+// SetAdaptiveTimeout enables, with a positive margin, or disables, with a
+// margin of 0, the adaptive timeout of Read and Write on the endpoint. The
+// adaptive timeout tracks the latencies of the last 100 successful or timed
+// out transfers issued by Read and Write and sets the libusb timeout of the
+// next transfers to the p99 of those latencies plus margin, so that the
+// timeout follows the link: a slow device doesn't cause spurious timeouts
+// and a fast one doesn't take long to detect a failure. A timed out
+// transfer counts with its full timeout, which lets the timeout grow when
+// the latencies rise. Until 10 latencies are known, and if it's longer than
+// the timeout set with SetLibusbTimeout, the adaptive timeout is not
+// applied. Enabling the adaptive timeout clears the latencies.
+// A margin of a few times the typical latency avoids timing out transfers
+// whose latency is slightly above the p99. See AdaptiveTimeout.
+func (e *endpoint) SetAdaptiveTimeout(margin time.Duration) error {
+	if margin < 0 {
+		return fmt.Errorf("invalid adaptive timeout margin %v, must be >= 0", margin)
+	}
+	e.adaptive.setMargin(margin)
+	return nil
+}
+
+// AdaptiveTimeout returns the libusb timeout currently derived by the
+// adaptive timeout, see SetAdaptiveTimeout. It returns 0 if the adaptive
+// timeout is disabled or doesn't have enough latencies yet.
+func (e *endpoint) AdaptiveTimeout() time.Duration {
+	return e.adaptive.timeout()
+}
@@ -0,0 +1,259 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 64,
+		TransferType:  TransferTypeBulk,
+	}}}
+	base := time.Unix(1000, 0)
+	now := base
+	ep.latency.now = func() time.Time { return now }
+
+	xfer, err := ep.newUSBTransfer(64)
+	if err != nil {
+		t.Fatalf("newUSBTransfer(): %v", err)
+	}
+	defer xfer.free()
+	complete := func(latency time.Duration) {
+		now = base
+		if err := xfer.submit(); err != nil {
+			t.Fatalf("submit(): %v", err)
+		}
+		ft := lib.waitForSubmitted(nil)
+		now = base.Add(latency)
+		ft.setStatus(TransferCompleted)
+		if _, err := xfer.wait(context.Background()); err != nil {
+			t.Fatalf("wait(): %v", err)
+		}
+	}
+
+	// Not recorded, the histogram is disabled by default.
+	complete(time.Millisecond)
+	if got := ep.LatencyHistogram().Count; got != 0 {
+		t.Errorf("LatencyHistogram().Count with the histogram disabled: got %d, want 0", got)
+	}
+
+	ep.SetLatencyHistogram(true)
+	for _, d := range []time.Duration{
+		30 * time.Microsecond,
+		50 * time.Microsecond,
+		80 * time.Microsecond,
+		80 * time.Microsecond,
+		800 * time.Microsecond,
+		3 * time.Millisecond,
+		3 * time.Millisecond,
+		3 * time.Millisecond,
+		40 * time.Millisecond,
+		2 * time.Second,
+	} {
+		complete(d)
+	}
+	h := ep.LatencyHistogram()
+	if h.Count != 10 {
+		t.Errorf("LatencyHistogram().Count: got %d, want 10", h.Count)
+	}
+	want := map[time.Duration]int64{
+		50 * time.Microsecond:  2,
+		100 * time.Microsecond: 2,
+		time.Millisecond:       1,
+		5 * time.Millisecond:   3,
+		50 * time.Millisecond:  1,
+		math.MaxInt64:          1,
+	}
+	for _, b := range h.Buckets {
+		if b.Count != want[b.UpperBound] {
+			t.Errorf("bucket up to %v: got %d transfers, want %d", b.UpperBound, b.Count, want[b.UpperBound])
+		}
+	}
+	for _, tc := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, time.Millisecond},
+		{90, 50 * time.Millisecond},
+		{99, math.MaxInt64},
+		{10, 50 * time.Microsecond},
+	} {
+		if got := h.Percentile(tc.p); got != tc.want {
+			t.Errorf("Percentile(%v): got %v, want %v", tc.p, got, tc.want)
+		}
+	}
+
+	ep.SetLatencyHistogram(false)
+	complete(time.Millisecond)
+	if got := ep.LatencyHistogram().Count; got != 10 {
+		t.Errorf("LatencyHistogram().Count after disabling: got %d, want 10", got)
+	}
+	ep.SetLatencyHistogram(true)
+	if got := ep.LatencyHistogram().Count; got != 0 {
+		t.Errorf("LatencyHistogram().Count after re-enabling: got %d, want 0", got)
+	}
+	if got := (LatencyHistogram{}).Percentile(50); got != 0 {
+		t.Errorf("Percentile(50) of an empty histogram: got %v, want 0", got)
+	}
+}
+
+func TestQueueDelayHistogram(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	ep.SetLatencyHistogram(true)
+	ep.SetQueueDelayHistogram(true)
+
+	xfer, err := ep.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("NewTransfer(): %v", err)
+	}
+	defer xfer.Free()
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	// The device completes the transfer after 30ms, the application
+	// collects it 300ms later.
+	completed := make(chan struct{})
+	go func() {
+		ft := lib.waitForSubmitted(nil)
+		time.Sleep(30 * time.Millisecond)
+		ft.setStatus(TransferCompleted)
+		close(completed)
+	}()
+	<-completed
+	time.Sleep(300 * time.Millisecond)
+	if _, err := xfer.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait(): %v", err)
+	}
+
+	queue, total := ep.QueueDelayHistogram(), ep.LatencyHistogram()
+	if queue.Count != 1 || total.Count != 1 {
+		t.Fatalf("histogram counts: got queue delay %d, latency %d, want 1 and 1", queue.Count, total.Count)
+	}
+	if got := queue.Percentile(100); got < 50*time.Millisecond || got > 250*time.Millisecond {
+		t.Errorf("queue delay: got bucket up to %v, want between 50ms and 250ms", got)
+	}
+	if got := total.Percentile(100); got < 500*time.Millisecond {
+		t.Errorf("latency: got bucket up to %v, want at least 500ms", got)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+	if err := ep.SetAdaptiveTimeout(-time.Millisecond); err == nil {
+		t.Error("SetAdaptiveTimeout(-1ms): got nil error, want non-nil")
+	}
+	// Without enough latencies, the transfers have no timeout.
+	if err := ep.SetAdaptiveTimeout(5 * time.Millisecond); err != nil {
+		t.Fatalf("SetAdaptiveTimeout(5ms): %v", err)
+	}
+	// read issues a Read completed with st and returns the libusb timeout
+	// of its transfer.
+	read := func(st TransferStatus) time.Duration {
+		t.Helper()
+		timeout := make(chan time.Duration, 1)
+		go func() {
+			ft := lib.waitForSubmitted(nil)
+			ft.mu.Lock()
+			timeout <- ft.timeout
+			ft.mu.Unlock()
+			ft.setData([]byte{1})
+			ft.setStatus(st)
+		}()
+		ep.Read(make([]byte, 512))
+		return <-timeout
+	}
+	if got := read(TransferCompleted); got != 0 {
+		t.Errorf("timeout of a Read without latencies: got %v, want 0", got)
+	}
+
+	feed := func(lat ...time.Duration) {
+		for _, d := range lat {
+			ep.adaptive.record(d)
+		}
+	}
+	repeat := func(d time.Duration, n int) []time.Duration {
+		var ret []time.Duration
+		for i := 0; i < n; i++ {
+			ret = append(ret, d)
+		}
+		return ret
+	}
+
+	feed(repeat(2*time.Millisecond, adaptiveMinSamples-2)...)
+	if got := ep.AdaptiveTimeout(); got != 0 {
+		t.Errorf("AdaptiveTimeout() with %d latencies: got %v, want 0", adaptiveMinSamples-1, got)
+	}
+	// A steady link: p99 2ms plus the 5ms margin, applied to the Read
+	// transfers, capped by the libusb timeout of the endpoint.
+	feed(repeat(2*time.Millisecond, adaptiveWindow)...)
+	if got, want := ep.AdaptiveTimeout(), 7*time.Millisecond; got != want {
+		t.Errorf("AdaptiveTimeout() on a steady link: got %v, want %v", got, want)
+	}
+	if got, want := read(TransferCompleted), 7*time.Millisecond; got != want {
+		t.Errorf("timeout of a Read on a steady link: got %v, want %v", got, want)
+	}
+	if err := ep.SetLibusbTimeout(3 * time.Millisecond); err != nil {
+		t.Fatalf("SetLibusbTimeout(3ms): %v", err)
+	}
+	if got, want := read(TransferTimedOut), 3*time.Millisecond; got != want {
+		t.Errorf("timeout of a Read with a shorter libusb timeout: got %v, want %v", got, want)
+	}
+	if err := ep.SetLibusbTimeout(0); err != nil {
+		t.Fatalf("SetLibusbTimeout(0): %v", err)
+	}
+	// The link gets slower and jittery, the timeout grows with the p99.
+	for i := 0; i < adaptiveWindow; i++ {
+		feed(time.Duration(10+i%20) * time.Millisecond)
+	}
+	if got, want := ep.AdaptiveTimeout(), 34*time.Millisecond; got != want {
+		t.Errorf("AdaptiveTimeout() on a slow link: got %v, want %v", got, want)
+	}
+	// A single outlier doesn't move the p99 of a full window.
+	feed(time.Second)
+	if got, want := ep.AdaptiveTimeout(), 34*time.Millisecond; got != want {
+		t.Errorf("AdaptiveTimeout() after an outlier: got %v, want %v", got, want)
+	}
+	// The link recovers, the slow latencies leave the window.
+	feed(repeat(1500*time.Microsecond, adaptiveWindow)...)
+	if got, want := ep.AdaptiveTimeout(), 7*time.Millisecond; got != want {
+		t.Errorf("AdaptiveTimeout() after the link recovered: got %v, want %v (rounded up to 1ms)", got, want)
+	}
+}
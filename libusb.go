@@ -19,6 +19,7 @@ import (
 	"log"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -26,12 +27,19 @@ import (
 /*
 #cgo pkg-config: libusb-1.0
 #include <libusb.h>
+#include <stdlib.h>
 
-int gousb_compact_iso_data(struct libusb_transfer *xfer, unsigned char *status);
+int gousb_compact_iso_data(struct libusb_transfer *xfer, unsigned char *status, int *failed);
 struct libusb_transfer *gousb_alloc_transfer_and_buffer(int bufLen, int numIsoPackets);
 void gousb_free_transfer_and_buffer(struct libusb_transfer *xfer);
+void gousb_set_iso_packet_length(struct libusb_transfer *xfer, int i, unsigned int length);
+void gousb_set_callback(struct libusb_transfer *xfer);
+struct libusb_iso_packet_descriptor *gousb_iso_packet_desc(struct libusb_transfer *xfer, int i);
 int submit(struct libusb_transfer *xfer);
 void gousb_set_debug(libusb_context *ctx, int lvl);
+void gousb_interrupt_event_handler(libusb_context *ctx);
+int gousb_handle_events(libusb_context *ctx, long long timeout_usec);
+int gousb_hotplug_register(libusb_context *ctx, int id, libusb_hotplug_callback_handle *handle);
 */
 import "C"
 
@@ -48,7 +56,11 @@ func (ep libusbEndpoint) endpointDesc(dev *DeviceDesc) EndpointDesc {
 		Direction:     EndpointDirection((ep.bEndpointAddress & endpointDirectionMask) != 0),
 		TransferType:  TransferType(ep.bmAttributes & transferTypeMask),
 		MaxPacketSize: int(ep.wMaxPacketSize),
+		Refresh:       uint8(ep.bRefresh),
+		SynchAddress:  EndpointAddress(ep.bSynchAddress),
+		Extra:         extraBytes(ep.extra, ep.extra_length),
 	}
+	ei.ExtraDescriptors = parseExtra(ei.Extra)
 	if ei.TransferType == TransferTypeIsochronous {
 		// bits 0-10 identify the packet size, bits 11-12 are the number of additional transactions per microframe.
 		// Don't use libusb_get_max_iso_packet_size, as it has a bug where it returns the same value
@@ -56,16 +68,8 @@ func (ep libusbEndpoint) endpointDesc(dev *DeviceDesc) EndpointDesc {
 		// max packet sizes.
 		// See http://libusb.org/ticket/77 for more background.
 		ei.MaxPacketSize = int(ep.wMaxPacketSize) & 0x07ff * (int(ep.wMaxPacketSize)>>11&3 + 1)
-		ei.IsoSyncType = IsoSyncType(ep.bmAttributes & isoSyncTypeMask)
-		switch ep.bmAttributes & usageTypeMask {
-		case C.LIBUSB_ISO_USAGE_TYPE_DATA:
-			ei.UsageType = IsoUsageTypeData
-		case C.LIBUSB_ISO_USAGE_TYPE_FEEDBACK:
-			ei.UsageType = IsoUsageTypeFeedback
-		case C.LIBUSB_ISO_USAGE_TYPE_IMPLICIT:
-			ei.UsageType = IsoUsageTypeImplicit
-		}
 	}
+	ei.IsoSyncType, ei.UsageType = endpointAttributes(uint8(ep.bmAttributes))
 	switch {
 	// If the device conforms to USB1.x:
 	//   Interval for polling endpoint for data transfers. Expressed in
@@ -119,12 +123,21 @@ func (ep libusbEndpoint) endpointDesc(dev *DeviceDesc) EndpointDesc {
 	//   bInterval of 4 means a period of 8 (2^(4-1) → 2^3 → 8).
 	//   This field is reserved and shall not be used for Enhanced SuperSpeed
 	//   bulk or control endpoints.
-	case dev.Speed == SpeedHigh || dev.Speed == SpeedSuper:
+	case dev.Speed == SpeedHigh || dev.Speed.superSpeed():
 		ei.PollInterval = 125 * time.Microsecond << (ep.bInterval - 1)
 	}
 	return ei
 }
 
+// extraBytes returns a Go copy of the extra descriptor bytes of a libusb
+// descriptor.
+func extraBytes(extra *C.uchar, length C.int) []byte {
+	if extra == nil || length <= 0 {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(extra), length)
+}
+
 // libusbIntf is a set of trivial idiomatic Go wrappers around libusb C functions.
 // The underlying code is generally not testable or difficult to test,
 // since libusb interacts directly with the host USB stack.
@@ -134,18 +147,27 @@ func (ep libusbEndpoint) endpointDesc(dev *DeviceDesc) EndpointDesc {
 type libusbIntf interface {
 	// context
 	init() (*libusbContext, error)
-	handleEvents(*libusbContext, <-chan struct{})
+	// handleEvents handles libusb events until done is closed, waiting
+	// for events at most timeout at a time.
+	handleEvents(c *libusbContext, timeout time.Duration, done <-chan struct{})
+	// interruptEvents makes a running handleEvents return from waiting
+	// for events immediately.
+	interruptEvents(*libusbContext)
 	getDevices(*libusbContext) ([]*libusbDevice, error)
 	exit(*libusbContext) error
 	setDebug(*libusbContext, int)
+	getVersion() LibusbVersion
 
 	// device
+	reference(*libusbDevice)
 	dereference(*libusbDevice)
 	getDeviceDesc(*libusbDevice) (*DeviceDesc, error)
 	open(*libusbDevice) (*libusbDevHandle, error)
 
 	close(*libusbDevHandle)
+	getDevice(*libusbDevHandle) *libusbDevice
 	reset(*libusbDevHandle) error
+	clearHalt(*libusbDevHandle, uint8) error
 	control(*libusbDevHandle, time.Duration, uint8, uint8, uint16, uint16, []byte) (int, error)
 	getConfig(*libusbDevHandle) (uint8, error)
 	setConfig(*libusbDevHandle, uint8) error
@@ -155,19 +177,57 @@ type libusbIntf interface {
 
 	// interface
 	claim(*libusbDevHandle, uint8) error
-	release(*libusbDevHandle, uint8)
+	release(*libusbDevHandle, uint8) error
 	setAlt(*libusbDevHandle, uint8, uint8) error
 
 	// transfer
 	alloc(*libusbDevHandle, *EndpointDesc, int, int, chan struct{}) (*libusbTransfer, error)
+	// allocBuffer and freeBuffer manage memory outside of the Go heap that
+	// can be shared by multiple transfers allocated with allocWithBuffer.
+	// Such transfers are released with freeTransfer, which doesn't free
+	// the buffer.
+	allocBuffer(int) ([]byte, error)
+	freeBuffer([]byte)
+	allocWithBuffer(*libusbDevHandle, *EndpointDesc, int, []byte, chan struct{}) (*libusbTransfer, error)
+	freeTransfer(*libusbTransfer)
 	cancel(*libusbTransfer) error
 	submit(*libusbTransfer) error
 	buffer(*libusbTransfer) []byte
 	data(*libusbTransfer) (int, TransferStatus)
 	free(*libusbTransfer)
 	setIsoPacketLengths(*libusbTransfer, uint32)
+	// setLength sets the number of bytes to transfer and, for isochronous
+	// transfers, the lengths of the iso packets to use.
+	setLength(t *libusbTransfer, length int, isoPackets []int)
+	// timeout and setTimeout get and set the libusb timeout of a transfer,
+	// 0 means no timeout.
+	timeout(*libusbTransfer) time.Duration
+	setTimeout(*libusbTransfer, time.Duration)
+	// wrap registers a transfer allocated outside of gousb, so that its
+	// completion is signalled on the channel. unwrap reverses wrap,
+	// the transfer memory is left to its owner.
+	wrap(*libusbTransfer, chan struct{}) error
+	unwrap(*libusbTransfer)
+	// isoPackets appends the iso packet descriptors of a transfer to dst.
+	isoPackets(t *libusbTransfer, dst []IsoPacket) []IsoPacket
+	// status returns the status of a transfer. Unlike data, it leaves the
+	// data of iso transfers in place, as delivered by the device.
+	status(*libusbTransfer) TransferStatus
+	// completionTime returns when libusb reported the completion of the
+	// last submission of a transfer, the zero time if unknown.
+	completionTime(*libusbTransfer) time.Time
+	// setCompletionHook registers a function called after each completion
+	// of a transfer was signalled on its done channel. The function runs
+	// on the libusb event thread, it must not block.
+	setCompletionHook(*libusbTransfer, func())
 
 	getParent(*libusbDevice) *libusbDevice
+
+	// hotplug
+	// registerHotplug calls cb on the event loop for every device that
+	// arrives or leaves, until the returned deregister func is called. The
+	// device passed to cb is valid only during the call.
+	registerHotplug(c *libusbContext, cb func(dev *libusbDevice, arrived bool)) (func(), error)
 }
 
 // libusbImpl is an implementation of libusbIntf using real CGo-wrapped libusb.
@@ -181,16 +241,30 @@ func (libusbImpl) init() (*libusbContext, error) {
 	return (*libusbContext)(ctx), nil
 }
 
-func (libusbImpl) handleEvents(c *libusbContext, done <-chan struct{}) {
-	tv := C.struct_timeval{tv_usec: 100e3}
+func (libusbImpl) handleEvents(c *libusbContext, timeout time.Duration, done <-chan struct{}) {
+	usec := C.longlong(timeout / time.Microsecond)
+	eventLoop(done, func() error {
+		return fromErrNo(C.gousb_handle_events((*C.libusb_context)(c), usec))
+	}, log.Printf)
+}
+
+func (libusbImpl) interruptEvents(c *libusbContext) {
+	C.gousb_interrupt_event_handler((*C.libusb_context)(c))
+}
+
+// eventLoop calls handle until done is closed. Errors of handle are logged
+// with logf, except for ErrorInterrupted: libusb returns it when a signal
+// arrives while it waits for events, which is not a failure of event
+// handling, so handle is simply called again.
+func eventLoop(done <-chan struct{}, handle func() error, logf func(format string, v ...interface{})) {
 	for {
 		select {
 		case <-done:
 			return
 		default:
 		}
-		if errno := C.libusb_handle_events_timeout_completed((*C.libusb_context)(c), &tv, nil); errno < 0 {
-			log.Printf("handle_events: error: %s", Error(errno))
+		if err := handle(); err != nil && err != ErrorInterrupted {
+			logf("handle_events: error: %s", err)
 		}
 	}
 }
@@ -225,6 +299,16 @@ func (libusbImpl) setDebug(c *libusbContext, lvl int) {
 	C.gousb_set_debug((*C.libusb_context)(c), C.int(lvl))
 }
 
+func (libusbImpl) getVersion() LibusbVersion {
+	v := C.libusb_get_version()
+	return LibusbVersion{
+		Major: uint16(v.major),
+		Minor: uint16(v.minor),
+		Micro: uint16(v.micro),
+		Nano:  uint16(v.nano),
+	}
+}
+
 func (libusbImpl) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
 	var desc C.struct_libusb_device_descriptor
 	if err := fromErrNo(C.libusb_get_device_descriptor((*C.libusb_device)(d), &desc)); err != nil {
@@ -247,6 +331,13 @@ func (libusbImpl) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
 		iProduct:             int(desc.iProduct),
 		iSerialNumber:        int(desc.iSerialNumber),
 	}
+	var ports [7]C.uint8_t
+	if n := C.libusb_get_port_numbers((*C.libusb_device)(d), &ports[0], C.int(len(ports))); n > 0 {
+		dev.Path = make([]int, n)
+		for i := range dev.Path {
+			dev.Path[i] = int(ports[i])
+		}
+	}
 	// Enumerate configurations
 	cfgs := make(map[int]ConfigDesc)
 	for i := 0; i < int(desc.bNumConfigurations); i++ {
@@ -259,10 +350,13 @@ func (libusbImpl) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
 			SelfPowered:    (cfg.bmAttributes & selfPoweredMask) != 0,
 			RemoteWakeup:   (cfg.bmAttributes & remoteWakeupMask) != 0,
 			MaxPower:       2 * Milliamperes(cfg.MaxPower),
+			TotalLength:    int(cfg.wTotalLength),
+			Extra:          extraBytes(cfg.extra, cfg.extra_length),
 			iConfiguration: int(cfg.iConfiguration),
 		}
+		c.ExtraDescriptors = parseExtra(c.Extra)
 		// at GenX speeds MaxPower is expressed in units of 8mA, not 2mA.
-		if dev.Speed == SpeedSuper {
+		if dev.Speed.superSpeed() {
 			c.MaxPower *= 4
 		}
 
@@ -294,8 +388,10 @@ func (libusbImpl) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
 					Class:      Class(alt.bInterfaceClass),
 					SubClass:   Class(alt.bInterfaceSubClass),
 					Protocol:   Protocol(alt.bInterfaceProtocol),
+					Extra:      extraBytes(alt.extra, alt.extra_length),
 					iInterface: int(alt.iInterface),
 				}
+				i.ExtraDescriptors = parseExtra(i.Extra)
 
 				if hasIntf[i.Number][i.Alternate] {
 					log.Printf("Device on bus %d address %d offered a descriptor for config %d with two different entries with the same interface number (%d) and the same alternate setting number (%d). gousb will use only the first one.", dev.Bus, dev.Address, c.Number, i.Number, i.Alternate)
@@ -324,6 +420,7 @@ func (libusbImpl) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
 				AltSettings: descs,
 			})
 		}
+		c.InterfaceAssociations = c.interfaceAssociations()
 		C.libusb_free_config_descriptor(cfg)
 		cfgs[c.Number] = c
 	}
@@ -332,6 +429,10 @@ func (libusbImpl) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
 	return dev, nil
 }
 
+func (libusbImpl) reference(d *libusbDevice) {
+	C.libusb_ref_device((*C.libusb_device)(d))
+}
+
 func (libusbImpl) dereference(d *libusbDevice) {
 	C.libusb_unref_device((*C.libusb_device)(d))
 }
@@ -348,10 +449,18 @@ func (libusbImpl) close(d *libusbDevHandle) {
 	C.libusb_close((*C.libusb_device_handle)(d))
 }
 
+func (libusbImpl) getDevice(d *libusbDevHandle) *libusbDevice {
+	return (*libusbDevice)(C.libusb_get_device((*C.libusb_device_handle)(d)))
+}
+
 func (libusbImpl) reset(d *libusbDevHandle) error {
 	return fromErrNo(C.libusb_reset_device((*C.libusb_device_handle)(d)))
 }
 
+func (libusbImpl) clearHalt(d *libusbDevHandle, ep uint8) error {
+	return fromErrNo(C.libusb_clear_halt((*C.libusb_device_handle)(d), C.uchar(ep)))
+}
+
 func (libusbImpl) control(d *libusbDevHandle, timeout time.Duration, rType, request uint8, val, idx uint16, data []byte) (int, error) {
 	dataSlice := (*reflect.SliceHeader)(unsafe.Pointer(&data))
 	n := C.libusb_control_transfer(
@@ -422,8 +531,8 @@ func (libusbImpl) claim(d *libusbDevHandle, iface uint8) error {
 	return fromErrNo(C.libusb_claim_interface((*C.libusb_device_handle)(d), C.int(iface)))
 }
 
-func (libusbImpl) release(d *libusbDevHandle, iface uint8) {
-	C.libusb_release_interface((*C.libusb_device_handle)(d), C.int(iface))
+func (libusbImpl) release(d *libusbDevHandle, iface uint8) error {
+	return fromErrNo(C.libusb_release_interface((*C.libusb_device_handle)(d), C.int(iface)))
 }
 
 func (libusbImpl) setAlt(d *libusbDevHandle, iface, setup uint8) error {
@@ -438,15 +547,65 @@ func (libusbImpl) alloc(d *libusbDevHandle, ep *EndpointDesc, isoPackets int, bu
 	if int(xfer.length) != bufLen {
 		return nil, fmt.Errorf("gousb_alloc_transfer_and_buffer(%d, %d): length = %d, want %d", bufLen, isoPackets, xfer.length, bufLen)
 	}
+	return fillTransfer(xfer, d, ep, isoPackets, done), nil
+}
+
+func (libusbImpl) allocBuffer(size int) ([]byte, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid buffer size %d", size)
+	}
+	p := C.malloc(C.size_t(size))
+	if p == nil {
+		return nil, fmt.Errorf("malloc(%d) failed", size)
+	}
+	var ret []byte
+	*(*reflect.SliceHeader)(unsafe.Pointer(&ret)) = reflect.SliceHeader{
+		Data: uintptr(p),
+		Len:  size,
+		Cap:  size,
+	}
+	return ret, nil
+}
+
+func (libusbImpl) freeBuffer(buf []byte) {
+	C.free(unsafe.Pointer(&buf[:1][0]))
+}
+
+func (libusbImpl) allocWithBuffer(d *libusbDevHandle, ep *EndpointDesc, isoPackets int, buf []byte, done chan struct{}) (*libusbTransfer, error) {
+	xfer := C.libusb_alloc_transfer(C.int(isoPackets))
+	if xfer == nil {
+		return nil, fmt.Errorf("libusb_alloc_transfer(%d) failed", isoPackets)
+	}
+	if len(buf) > 0 {
+		xfer.buffer = (*C.uchar)(unsafe.Pointer(&buf[0]))
+	}
+	xfer.length = C.int(len(buf))
+	return fillTransfer(xfer, d, ep, isoPackets, done), nil
+}
+
+func (libusbImpl) freeTransfer(t *libusbTransfer) {
+	xferDoneMap.Lock()
+	delete(xferDoneMap.m, t)
+	xferDoneMap.Unlock()
+	C.libusb_free_transfer((*C.struct_libusb_transfer)(t))
+}
+
+// fillTransfer sets up a newly allocated transfer for the endpoint and
+// registers the done channel that is signalled on its completion.
+func fillTransfer(xfer *C.struct_libusb_transfer, d *libusbDevHandle, ep *EndpointDesc, isoPackets int, done chan struct{}) *libusbTransfer {
 	xfer.dev_handle = (*C.libusb_device_handle)(d)
 	xfer.endpoint = C.uchar(ep.Address)
 	xfer._type = C.uchar(ep.TransferType)
 	xfer.num_iso_packets = C.int(isoPackets)
+	// No timeout: transfers wait for the device until completed or
+	// cancelled. This is important for event-driven endpoints, like
+	// interrupt endpoints of HID buttons, that may stay silent for minutes.
+	xfer.timeout = 0
 	ret := (*libusbTransfer)(xfer)
 	xferDoneMap.Lock()
-	xferDoneMap.m[ret] = done
+	xferDoneMap.m[ret] = &xferDone{ch: done}
 	xferDoneMap.Unlock()
-	return ret, nil
+	return ret
 }
 
 func (libusbImpl) cancel(t *libusbTransfer) error {
@@ -471,13 +630,31 @@ func (libusbImpl) buffer(t *libusbTransfer) []byte {
 
 func (libusbImpl) data(t *libusbTransfer) (int, TransferStatus) {
 	if TransferType(t._type) == TransferTypeIsochronous {
-		var status TransferStatus
-		n := int(C.gousb_compact_iso_data((*C.struct_libusb_transfer)(t), (*C.uchar)(unsafe.Pointer(&status))))
-		return n, status
+		var first TransferStatus
+		var failed C.int
+		n := int(C.gousb_compact_iso_data((*C.struct_libusb_transfer)(t), (*C.uchar)(unsafe.Pointer(&first)), &failed))
+		return n, isoStatus(TransferStatus(t.status), first, int(failed), int(t.num_iso_packets))
 	}
 	return int(t.actual_length), TransferStatus(t.status)
 }
 
+// isoStatus returns the status of an isochronous transfer: the status of the
+// transfer itself if it didn't complete, e.g. because it was cancelled,
+// otherwise the status of the first failed packet if all packets failed, or
+// TransferPartial if only some of them did.
+func isoStatus(xfer, firstFailed TransferStatus, failed, packets int) TransferStatus {
+	switch {
+	case xfer != TransferCompleted:
+		return xfer
+	case failed == 0:
+		return TransferCompleted
+	case failed == packets:
+		return firstFailed
+	default:
+		return TransferPartial
+	}
+}
+
 func (libusbImpl) free(t *libusbTransfer) {
 	xferDoneMap.Lock()
 	delete(xferDoneMap.m, t)
@@ -489,24 +666,165 @@ func (libusbImpl) setIsoPacketLengths(t *libusbTransfer, length uint32) {
 	C.libusb_set_iso_packet_lengths((*C.struct_libusb_transfer)(t), C.uint(length))
 }
 
+func (libusbImpl) setLength(t *libusbTransfer, length int, isoPackets []int) {
+	t.length = C.int(length)
+	if TransferType(t._type) != TransferTypeIsochronous {
+		return
+	}
+	t.num_iso_packets = C.int(len(isoPackets))
+	for i, l := range isoPackets {
+		C.gousb_set_iso_packet_length((*C.struct_libusb_transfer)(t), C.int(i), C.uint(l))
+	}
+}
+
+func (libusbImpl) timeout(t *libusbTransfer) time.Duration {
+	return time.Duration(t.timeout) * time.Millisecond
+}
+
+func (libusbImpl) setTimeout(t *libusbTransfer, d time.Duration) {
+	t.timeout = C.uint(d / time.Millisecond)
+}
+
+func (libusbImpl) isoPackets(t *libusbTransfer, dst []IsoPacket) []IsoPacket {
+	if TransferType(t._type) != TransferTypeIsochronous {
+		return dst
+	}
+	for i := 0; i < int(t.num_iso_packets); i++ {
+		pkt := C.gousb_iso_packet_desc((*C.struct_libusb_transfer)(t), C.int(i))
+		dst = append(dst, IsoPacket{
+			Length:       int(pkt.length),
+			ActualLength: int(pkt.actual_length),
+			Status:       TransferStatus(pkt.status),
+		})
+	}
+	return dst
+}
+
+func (libusbImpl) status(t *libusbTransfer) TransferStatus {
+	return TransferStatus(t.status)
+}
+
+func (libusbImpl) wrap(t *libusbTransfer, done chan struct{}) error {
+	xferDoneMap.Lock()
+	defer xferDoneMap.Unlock()
+	if _, ok := xferDoneMap.m[t]; ok {
+		return fmt.Errorf("transfer %p is already managed by gousb", t)
+	}
+	C.gousb_set_callback((*C.struct_libusb_transfer)(t))
+	xferDoneMap.m[t] = &xferDone{ch: done}
+	return nil
+}
+
+func (libusbImpl) unwrap(t *libusbTransfer) {
+	xferDoneMap.Lock()
+	delete(xferDoneMap.m, t)
+	xferDoneMap.Unlock()
+}
+
 func (libusbImpl) getParent(dev *libusbDevice) *libusbDevice {
 	return (*libusbDevice)(C.libusb_get_parent((*C.libusb_device)(dev)))
 }
 
+func (libusbImpl) registerHotplug(c *libusbContext, cb func(*libusbDevice, bool)) (func(), error) {
+	if C.libusb_has_capability(C.LIBUSB_CAP_HAS_HOTPLUG) == 0 {
+		return nil, ErrorNotSupported
+	}
+	hotplugCallbacks.Lock()
+	id := hotplugCallbacks.next
+	hotplugCallbacks.next++
+	hotplugCallbacks.m[id] = cb
+	hotplugCallbacks.Unlock()
+	var h C.libusb_hotplug_callback_handle
+	if err := fromErrNo(C.gousb_hotplug_register((*C.libusb_context)(c), C.int(id), &h)); err != nil {
+		hotplugCallbacks.Lock()
+		delete(hotplugCallbacks.m, id)
+		hotplugCallbacks.Unlock()
+		return nil, err
+	}
+	return func() {
+		C.libusb_hotplug_deregister_callback((*C.libusb_context)(c), h)
+		hotplugCallbacks.Lock()
+		delete(hotplugCallbacks.m, id)
+		hotplugCallbacks.Unlock()
+	}, nil
+}
+
+func (libusbImpl) setCompletionHook(t *libusbTransfer, fn func()) {
+	xferDoneMap.Lock()
+	defer xferDoneMap.Unlock()
+	if d := xferDoneMap.m[t]; d != nil {
+		d.hook = fn
+	}
+}
+
+func (libusbImpl) completionTime(t *libusbTransfer) time.Time {
+	xferDoneMap.RLock()
+	d := xferDoneMap.m[t]
+	xferDoneMap.RUnlock()
+	if d == nil {
+		return time.Time{}
+	}
+	if at := atomic.LoadInt64(&d.at); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
+}
+
+// xferDone is the completion signal of a transfer.
+type xferDone struct {
+	// ch is signalled on each completion.
+	ch chan struct{}
+	// at is the time of the last completion in Unix nanoseconds, updated
+	// atomically.
+	at int64
+	// hook, if not nil, is called after each completion, see
+	// setCompletionHook. Protected by xferDoneMap.
+	hook func()
+}
+
 // xferDoneMap keeps a map of done callback channels for all allocated transfers.
+// It's shared by all Contexts, but the transfer pointers are unique, so
+// the transfers of different Contexts never interfere.
 var xferDoneMap = struct {
-	m map[*libusbTransfer]chan struct{}
+	m map[*libusbTransfer]*xferDone
 	sync.RWMutex
 }{
-	m: make(map[*libusbTransfer]chan struct{}),
+	m: make(map[*libusbTransfer]*xferDone),
 }
 
 //export xferCallback
 func xferCallback(xfer *C.struct_libusb_transfer) {
 	xferDoneMap.RLock()
-	ch := xferDoneMap.m[(*libusbTransfer)(xfer)]
+	d := xferDoneMap.m[(*libusbTransfer)(xfer)]
+	hook := d.hook
 	xferDoneMap.RUnlock()
-	ch <- struct{}{}
+	atomic.StoreInt64(&d.at, time.Now().UnixNano())
+	d.ch <- struct{}{}
+	if hook != nil {
+		hook()
+	}
+}
+
+// hotplugCallbacks are the callbacks registered with registerHotplug, by
+// the id passed to libusb in the user_data of the callback.
+var hotplugCallbacks = struct {
+	sync.Mutex
+	m    map[int]func(*libusbDevice, bool)
+	next int
+}{
+	m: make(map[int]func(*libusbDevice, bool)),
+}
+
+//export hotplugCallback
+func hotplugCallback(ctx *C.libusb_context, dev *C.libusb_device, event C.int, userData unsafe.Pointer) C.int {
+	hotplugCallbacks.Lock()
+	cb := hotplugCallbacks.m[int(uintptr(userData))]
+	hotplugCallbacks.Unlock()
+	if cb != nil {
+		cb((*libusbDevice)(dev), event == C.LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED)
+	}
+	// Keep the callback registered.
+	return 0
 }
 
 // for benchmarking of method on implementation vs vanilla function.
@@ -523,6 +841,13 @@ func newFakeTransferPointer() *libusbTransfer {
 	return (*libusbTransfer)(unsafe.Pointer(C.malloc(1)))
 }
 
+// for filling in the iso packet results of a real libusb_transfer.
+func setIsoPacketResult(t *libusbTransfer, i, actual int, status TransferStatus) {
+	pkt := C.gousb_iso_packet_desc((*C.struct_libusb_transfer)(t), C.int(i))
+	pkt.actual_length = C.uint(actual)
+	pkt.status = C.enum_libusb_transfer_status(status)
+}
+
 func newContextPointer() *libusbContext {
 	return (*libusbContext)(unsafe.Pointer(C.malloc(1)))
 }
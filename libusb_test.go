@@ -0,0 +1,45 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEventLoopInterrupted(t *testing.T) {
+	results := []error{ErrorInterrupted, nil, ErrorInterrupted, ErrorInterrupted, ErrorIO, nil}
+	done := make(chan struct{})
+	var calls int
+	var logged []string
+	eventLoop(done, func() error {
+		err := results[calls]
+		calls++
+		if calls == len(results) {
+			close(done)
+		}
+		return err
+	}, func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	})
+	if calls != len(results) {
+		t.Errorf("eventLoop called handle %d times, want %d", calls, len(results))
+	}
+	want := fmt.Sprintf("handle_events: error: %s", ErrorIO)
+	if len(logged) != 1 || logged[0] != want {
+		t.Errorf("eventLoop logged %q, want only %q", logged, want)
+	}
+}
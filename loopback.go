@@ -0,0 +1,90 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LoopbackStats contains the results of a loopback test.
+type LoopbackStats struct {
+	// Iterations is the number of completed write/read cycles.
+	Iterations int
+	// Bytes is the total number of bytes written and read back.
+	Bytes int64
+	// Mismatches is the number of iterations in which the data read back
+	// differed from the data written.
+	Mismatches int
+	// Duration is the total time spent in the test.
+	Duration time.Duration
+}
+
+// Throughput returns the average loopback throughput, in bytes per second.
+func (s LoopbackStats) Throughput() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Duration.Seconds()
+}
+
+// String returns a human-readable summary of the test results.
+func (s LoopbackStats) String() string {
+	return fmt.Sprintf("%d iterations, %d bytes in %v (%.0f B/s), %d mismatches", s.Iterations, s.Bytes, s.Duration, s.Throughput(), s.Mismatches)
+}
+
+// LoopbackTest runs a self-test on a device that echoes the data written to
+// e back on the in endpoint, such as a loopback test firmware. Each of the
+// iterations writes the pattern to e, reads len(pattern) bytes back from in
+// and compares them with the pattern. Data mismatches are counted in the
+// returned statistics, but do not stop the test. A failed transfer stops
+// the test, LoopbackTest then returns the statistics gathered so far and
+// the error.
+func (e *OutEndpoint) LoopbackTest(in *InEndpoint, pattern []byte, iterations int) (stats LoopbackStats, err error) {
+	if len(pattern) == 0 {
+		return stats, errors.New("loopback test pattern must not be empty")
+	}
+	buf := make([]byte, len(pattern))
+	start := time.Now()
+	defer func() { stats.Duration = time.Since(start) }()
+	for i := 0; i < iterations; i++ {
+		n, err := e.Write(pattern)
+		if err != nil {
+			return stats, fmt.Errorf("iteration %d: write to %s: %v", i, e, err)
+		}
+		if n != len(pattern) {
+			return stats, fmt.Errorf("iteration %d: short write to %s: %d bytes written, want %d", i, e, n, len(pattern))
+		}
+		// The device might return the data in more than one transfer.
+		for got := 0; got < len(buf); {
+			n, err := in.Read(buf[got:])
+			got += n
+			if err != nil {
+				return stats, fmt.Errorf("iteration %d: read from %s after %d bytes: %v", i, in, got, err)
+			}
+			if n == 0 {
+				return stats, fmt.Errorf("iteration %d: read from %s returned no data after %d bytes", i, in, got)
+			}
+		}
+		stats.Iterations++
+		stats.Bytes += int64(len(pattern))
+		if !bytes.Equal(buf, pattern) {
+			stats.Mismatches++
+		}
+	}
+	return stats, nil
+}
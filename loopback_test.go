@@ -0,0 +1,95 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "testing"
+
+func TestLoopbackTest(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+
+	out := &OutEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x01,
+		Number:        1,
+		Direction:     EndpointDirectionOut,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+	in := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+
+	pattern := []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0x02}
+	const iterations = 3
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; i < iterations; i++ {
+			ft := lib.waitForSubmitted(done)
+			if ft == nil {
+				return
+			}
+			echo := append([]byte(nil), ft.buf...)
+			ft.setData(echo)
+			ft.setStatus(TransferCompleted)
+			// Echo the data in two halves, corrupting the last iteration.
+			for _, half := range [][]byte{echo[:3], echo[3:]} {
+				rt := lib.waitForSubmitted(done)
+				if rt == nil {
+					return
+				}
+				if i == iterations-1 {
+					half = []byte{0, 0, 0}
+				}
+				rt.setData(half)
+				rt.setStatus(TransferCompleted)
+			}
+		}
+	}()
+
+	stats, err := out.LoopbackTest(in, pattern, iterations)
+	if err != nil {
+		t.Fatalf("LoopbackTest(): %v", err)
+	}
+	if stats.Iterations != iterations || stats.Bytes != iterations*int64(len(pattern)) || stats.Mismatches != 1 {
+		t.Errorf("LoopbackTest(): got %s, want %d iterations, %d bytes, 1 mismatch", stats, iterations, iterations*len(pattern))
+	}
+	if stats.Duration <= 0 || stats.Throughput() <= 0 {
+		t.Errorf("LoopbackTest(): got duration %v, throughput %.0f B/s, want both > 0", stats.Duration, stats.Throughput())
+	}
+
+	go func() {
+		ft := lib.waitForSubmitted(done)
+		if ft != nil {
+			ft.setStatus(TransferStall)
+		}
+	}()
+	stats, err = out.LoopbackTest(in, pattern, 1)
+	if err == nil {
+		t.Errorf("LoopbackTest() with a stalled write: got %s, nil error, want non-nil", stats)
+	}
+	if stats.Iterations != 0 {
+		t.Errorf("LoopbackTest() with a stalled write: got %d iterations, want 0", stats.Iterations)
+	}
+	if stats.Duration <= 0 {
+		t.Errorf("LoopbackTest() with a stalled write: got duration %v, want > 0", stats.Duration)
+	}
+}
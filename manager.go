@@ -0,0 +1,227 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// defaultConnectionBuffer is the capacity of the channel returned by
+// DeviceManager.Events.
+const defaultConnectionBuffer = 16
+
+// ConnectionEvent is a change of the connection of a DeviceManager,
+// delivered by DeviceManager.Events.
+type ConnectionEvent struct {
+	// Device is the device that was opened and set up, nil if the device
+	// was disconnected or could not be opened.
+	Device *Device
+	// Desc is the descriptor of the device that arrived or left.
+	Desc *DeviceDesc
+	// Err is the failure to open or set up the device that arrived.
+	Err error
+}
+
+// String returns a human-readable description of the event.
+func (e ConnectionEvent) String() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%s failed to connect: %v", e.Desc, e.Err)
+	case e.Device != nil:
+		return fmt.Sprintf("%s connected", e.Desc)
+	}
+	return fmt.Sprintf("%s disconnected", e.Desc)
+}
+
+// DeviceManager keeps a connection to a device that can be disconnected
+// and reconnected, e.g. a device that is unplugged, or that reboots into
+// a new firmware. The manager opens the first device matching its filter,
+// and every time the device arrives again after it left, see
+// Context.NewDeviceManager.
+type DeviceManager struct {
+	c     *Context
+	match func(desc *DeviceDesc) bool
+	setup func(*Device) (release func(), err error)
+	// stop cancels the Notifications of the manager, which closes quit.
+	stop context.CancelFunc
+	quit <-chan struct{}
+	// done is closed when the manager goroutine returns, closeErr is
+	// the error of closing the device on the way out.
+	done     chan struct{}
+	closeErr error
+	events   chan ConnectionEvent
+
+	// mu protects dev and release, the cleanup returned by setup for dev.
+	mu      sync.Mutex
+	dev     *Device
+	release func()
+}
+
+// NewDeviceManager returns a DeviceManager of the devices for which match
+// returns true. The manager opens a matching device that is already
+// connected, and then follows the hotplug events of the Context, see
+// Notifications: when the device leaves, the manager closes it, which
+// cancels its transfers and releases its interfaces, and when a matching
+// device arrives while none is open, the manager opens it.
+// setup, if not nil, is called with every device the manager opens, before
+// the device is made available, e.g. to claim its interfaces. The release
+// function returned by setup, if not nil, is called before the manager
+// closes the device, e.g. the done function of Device.DefaultInterface.
+// If setup returns an error, it must clean up after itself: the device is
+// closed and the error is reported in a ConnectionEvent.
+// NewDeviceManager requires hotplug support, see Notifications. The
+// DeviceManager must be closed before the Context.
+func (c *Context) NewDeviceManager(match func(desc *DeviceDesc) bool, setup func(*Device) (release func(), err error)) (*DeviceManager, error) {
+	ctx, stop := context.WithCancel(context.Background())
+	ch, err := c.Notifications(ctx, WithNotificationBuffer(defaultConnectionBuffer))
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	m := &DeviceManager{
+		c:      c,
+		match:  match,
+		setup:  setup,
+		stop:   stop,
+		quit:   ctx.Done(),
+		done:   make(chan struct{}),
+		events: make(chan ConnectionEvent, defaultConnectionBuffer),
+	}
+	// Notifications are registered first, a device arriving before the
+	// scan is then reported again as an arrival, which is ignored.
+	m.connect(match)
+	go m.run(ch)
+	return m, nil
+}
+
+// Device returns the device that is currently connected and set up, or nil
+// if the device is disconnected. The returned device is closed by the
+// manager when the device leaves, the caller must not close it.
+func (m *DeviceManager) Device() *Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dev
+}
+
+// Events returns the channel delivering the connection changes of the
+// manager, in order. The manager waits for the event to be received
+// before it handles the next hotplug event, hotplug events that arrive
+// meanwhile are buffered, see Notifications. The channel is closed when
+// the manager is closed.
+func (m *DeviceManager) Events() <-chan ConnectionEvent {
+	return m.events
+}
+
+// Close stops following the hotplug events and closes the device, if it's
+// connected. Close returns the error of closing the device.
+func (m *DeviceManager) Close() error {
+	m.stop()
+	<-m.done
+	return m.closeErr
+}
+
+// run handles the hotplug events until the Notifications are stopped.
+func (m *DeviceManager) run(ch <-chan DeviceEvent) {
+	defer close(m.done)
+	defer close(m.events)
+	for ev := range ch {
+		switch ev.Type {
+		case DeviceArrived:
+			if m.Device() != nil {
+				continue
+			}
+			m.connect(func(desc *DeviceDesc) bool {
+				return sameDevice(desc, ev.Desc) && m.match(desc)
+			})
+		case DeviceLeft:
+			m.disconnect(ev.Desc)
+		}
+	}
+	m.closeErr = m.closeDevice()
+}
+
+// connect opens and sets up the first device for which match returns
+// true, if any.
+func (m *DeviceManager) connect(match func(desc *DeviceDesc) bool) {
+	devs, err := m.c.OpenDevices(match)
+	var dev *Device
+	for _, d := range devs {
+		if dev == nil {
+			dev = d
+			continue
+		}
+		d.Close()
+	}
+	if dev == nil {
+		if err != nil {
+			debug.Printf("DeviceManager: enumerating devices: %v", err)
+		}
+		return
+	}
+	var release func()
+	if m.setup != nil {
+		if release, err = m.setup(dev); err != nil {
+			dev.Close()
+			m.send(ConnectionEvent{Desc: dev.Desc, Err: fmt.Errorf("setting up %s: %w", dev, err)})
+			return
+		}
+	}
+	m.mu.Lock()
+	m.dev, m.release = dev, release
+	m.mu.Unlock()
+	m.send(ConnectionEvent{Device: dev, Desc: dev.Desc})
+}
+
+// disconnect closes the device if it's the device that left.
+func (m *DeviceManager) disconnect(desc *DeviceDesc) {
+	if dev := m.Device(); dev == nil || !sameDevice(dev.Desc, desc) {
+		return
+	}
+	if err := m.closeDevice(); err != nil {
+		debug.Printf("DeviceManager: closing %s after it left: %v", desc, err)
+	}
+	m.send(ConnectionEvent{Desc: desc})
+}
+
+// closeDevice releases and closes the connected device, if any.
+func (m *DeviceManager) closeDevice() error {
+	m.mu.Lock()
+	dev, release := m.dev, m.release
+	m.dev, m.release = nil, nil
+	m.mu.Unlock()
+	if dev == nil {
+		return nil
+	}
+	if release != nil {
+		release()
+	}
+	return dev.Close()
+}
+
+// send delivers an event, unless the manager is closed.
+func (m *DeviceManager) send(ev ConnectionEvent) {
+	select {
+	case m.events <- ev:
+	case <-m.quit:
+	}
+}
+
+// sameDevice returns true if a and b describe the same connected device.
+func sameDevice(a, b *DeviceDesc) bool {
+	return a.Bus == b.Bus && a.Address == b.Address
+}
@@ -0,0 +1,140 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeviceManager(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	c := newContextWithImpl(lib)
+	defer c.Close()
+
+	// plug connects a copy of the 9999:0001 device at addr.
+	plug := func(addr int) *libusbDevice {
+		desc := *fakeDevices[0].devDesc
+		desc.Bus, desc.Address, desc.Vendor = 3, addr, 0x5555
+		dev := newDevicePointer()
+		lib.mu.Lock()
+		lib.fakeDevices[dev] = &fakeDevice{devDesc: &desc}
+		lib.mu.Unlock()
+		lib.hotplug(dev, true)
+		return dev
+	}
+	unplug := func(dev *libusbDevice) {
+		lib.hotplug(dev, false)
+		lib.mu.Lock()
+		delete(lib.fakeDevices, dev)
+		lib.mu.Unlock()
+	}
+	next := func(m *DeviceManager) ConnectionEvent {
+		t.Helper()
+		select {
+		case ev := <-m.Events():
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("DeviceManager.Events(): no event received")
+		}
+		return ConnectionEvent{}
+	}
+
+	setupErr := errors.New("setup failed")
+	failSetup := false
+	// claimed are the devices whose interface is claimed by setup and
+	// not released yet.
+	claimed := make(map[*Device]bool)
+	m, err := c.NewDeviceManager(func(desc *DeviceDesc) bool {
+		return desc.Vendor == 0x5555
+	}, func(dev *Device) (func(), error) {
+		if failSetup {
+			return nil, setupErr
+		}
+		_, done, err := dev.DefaultInterface()
+		if err != nil {
+			return nil, err
+		}
+		claimed[dev] = true
+		return func() {
+			done()
+			delete(claimed, dev)
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("NewDeviceManager(): %v", err)
+	}
+	if dev := m.Device(); dev != nil {
+		t.Errorf("Device() before any arrival: got %s, want nil", dev)
+	}
+
+	var prev *Device
+	for _, addr := range []int{7, 8} {
+		dev := plug(addr)
+		ev := next(m)
+		if ev.Err != nil || ev.Device == nil || ev.Desc.Address != addr {
+			t.Fatalf("arrival of the device at address %d: got event %s, want the device connected", addr, ev)
+		}
+		if got := m.Device(); got != ev.Device {
+			t.Errorf("Device(): got %v, want %v", got, ev.Device)
+		}
+		if !claimed[ev.Device] {
+			t.Errorf("%s: interface 0 not claimed by setup", ev.Device)
+		}
+		if prev != nil && prev == ev.Device {
+			t.Errorf("arrival of the device at address %d: got the previous device %s, want a new device", addr, prev)
+		}
+		prev = ev.Device
+		// A second device arriving while the first is connected is ignored.
+		other := plug(9)
+		unplug(other)
+
+		unplug(dev)
+		ev = next(m)
+		if ev.Device != nil || ev.Err != nil || ev.Desc.Address != addr {
+			t.Fatalf("departure of the device at address %d: got event %s, want the device disconnected", addr, ev)
+		}
+		if got := m.Device(); got != nil {
+			t.Errorf("Device() after the departure: got %s, want nil", got)
+		}
+		if prev.IsOpen() || claimed[prev] {
+			t.Errorf("%s still open after the departure", prev)
+		}
+	}
+
+	// A failed setup is reported, and the device is not connected.
+	failSetup = true
+	dev := plug(10)
+	if ev := next(m); !errors.Is(ev.Err, setupErr) || ev.Device != nil {
+		t.Errorf("arrival with a failing setup: got event %s, want error %v", ev, setupErr)
+	}
+	if got := m.Device(); got != nil {
+		t.Errorf("Device() after a failed setup: got %s, want nil", got)
+	}
+	unplug(dev)
+
+	if err := m.Close(); err != nil {
+		t.Errorf("DeviceManager.Close(): %v", err)
+	}
+	if _, ok := <-m.Events(); ok {
+		t.Error("DeviceManager.Events(): got an event after Close, want closed channel")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Context.Close(): %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package gousb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// MmapBuffers is a BufferProvider handing out consecutive regions of a
+// memory mapped file as transfer buffers. Data read by IN transfers lands
+// directly in the page cache of the file and is written to the file by the
+// kernel, without a copy or an explicit write, which suits capturing a
+// stream straight to disk. Use Offset to find where the data of a transfer
+// is stored in the file.
+//
+// Regions are not reused: each transfer allocated by a stream takes the
+// next region, which stays in place after the transfer is released.
+// Resubmissions of a transfer read into the same region again, overwriting
+// its previous data, so the data must be consumed, e.g. from
+// ReadStream.Results, before the buffer is released back to the stream.
+type MmapBuffers struct {
+	// mapping is the memory mapped with mmap, starting at a page boundary
+	// at or before the requested offset.
+	mapping []byte
+	// region is the requested part of mapping.
+	region []byte
+	offset int64
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewMmapBuffers maps length bytes of f, starting at offset, and returns
+// a provider of buffers from this region. offset doesn't need to be
+// aligned to the page size. f must be open for reading and writing, and it
+// is extended if it's shorter than offset+length. The mapping stays valid
+// after f is closed, until Close.
+func NewMmapBuffers(f *os.File, offset int64, length int) (*MmapBuffers, error) {
+	if offset < 0 || length <= 0 {
+		return nil, fmt.Errorf("invalid mapped region of %d bytes at offset %d", length, offset)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if end := offset + int64(length); st.Size() < end {
+		if err := f.Truncate(end); err != nil {
+			return nil, fmt.Errorf("failed to extend %s to %d bytes: %v", f.Name(), end, err)
+		}
+	}
+	// mmap requires the file offset to be a multiple of the page size.
+	pad := int(offset % int64(os.Getpagesize()))
+	mapping, err := syscall.Mmap(int(f.Fd()), offset-int64(pad), pad+length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap of %s failed: %v", f.Name(), err)
+	}
+	return &MmapBuffers{
+		mapping: mapping,
+		region:  mapping[pad : pad+length],
+		offset:  offset,
+	}, nil
+}
+
+// Buffer returns the next unused region of size bytes. It returns an error
+// if the remaining part of the mapping is too small.
+func (m *MmapBuffers) Buffer(size int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mapping == nil {
+		return nil, errors.New("MmapBuffers.Buffer called after Close")
+	}
+	if size <= 0 || size > len(m.region)-m.next {
+		return nil, fmt.Errorf("can't allocate a buffer of %d bytes, %d bytes of the mapped region are left", size, len(m.region)-m.next)
+	}
+	buf := m.region[m.next : m.next+size : m.next+size]
+	m.next += size
+	return buf, nil
+}
+
+// Release implements BufferProvider. The region of buf is not reused, its
+// data stays in the file.
+func (m *MmapBuffers) Release(buf []byte) {}
+
+// Bytes returns the whole mapped region.
+func (m *MmapBuffers) Bytes() []byte {
+	return m.region
+}
+
+// Offset returns the offset in the file of the start of buf, a buffer
+// returned by Buffer or a part of one, e.g. the data of a TransferResult.
+// It returns -1 if buf is not a part of the mapped region.
+func (m *MmapBuffers) Offset(buf []byte) int64 {
+	if cap(buf) == 0 || len(m.region) == 0 {
+		return -1
+	}
+	start := uintptr(unsafe.Pointer(&m.region[0]))
+	p := uintptr(unsafe.Pointer(&buf[:1][0]))
+	if p < start || p >= start+uintptr(len(m.region)) {
+		return -1
+	}
+	return m.offset + int64(p-start)
+}
+
+// Flush writes the modified pages of the mapping to the file and waits
+// for the write to finish, see msync(2). Flush is not needed for the data
+// to reach the file eventually, only to make sure it's on disk, e.g.
+// before the data is read from the file by other means than the mapping.
+func (m *MmapBuffers) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mapping == nil {
+		return errors.New("MmapBuffers.Flush called after Close")
+	}
+	return msync(m.mapping)
+}
+
+// Close flushes and unmaps the mapping. Close must be called only after
+// all the streams using the buffers were closed and their transfers
+// finished, since the buffers become invalid.
+func (m *MmapBuffers) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mapping == nil {
+		return nil
+	}
+	err := msync(m.mapping)
+	if uerr := syscall.Munmap(m.mapping); err == nil {
+		err = uerr
+	}
+	m.mapping, m.region = nil, nil
+	return err
+}
+
+func msync(b []byte) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), syscall.MS_SYNC); errno != 0 {
+		return fmt.Errorf("msync failed: %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package gousb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMmapBuffersStream(t *testing.T) {
+	t.Parallel()
+	f, err := ioutil.TempFile("", "gousb-mmap")
+	if err != nil {
+		t.Fatalf("TempFile(): %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	// A header before the data, the region doesn't start at a page boundary.
+	const offset = 100
+	if _, err := f.Write(bytes.Repeat([]byte{0xff}, offset)); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	mb, err := NewMmapBuffers(f, offset, 3*512)
+	if err != nil {
+		t.Fatalf("NewMmapBuffers(): %v", err)
+	}
+
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	rs, err := ep.NewStream(512, 2, WithBufferProvider(mb))
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	results := rs.Results()
+	// Inject the completions of both transfers, as if the device sent the
+	// data.
+	for _, v := range []byte{1, 2} {
+		ft := lib.waitForSubmitted(nil)
+		ft.setData(bytes.Repeat([]byte{v}, 512))
+		ft.setStatus(TransferCompleted)
+	}
+	var want []byte
+	var held [][]byte
+	for i, v := range []byte{1, 2} {
+		r := <-results
+		if r.Err != nil {
+			t.Fatalf("result %d: %v", i, r.Err)
+		}
+		if got, want := mb.Offset(r.Data), int64(offset+i*512); got != want {
+			t.Errorf("result %d: Offset(): got %d, want %d", i, got, want)
+		}
+		want = append(want, bytes.Repeat([]byte{v}, 512)...)
+		held = append(held, r.Data)
+	}
+	if got := mb.Bytes()[:1024]; !bytes.Equal(got, want) {
+		t.Error("the mapped region doesn't hold the data of the transfers")
+	}
+	if _, err := mb.Buffer(1024); err == nil {
+		t.Error("Buffer(1024) with 512 bytes left: got nil error, want non-nil")
+	}
+	if got := mb.Offset(make([]byte, 10)); got != -1 {
+		t.Errorf("Offset() of a foreign buffer: got %d, want -1", got)
+	}
+	// The data is in the file, without any explicit write.
+	if err := mb.Flush(); err != nil {
+		t.Errorf("Flush(): %v", err)
+	}
+	got, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	if len(got) != offset+3*512 || !bytes.Equal(got[offset:offset+1024], want) || !bytes.Equal(got[:offset], bytes.Repeat([]byte{0xff}, offset)) {
+		t.Errorf("file contents don't match the data of the transfers")
+	}
+
+	// Buffers released after Close free their transfers.
+	rs.Close()
+	for _, buf := range held {
+		if err := rs.Release(buf); err != nil {
+			t.Errorf("Release(): %v", err)
+		}
+	}
+	for r := range results {
+		t.Errorf("unexpected result after Close: %+v", r)
+	}
+	if err := mb.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Microsoft OS 1.0 descriptors, see "Microsoft OS 1.0 Descriptors
+// Specification". Windows reads them to bind drivers, e.g. WinUSB, to
+// devices without an INF file.
+const (
+	// msOSStringIndex is the index of the Microsoft OS string descriptor.
+	msOSStringIndex = 0xee
+	// msOSStringLength is the length of the Microsoft OS string descriptor.
+	msOSStringLength = 0x12
+	// Feature indexes passed in wIndex of the vendor request.
+	msOSExtendedCompatID   = 0x0004
+	msOSExtendedProperties = 0x0005
+	// msOSHeaderLength is the length of the shortest feature descriptor
+	// header, the extended properties header. Both headers start with the
+	// dwLength of the entire descriptor.
+	msOSHeaderLength = 10
+)
+
+// msOSSignature is the qwSignature of the Microsoft OS string descriptor,
+// "MSFT100" in UTF-16LE.
+var msOSSignature = []byte{'M', 0, 'S', 0, 'F', 0, 'T', 0, '1', 0, '0', 0, '0', 0}
+
+// GetMSOSDescriptor reads the Microsoft OS string descriptor of the device,
+// the string descriptor with index 0xEE. The raw descriptor is returned, the
+// vendor code to use for the feature descriptors is at offset 16, see
+// MSOSVendorCode. Devices without Microsoft OS descriptors usually stall
+// the request, GetMSOSDescriptor returns an error wrapping ErrorPipe then.
+// Microsoft OS 2.0 descriptors are announced through a BOS platform
+// capability instead, and are not read by GetMSOSDescriptor.
+func (d *Device) GetMSOSDescriptor() ([]byte, error) {
+	buf := make([]byte, msOSStringLength)
+	n, err := d.getDescriptor(DescriptorTypeString, msOSStringIndex, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Microsoft OS string descriptor of %s: %w", d, err)
+	}
+	buf = buf[:n]
+	if n < msOSStringLength || DescriptorType(buf[1]) != DescriptorTypeString || !bytes.Equal(buf[2:16], msOSSignature) {
+		return nil, fmt.Errorf("device %s has no Microsoft OS string descriptor, got % x", d, buf)
+	}
+	return buf, nil
+}
+
+// MSOSVendorCode returns the vendor code from the Microsoft OS string
+// descriptor of the device, the bRequest of the vendor requests reading
+// the Microsoft OS feature descriptors.
+func (d *Device) MSOSVendorCode() (uint8, error) {
+	desc, err := d.GetMSOSDescriptor()
+	if err != nil {
+		return 0, err
+	}
+	return desc[16], nil
+}
+
+// GetMSOSExtendedCompatID reads the raw extended compat ID descriptor of
+// the device, which lists the compatible IDs of the interfaces, e.g.
+// "WINUSB". vendorCode is the code returned by MSOSVendorCode.
+func (d *Device) GetMSOSExtendedCompatID(vendorCode uint8) ([]byte, error) {
+	rType := ControlType(ControlKindVendor, ControlRecipientDevice, EndpointDirectionIn)
+	b, err := d.getMSOSFeature(rType, vendorCode, 0, msOSExtendedCompatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Microsoft OS extended compat ID descriptor of %s: %w", d, err)
+	}
+	return b, nil
+}
+
+// GetMSOSExtendedProperties reads the raw extended properties descriptor
+// of interface iface of the device, which holds registry properties, e.g.
+// the DeviceInterfaceGUID used by WinUSB applications. vendorCode is the
+// code returned by MSOSVendorCode.
+func (d *Device) GetMSOSExtendedProperties(vendorCode uint8, iface int) ([]byte, error) {
+	rType := ControlType(ControlKindVendor, ControlRecipientInterface, EndpointDirectionIn)
+	b, err := d.getMSOSFeature(rType, vendorCode, uint16(iface)<<8, msOSExtendedProperties)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Microsoft OS extended properties descriptor of interface %d of %s: %w", iface, d, err)
+	}
+	return b, nil
+}
+
+// getMSOSFeature reads a Microsoft OS feature descriptor: the header first,
+// to learn the length of the descriptor, then the entire descriptor.
+func (d *Device) getMSOSFeature(rType, vendorCode uint8, val, idx uint16) ([]byte, error) {
+	hdr := make([]byte, msOSHeaderLength)
+	n, err := d.Control(rType, vendorCode, val, idx, hdr)
+	if err != nil {
+		return nil, err
+	}
+	if n < 4 {
+		return nil, fmt.Errorf("descriptor header too short: %d bytes", n)
+	}
+	l := int(binary.LittleEndian.Uint32(hdr))
+	if l < n || l > 0xffff {
+		return nil, fmt.Errorf("invalid descriptor length %d", l)
+	}
+	if l == n {
+		return hdr[:n], nil
+	}
+	buf := make([]byte, l)
+	if n, err = d.Control(rType, vendorCode, val, idx, buf); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
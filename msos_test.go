@@ -0,0 +1,99 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMSOSDescriptors(t *testing.T) {
+	t.Parallel()
+	osString := []byte{0x12, 0x03, 'M', 0, 'S', 0, 'F', 0, 'T', 0, '1', 0, '0', 0, '0', 0, 0x20, 0x00}
+	compatID := []byte{
+		// header: dwLength 40, bcdVersion 1.00, wIndex 4, bCount 1.
+		0x28, 0x00, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0,
+		// function: interface 0, compatible ID "WINUSB".
+		0x00, 0x01, 'W', 'I', 'N', 'U', 'S', 'B', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	props := []byte{0x0e, 0x00, 0x00, 0x00, 0x00, 0x01, 0x05, 0x00, 0x00, 0x00, 0xaa, 0xbb, 0xcc, 0xdd}
+	noMSOS := false
+
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		switch {
+		case noMSOS:
+			return 0, ErrorPipe
+		case req.rType == 0x80 && req.request == 0x06 && req.val == 0x03ee:
+			return copy(data, osString), nil
+		case req.rType == 0xc0 && req.request == 0x20 && req.idx == 4:
+			return copy(data, compatID), nil
+		case req.rType == 0xc1 && req.request == 0x20 && req.idx == 5:
+			return copy(data, props), nil
+		}
+		return 0, ErrorPipe
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	got, err := dev.GetMSOSDescriptor()
+	if err != nil || !bytes.Equal(got, osString) {
+		t.Errorf("%s.GetMSOSDescriptor(): got % x, %v, want % x, nil", dev, got, err, osString)
+	}
+	code, err := dev.MSOSVendorCode()
+	if err != nil || code != 0x20 {
+		t.Errorf("%s.MSOSVendorCode(): got 0x%02x, %v, want 0x20, nil", dev, code, err)
+	}
+	wantReqs := []controlRequest{
+		{0x80, 0x06, 0x03ee, 0, make([]byte, 18)},
+		{0x80, 0x06, 0x03ee, 0, make([]byte, 18)},
+	}
+	if reqs := lib.requests(); !reflect.DeepEqual(reqs, wantReqs) {
+		t.Errorf("control requests: got %v, want %v", reqs, wantReqs)
+	}
+
+	lib.reqs = nil
+	got, err = dev.GetMSOSExtendedCompatID(code)
+	if err != nil || !bytes.Equal(got, compatID) {
+		t.Errorf("%s.GetMSOSExtendedCompatID(): got % x, %v, want % x, nil", dev, got, err, compatID)
+	}
+	got, err = dev.GetMSOSExtendedProperties(code, 2)
+	if err != nil || !bytes.Equal(got, props) {
+		t.Errorf("%s.GetMSOSExtendedProperties(): got % x, %v, want % x, nil", dev, got, err, props)
+	}
+	wantReqs = []controlRequest{
+		// the header first, then the entire descriptor.
+		{0xc0, 0x20, 0x0000, 4, make([]byte, 10)},
+		{0xc0, 0x20, 0x0000, 4, make([]byte, len(compatID))},
+		{0xc1, 0x20, 0x0200, 5, make([]byte, 10)},
+		{0xc1, 0x20, 0x0200, 5, make([]byte, len(props))},
+	}
+	if reqs := lib.requests(); !reflect.DeepEqual(reqs, wantReqs) {
+		t.Errorf("control requests: got %v, want %v", reqs, wantReqs)
+	}
+
+	noMSOS = true
+	if _, err := dev.GetMSOSDescriptor(); !errors.Is(err, ErrorPipe) {
+		t.Errorf("%s.GetMSOSDescriptor() on a device without MS OS descriptors: got error %v, want an error wrapping %v", dev, err, ErrorPipe)
+	}
+}
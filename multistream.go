@@ -0,0 +1,102 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MultiStreamResult is a transfer result of one of the streams of
+// a MultiStream, tagged with the endpoint of the stream.
+type MultiStreamResult struct {
+	// Endpoint is the address of the endpoint the data was read from.
+	Endpoint EndpointAddress
+	// Data is the data read by the transfer, owned by the receiver until
+	// passed to MultiStream.Release. See TransferResult.
+	Data []byte
+	// Err is the error that stopped the stream of Endpoint. A result with
+	// a non-nil Err is the last result of that endpoint.
+	Err error
+}
+
+// MultiStream merges the results of the read streams of several endpoints
+// into a single channel, so that a multiplexed device, e.g. one with audio
+// and status IN endpoints, can be served by one loop. The results of each
+// endpoint are delivered in the order of completion of its transfers,
+// results of different endpoints are interleaved as they complete.
+type MultiStream struct {
+	streams map[EndpointAddress]*ReadStream
+	results chan MultiStreamResult
+}
+
+// NewMultiStream merges the given streams, keyed by their endpoint
+// addresses. The streams are switched to delivering their results through
+// ReadStream.Results, they must not be read directly afterwards.
+// An error on one stream ends that stream only, the other streams keep
+// delivering results.
+func NewMultiStream(streams map[EndpointAddress]*ReadStream) *MultiStream {
+	m := &MultiStream{
+		streams: make(map[EndpointAddress]*ReadStream, len(streams)),
+		results: make(chan MultiStreamResult),
+	}
+	var wg sync.WaitGroup
+	for addr, s := range streams {
+		m.streams[addr] = s
+		wg.Add(1)
+		go func(addr EndpointAddress, results <-chan TransferResult) {
+			defer wg.Done()
+			for res := range results {
+				m.results <- MultiStreamResult{Endpoint: addr, Data: res.Data, Err: res.Err}
+			}
+		}(addr, s.Results())
+	}
+	go func() {
+		wg.Wait()
+		close(m.results)
+	}()
+	return m
+}
+
+// Results returns the channel delivering the results of all streams. The
+// channel is closed once all streams have ended, i.e. after Close, or after
+// all streams failed.
+func (m *MultiStream) Results() <-chan MultiStreamResult {
+	return m.results
+}
+
+// Release returns the buffer of a result to its stream, see
+// ReadStream.Release.
+func (m *MultiStream) Release(res MultiStreamResult) error {
+	s, ok := m.streams[res.Endpoint]
+	if !ok {
+		return fmt.Errorf("MultiStream.Release: no stream for endpoint %s", res.Endpoint)
+	}
+	return s.Release(res.Data)
+}
+
+// Close closes all streams. The transfers still in flight are delivered
+// through Results before the channel is closed, and their buffers still
+// need to be released.
+func (m *MultiStream) Close() error {
+	var ret error
+	for _, s := range m.streams {
+		if err := s.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
@@ -0,0 +1,92 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "testing"
+
+func TestMultiStream(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			ft := lib.waitForSubmitted(done)
+			if ft == nil {
+				return
+			}
+			// Tag the data with the endpoint it was read from.
+			data := make([]byte, len(ft.buf))
+			for i := range data {
+				data[i] = byte(ft.ep.Address)
+			}
+			ft.setData(data)
+			ft.setStatus(TransferCompleted)
+		}
+	}()
+
+	streams := make(map[EndpointAddress]*ReadStream)
+	for _, addr := range []EndpointAddress{0x81, 0x82} {
+		ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+			Address:       addr,
+			Number:        int(addr & 0x0f),
+			Direction:     EndpointDirectionIn,
+			MaxPacketSize: 64,
+			TransferType:  TransferTypeBulk,
+		}}}
+		s, err := ep.NewStream(64, 2)
+		if err != nil {
+			t.Fatalf("%s.NewStream(): %v", ep, err)
+		}
+		streams[addr] = s
+	}
+	m := NewMultiStream(streams)
+
+	const want = 5
+	got := make(map[EndpointAddress]int)
+	closed := false
+	for res := range m.Results() {
+		if res.Err != nil {
+			t.Errorf("result of endpoint %s: %v", res.Endpoint, res.Err)
+			continue
+		}
+		if len(res.Data) != 64 || res.Data[0] != byte(res.Endpoint) {
+			t.Errorf("result of endpoint %s: got data % x, want 64 bytes of %02x", res.Endpoint, res.Data, byte(res.Endpoint))
+		}
+		got[res.Endpoint]++
+		if err := m.Release(res); err != nil {
+			t.Errorf("Release(): %v", err)
+		}
+		if !closed && got[0x81] >= want && got[0x82] >= want {
+			if err := m.Close(); err != nil {
+				t.Errorf("MultiStream.Close(): %v", err)
+			}
+			closed = true
+		}
+	}
+	if !closed {
+		t.Errorf("Results() closed before all endpoints delivered %d results: got %v", want, got)
+	}
+	if err := m.Release(MultiStreamResult{Endpoint: 0x83, Data: make([]byte, 1)}); err == nil {
+		t.Errorf("Release() for an unknown endpoint: got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// nullLibusb completes every transfer as soon as it's submitted: IN
+// transfers return a full buffer (a null source), OUT transfers accept all
+// data (a null sink). Benchmarks using nullLibusb measure the overhead of
+// the gousb transfer path, without any device or test goroutine involved.
+type nullLibusb struct {
+	*fakeLibusb
+}
+
+func (n nullLibusb) submit(t *libusbTransfer) error {
+	n.mu.Lock()
+	ft := n.ts[t]
+	n.mu.Unlock()
+	ft.mu.Lock()
+	ft.length = len(ft.buf)
+	ft.status = TransferCompleted
+	ft.finished = true
+	ft.mu.Unlock()
+	ft.done <- struct{}{}
+	return nil
+}
+
+// eventLoopLibusb completes transfers from the event loop goroutine, like
+// libusb does, so that benchmarks using it include the latency of waking
+// up the event loop.
+type eventLoopLibusb struct {
+	nullLibusb
+	pending chan *fakeTransfer
+}
+
+func newEventLoopLibusb() eventLoopLibusb {
+	return eventLoopLibusb{nullLibusb{newFakeLibusb()}, make(chan *fakeTransfer, 16)}
+}
+
+func (e eventLoopLibusb) submit(t *libusbTransfer) error {
+	e.mu.Lock()
+	ft := e.ts[t]
+	e.mu.Unlock()
+	e.pending <- ft
+	return nil
+}
+
+func (e eventLoopLibusb) handleEvents(_ *libusbContext, _ time.Duration, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case ft := <-e.pending:
+			ft.mu.Lock()
+			ft.length = len(ft.buf)
+			ft.status = TransferCompleted
+			ft.finished = true
+			ft.mu.Unlock()
+			ft.done <- struct{}{}
+		}
+	}
+}
+
+func newNullEndpoint(ctx *Context, dir EndpointDirection) *endpoint {
+	addr := EndpointAddress(0x01)
+	if dir == EndpointDirectionIn {
+		addr = 0x81
+	}
+	return &endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       addr,
+		Number:        1,
+		Direction:     dir,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}
+}
+
+// BenchmarkNullTransfer reports the cost of a single Read or Write,
+// including the allocation of its transfer, per op.
+func BenchmarkNullTransfer(b *testing.B) {
+	for _, size := range []int{64, 512} {
+		ctx := newContextWithImpl(nullLibusb{newFakeLibusb()})
+		in := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+		out := &OutEndpoint{newNullEndpoint(ctx, EndpointDirectionOut)}
+		buf := make([]byte, size)
+		b.Run(fmt.Sprintf("source/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := in.Read(buf); err != nil {
+					b.Fatalf("Read(): %v", err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("sink/%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := out.Write(buf); err != nil {
+					b.Fatalf("Write(): %v", err)
+				}
+			}
+		})
+		ctx.Close()
+	}
+}
+
+// BenchmarkNullTransferReuse reports the cost of a Submit and Wait of a
+// reused transfer per op, with and without WithSingleOwner.
+func BenchmarkNullTransferReuse(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		opts []TransferOption
+	}{
+		{"locked", nil},
+		{"single-owner", []TransferOption{WithSingleOwner()}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			ctx := newContextWithImpl(nullLibusb{newFakeLibusb()})
+			defer ctx.Close()
+			in := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+			xfer, err := in.NewTransfer(512, tc.opts...)
+			if err != nil {
+				b.Fatalf("NewTransfer(): %v", err)
+			}
+			defer xfer.Free()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := xfer.Submit(); err != nil {
+					b.Fatalf("Submit(): %v", err)
+				}
+				if _, err := xfer.Wait(context.Background()); err != nil {
+					b.Fatalf("Wait(): %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkNullStream reports the cost of one stream transfer per op.
+// Each Read or Write uses a buffer of the transfer size, so that it
+// consumes or produces exactly one transfer.
+func BenchmarkNullStream(b *testing.B) {
+	b.Run("default", func(b *testing.B) { benchmarkNullStream(b) })
+	b.Run("contiguous", func(b *testing.B) { benchmarkNullStream(b, WithContiguousBuffers()) })
+}
+
+func benchmarkNullStream(b *testing.B, opts ...StreamOption) {
+	const size, count = 512, 4
+	ctx := newContextWithImpl(nullLibusb{newFakeLibusb()})
+	defer ctx.Close()
+	buf := make([]byte, size)
+
+	b.Run("source", func(b *testing.B) {
+		in := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+		s, err := in.NewStream(size, count, opts...)
+		if err != nil {
+			b.Fatalf("NewStream(): %v", err)
+		}
+		defer s.Close()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Read(buf); err != nil {
+				b.Fatalf("Read(): %v", err)
+			}
+		}
+	})
+	b.Run("sink", func(b *testing.B) {
+		out := &OutEndpoint{newNullEndpoint(ctx, EndpointDirectionOut)}
+		s, err := out.NewStream(size, count, opts...)
+		if err != nil {
+			b.Fatalf("NewStream(): %v", err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := s.Write(buf); err != nil {
+				b.Fatalf("Write(): %v", err)
+			}
+		}
+		b.StopTimer()
+		if err := s.Close(); err != nil {
+			b.Fatalf("Close(): %v", err)
+		}
+	})
+}
+
+// BenchmarkEventLoopCompletion reports the round trip of a transfer
+// completed by the event loop per op, with and without the event loop
+// options. Raising the priority requires privileges, without them the
+// setup fails and the benchmark runs with the default priority.
+func BenchmarkEventLoopCompletion(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []ContextOption
+	}{
+		{"default", nil},
+		{"pinned", []ContextOption{WithEventLoopCPU(0)}},
+		{"priority", []ContextOption{WithEventLoopPriority(-10)}},
+		{"pinned+priority", []ContextOption{WithEventLoopCPU(0), WithEventLoopPriority(-10)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			ctx := newContextWithImpl(newEventLoopLibusb(), bc.opts...)
+			defer ctx.Close()
+			in := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+			t, err := in.newUSBTransfer(512)
+			if err != nil {
+				b.Fatalf("newUSBTransfer(): %v", err)
+			}
+			defer t.free()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := t.submit(); err != nil {
+					b.Fatalf("submit(): %v", err)
+				}
+				if _, err := t.wait(context.Background()); err != nil {
+					b.Fatalf("wait(): %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TransferRecord is a transfer of an endpoint recorded by a
+// TransferRecorder. Records are serialized as JSON, one object per line:
+//
+//	{"time_ns":1250000,"duration_ns":1000000,"endpoint":130,"status":0,"data":"776f726c64"}
+//
+// time_ns is the completion time of the transfer since the start of the
+// recording, duration_ns the time from its submission to its completion.
+// endpoint is the endpoint address, whose bit 7 is the direction. status
+// is the TransferStatus value and data the bytes received from, or sent
+// to, the device, hex encoded.
+type TransferRecord struct {
+	Time     time.Duration   `json:"time_ns"`
+	Duration time.Duration   `json:"duration_ns"`
+	Endpoint EndpointAddress `json:"endpoint"`
+	Status   TransferStatus  `json:"status"`
+	Data     HexBytes        `json:"data"`
+}
+
+// Direction returns the direction of the endpoint of the transfer.
+func (r TransferRecord) Direction() EndpointDirection {
+	return r.Endpoint&endpointDirectionMask != 0
+}
+
+// String returns a human-readable description of the record.
+func (r TransferRecord) String() string {
+	return fmt.Sprintf("%v: ep %s %s, %d bytes, %s after %v", r.Time, r.Endpoint, r.Direction(), len(r.Data), r.Status, r.Duration)
+}
+
+// TransferRecorder writes a TransferRecord of every transfer completed on
+// the endpoints of a Context, see WithTransferRecorder. The recording can
+// be replayed with a TransferReplay, e.g. to reproduce a bug seen in the
+// field against a simulated device. Control transfers are not recorded.
+type TransferRecorder struct {
+	start time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewTransferRecorder returns a recorder writing to w, see TransferRecord
+// for the format. Writes to w are serialized, but not buffered.
+func NewTransferRecorder(w io.Writer) *TransferRecorder {
+	return &TransferRecorder{start: time.Now(), enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing a record. Records are not written
+// after an error.
+func (r *TransferRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// record writes the record of a transfer submitted at submitted and
+// completed at completed with data and status st.
+func (r *TransferRecorder) record(ep EndpointAddress, submitted, completed time.Time, data []byte, st TransferStatus) {
+	if completed.IsZero() {
+		completed = time.Now()
+	}
+	rec := TransferRecord{
+		Time:     completed.Sub(r.start),
+		Duration: completed.Sub(submitted),
+		Endpoint: ep,
+		Status:   st,
+		Data:     append(HexBytes{}, data...),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.enc.Encode(rec); err != nil {
+		r.err = fmt.Errorf("failed to record transfer %s: %w", rec, err)
+	}
+}
+
+// packetData returns the data of the iso packets pkts, left in place in
+// buf, without gaps.
+func packetData(buf []byte, pkts []IsoPacket) []byte {
+	var ret []byte
+	off := 0
+	for _, p := range pkts {
+		ret = append(ret, buf[off:off+p.ActualLength]...)
+		off += p.Length
+	}
+	return ret
+}
+
+// WithTransferRecorder records the transfers of all the endpoints of the
+// Context with r. Recording copies the data of every transfer, it's meant
+// for debugging rather than for production.
+func WithTransferRecorder(r *TransferRecorder) ContextOption {
+	return func(o *contextOptions) {
+		o.recorder = r
+	}
+}
+
+// TransferReplay is a source of recorded transfers, read from the output
+// of a TransferRecorder. A Context created with WithTransferReplay, or a
+// simulated device, replays the recording by completing each transfer
+// submitted on an endpoint with the next record of the endpoint, see Next.
+type TransferReplay struct {
+	mu sync.Mutex
+	// records are the records not replayed yet, by endpoint, in order.
+	records map[EndpointAddress][]TransferRecord
+}
+
+// NewTransferReplay reads all the records of r.
+func NewTransferReplay(r io.Reader) (*TransferReplay, error) {
+	ret := &TransferReplay{records: make(map[EndpointAddress][]TransferRecord)}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<26)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var rec TransferRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("invalid transfer record on line %d: %w", line, err)
+		}
+		ret.records[rec.Endpoint] = append(ret.records[rec.Endpoint], rec)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transfer records: %w", err)
+	}
+	return ret, nil
+}
+
+// Next returns the next record of endpoint ep that was not replayed yet and
+// removes it from the replay. The records of different endpoints are
+// independent: transfers of different endpoints are not ordered relative
+// to each other on the bus. Next returns io.EOF after the last record of
+// the endpoint.
+func (r *TransferReplay) Next(ep EndpointAddress) (TransferRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs := r.records[ep]
+	if len(recs) == 0 {
+		return TransferRecord{}, io.EOF
+	}
+	r.records[ep] = recs[1:]
+	return recs[0], nil
+}
+
+// Len returns the number of records of all endpoints not replayed yet.
+func (r *TransferReplay) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, recs := range r.records {
+		n += len(recs)
+	}
+	return n
+}
+
+// WithTransferReplay completes the transfers of all the endpoints of the
+// Context with the records of r instead of submitting them to libusb. Each
+// submitted transfer completes right away with the status of the next
+// record of its endpoint: an IN transfer receives the recorded data, the
+// data sent by an OUT transfer must match the recorded data. A transfer
+// with no record left, or whose data differs from the recording, fails
+// with an error.
+// Only the endpoint transfers are replayed: devices are still opened and
+// configured, and control requests are still sent, through libusb. The
+// timing of the recording is not reproduced.
+func WithTransferReplay(r *TransferReplay) ContextOption {
+	return func(o *contextOptions) {
+		o.replay = r
+	}
+}
+
+// replay completes the transfer with the next record of its endpoint in r.
+func (t *usbTransfer) replay(r *TransferReplay) {
+	rec, err := r.Next(t.ep)
+	if err != nil {
+		t.finishQueued(fmt.Errorf("transfer on endpoint %s not in the recording: %w", t.ep, err))
+		return
+	}
+	n := len(rec.Data)
+	st := rec.Status
+	if rec.Direction() == EndpointDirectionOut {
+		if sent := t.buf[:t.length]; !bytes.Equal(sent, rec.Data) {
+			t.finishQueued(fmt.Errorf("data sent to endpoint %s differs from the recording: got %x, want %x", t.ep, sent, []byte(rec.Data)))
+			return
+		}
+	} else if n = copy(t.buf, rec.Data); n < len(rec.Data) {
+		st = TransferOverflow
+	}
+	t.replayed, t.replayN, t.replayStatus = true, n, st
+	t.finish()
+}
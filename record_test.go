@@ -0,0 +1,172 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordedSession sends a command to the 9999:0001 fake device and reads
+// its replies until the device stalls.
+func recordedSession(t *testing.T, ctx *Context) (replies []string, err error) {
+	t.Helper()
+	dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer done()
+	out, err := intf.OutEndpoint(1)
+	if err != nil {
+		t.Fatalf("%s.OutEndpoint(1): %v", intf, err)
+	}
+	in, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	if _, err := out.Write([]byte("ping")); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 512)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return replies, err
+		}
+		replies = append(replies, string(buf[:n]))
+	}
+}
+
+// outData returns the data of a submitted OUT transfer.
+func outData(ft *fakeTransfer) []byte {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	n := len(ft.buf)
+	if ft.maxLength < n {
+		n = ft.maxLength
+	}
+	return append([]byte{}, ft.buf[:n]...)
+}
+
+func TestTransferRecordReplay(t *testing.T) {
+	t.Parallel()
+	wantReplies := []string{"pong 1", "pong 2"}
+
+	// Record a session with a simulated device.
+	var recording bytes.Buffer
+	rec := NewTransferRecorder(&recording)
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib, WithTransferRecorder(rec))
+	done := make(chan struct{})
+	go func(lib *fakeLibusb, done <-chan struct{}) {
+		replies := 0
+		for {
+			ft := lib.waitForSubmitted(done)
+			if ft == nil {
+				return
+			}
+			switch {
+			case ft.ep.Direction == EndpointDirectionOut:
+				ft.setLength(len(outData(ft)))
+			case replies < len(wantReplies):
+				ft.setData([]byte(wantReplies[replies]))
+				replies++
+			default:
+				ft.setStatus(TransferStall)
+				continue
+			}
+			ft.setStatus(TransferCompleted)
+		}
+	}(lib, done)
+	replies, err := recordedSession(t, ctx)
+	close(done)
+	ctx.Close()
+	if !reflect.DeepEqual(replies, wantReplies) || !errors.Is(err, ErrStall) {
+		t.Fatalf("recorded session: got %q, %v, want %q, %v", replies, err, wantReplies, ErrStall)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatalf("TransferRecorder.Err(): %v", err)
+	}
+	if got := strings.Count(recording.String(), "\n"); got != 4 {
+		t.Errorf("recording:\n%s\ngot %d records, want 4", recording.String(), got)
+	}
+
+	// The recording is readable and keeps the transfers in order.
+	rp, err := NewTransferReplay(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatalf("NewTransferReplay(): %v", err)
+	}
+	if got := rp.Len(); got != 4 {
+		t.Errorf("Len(): got %d, want 4", got)
+	}
+	peek, err := rp.Next(0x01)
+	if err != nil {
+		t.Fatalf("Next(0x01): %v", err)
+	}
+	if peek.Status != TransferCompleted || string(peek.Data) != "ping" || peek.Direction() != EndpointDirectionOut || peek.Duration < 0 || peek.Time < peek.Duration {
+		t.Errorf("Next(0x01): got %s with data %q, want a completed OUT transfer of %q", peek, peek.Data, "ping")
+	}
+	if _, err := rp.Next(0x01); err != io.EOF {
+		t.Errorf("Next(0x01) after the last record: got %v, want %v", err, io.EOF)
+	}
+
+	// Replay the session, without the device: no transfer reaches libusb.
+	rp, err = NewTransferReplay(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatalf("NewTransferReplay(): %v", err)
+	}
+	lib = newFakeLibusb()
+	ctx = newContextWithImpl(lib, WithTransferReplay(rp))
+	defer ctx.Close()
+	replies, err = recordedSession(t, ctx)
+	if !reflect.DeepEqual(replies, wantReplies) || !errors.Is(err, ErrStall) {
+		t.Errorf("replayed session: got %q, %v, want %q, %v", replies, err, wantReplies, ErrStall)
+	}
+	if got := rp.Len(); got != 0 {
+		t.Errorf("Len() after the replay: got %d records left, want 0", got)
+	}
+	if !lib.empty() {
+		t.Error("replayed session: transfers were submitted to libusb")
+	}
+	// The recording is exhausted, the next session fails.
+	if _, err := recordedSession(t, ctx); !errors.Is(err, io.EOF) {
+		t.Errorf("session after the end of the replay: got error %v, want %v", err, io.EOF)
+	}
+
+	// Data sent that differs from the recording fails the transfer.
+	rp, err = NewTransferReplay(strings.NewReader("{\"endpoint\":1,\"data\":\"706f6e67\"}\n"))
+	if err != nil {
+		t.Fatalf("NewTransferReplay(): %v", err)
+	}
+	ctx2 := newContextWithImpl(newFakeLibusb(), WithTransferReplay(rp))
+	defer ctx2.Close()
+	if _, err := recordedSession(t, ctx2); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("session sending %q, recording of %q: got error %v, want a data mismatch", "ping", "pong", err)
+	}
+
+	if _, err := NewTransferReplay(strings.NewReader("{\"endpoint\":1}\nnot json\n")); err == nil {
+		t.Error("NewTransferReplay(invalid record): got nil error, want non-nil")
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// scheduler limits the number of transfers of a device in flight and
+// orders the submissions waiting for a free slot by priority. Submissions
+// never block: a transfer without a slot is queued and submitted to libusb
+// when another transfer releases its slot, see usbTransfer.schedule.
+type scheduler struct {
+	mu sync.Mutex
+	// limit is the maximum number of transfers in flight, 0 is unlimited.
+	limit    int
+	inFlight int
+	waiting  waitQueue
+	// seq orders the waiters with equal priority by arrival.
+	seq uint64
+}
+
+type waiter struct {
+	prio int
+	seq  uint64
+	// index is the position of the waiter in the queue, -1 once it left
+	// the queue.
+	index int
+	t     *usbTransfer
+}
+
+// waitQueue is a heap of waiters, highest priority first.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].prio != q[j].prio {
+		return q[i].prio > q[j].prio
+	}
+	return q[i].seq < q[j].seq
+}
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// acquire takes a slot for transfer t with priority prio and returns true
+// if one is free. Otherwise t is queued, acquire returns false and the slot
+// is handed to t later with t.granted. t.qmu must be held.
+func (s *scheduler) acquire(t *usbTransfer, prio int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.waiting.Len() == 0 && s.hasSlot() {
+		s.inFlight++
+		return true
+	}
+	w := &waiter{prio: prio, seq: s.seq, t: t}
+	s.seq++
+	heap.Push(&s.waiting, w)
+	t.queue, t.waiter = s, w
+	return false
+}
+
+// remove takes w out of the queue. It returns false if w already left the
+// queue with a slot.
+func (s *scheduler) remove(w *waiter) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.index < 0 {
+		return false
+	}
+	heap.Remove(&s.waiting, w.index)
+	return true
+}
+
+// release returns the slot of a finished or failed submission.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	s.inFlight--
+	ws := s.dispatch()
+	s.mu.Unlock()
+	s.grant(ws)
+}
+
+func (s *scheduler) setLimit(n int) {
+	s.mu.Lock()
+	s.limit = n
+	ws := s.dispatch()
+	s.mu.Unlock()
+	s.grant(ws)
+}
+
+// hasSlot returns true if another transfer can be put in flight. s.mu must
+// be held.
+func (s *scheduler) hasSlot() bool {
+	return s.limit == 0 || s.inFlight < s.limit
+}
+
+// dispatch takes the waiters that fit within the limit out of the queue, in
+// priority order, and returns them. Each of them holds a slot and must be
+// passed to grant. s.mu must be held.
+func (s *scheduler) dispatch() []*waiter {
+	var ws []*waiter
+	for s.waiting.Len() > 0 && s.hasSlot() {
+		ws = append(ws, heap.Pop(&s.waiting).(*waiter))
+		s.inFlight++
+	}
+	return ws
+}
+
+// grant hands the slots taken by dispatch to the queued transfers, which
+// are submitted to libusb once they hold all the slots they need. s.mu
+// must not be held, the submission acquires the locks of the transfer.
+func (s *scheduler) grant(ws []*waiter) {
+	for _, w := range ws {
+		w.t.granted(s)
+	}
+}
+
+// schedule submits the transfer through the scheduler of its device and
+// the global scheduler, see SetTransferScheduling and
+// SetMaxConcurrentTransfers. If a slot is free in both, the transfer is
+// submitted to libusb right away. Otherwise it is queued for the slot and
+// schedule returns without blocking: the transfer counts as submitted, and
+// it reaches libusb when a completing transfer releases its slot. Errors
+// of such a delayed submission are returned by wait. t.mu must be held.
+func (t *usbTransfer) schedule(global bool) error {
+	t.prio = 0
+	if t.sched != nil {
+		t.prio = t.priority()
+	}
+	t.needGlobal = global
+	atomic.StoreInt32(&t.cancelReq, 0)
+	t.submitted = true
+	atomic.StoreInt32(&t.inFlight, 1)
+	if !t.acquireSlots() {
+		return nil
+	}
+	if err := t.start(); err != nil {
+		t.submitted = false
+		t.notInFlight()
+		t.releaseSlots()
+		return err
+	}
+	if atomic.LoadInt32(&t.cancelReq) != 0 {
+		t.ctx.libusb.cancel(t.xfer)
+	}
+	return nil
+}
+
+// acquireSlots takes the slots the transfer still needs, first of the
+// device, then the global one. It returns false if the transfer was queued
+// for one of them.
+func (t *usbTransfer) acquireSlots() bool {
+	t.qmu.Lock()
+	defer t.qmu.Unlock()
+	if t.sched != nil && !t.devSlot {
+		if !t.sched.acquire(t, t.prio) {
+			return false
+		}
+		t.devSlot = true
+	}
+	if t.needGlobal && !t.globalSlot {
+		if !globalSched.acquire(t, t.prio) {
+			return false
+		}
+		t.globalSlot = true
+	}
+	return true
+}
+
+// granted is called when s hands a slot to the queued transfer. Once the
+// transfer holds all its slots, it's submitted to libusb. The owner of the
+// transfer may be blocked in wait, holding t.mu, granted doesn't acquire it.
+func (t *usbTransfer) granted(s *scheduler) {
+	t.qmu.Lock()
+	t.queue, t.waiter = nil, nil
+	if s == globalSched {
+		t.globalSlot = true
+	} else {
+		t.devSlot = true
+	}
+	t.qmu.Unlock()
+	if !t.acquireSlots() {
+		return
+	}
+	// abort can't cancel a transfer that left the queue but isn't known
+	// to libusb yet, it leaves cancelReq to be checked here instead.
+	if atomic.LoadInt32(&t.cancelReq) != 0 {
+		t.finishQueued(TransferCancelled)
+		return
+	}
+	if err := t.start(); err != nil {
+		t.finishQueued(err)
+		return
+	}
+	if atomic.LoadInt32(&t.cancelReq) != 0 {
+		t.ctx.libusb.cancel(t.xfer)
+	}
+}
+
+// dequeue takes the transfer out of the scheduler queue it's waiting in and
+// returns true, or returns false if it's not queued.
+func (t *usbTransfer) dequeue() bool {
+	t.qmu.Lock()
+	defer t.qmu.Unlock()
+	if t.queue == nil || !t.queue.remove(t.waiter) {
+		return false
+	}
+	t.queue, t.waiter = nil, nil
+	return true
+}
+
+// finishQueued completes a scheduled transfer that never reached libusb
+// with err, which is returned by wait.
+func (t *usbTransfer) finishQueued(err error) {
+	t.queueErr = err
+	t.finish()
+}
+
+// finish signals the completion of a transfer that didn't go through
+// libusb, which signals the others.
+func (t *usbTransfer) finish() {
+	t.done <- struct{}{}
+	t.onCompletion()
+}
+
+// SetTransferScheduling limits the number of transfers of the device in
+// flight at the same time to n, across all endpoints and streams. While n
+// transfers are in flight, further submissions don't block but are queued
+// until a slot is free: a transfer releases its slot when it completes and
+// is waited for. Queued transfers count as submitted, they can be waited
+// for and cancelled, and they are let through in the order of their
+// endpoint priorities, see SetPriority, so that e.g. a latency-sensitive
+// interrupt endpoint is not queued behind the transfers of a bulk stream.
+// Submissions of equal priority are let through in arrival order. A
+// stream can have more transfers than n, only n of them are then in
+// flight in libusb. n of 0, the default, disables the limit.
+//
+// Scheduling only affects the order in which gousb submits transfers to
+// libusb. The bus scheduling of the submitted transfers is still done by
+// libusb, the kernel and the host controller.
+func (d *Device) SetTransferScheduling(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid number of scheduled transfers %d, must be >= 0", n)
+	}
+	d.sched.setLimit(n)
+	return nil
+}
+
+// globalSched limits the number of transfers in flight across all
+// Contexts and devices, see SetMaxConcurrentTransfers. globalLimit mirrors
+// its limit, so that submissions skip it while it's disabled.
+var (
+	globalSched = &scheduler{}
+	globalLimit int32
+)
+
+// SetMaxConcurrentTransfers limits the number of transfers in flight at the
+// same time to n, across all Contexts, devices and endpoints of the
+// process. Each transfer in flight holds kernel memory for its URBs, on
+// memory constrained systems a limit keeps many devices or deep streams from
+// exhausting it. While n transfers are in flight, further submissions are
+// queued, without blocking, until one of the transfers completes and is
+// waited for, as with Device.SetTransferScheduling. n of 0, the default,
+// disables the limit. Transfers submitted while the limit was disabled
+// don't count towards a limit set later.
+//
+// The global limit applies in addition to the per-device limit of
+// Device.SetTransferScheduling, a transfer waits for a device slot first;
+// a transfer waiting for a device slot doesn't hold a global one.
+func SetMaxConcurrentTransfers(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid number of concurrent transfers %d, must be >= 0", n)
+	}
+	globalSched.setLimit(n)
+	atomic.StoreInt32(&globalLimit, int32(n))
+	return nil
+}
+
+// globalEnabled returns true if the global limit of
+// SetMaxConcurrentTransfers is enabled.
+func globalEnabled() bool {
+	return atomic.LoadInt32(&globalLimit) != 0
+}
+
+// SetPriority sets the scheduling priority of transfers submitted on the
+// endpoint, including the transfers of its streams. Higher values are
+// submitted first when transfer scheduling is enabled on the device, see
+// Device.SetTransferScheduling. The default priority is 0.
+func (e *endpoint) SetPriority(p int) {
+	atomic.StoreInt32(&e.prio, int32(p))
+}
+
+// priority returns the scheduling priority of the endpoint.
+func (e *endpoint) priority() int {
+	return int(atomic.LoadInt32(&e.prio))
+}
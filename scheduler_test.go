@@ -0,0 +1,330 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTransferScheduling(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	sched := &scheduler{}
+	sched.setLimit(1)
+	newEp := func(addr EndpointAddress, tt TransferType, prio int) *InEndpoint {
+		ep := &InEndpoint{&endpoint{ctx: ctx, sched: sched, Desc: EndpointDesc{
+			Address:       addr,
+			Number:        int(addr & endpointNumMask),
+			Direction:     EndpointDirectionIn,
+			MaxPacketSize: 512,
+			TransferType:  tt,
+		}}}
+		ep.SetPriority(prio)
+		return ep
+	}
+	bulk := newEp(0x81, TransferTypeBulk, 0)
+	intr := newEp(0x82, TransferTypeInterrupt, 10)
+
+	var xfers []*Transfer
+	byFake := make(map[*fakeTransfer]*Transfer)
+	for _, ep := range []*InEndpoint{bulk, bulk, intr} {
+		x, err := ep.NewTransfer(64)
+		if err != nil {
+			t.Fatalf("%s.NewTransfer(): %v", ep, err)
+		}
+		defer x.Free()
+		xfers = append(xfers, x)
+		lib.mu.Lock()
+		byFake[lib.ts[x.t.xfer]] = x
+		lib.mu.Unlock()
+	}
+
+	// The first bulk transfer takes the only slot.
+	if err := xfers[0].Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	// Queue a bulk transfer, then an interrupt transfer with a higher priority.
+	for _, x := range xfers[1:] {
+		go func(x *Transfer) {
+			if err := x.Submit(); err != nil {
+				t.Errorf("Submit(): %v", err)
+			}
+		}(x)
+		want := len(xfers) - 1
+		if x == xfers[1] {
+			want = 1
+		}
+		for {
+			sched.mu.Lock()
+			n := sched.waiting.Len()
+			sched.mu.Unlock()
+			if n == want {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var got []EndpointAddress
+	for range xfers {
+		ft := lib.waitForSubmitted(nil)
+		got = append(got, ft.ep.Address)
+		ft.setStatus(TransferCompleted)
+		x := byFake[ft]
+		if _, err := x.Wait(context.Background()); err != nil {
+			t.Errorf("Wait(): %v", err)
+		}
+	}
+	want := []EndpointAddress{0x81, 0x82, 0x81}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("submission order: got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestSchedulerUnlimited(t *testing.T) {
+	var s scheduler
+	for i := 0; i < 10; i++ {
+		if !s.acquire(nil, 0) {
+			t.Fatalf("acquire() #%d without a limit: got false, want true", i)
+		}
+	}
+	if s.inFlight != 10 {
+		t.Errorf("inFlight: got %d, want 10", s.inFlight)
+	}
+	for i := 0; i < 10; i++ {
+		s.release()
+	}
+}
+
+// TestMaxConcurrentTransfers changes the process-wide limit, so it must not
+// run in parallel with other tests.
+func TestMaxConcurrentTransfers(t *testing.T) {
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	if err := SetMaxConcurrentTransfers(-1); err == nil {
+		t.Error("SetMaxConcurrentTransfers(-1): got nil error, want an error")
+	}
+	if err := SetMaxConcurrentTransfers(2); err != nil {
+		t.Fatalf("SetMaxConcurrentTransfers(2): %v", err)
+	}
+	defer SetMaxConcurrentTransfers(0)
+
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	var xfers []*Transfer
+	byFake := make(map[*Transfer]*fakeTransfer)
+	for i := 0; i < 3; i++ {
+		x, err := ep.NewTransfer(64)
+		if err != nil {
+			t.Fatalf("NewTransfer(): %v", err)
+		}
+		defer x.Free()
+		xfers = append(xfers, x)
+		lib.mu.Lock()
+		byFake[x] = lib.ts[x.t.xfer]
+		lib.mu.Unlock()
+	}
+	for _, x := range xfers[:2] {
+		if err := x.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+	}
+	// The third transfer is queued without blocking Submit, it reaches
+	// libusb once one of the others completes and is waited for.
+	if err := xfers[2].Submit(); err != nil {
+		t.Fatalf("third Submit(): %v", err)
+	}
+	if !xfers[2].InFlight() {
+		t.Error("InFlight() of the queued transfer: got false, want true")
+	}
+	for i := 0; i < 2; i++ {
+		lib.waitForSubmitted(nil)
+	}
+	select {
+	case <-lib.submitted:
+		t.Fatal("third transfer submitted to libusb with 2 transfers in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	for _, x := range xfers {
+		ft := byFake[x]
+		if x == xfers[2] {
+			if got := lib.waitForSubmitted(nil); got != ft {
+				t.Fatal("the transfer submitted to libusb after a slot was released is not the queued one")
+			}
+		}
+		ft.setStatus(TransferCompleted)
+		if _, err := x.Wait(context.Background()); err != nil {
+			t.Errorf("Wait(): %v", err)
+		}
+	}
+	// Streams deeper than the limit queue the transfers above it.
+	testStreamAboveLimit(t, lib, ep, 2)
+	globalSched.mu.Lock()
+	n := globalSched.inFlight
+	globalSched.mu.Unlock()
+	if n != 0 {
+		t.Errorf("transfers holding a global slot after all completed: %d, want 0", n)
+	}
+}
+
+// testStreamAboveLimit reads from a stream on ep with more transfers than
+// the limit of transfers in flight. Only limit transfers reach libusb at a
+// time, the others wait in the scheduler queue, and the data is read in
+// order.
+func testStreamAboveLimit(t *testing.T, lib *fakeLibusb, ep *InEndpoint, limit int) {
+	t.Helper()
+	count := limit + 2
+	type result struct {
+		s   *ReadStream
+		err error
+	}
+	created := make(chan result)
+	go func() {
+		s, err := ep.NewStream(64, count)
+		created <- result{s, err}
+	}()
+	var s *ReadStream
+	select {
+	case r := <-created:
+		if r.err != nil {
+			t.Fatalf("NewStream(64, %d): %v", count, r.err)
+		}
+		s = r.s
+	case <-time.After(5 * time.Second):
+		t.Fatalf("NewStream(64, %d) with a limit of %d transfers in flight did not return", count, limit)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := len(lib.submitted); got != limit {
+		t.Errorf("transfers of a new stream submitted to libusb: got %d, want %d", got, limit)
+	}
+	buf := make([]byte, 64)
+	for i := 0; i < 2*count; i++ {
+		ft := lib.waitForSubmitted(nil)
+		ft.setData([]byte{byte(i)})
+		ft.setStatus(TransferCompleted)
+		if n, err := s.Read(buf); n != 1 || err != nil || buf[0] != byte(i) {
+			t.Fatalf("Read() #%d: got %d, %v, data %v, want 1, nil, data [%d]", i, n, err, buf[:n], i)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("ReadStream.Close(): %v", err)
+	}
+	// The transfers in flight and in the queue are read until io.EOF.
+	done := make(chan struct{})
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for ft := lib.waitForSubmitted(done); ft != nil; ft = lib.waitForSubmitted(done) {
+			ft.setStatus(TransferCompleted)
+		}
+	}()
+	for {
+		if _, err := s.Read(buf); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Read() after Close(): %v, want data or io.EOF", err)
+		}
+	}
+	close(done)
+	<-drained
+}
+
+func TestTransferSchedulingStream(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	sched := &scheduler{}
+	sched.setLimit(1)
+	e := newNullEndpoint(ctx, EndpointDirectionIn)
+	e.sched = sched
+	testStreamAboveLimit(t, lib, &InEndpoint{e}, 1)
+	sched.mu.Lock()
+	defer sched.mu.Unlock()
+	if sched.inFlight != 0 || sched.waiting.Len() != 0 {
+		t.Errorf("scheduler after the stream was closed: %d transfers holding a slot, %d queued, want 0, 0", sched.inFlight, sched.waiting.Len())
+	}
+}
+
+func TestScheduledTransferCancel(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	sched := &scheduler{}
+	sched.setLimit(1)
+	e := newNullEndpoint(ctx, EndpointDirectionIn)
+	e.sched = sched
+	ep := &InEndpoint{e}
+	var xfers []*Transfer
+	for i := 0; i < 2; i++ {
+		x, err := ep.NewTransfer(64)
+		if err != nil {
+			t.Fatalf("NewTransfer(): %v", err)
+		}
+		defer x.Free()
+		if err := x.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+		xfers = append(xfers, x)
+	}
+	first := lib.waitForSubmitted(nil)
+
+	// Cancelling the queued transfer completes it without reaching libusb.
+	cctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := xfers[1].Wait(cctx); !errors.Is(err, TransferCancelled) {
+		t.Errorf("Wait() of a cancelled queued transfer: got error %v, want %v", err, TransferCancelled)
+	}
+	first.setStatus(TransferCompleted)
+	if _, err := xfers[0].Wait(context.Background()); err != nil {
+		t.Errorf("Wait(): %v", err)
+	}
+	select {
+	case <-lib.submitted:
+		t.Error("cancelled queued transfer was submitted to libusb")
+	case <-time.After(20 * time.Millisecond):
+	}
+	sched.mu.Lock()
+	if sched.inFlight != 0 || sched.waiting.Len() != 0 {
+		t.Errorf("scheduler after all transfers finished: %d transfers holding a slot, %d queued, want 0, 0", sched.inFlight, sched.waiting.Len())
+	}
+	sched.mu.Unlock()
+
+	// The cancelled transfer can be submitted again.
+	if err := xfers[1].Submit(); err != nil {
+		t.Fatalf("Submit() after cancellation: %v", err)
+	}
+	ft := lib.waitForSubmitted(nil)
+	ft.setData([]byte{1})
+	ft.setStatus(TransferCompleted)
+	if n, err := xfers[1].Wait(context.Background()); n != 1 || err != nil {
+		t.Errorf("Wait() after resubmission: got %d, %v, want 1, nil", n, err)
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// StreamOption configures a stream created by InEndpoint.NewStream or
+// OutEndpoint.NewStream.
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	// dedicatedThread is true if the transfers should be submitted and
+	// waited for on a dedicated OS thread.
+	dedicatedThread bool
+	// threadSetup is called on the dedicated thread before any transfer
+	// operations.
+	threadSetup func()
+	// contiguousBuffers is true if the buffers of the initial transfers
+	// should be allocated as a single block.
+	contiguousBuffers bool
+	// bufferProvider, if not nil, supplies the buffers of all transfers.
+	bufferProvider BufferProvider
+	// drain is the longest time spent discarding stale data before a read
+	// stream starts, 0 if the data is not drained.
+	drain time.Duration
+	// zeroBuffers is true if the transfer buffers are cleared whenever
+	// they are reused or released.
+	zeroBuffers bool
+	// ctx, if not nil, bounds the lifetime of the stream transfers.
+	ctx context.Context
+	// lowWater is the minimum number of transfers queued on the endpoint
+	// of a write stream, 0 if not monitored. onLowWater is called when the
+	// queue drops below it.
+	lowWater   int
+	onLowWater func(queued int) error
+}
+
+func newStreamOptions(opts []StreamOption) streamOptions {
+	var ret streamOptions
+	for _, o := range opts {
+		o(&ret)
+	}
+	return ret
+}
+
+// WithDedicatedThread makes the stream submit and wait for all of its
+// transfers from a single goroutine locked to its own OS thread, see
+// runtime.LockOSThread. This reduces the jitter of resubmitting transfers
+// when the process is busy, which matters for isochronous streams, e.g.
+// real-time audio, where a late resubmission causes an underrun.
+//
+// The tradeoffs: every transfer operation of the stream is handed over to
+// the dedicated goroutine and back, which adds a small, constant latency
+// to each Read or Write. The stream holds an OS thread until all of its
+// transfers are released, i.e. until the stream is closed and, for read
+// streams, read until the end. The Go scheduler does not prioritize the
+// dedicated goroutine, the benefit comes mostly from not sharing the thread
+// with other goroutines and from raising the priority of the thread, see
+// WithThreadSetup.
+func WithDedicatedThread() StreamOption {
+	return func(o *streamOptions) {
+		o.dedicatedThread = true
+	}
+}
+
+// WithThreadSetup implies WithDedicatedThread and calls fn on the
+// dedicated thread before the stream starts. fn can be used to raise the
+// scheduling priority of the thread, e.g. with unix.Setpriority or
+// sched_setscheduler on Linux. The thread is terminated when the stream
+// releases it, a thread with modified scheduling parameters is never
+// reused for other goroutines.
+func WithThreadSetup(fn func()) StreamOption {
+	return func(o *streamOptions) {
+		o.dedicatedThread = true
+		o.threadSetup = fn
+	}
+}
+
+// WithContiguousBuffers allocates the buffers of all transfers of the
+// stream as a single block of count*size bytes, each transfer using its
+// own part of the block, instead of allocating the buffers one by one.
+// This improves memory locality and reduces fragmentation for streams
+// with many transfers. Like all transfer buffers, the block is allocated
+// outside of the Go heap, so it never moves while libusb uses it.
+// The block is freed once all of its transfers are released. Transfers
+// added beyond the initial count, e.g. by SetDepth, get their own buffers.
+func WithContiguousBuffers() StreamOption {
+	return func(o *streamOptions) {
+		o.contiguousBuffers = true
+	}
+}
+
+// WithDrain makes a read stream discard the data queued by the device
+// before the stream starts, e.g. bytes buffered by a serial adapter while
+// nobody was reading. Before submitting its transfers, the stream reads
+// from the endpoint with short timeouts and drops the data until a read
+// returns no data or times out, or until timeout elapses. The stream then
+// delivers only the data that arrived after the drain. An error other than
+// a timeout while draining is returned by NewStream.
+// WithDrain has no effect on write streams.
+func WithDrain(timeout time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.drain = timeout
+	}
+}
+
+// WithZeroBuffers clears the transfer buffers of the stream whenever they
+// are about to be reused or released, so that the data of one transfer is
+// never visible past its own lifetime. Stream buffers are reused for many
+// transfers, and without clearing, the bytes of an earlier transfer stay
+// in the buffer: a consumer handed a buffer by Results or NextBuffer could
+// read them past the end of the current data, and buffers released to a
+// BufferProvider, or freed at Close, keep sensitive data in memory where
+// unrelated code may later obtain it. WithZeroBuffers addresses only these
+// leaks between users of the same process memory, it doesn't protect the
+// data from the kernel, libusb or the device.
+// The buffers of read streams are cleared before each submission and the
+// bytes past the data read are cleared after each completion. The buffers
+// of write streams are cleared as soon as their transfer completes. All
+// buffers are cleared before they are freed. Clearing costs a pass over
+// every buffer for each transfer, so it's disabled by default.
+func WithZeroBuffers() StreamOption {
+	return func(o *streamOptions) {
+		o.zeroBuffers = true
+	}
+}
+
+// WithStreamContext bounds the stream by ctx. Each transfer submitted
+// while ctx has a deadline gets a timeout no longer than the time left
+// until the deadline, so that no transfer blocks past it. Once ctx is
+// done, transfers in flight are cancelled and no more transfers are
+// submitted: the stream terminates with an error wrapping ctx.Err(), e.g.
+// context.DeadlineExceeded, which can be checked with errors.Is. A
+// transfer that times out at the deadline is reported the same way.
+// Unlike the context passed to ReadContext, which applies to a single
+// Read, ctx covers the whole streaming session, which makes it suitable
+// for captures of a bounded duration.
+func WithStreamContext(ctx context.Context) StreamOption {
+	return func(o *streamOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithLowWaterMark makes a write stream check, after each transfer it
+// submits, that at least n transfers are queued on the endpoint, see
+// WriteStream.QueueDepth. Isochronous transfers submitted too late miss
+// their scheduled (micro)frame, the queued transfers are the lead time
+// that absorbs the delays of the application producing the data. When the
+// queue drops below n, fn is called with the number of queued transfers,
+// e.g. to log a warning before the stream underruns. If fn returns an
+// error, the stream stops accepting data: the Write or SubmitBuffer call
+// returns the error, as does Close, after the transfers already queued
+// complete. The check starts once n transfers were queued for the first
+// time, so that the stream can fill up after NewStream.
+// n must not exceed the number of transfers of the stream, fn is called
+// from the goroutine writing to the stream. WithLowWaterMark has no effect
+// on read streams, with n <= 0 or with a nil fn.
+func WithLowWaterMark(n int, fn func(queued int) error) StreamOption {
+	return func(o *streamOptions) {
+		if n <= 0 || fn == nil {
+			o.lowWater, o.onLowWater = 0, nil
+			return
+		}
+		o.lowWater = n
+		o.onLowWater = fn
+	}
+}
+
+// minDeadlineTimeout is the shortest timeout given to a transfer bounded
+// by a deadline, libusb treats a timeout of 0 as no timeout at all.
+const minDeadlineTimeout = time.Millisecond
+
+// streamContext cancels the transfers of a stream in flight once the
+// context of the stream is done, see WithStreamContext. A single goroutine
+// watches the context for all transfers of the stream, it runs while the
+// stream has transfers that were not freed.
+type streamContext struct {
+	ctx context.Context
+	// mu protects ts and stop.
+	mu sync.Mutex
+	// ts are the transfers of the stream that were not freed yet.
+	ts map[*usbTransfer]bool
+	// stop is closed to end the watch when the last transfer is freed.
+	stop chan struct{}
+}
+
+func newStreamContext(ctx context.Context) *streamContext {
+	return &streamContext{ctx: ctx, ts: make(map[*usbTransfer]bool)}
+}
+
+// add starts watching the context for t.
+func (s *streamContext) add(t *usbTransfer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ts) == 0 && s.ctx.Done() != nil {
+		s.stop = make(chan struct{})
+		go s.watch(s.stop)
+	}
+	s.ts[t] = true
+}
+
+// remove stops watching the context for t, which is about to be freed.
+func (s *streamContext) remove(t *usbTransfer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ts[t] {
+		return
+	}
+	delete(s.ts, t)
+	if len(s.ts) == 0 && s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+func (s *streamContext) watch(stop chan struct{}) {
+	select {
+	case <-s.ctx.Done():
+	case <-stop:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the transfers can't be freed until mu is released.
+	for t := range s.ts {
+		t.cancel()
+	}
+}
+
+// contextTransfer is a stream transfer bounded by a context, see
+// WithStreamContext.
+type contextTransfer struct {
+	transferIntf
+	t   *usbTransfer
+	ctx context.Context
+	// sc cancels the transfer when ctx is done.
+	sc *streamContext
+	// timeout is the timeout of the transfer before it was bounded by
+	// the deadline of ctx, 0 for no timeout.
+	timeout time.Duration
+	// bounded is true if the timeout of the submitted transfer was
+	// shortened to expire at the deadline of ctx.
+	bounded bool
+}
+
+func newContextTransfer(sc *streamContext, t *usbTransfer) *contextTransfer {
+	sc.add(t)
+	return &contextTransfer{transferIntf: t, t: t, ctx: sc.ctx, sc: sc, timeout: t.timeout()}
+}
+
+// ctxErr returns the error reported when ctx is done.
+func (t *contextTransfer) ctxErr(err error) error {
+	return fmt.Errorf("stream transfer on endpoint %s: %w", t.t.ep, err)
+}
+
+func (t *contextTransfer) submit() error {
+	if err := t.ctx.Err(); err != nil {
+		return t.ctxErr(err)
+	}
+	t.bounded = false
+	d := t.timeout
+	if dl, ok := t.ctx.Deadline(); ok {
+		left := time.Until(dl)
+		if left < minDeadlineTimeout {
+			left = minDeadlineTimeout
+		}
+		if d == 0 || left < d {
+			d = left
+			t.bounded = true
+		}
+	}
+	if err := t.t.setTimeout(d); err != nil {
+		return err
+	}
+	if err := t.transferIntf.submit(); err != nil {
+		return err
+	}
+	if t.ctx.Err() != nil {
+		// ctx was done before the transfer was in flight, the watcher of
+		// the stream didn't cancel it.
+		t.t.cancel()
+	}
+	return nil
+}
+
+func (t *contextTransfer) wait(ctx context.Context) (int, error) {
+	n, err := t.transferIntf.wait(ctx)
+	if err == nil {
+		return n, nil
+	}
+	if cerr := t.ctx.Err(); cerr != nil {
+		return n, t.ctxErr(cerr)
+	}
+	if t.bounded && errors.Is(err, TransferTimedOut) {
+		// libusb timeouts have a millisecond resolution, the transfer
+		// may expire slightly before ctx does.
+		return n, t.ctxErr(context.DeadlineExceeded)
+	}
+	return n, err
+}
+
+func (t *contextTransfer) free() error {
+	t.sc.remove(t.t)
+	return t.transferIntf.free()
+}
+
+// zeroingTransfer is a stream transfer that clears its buffer, see
+// WithZeroBuffers.
+type zeroingTransfer struct {
+	transferIntf
+	// in is true for transfers of IN endpoints.
+	in bool
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func (t *zeroingTransfer) submit() error {
+	if t.in {
+		zero(t.data())
+	}
+	return t.transferIntf.submit()
+}
+
+func (t *zeroingTransfer) wait(ctx context.Context) (int, error) {
+	n, err := t.transferIntf.wait(ctx)
+	buf := t.data()
+	if !t.in {
+		zero(buf)
+	} else if n < len(buf) {
+		zero(buf[n:])
+	}
+	return n, err
+}
+
+func (t *zeroingTransfer) free() error {
+	// A transfer in flight can't be freed, its buffer is owned by libusb.
+	if t.completed() {
+		zero(t.data())
+	}
+	return t.transferIntf.free()
+}
+
+// osThread runs functions on a goroutine locked to an OS thread.
+type osThread struct {
+	ops chan func()
+	// exited is closed when the goroutine exits.
+	exited chan struct{}
+
+	mu sync.Mutex
+	// users is the number of transfers that use the thread.
+	users int
+}
+
+// newOSThread starts a new thread. The thread runs until all the
+// transfers wrapped with wrap are freed.
+func newOSThread(setup func()) *osThread {
+	t := &osThread{
+		ops:    make(chan func()),
+		exited: make(chan struct{}),
+	}
+	ready := make(chan struct{})
+	go func() {
+		defer close(t.exited)
+		// The goroutine exits without unlocking, which terminates the
+		// thread, since setup might have modified it.
+		runtime.LockOSThread()
+		if setup != nil {
+			setup()
+		}
+		close(ready)
+		for op := range t.ops {
+			op()
+		}
+	}()
+	<-ready
+	return t
+}
+
+// run calls f on the thread and waits for it to return.
+func (t *osThread) run(f func()) {
+	done := make(chan struct{})
+	t.ops <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+// wrap returns a transfer that runs its operations on the thread and
+// keeps the thread alive until it's freed.
+func (t *osThread) wrap(xfer transferIntf) transferIntf {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.users++
+	return &threadTransfer{transferIntf: xfer, thread: t}
+}
+
+// release signals that one of the users no longer needs the thread. After
+// the last one is released, the thread exits.
+func (t *osThread) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.users--
+	if t.users == 0 {
+		close(t.ops)
+	}
+}
+
+// threadTransfer is a transfer whose submit, wait and free operations run
+// on an osThread. cancel is not routed through the thread, as it may be
+// needed to abort a wait that blocks the thread.
+type threadTransfer struct {
+	transferIntf
+	thread   *osThread
+	released bool
+}
+
+func (t *threadTransfer) submit() (err error) {
+	t.thread.run(func() { err = t.transferIntf.submit() })
+	return err
+}
+
+func (t *threadTransfer) wait(ctx context.Context) (n int, err error) {
+	t.thread.run(func() { n, err = t.transferIntf.wait(ctx) })
+	return n, err
+}
+
+func (t *threadTransfer) free() (err error) {
+	if t.released {
+		return t.transferIntf.free()
+	}
+	t.thread.run(func() { err = t.transferIntf.free() })
+	if err == nil {
+		t.released = true
+		t.thread.release()
+	}
+	return err
+}
@@ -0,0 +1,528 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newIsoInEndpoint(ctx *Context) *InEndpoint {
+	return &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 192,
+		TransferType:  TransferTypeIsochronous,
+		PollInterval:  time.Millisecond,
+	}}}
+}
+
+// completeAll completes all submitted transfers with full buffers,
+// until done is closed.
+func completeAll(lib *fakeLibusb, done <-chan struct{}) {
+	for {
+		ft := lib.waitForSubmitted(done)
+		if ft == nil {
+			return
+		}
+		ft.setData(make([]byte, len(ft.buf)))
+		ft.setStatus(TransferCompleted)
+	}
+}
+
+func TestStreamWithDedicatedThread(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go completeAll(lib, done)
+
+	var mu sync.Mutex
+	setupCalls := 0
+	stream, err := newIsoInEndpoint(ctx).NewStream(4*192, 3, WithThreadSetup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		setupCalls++
+	}))
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	transfers := stream.s.transfers
+	buf := make([]byte, 4*192)
+	for i := 0; i < 10; i++ {
+		if n, err := stream.Read(buf); err != nil || n != len(buf) {
+			t.Fatalf("stream.Read(): got %d, %v, want %d, nil", n, err, len(buf))
+		}
+	}
+	tt := <-transfers
+	transfers <- tt
+	thread := tt.(*trackedTransfer).transferIntf.(*threadTransfer).thread
+	stream.Close()
+	for {
+		if _, err := stream.Read(buf); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("stream.Read() after Close: %v, want data or io.EOF", err)
+		}
+	}
+	select {
+	case <-thread.exited:
+	case <-time.After(time.Second):
+		t.Error("dedicated thread still running after all the transfers of the stream were released")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if setupCalls != 1 {
+		t.Errorf("thread setup calls: got %d, want 1", setupCalls)
+	}
+}
+
+func TestStreamWithContiguousBuffers(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	const size, count = 4 * 192, 3
+	stream, err := newIsoInEndpoint(ctx).NewStream(size, count, WithContiguousBuffers())
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	var fts []*fakeTransfer
+	var bufs [][]byte
+	for i := 0; i < count; i++ {
+		ft := lib.waitForSubmitted(nil)
+		fts = append(fts, ft)
+		bufs = append(bufs, ft.buf)
+	}
+	lib.mu.Lock()
+	if lib.buffers != 1 {
+		t.Errorf("allocated buffers: got %d, want 1", lib.buffers)
+	}
+	lib.mu.Unlock()
+	// All transfer buffers are parts of one block, in order.
+	base := &bufs[0][:cap(bufs[0])][0]
+	for i, b := range bufs {
+		if len(b) != size {
+			t.Errorf("transfer #%d: buffer of %d bytes, want %d", i, len(b), size)
+		}
+		if got, want := &b[0], &bufs[0][:cap(bufs[0])][i*size]; got != want {
+			t.Errorf("transfer #%d: buffer starts at %p, want %p (%d bytes after %p)", i, got, want, i*size, base)
+		}
+	}
+	stream.Close()
+	for _, ft := range fts {
+		ft.setStatus(TransferCancelled)
+	}
+	for {
+		if _, err := stream.Read(make([]byte, size)); err != nil {
+			break
+		}
+	}
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+	if lib.buffers != 0 {
+		t.Errorf("buffers after the stream was closed: got %d, want 0", lib.buffers)
+	}
+}
+
+// BenchmarkStreamJitter measures the variation of the time between
+// subsequent reads of an isochronous read stream while the process is busy
+// with other goroutines, with and without a dedicated thread.
+func BenchmarkStreamJitter(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []StreamOption
+	}{
+		{"default", nil},
+		{"dedicated_thread", []StreamOption{WithDedicatedThread()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			lib := newFakeLibusb()
+			ctx := newContextWithImpl(lib)
+			defer ctx.Close()
+			done := make(chan struct{})
+			defer close(done)
+			go completeAll(lib, done)
+			// background load
+			for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+				go func() {
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						for j := 0; j < 1000; j++ {
+							_ = math.Sqrt(float64(j))
+						}
+					}
+				}()
+			}
+
+			stream, err := newIsoInEndpoint(ctx).NewStream(4*192, 3, bc.opts...)
+			if err != nil {
+				b.Fatalf("NewStream(): %v", err)
+			}
+			buf := make([]byte, 4*192)
+			var sum, sumSq float64
+			b.ResetTimer()
+			last := time.Now()
+			for i := 0; i < b.N; i++ {
+				if _, err := stream.Read(buf); err != nil {
+					b.Fatalf("stream.Read(): %v", err)
+				}
+				now := time.Now()
+				d := float64(now.Sub(last))
+				last = now
+				sum += d
+				sumSq += d * d
+			}
+			b.StopTimer()
+			mean := sum / float64(b.N)
+			b.ReportMetric(math.Sqrt(math.Max(sumSq/float64(b.N)-mean*mean, 0)), "ns-jitter")
+			stream.Close()
+			for {
+				if _, err := stream.Read(buf); err != nil {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestStreamZeroBuffers(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+
+	allZero := func(b []byte) bool {
+		for _, v := range b {
+			if v != 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Read stream: the reused buffer is cleared before the next submission.
+	in := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	rs, err := in.NewStream(512, 1, WithZeroBuffers())
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	ft := lib.waitForSubmitted(nil)
+	ft.setData(bytes.Repeat([]byte{0xaa}, 512))
+	ft.setStatus(TransferCompleted)
+	res := <-rs.Results()
+	if !bytes.Equal(res.Data, bytes.Repeat([]byte{0xaa}, 512)) {
+		t.Fatalf("first result: got %x..., want aa...", res.Data[:4])
+	}
+	if err := rs.Release(res.Data); err != nil {
+		t.Fatalf("Release(): %v", err)
+	}
+	ft = lib.waitForSubmitted(nil)
+	ft.mu.Lock()
+	if !allZero(ft.buf) {
+		t.Errorf("buffer of a resubmitted read transfer: got %x..., want zeroes", ft.buf[:4])
+	}
+	ft.mu.Unlock()
+	// A short read leaves no stale bytes past its data.
+	ft.setData([]byte{1, 2, 3})
+	ft.setStatus(TransferCompleted)
+	res = <-rs.Results()
+	if full := res.Data[:cap(res.Data)]; !bytes.Equal(full[:3], []byte{1, 2, 3}) || !allZero(full[3:]) {
+		t.Errorf("buffer of a short read: got %x..., want 010203 followed by zeroes", full[:8])
+	}
+	rs.Close()
+	rs.Release(res.Data)
+
+	// Write stream: the buffer is cleared once its transfer completes.
+	out := &OutEndpoint{newNullEndpoint(ctx, EndpointDirectionOut)}
+	ws, err := out.NewStream(512, 1, WithZeroBuffers())
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	buf, err := ws.NextBuffer(context.Background())
+	if err != nil {
+		t.Fatalf("NextBuffer(): %v", err)
+	}
+	for i := range buf {
+		buf[i] = 0xbb
+	}
+	if err := ws.SubmitBuffer(buf); err != nil {
+		t.Fatalf("SubmitBuffer(): %v", err)
+	}
+	ft = lib.waitForSubmitted(nil)
+	ft.mu.Lock()
+	if !bytes.Equal(ft.buf, bytes.Repeat([]byte{0xbb}, 512)) {
+		t.Errorf("data of the submitted write transfer: got %x..., want bb...", ft.buf[:4])
+	}
+	ft.mu.Unlock()
+	ft.setLength(512)
+	ft.setStatus(TransferCompleted)
+	buf, err = ws.NextBuffer(context.Background())
+	if err != nil {
+		t.Fatalf("NextBuffer(): %v", err)
+	}
+	if !allZero(buf) {
+		t.Errorf("reused write buffer: got %x..., want zeroes", buf[:4])
+	}
+	if err := ws.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+}
+
+func TestStreamWithStreamContext(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+
+	const session = 200 * time.Millisecond
+	sctx, cancel := context.WithTimeout(context.Background(), session)
+	defer cancel()
+	in := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	rs, err := in.NewStream(512, 1, WithStreamContext(sctx))
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	defer rs.Close()
+	ft := lib.waitForSubmitted(nil)
+	ft.mu.Lock()
+	timeout := ft.timeout
+	ft.mu.Unlock()
+	if timeout <= 0 || timeout > session {
+		t.Errorf("timeout of the first transfer: got %v, want in (0, %v]", timeout, session)
+	}
+	ft.setData(make([]byte, 512))
+	ft.setStatus(TransferCompleted)
+	buf := make([]byte, 512)
+	if n, err := rs.Read(buf); err != nil || n != len(buf) {
+		t.Fatalf("Read(): got %d, %v, want %d, nil", n, err, len(buf))
+	}
+
+	// The resubmitted transfer never completes, the deadline cuts the
+	// stream short.
+	ft = lib.waitForSubmitted(nil)
+	ft.mu.Lock()
+	left := ft.timeout
+	ft.mu.Unlock()
+	if left <= 0 || left > timeout {
+		t.Errorf("timeout of the resubmitted transfer: got %v, want in (0, %v]", left, timeout)
+	}
+	start := time.Now()
+	_, err = rs.Read(buf)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Read() past the deadline: got %v, want an error wrapping context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > session+time.Second {
+		t.Errorf("Read() returned %v after the deadline, want no later than the deadline", d)
+	}
+	if _, err := rs.Read(buf); err == nil {
+		t.Errorf("Read() after the stream was cut short: got nil error, want error")
+	}
+
+	// A stream started past its deadline doesn't submit its transfers.
+	rs, err = in.NewStream(512, 2, WithStreamContext(sctx))
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	defer rs.Close()
+	if _, err := rs.Read(buf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Read() of a stream started past its deadline: got %v, want an error wrapping context.DeadlineExceeded", err)
+	}
+}
+
+func TestStreamContextCancel(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+
+	sctx, cancel := context.WithCancel(context.Background())
+	in := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+	rs, err := in.NewStream(512, 2, WithStreamContext(sctx))
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	defer rs.Close()
+	for i := 0; i < 2; i++ {
+		ft := lib.waitForSubmitted(nil)
+		ft.mu.Lock()
+		timeout := ft.timeout
+		ft.mu.Unlock()
+		if timeout != 0 {
+			t.Errorf("timeout of a transfer without a deadline: got %v, want 0", timeout)
+		}
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := rs.Read(make([]byte, 512)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Read() after the stream context was cancelled: got %v, want an error wrapping context.Canceled", err)
+	}
+}
+
+func TestStreamContextWatch(t *testing.T) {
+	t.Parallel()
+	sctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc := newStreamContext(sctx)
+	t1, t2 := &usbTransfer{}, &usbTransfer{}
+	sc.add(t1)
+	sc.add(t2)
+	sc.mu.Lock()
+	stop := sc.stop
+	sc.mu.Unlock()
+	if stop == nil {
+		t.Fatal("no watcher after the transfers were added")
+	}
+	sc.remove(t1)
+	select {
+	case <-stop:
+		t.Error("watcher stopped with a transfer left")
+	default:
+	}
+	sc.remove(t2)
+	select {
+	case <-stop:
+	default:
+		t.Error("watcher still running after the last transfer was freed")
+	}
+	// The watch restarts for a transfer added later.
+	sc.add(t1)
+	sc.mu.Lock()
+	restarted := sc.stop != nil
+	sc.mu.Unlock()
+	if !restarted {
+		t.Error("no watcher after a transfer was added again")
+	}
+	sc.remove(t1)
+}
+
+func TestStreamLowWaterMark(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+
+	const pktSize = 192
+	ep := &OutEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x01,
+		Number:        1,
+		Direction:     EndpointDirectionOut,
+		MaxPacketSize: pktSize,
+		TransferType:  TransferTypeIsochronous,
+		PollInterval:  time.Millisecond,
+	}}}
+	noop := func(int) error { return nil }
+	if _, err := ep.NewStream(pktSize, 2, WithLowWaterMark(3, noop)); err == nil {
+		t.Errorf("NewStream() with a low-water mark above the stream depth: got nil error, want non-nil")
+	}
+
+	errLow := errors.New("playback can't keep up")
+	var warnings []int
+	ws, err := ep.NewStream(pktSize, 4, WithLowWaterMark(3, func(queued int) error {
+		warnings = append(warnings, queued)
+		if queued < 2 {
+			return errLow
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	chunk := make([]byte, pktSize)
+	var queued []*fakeTransfer
+	write := func() error {
+		_, err := ws.Write(chunk)
+		if ft := lib.waitForSubmitted(nil); ft != nil {
+			queued = append(queued, ft)
+		}
+		return err
+	}
+	// consume completes the n oldest queued transfers, as the device plays
+	// their data while the application is busy.
+	consume := func(n int) {
+		for _, ft := range queued[:n] {
+			ft.setLength(pktSize)
+			ft.setStatus(TransferCompleted)
+		}
+		queued = queued[n:]
+	}
+
+	// Filling up the stream doesn't trigger the warning.
+	for i := 0; i < 4; i++ {
+		if err := write(); err != nil {
+			t.Fatalf("Write() #%d: %v", i, err)
+		}
+	}
+	if len(warnings) != 0 {
+		t.Errorf("low-water warnings while priming the stream: got %v, want none", warnings)
+	}
+	if got := ws.QueueDepth(); got != 4 {
+		t.Errorf("QueueDepth() of a full stream: got %d, want 4", got)
+	}
+
+	// The application is slow, the device plays 3 transfers before the
+	// next write.
+	consume(3)
+	if got := ws.QueueDepth(); got != 1 {
+		t.Errorf("QueueDepth() after the device consumed the data: got %d, want 1", got)
+	}
+	if err := write(); err != nil {
+		t.Fatalf("Write() below the low-water mark: %v", err)
+	}
+	if want := []int{2}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("low-water warnings: got %v, want %v", warnings, want)
+	}
+
+	// Falling further behind is fatal.
+	consume(2)
+	if err := write(); err != errLow {
+		t.Errorf("Write() with a single transfer queued: got error %v, want %v", err, errLow)
+	}
+	if _, err := ws.Write(chunk); err != io.ErrClosedPipe {
+		t.Errorf("Write() after the low-water error: got error %v, want %v", err, io.ErrClosedPipe)
+	}
+	consume(1)
+	if err := ws.Close(); err != errLow {
+		t.Errorf("Close(): got error %v, want %v", err, errLow)
+	}
+	if got, want := ws.Written(), 6*pktSize; got != want {
+		t.Errorf("Written(): got %d, want %d", got, want)
+	}
+}
@@ -21,6 +21,14 @@
 void print_xfer(struct libusb_transfer *xfer);
 void xferCallback(struct libusb_transfer*);
 
+// sets the gousb completion callback on a transfer. Completion is signalled
+// on the channel registered for the transfer pointer in xferDoneMap, Go
+// channels can't be stored in user_data, which is cleared.
+void gousb_set_callback(struct libusb_transfer *xfer) {
+	xfer->callback = (libusb_transfer_cb_fn)(&xferCallback);
+	xfer->user_data = NULL;
+}
+
 int submit(struct libusb_transfer *xfer) {
 	xfer->callback = (libusb_transfer_cb_fn)(&xferCallback);
 	xfer->status = -1;
@@ -51,19 +59,26 @@ void print_xfer(struct libusb_transfer *xfer) {
 	}
 }
 
-// compact the data in an isochronous transfer. The contents of individual
-// iso packets are shifted left, so that no gaps are left between them.
-// Status is set to the first non-zero status of an iso packet.
-int gousb_compact_iso_data(struct libusb_transfer *xfer, unsigned char *status) {
+// compact the data in an isochronous transfer. The contents of the
+// successful iso packets are shifted left, so that no gaps are left between
+// them, the data of failed packets is dropped. Returns the number of bytes
+// of the compacted data. Status is set to the status of the first failed
+// packet and failed to the number of failed packets.
+int gousb_compact_iso_data(struct libusb_transfer *xfer, unsigned char *status, int *failed) {
 	int i;
 	int sum = 0;
 	unsigned char *in = xfer->buffer;
 	unsigned char *out = xfer->buffer;
+	*failed = 0;
 	for (i = 0; i < xfer->num_iso_packets; i++) {
 		struct libusb_iso_packet_descriptor pkt = xfer->iso_packet_desc[i];
 		if (pkt.status != 0) {
-		    *status = pkt.status;
-			break;
+			if (*failed == 0) {
+				*status = pkt.status;
+			}
+			(*failed)++;
+			in += pkt.length;
+			continue;
 		}
 		// Copy the data
 		int len = pkt.actual_length;
@@ -98,3 +113,13 @@ void gousb_free_transfer_and_buffer(struct libusb_transfer *xfer) {
         xfer->length = 0;
         libusb_free_transfer(xfer);
 }
+
+// returns the i-th iso packet descriptor of an isochronous transfer.
+struct libusb_iso_packet_descriptor *gousb_iso_packet_desc(struct libusb_transfer *xfer, int i) {
+        return &xfer->iso_packet_desc[i];
+}
+
+// sets the length of the i-th iso packet of an isochronous transfer.
+void gousb_set_iso_packet_length(struct libusb_transfer *xfer, int i, unsigned int length) {
+        xfer->iso_packet_desc[i].length = length;
+}
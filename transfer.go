@@ -17,8 +17,12 @@ package gousb
 import (
 	"context"
 	"errors"
+	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
 type usbTransfer struct {
@@ -30,28 +34,230 @@ type usbTransfer struct {
 	// is allocated by the C code, both buf and xfer.buffer point to the same
 	// memory.
 	buf []byte
+	// length is the number of bytes of buf sent by an OUT transfer, see
+	// setLength.
+	length int
 	// done is blocking until the transfer is complete and data and transfer
 	// status are available.
 	done chan struct{}
 	// submitted is true if submit() was called on this transfer.
 	submitted bool
+	// inFlight mirrors submitted, but can be read without acquiring mu,
+	// which is held by wait() for as long as the transfer is in flight.
+	inFlight int32
 	// ctx is the Context that created this transfer.
 	ctx *Context
+	// ep is the address of the endpoint of the transfer, reported in
+	// submit errors. It's 0 for transfers wrapped with WrapTransfer.
+	ep EndpointAddress
+	// dev is the state of the Device of the transfer, nil for transfers
+	// not allocated through an endpoint of a Device, e.g. wrapped with
+	// Context.WrapTransfer.
+	dev *deviceState
+	// stats, if not nil, collects the results of the transfer.
+	stats *endpointStats
+	// latency, if not nil, collects the latency of the transfer measured
+	// from submitTime.
+	latency    *latencyHistogram
+	submitTime time.Time
+	// queueDelay, if not nil, collects the time from queueStart until
+	// libusb reports the completion of the transfer.
+	queueDelay *latencyHistogram
+	queueStart time.Time
+	// freedAt is the stack of the call that freed the transfer, recorded
+	// only with WithTransferDebug.
+	freedAt string
+	// recordStart is the submission time reported to the recorder of
+	// the Context, see WithTransferRecorder.
+	recordStart time.Time
+	// isoPackets and isoPktSize are the number and size of iso packets
+	// allocated for isochronous transfers.
+	isoPackets, isoPktSize int
+	// borrowed is true if xfer was allocated outside of gousb and
+	// wrapped with Context.WrapTransfer. Its memory is not freed by free().
+	borrowed bool
+	// sched, if not nil, is the scheduler that orders the submissions of
+	// the transfer, using the priority returned by priority.
+	sched    *scheduler
+	priority func() int
+	// prio is the priority of the last scheduled submission, needGlobal
+	// is true if it needed a slot of the global limit, see
+	// SetMaxConcurrentTransfers.
+	prio       int
+	needGlobal bool
+	// qmu protects devSlot, globalSlot, queue, waiter and hook. It's held
+	// while acquiring the lock of a scheduler, never the other way round.
+	qmu sync.Mutex
+	// devSlot and globalSlot are true while the transfer holds a slot of
+	// sched and of the global limit.
+	devSlot, globalSlot bool
+	// queue and waiter identify the scheduler queue in which the transfer
+	// waits for a slot, see schedule.
+	queue  *scheduler
+	waiter *waiter
+	// cancelReq is set by abort, it cancels a scheduled transfer on its
+	// way from the queue to libusb. Accessed atomically.
+	cancelReq int32
+	// queueErr is the result of a scheduled transfer that never reached
+	// libusb, returned by wait.
+	queueErr error
+	// hook, if not nil, is called after each completion of the transfer,
+	// see setCompletionHook.
+	hook func()
+	// replayed is true if the transfer was completed from the
+	// TransferReplay of the Context with replayN bytes and replayStatus,
+	// see WithTransferReplay.
+	replayed     bool
+	replayN      int
+	replayStatus TransferStatus
+	// shared, if not nil, is the buffer of which buf is a part, see
+	// WithContiguousBuffers.
+	shared *sharedBuffer
+	// rawIso is true if the data of the iso packets is left in place,
+	// uncompacted, pkts are then the packet results read by wait. See
+	// InEndpoint.ReadIsoPackets.
+	rawIso bool
+	pkts   []IsoPacket
+	// group, if not nil, is the CancelGroup of the transfer.
+	group *CancelGroup
+	// singleOwner is true if the transfer is only ever used by a single
+	// goroutine, submit and wait then don't acquire mu. See
+	// WithSingleOwner.
+	singleOwner bool
+}
+
+// sharedBuffer is a block of memory allocated outside of the Go heap,
+// split into the buffers of multiple transfers. It is freed when the last
+// of its users releases it.
+type sharedBuffer struct {
+	mem []byte
+	// freeMem releases mem after the last user is gone.
+	freeMem func([]byte)
+
+	mu    sync.Mutex
+	users int
+}
+
+func newSharedBuffer(ctx *Context, size int) (*sharedBuffer, error) {
+	mem, err := ctx.libusb.allocBuffer(size)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedBuffer{mem: mem, freeMem: ctx.libusb.freeBuffer, users: 1}, nil
+}
+
+// acquire adds a user of the buffer.
+func (b *sharedBuffer) acquire() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.users++
+}
+
+// release removes a user of the buffer, freeing the memory if it was the
+// last one.
+func (b *sharedBuffer) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.users--
+	if b.users == 0 {
+		b.freeMem(b.mem)
+		b.mem = nil
+	}
+}
+
+// lock acquires mu, unless the transfer has a single owner.
+func (t *usbTransfer) lock() {
+	if !t.singleOwner {
+		t.mu.Lock()
+	}
+}
+
+// unlock releases mu acquired by lock.
+func (t *usbTransfer) unlock() {
+	if !t.singleOwner {
+		t.mu.Unlock()
+	}
 }
 
 // submits the transfer. After submit() the transfer is in flight and is owned by libusb.
 // It's not safe to access the contents of the transfer until wait() returns.
 // Once wait() returns, it's ok to re-use the same transfer structure by calling submit() again.
 func (t *usbTransfer) submit() error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	t.lock()
+	defer t.unlock()
 	if t.submitted {
 		return errors.New("transfer was already submitted and is not finished yet")
 	}
-	if err := t.ctx.libusb.submit(t.xfer); err != nil {
+	if global := globalEnabled(); t.sched != nil || global {
+		return t.schedule(global)
+	}
+	return t.submitLocked()
+}
+
+// releaseSlots returns the scheduling slots taken by schedule. t.mu must
+// be held.
+func (t *usbTransfer) releaseSlots() {
+	t.qmu.Lock()
+	global, dev := t.globalSlot, t.devSlot
+	t.globalSlot, t.devSlot = false, false
+	t.qmu.Unlock()
+	if global {
+		globalSched.release()
+	}
+	if dev {
+		t.sched.release()
+	}
+}
+
+// submitLocked submits the transfer to libusb and marks it as submitted.
+// t.mu must be held.
+func (t *usbTransfer) submitLocked() error {
+	if err := t.start(); err != nil {
 		return err
 	}
 	t.submitted = true
+	atomic.StoreInt32(&t.inFlight, 1)
+	return nil
+}
+
+// start submits the transfer to libusb. t.mu must be held, unless the
+// transfer is queued in a scheduler and marked as submitted, see schedule.
+func (t *usbTransfer) start() error {
+	// Hold the group lock too, so that a concurrent CancelAll either
+	// prevents the submission or finds the transfer in flight.
+	if g := t.group; g != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.cancelled {
+			return ErrGroupCancelled
+		}
+	}
+	// Hold the lock until the transfer is marked as in flight, so that
+	// it is cancelled by a concurrent Context.Close.
+	t.ctx.xferMu.RLock()
+	defer t.ctx.xferMu.RUnlock()
+	if t.ctx.closing {
+		return errors.New("transfer submitted after the Context was closed")
+	}
+	if t.dev != nil && t.dev.closed {
+		return ErrDeviceClosed
+	}
+	if t.xfer == nil {
+		t.checkFreed("submit")
+		return errors.New("transfer submitted after it was freed")
+	}
+	t.submitTime = t.latency.start()
+	t.queueStart = t.queueDelay.start()
+	if t.ctx.recorder != nil {
+		t.recordStart = time.Now()
+	}
+	if rp := t.ctx.replay; rp != nil {
+		t.replay(rp)
+		return nil
+	}
+	if err := t.ctx.libusb.submit(t.xfer); err != nil {
+		return &SubmitError{Endpoint: t.ep, Length: len(t.buf), Err: err}
+	}
 	return nil
 }
 
@@ -61,36 +267,106 @@ func (t *usbTransfer) submit() error {
 // of the buffer were read or written by libusb, and it can be
 // smaller than the length of t.buf.
 func (t *usbTransfer) wait(ctx context.Context) (n int, err error) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+	t.lock()
+	defer t.unlock()
 	if !t.submitted {
+		if t.xfer == nil {
+			// freed by its owner after Device.Close.
+			if t.ctx.deviceClosed(t.dev) {
+				return 0, ErrDeviceClosed
+			}
+			t.checkFreed("wait")
+			return 0, errors.New("wait() called on a freed transfer")
+		}
+		if t.ctx.deviceClosed(t.dev) {
+			return 0, ErrDeviceClosed
+		}
 		return 0, nil
 	}
 	select {
 	case <-ctx.Done():
-		t.ctx.libusb.cancel(t.xfer)
+		t.abort()
 		// after the transfer is cancelled, it will run a callback
 		// that triggers the activation of t.done.
 		<-t.done
 	case <-t.done:
 	}
+	if err := t.queueErr; err != nil {
+		// a scheduled transfer that never reached libusb.
+		t.queueErr = nil
+		t.submitted = false
+		t.notInFlight()
+		t.releaseSlots()
+		if errors.Is(err, TransferCancelled) {
+			if t.group != nil && t.group.Cancelled() {
+				return 0, ErrGroupCancelled
+			}
+			if t.ctx.deviceClosed(t.dev) {
+				return 0, ErrDeviceClosed
+			}
+		}
+		return 0, err
+	}
+	t.latency.record(t.submitTime)
+	replayed := t.replayed
+	var completed time.Time
+	if !replayed {
+		completed = t.ctx.libusb.completionTime(t.xfer)
+	}
+	if !t.queueStart.IsZero() && !replayed {
+		t.queueDelay.recordUntil(t.queueStart, completed)
+	}
 	t.submitted = false
-	n, status := t.ctx.libusb.data(t.xfer)
+	t.notInFlight()
+	t.releaseSlots()
+	var status TransferStatus
+	if replayed {
+		n, status = t.replayN, t.replayStatus
+		t.replayed = false
+	} else if t.rawIso {
+		t.pkts = t.ctx.libusb.isoPackets(t.xfer, t.pkts[:0])
+		for _, p := range t.pkts {
+			n += p.ActualLength
+		}
+		status = t.ctx.libusb.status(t.xfer)
+	} else {
+		n, status = t.ctx.libusb.data(t.xfer)
+	}
+	if t.stats != nil {
+		t.stats.record(n, status)
+	}
+	if r := t.ctx.recorder; r != nil {
+		data := t.buf[:n]
+		if t.rawIso {
+			data = packetData(t.buf, t.pkts)
+		}
+		r.record(t.ep, t.recordStart, completed, data, status)
+	}
+	if status == TransferCancelled {
+		if t.group != nil && t.group.Cancelled() {
+			return n, ErrGroupCancelled
+		}
+		if t.ctx.deviceClosed(t.dev) {
+			return n, ErrDeviceClosed
+		}
+	}
 	if status != TransferCompleted {
-		return n, status
+		return n, statusError(status)
 	}
 	return n, err
 }
 
 // cancel aborts a submitted transfer. The transfer is cancelled
 // asynchronously and the user still needs to wait() to return.
+// cancel can be called concurrently with wait(), it doesn't acquire mu.
+// Instead, the Context transfer lock keeps the transfer from being freed.
 func (t *usbTransfer) cancel() error {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if !t.submitted {
+	t.ctx.xferMu.RLock()
+	defer t.ctx.xferMu.RUnlock()
+	if !t.isInFlight() {
 		return nil
 	}
-	err := t.ctx.libusb.cancel(t.xfer)
+	err := t.abort()
 	if err == ErrorNotFound {
 		// transfer already completed
 		return nil
@@ -98,6 +374,46 @@ func (t *usbTransfer) cancel() error {
 	return err
 }
 
+// abort cancels a transfer in flight, whether it's in libusb or still
+// queued by a scheduler, see schedule. A queued transfer is completed as
+// cancelled right away. abort doesn't acquire mu nor the Context transfer
+// lock, the caller must keep the transfer from being freed.
+func (t *usbTransfer) abort() error {
+	atomic.StoreInt32(&t.cancelReq, 1)
+	if t.dequeue() {
+		t.finishQueued(TransferCancelled)
+		return nil
+	}
+	return t.ctx.libusb.cancel(t.xfer)
+}
+
+// setCompletionHook registers fn to be called after each completion of
+// the transfer, including completions of scheduled transfers that never
+// reached libusb. fn must not block, it may run on the libusb event thread.
+func (t *usbTransfer) setCompletionHook(fn func()) {
+	t.qmu.Lock()
+	defer t.qmu.Unlock()
+	t.hook = fn
+}
+
+// onCompletion is called after each completion of the transfer, by libusb or
+// by finish. It wakes up awaitCompletion and calls the completion hook.
+func (t *usbTransfer) onCompletion() {
+	t.ctx.xferSignal.signal()
+	t.qmu.Lock()
+	hook := t.hook
+	t.qmu.Unlock()
+	if hook != nil {
+		hook()
+	}
+}
+
+// notInFlight marks the transfer as no longer in flight.
+func (t *usbTransfer) notInFlight() {
+	atomic.StoreInt32(&t.inFlight, 0)
+	t.ctx.xferSignal.signal()
+}
+
 // free releases the memory allocated for the transfer.
 // free should be called only if the transfer is not used by libusb,
 // i.e. it should not be called after submit() and before wait() returns.
@@ -110,37 +426,202 @@ func (t *usbTransfer) free() error {
 	if t.xfer == nil {
 		return nil
 	}
-	t.ctx.libusb.free(t.xfer)
+	// Unregister first, Context.Close may access xfer until then.
+	t.ctx.unregisterTransfer(t)
+	if t.ctx.debugTransfers {
+		t.poison()
+	}
+	if t.group != nil {
+		t.group.remove(t)
+		t.group = nil
+	}
+	switch {
+	case t.borrowed:
+		t.ctx.libusb.unwrap(t.xfer)
+	case t.shared != nil:
+		t.ctx.libusb.freeTransfer(t.xfer)
+		t.shared.release()
+		t.shared = nil
+	default:
+		t.ctx.libusb.free(t.xfer)
+	}
 	t.xfer = nil
 	t.buf = nil
-	t.done = nil
 	return nil
 }
 
+// poisonByte fills the buffers of freed transfers, see WithTransferDebug.
+const poisonByte = 0xdb
+
+// poison marks t as freed for checkFreed: it records the stack of the
+// caller of free and overwrites the buffer of the transfer, unless the
+// buffer belongs to the caller of WrapTransfer, so that data read from
+// a freed buffer stands out.
+func (t *usbTransfer) poison() {
+	stack := make([]byte, 4096)
+	t.freedAt = string(stack[:runtime.Stack(stack, false)])
+	if t.borrowed {
+		return
+	}
+	for i := range t.buf {
+		t.buf[i] = poisonByte
+	}
+}
+
+// checkFreed panics if debugging of transfers is enabled, see
+// WithTransferDebug. It's called when op is attempted on a transfer that
+// was freed by its owner.
+func (t *usbTransfer) checkFreed(op string) {
+	if !t.ctx.debugTransfers {
+		return
+	}
+	panic(fmt.Sprintf("gousb: %s() called on a freed transfer of endpoint %s, the transfer was freed by:\n%s", op, t.ep, t.freedAt))
+}
+
 // data returns the slice containing transfer buffer.
 func (t *usbTransfer) data() []byte {
 	return t.buf
 }
 
+// setLength sets the number of bytes of the buffer sent by subsequent
+// submit()s of an OUT transfer. For isochronous transfers, the data is
+// split into iso packets of the allocated packet size, the last packet
+// might be shorter.
+func (t *usbTransfer) setLength(n int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.submitted {
+		return errors.New("setLength() cannot be called on a submitted transfer until wait() returns")
+	}
+	if t.xfer == nil {
+		return errors.New("setLength() called on a freed transfer")
+	}
+	if n < 0 || n > len(t.buf) {
+		return fmt.Errorf("transfer length %d out of range, transfer buffer has %d bytes", n, len(t.buf))
+	}
+	var pkts []int
+	if t.isoPackets > 0 {
+		pkts = isoPacketLengths(n, t.isoPktSize, t.isoPackets)
+	}
+	t.ctx.libusb.setLength(t.xfer, n, pkts)
+	t.length = n
+	return nil
+}
+
+// setTimeout sets the libusb timeout used by subsequent submit()s.
+func (t *usbTransfer) setTimeout(d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.submitted {
+		return errors.New("setTimeout() cannot be called on a submitted transfer until wait() returns")
+	}
+	if t.xfer == nil {
+		return errors.New("setTimeout() called on a freed transfer")
+	}
+	if d < 0 {
+		return fmt.Errorf("transfer timeout %v is negative", d)
+	}
+	t.ctx.libusb.setTimeout(t.xfer, d)
+	return nil
+}
+
+// timeout returns the libusb timeout of the transfer.
+func (t *usbTransfer) timeout() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.xfer == nil {
+		return 0
+	}
+	return t.ctx.libusb.timeout(t.xfer)
+}
+
+// isoPacketLengths splits n bytes into at most max iso packets of size
+// bytes. The last packet holds the remainder. At least one packet is
+// returned, even for n == 0.
+func isoPacketLengths(n, size, max int) []int {
+	var ret []int
+	for n > 0 && len(ret) < max {
+		l := size
+		if n < l {
+			l = n
+		}
+		ret = append(ret, l)
+		n -= l
+	}
+	if len(ret) == 0 {
+		ret = append(ret, 0)
+	}
+	return ret
+}
+
+// isoPacketResults returns the iso packet descriptors of the last finished
+// transfer, or nil if the transfer is in flight or is not isochronous.
+func (t *usbTransfer) isoPacketResults() []IsoPacket {
+	if t.isInFlight() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.xfer == nil || t.isoPackets == 0 {
+		return nil
+	}
+	return t.ctx.libusb.isoPackets(t.xfer, nil)
+}
+
+// completed returns true if the transfer is not in flight or if libusb
+// already signalled its completion, even if wait() was not called yet.
+func (t *usbTransfer) completed() bool {
+	return !t.isInFlight() || len(t.done) > 0
+}
+
+// isInFlight returns true if the transfer was submitted and wait() did not
+// return yet. Unlike other methods, it does not block while wait() is in
+// progress.
+func (t *usbTransfer) isInFlight() bool {
+	return atomic.LoadInt32(&t.inFlight) != 0
+}
+
 // newUSBTransfer allocates a new transfer structure and a new buffer for
 // communication with a given device/endpoint.
 func newUSBTransfer(ctx *Context, dev *libusbDevHandle, ei *EndpointDesc, bufLen int) (*usbTransfer, error) {
+	return newUSBTransferWithBuffer(ctx, dev, nil, ei, bufLen, nil, 0)
+}
+
+// newUSBTransferWithBuffer allocates a new transfer structure. If shared is
+// not nil, the transfer uses bufLen bytes of shared, starting at offset,
+// as its buffer. Otherwise a new buffer is allocated. If state is not nil,
+// the transfer belongs to a Device and can't be allocated or submitted
+// after the Device is closed.
+func newUSBTransferWithBuffer(ctx *Context, dev *libusbDevHandle, state *deviceState, ei *EndpointDesc, bufLen int, shared *sharedBuffer, offset int) (*usbTransfer, error) {
+	if ctx.deviceClosed(state) {
+		return nil, ErrDeviceClosed
+	}
+	switch ei.TransferType {
+	case TransferTypeBulk, TransferTypeInterrupt:
+	case TransferTypeIsochronous:
+		if ei.MaxPacketSize <= 0 {
+			return nil, fmt.Errorf("isochronous endpoint %s has invalid max packet size %d, can't split the transfer into packets", ei, ei.MaxPacketSize)
+		}
+	case TransferTypeControl:
+		return nil, fmt.Errorf("endpoint %s is a control endpoint, use Device.Control for control transfers", ei)
+	default:
+		return nil, fmt.Errorf("endpoint %s has an unknown transfer type %d", ei, ei.TransferType)
+	}
+
 	var isoPackets, isoPktSize int
 	if ei.TransferType == TransferTypeIsochronous {
-		isoPktSize = ei.MaxPacketSize
-		if bufLen < isoPktSize {
-			isoPktSize = bufLen
-		}
-		if isoPktSize > 0 {
-			isoPackets = bufLen / isoPktSize
-		} else {
-			isoPackets = 1
-		}
+		isoPackets, isoPktSize = ei.isoPackets(bufLen)
 		debug.Printf("New isochronous transfer - buffer length %d, using %d packets of %d bytes each", bufLen, isoPackets, isoPktSize)
 	}
 
 	done := make(chan struct{}, 1)
-	xfer, err := ctx.libusb.alloc(dev, ei, isoPackets, bufLen, done)
+	var xfer *libusbTransfer
+	var err error
+	if shared != nil {
+		xfer, err = ctx.libusb.allocWithBuffer(dev, ei, isoPackets, shared.mem[offset:offset+bufLen], done)
+	} else {
+		xfer, err = ctx.libusb.alloc(dev, ei, isoPackets, bufLen, done)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -150,15 +631,242 @@ func newUSBTransfer(ctx *Context, dev *libusbDevHandle, ei *EndpointDesc, bufLen
 	}
 
 	t := &usbTransfer{
-		xfer: xfer,
-		buf:  ctx.libusb.buffer(xfer),
-		done: done,
-		ctx:  ctx,
-	}
-	runtime.SetFinalizer(t, func(t *usbTransfer) {
-		t.cancel()
-		t.wait(context.Background())
-		t.free()
-	})
+		xfer:       xfer,
+		buf:        ctx.libusb.buffer(xfer),
+		done:       done,
+		ctx:        ctx,
+		ep:         ei.Address,
+		dev:        state,
+		isoPackets: isoPackets,
+		isoPktSize: isoPktSize,
+	}
+	t.length = len(t.buf)
+	if err := ctx.registerTransfer(t); err != nil {
+		if shared != nil {
+			ctx.libusb.freeTransfer(xfer)
+		} else {
+			ctx.libusb.free(xfer)
+		}
+		return nil, err
+	}
+	if shared != nil {
+		shared.acquire()
+		t.shared = shared
+	}
+	runtime.SetFinalizer(t, finalizeTransfer)
 	return t, nil
 }
+
+// finalizeTransfer releases a transfer that was not freed by its owner.
+// A transfer that was freed is skipped, waiting for it would panic with
+// WithTransferDebug.
+func finalizeTransfer(t *usbTransfer) {
+	if t.xfer == nil {
+		return
+	}
+	t.cancel()
+	t.wait(context.Background())
+	t.free()
+}
+
+// WrapTransfer returns a Transfer managing a struct libusb_transfer that was
+// allocated and filled in by code calling libusb directly, e.g. with
+// libusb_alloc_transfer and libusb_fill_bulk_transfer. The pointer should
+// be a *C.struct_libusb_transfer. This allows code using libusb directly to
+// migrate to gousb incrementally, submitting its transfers with Submit and
+// collecting the results with Wait or OnComplete.
+//
+// The transfer must target a device opened in the libusb context of c, see
+// Context.Handle and Device.Handle. WrapTransfer replaces the callback of the
+// transfer and clears its user_data. The transfer fields, including the
+// buffer and length, must not be modified by the caller until Free returns.
+// The caller keeps the ownership of the transfer memory: Free only releases
+// the transfer from gousb and the caller still needs to call
+// libusb_free_transfer afterwards.
+func (c *Context) WrapTransfer(xfer unsafe.Pointer) (*Transfer, error) {
+	if c.ctx == nil {
+		return nil, errors.New("WrapTransfer called on a closed or uninitialized Context")
+	}
+	if xfer == nil {
+		return nil, errors.New("WrapTransfer called with a nil transfer")
+	}
+	x := (*libusbTransfer)(xfer)
+	done := make(chan struct{}, 1)
+	if err := c.libusb.wrap(x, done); err != nil {
+		return nil, err
+	}
+	t := &usbTransfer{
+		xfer:     x,
+		buf:      c.libusb.buffer(x),
+		done:     done,
+		ctx:      c,
+		borrowed: true,
+	}
+	t.length = len(t.buf)
+	if err := c.registerTransfer(t); err != nil {
+		c.libusb.unwrap(x)
+		return nil, err
+	}
+	return &Transfer{t: t}, nil
+}
+
+// Transfer is a single USB transfer with a buffer allocated for its
+// lifetime. A Transfer can be submitted repeatedly, but each Submit must be
+// followed by a Wait before the Transfer can be submitted again.
+// Most users should use Read/Write methods of the endpoints or the endpoint
+// streams instead. Transfer is intended for building custom transfer
+// scheduling on top of gousb.
+// A Transfer must be Free()d after use.
+type Transfer struct {
+	t *usbTransfer
+
+	// mu protects onComplete and inFlightFn.
+	mu         sync.Mutex
+	onComplete func(n int, err error)
+	// hooked is true once the completion hook delivering the results to
+	// the Context completion queue is registered.
+	hooked bool
+	// inFlightFn is the OnComplete function of the submission in flight,
+	// nil if the submission is collected with Wait.
+	inFlightFn func(n int, err error)
+}
+
+// TransferOption configures a transfer allocated by InEndpoint.NewTransfer
+// or OutEndpoint.NewTransfer.
+type TransferOption func(*transferOptions)
+
+type transferOptions struct {
+	// singleOwner is true if the transfer skips the locking of Submit
+	// and Wait.
+	singleOwner bool
+}
+
+// WithSingleOwner allocates a transfer whose Submit and Wait don't
+// acquire the internal lock of the transfer, which saves a little time on
+// every submission in hot loops, e.g. a dedicated loop resubmitting an
+// isochronous transfer. The transfer is not safe for concurrent use: all
+// of its methods except Cancel and InFlight must be called from one
+// goroutine at a time, with no OnComplete function registered. Concurrent
+// use is not detected and corrupts the transfer state.
+// Most users should keep the default, locked transfers.
+func WithSingleOwner() TransferOption {
+	return func(o *transferOptions) {
+		o.singleOwner = true
+	}
+}
+
+// OnComplete registers a function called with the results of the transfer
+// each time a submitted transfer finishes, as an alternative to Wait.
+// The functions of all transfers of a Context are called, in completion
+// order, on a single goroutine of the Context, never on the libusb event
+// handling thread. A function may submit the transfer again, but a slow
+// function delays the delivery of the other results. While a completion
+// function is registered, Wait must not be called, the results are
+// delivered only to the function. Context.Close waits until the results
+// of the cancelled transfers are delivered.
+// OnComplete applies to subsequent calls to Submit. Passing nil
+// removes the registered function.
+func (t *Transfer) OnComplete(fn func(n int, err error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onComplete = fn
+	if fn != nil && !t.hooked {
+		t.hooked = true
+		t.t.ctx.completions.start()
+		t.t.setCompletionHook(t.completed)
+	}
+}
+
+// Submit sends the transfer to the device. For OUT endpoints, the contents
+// of Data() are sent. After Submit the transfer buffer is owned by libusb
+// and must not be accessed until Wait returns or, if registered, until the
+// OnComplete function is called.
+func (t *Transfer) Submit() error {
+	t.mu.Lock()
+	fn := t.onComplete
+	t.inFlightFn = fn
+	t.mu.Unlock()
+	if fn == nil {
+		return t.t.submit()
+	}
+	q := t.t.ctx.completions
+	q.submitted()
+	if err := t.t.submit(); err != nil {
+		t.mu.Lock()
+		t.inFlightFn = nil
+		t.mu.Unlock()
+		q.done()
+		return err
+	}
+	return nil
+}
+
+// Wait blocks until the submitted transfer is finished and returns the
+// number of bytes transferred. Cancelling the context cancels the transfer,
+// resulting in TransferCancelled error. Wait returns immediately if the
+// transfer was not submitted. After the device is closed, Wait returns
+// ErrDeviceClosed, including for the transfer cancelled by Device.Close.
+func (t *Transfer) Wait(ctx context.Context) (int, error) {
+	return t.t.wait(ctx)
+}
+
+// Cancel aborts a submitted transfer. The transfer is cancelled
+// asynchronously and Wait still needs to be called, unless an OnComplete
+// function is registered. Cancel can be called while Wait is in progress.
+func (t *Transfer) Cancel() error {
+	return t.t.cancel()
+}
+
+// Free releases the memory allocated for the transfer. Free returns an error
+// if the transfer is still in flight.
+func (t *Transfer) Free() error {
+	return t.t.free()
+}
+
+// Data returns the transfer buffer. For IN transfers, after Wait returns n
+// bytes, the data read from the device is available in Data()[:n].
+func (t *Transfer) Data() []byte {
+	return t.t.data()
+}
+
+// SetTimeout sets the timeout of subsequent submissions of the transfer.
+// A transfer that doesn't finish within d completes with TransferTimedOut,
+// with the data transferred until then. A timeout of 0, the default, means
+// that the transfer waits for the device indefinitely. The timeout has
+// millisecond resolution. SetTimeout returns an error if the transfer is in
+// flight.
+func (t *Transfer) SetTimeout(d time.Duration) error {
+	return t.t.setTimeout(d)
+}
+
+// Timeout returns the timeout set with SetTimeout.
+func (t *Transfer) Timeout() time.Duration {
+	return t.t.timeout()
+}
+
+// IsoPacket describes a single packet of an isochronous transfer.
+type IsoPacket struct {
+	// Length is the number of bytes requested for the packet.
+	Length int
+	// ActualLength is the number of bytes actually transferred.
+	ActualLength int
+	// Status is the completion status of the packet.
+	Status TransferStatus
+}
+
+// IsoPackets returns the per-packet results of an isochronous transfer
+// after Wait returns, e.g. to find which packets were short or failed.
+// Note that for IN transfers Data() contains the data of all packets
+// of the successful packets compacted, without gaps, see ReadContext.
+// IsoPackets returns nil for other transfer types
+// and while the transfer is in flight.
+func (t *Transfer) IsoPackets() []IsoPacket {
+	return t.t.isoPacketResults()
+}
+
+// InFlight returns true if the transfer was submitted, but Wait has not
+// returned yet. InFlight can be called concurrently with other methods,
+// including a blocked Wait.
+func (t *Transfer) InFlight() bool {
+	return t.t.isInFlight()
+}
@@ -16,7 +16,11 @@ package gousb
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 )
 
 type transferIntf interface {
@@ -25,6 +29,8 @@ type transferIntf interface {
 	wait(context.Context) (int, error)
 	free() error
 	data() []byte
+	setLength(int) error
+	completed() bool
 }
 
 type stream struct {
@@ -34,6 +40,119 @@ type stream struct {
 	err error
 	// finished is true if transfers has been already closed.
 	finished bool
+	// alloc allocates a new transfer for the stream, used when the depth
+	// of the stream grows. Streams without alloc can't grow.
+	alloc func() (transferIntf, error)
+	// closed returns true if the device of the stream was closed, nil for
+	// streams that don't track their device.
+	closed func() bool
+
+	// mu protects all and depth.
+	mu sync.Mutex
+	// all is the set of transfers of the stream that were not freed yet.
+	all map[*trackedTransfer]bool
+	// depth is the requested number of transfers.
+	depth int
+}
+
+// trackedTransfer is a stream transfer that removes itself from the stream
+// when freed.
+type trackedTransfer struct {
+	transferIntf
+	s *stream
+}
+
+func (t *trackedTransfer) free() error {
+	err := t.transferIntf.free()
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	delete(t.s.all, t)
+	return err
+}
+
+func (s *stream) track(t transferIntf) transferIntf {
+	tt := &trackedTransfer{transferIntf: t, s: s}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.all[tt] = true
+	return tt
+}
+
+// shrink returns true if the stream has more transfers than requested,
+// in which case the caller should free a completed transfer instead of
+// reusing it.
+func (s *stream) shrink() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.all) > s.depth
+}
+
+// setDepth changes the requested number of transfers of the stream. New
+// transfers are allocated immediately and, if submit is true, submitted.
+// Excess transfers are released by the stream users as they complete,
+// see shrink.
+func (s *stream) setDepth(n int, submit bool) error {
+	if n < 1 {
+		return fmt.Errorf("invalid stream depth %d, must be at least 1", n)
+	}
+	if s.transfers == nil || s.finished {
+		return io.ErrClosedPipe
+	}
+	s.mu.Lock()
+	s.depth = n
+	total := len(s.all)
+	s.mu.Unlock()
+	if n <= total {
+		return nil
+	}
+	if s.alloc == nil {
+		return errors.New("stream can't allocate new transfers")
+	}
+	// transfers can hold all the transfers of the stream without blocking.
+	grown := make(chan transferIntf, n)
+	for len(s.transfers) > 0 {
+		grown <- <-s.transfers
+	}
+	s.transfers = grown
+	for i := total; i < n; i++ {
+		t, err := s.alloc()
+		if err != nil {
+			return err
+		}
+		t = s.track(t)
+		if submit {
+			if err := t.submit(); err != nil {
+				t.free()
+				return err
+			}
+		}
+		s.transfers <- t
+	}
+	return nil
+}
+
+// utilization returns the fraction of the transfers of the stream that are
+// completed, if completed is true, or still in flight.
+func (s *stream) utilization(completed bool) float64 {
+	n, total := s.count(completed)
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// count returns the number of transfers of the stream that are completed,
+// if completed is true, or still in flight, and the number of all the
+// transfers of the stream.
+func (s *stream) count(completed bool) (n, total int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t := range s.all {
+		if t.completed() == completed {
+			n++
+		}
+	}
+	return n, len(s.all)
 }
 
 func (s *stream) gotError(err error) {
@@ -95,6 +214,69 @@ type ReadStream struct {
 	current transferIntf
 	// total/used are the number of all/used bytes in the current transfer.
 	total, used int
+
+	// rmu serializes Release and Close with the delivery of results.
+	rmu sync.Mutex
+	// results is the channel returned by Results, nil if Results was
+	// never called.
+	results chan TransferResult
+	// held are the transfers whose buffers were delivered through results
+	// and not released yet, keyed by the start of the buffer.
+	held map[*byte]transferIntf
+	// onError is the callback set by OnError, nil if transfer errors stop
+	// the stream.
+	onError func(err error) bool
+}
+
+// OnError sets fn to handle the errors of the transfers of the stream
+// out of band from the data. When a transfer completes with an error, fn is
+// called with the error before the transfer is delivered. If fn returns
+// true, the error is tolerated: the transfer, which delivers no data, is
+// resubmitted and the stream continues as if it had not failed, e.g. to log
+// a stall and keep reading. If fn returns false, the error stops the
+// stream as it would without OnError. A nil fn restores the default, every
+// transfer error stops the stream.
+// fn is not called for errors of the context passed to ReadContext or
+// Process, which abort the wait rather than report a transfer failure.
+// fn is called from the goroutine reading the stream, or from the goroutine
+// delivering the results of Results.
+// OnError must be called before the stream is read.
+func (r *ReadStream) OnError(fn func(err error) bool) {
+	r.onError = fn
+}
+
+// tolerated returns true if the OnError callback tolerates err, the error
+// of a transfer waited for with ctx.
+func (r *ReadStream) tolerated(ctx context.Context, err error) bool {
+	return r.onError != nil && ctx.Err() == nil && r.onError(err)
+}
+
+// tolerate resubmits transfer t, which completed with err, and returns true
+// if the OnError callback tolerates err. Otherwise the caller still owns t.
+func (r *ReadStream) tolerate(ctx context.Context, t transferIntf, err error) bool {
+	if !r.tolerated(ctx, err) {
+		return false
+	}
+	r.requeue(t)
+	return true
+}
+
+// requeue resubmits a transfer whose data was consumed, or frees it if the
+// stream is stopping or shrinking. In Results mode, requeue must be called
+// with rmu held.
+func (r *ReadStream) requeue(t transferIntf) {
+	if r.s.err != nil || r.s.shrink() {
+		t.free()
+		return
+	}
+	if err := t.submit(); err != nil {
+		t.free()
+		r.s.gotError(err)
+		r.s.noMore()
+		return
+	}
+	// guaranteed to not block, the transfer was taken from the channel.
+	r.s.transfers <- t
 }
 
 // Read reads data from the transfer stream.
@@ -119,10 +301,13 @@ func (r *ReadStream) Read(p []byte) (int, error) {
 // operation within the stream. The semantics is identical to
 // Endpoint.ReadContext.
 func (r *ReadStream) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if r.results != nil {
+		return 0, errors.New("ReadStream.Read can't be used after ReadStream.Results")
+	}
 	if r.s.transfers == nil {
 		return 0, io.ErrClosedPipe
 	}
-	if r.current == nil {
+	for r.current == nil {
 		t, ok := <-r.s.transfers
 		if !ok {
 			// no more transfers in flight
@@ -131,6 +316,9 @@ func (r *ReadStream) ReadContext(ctx context.Context, p []byte) (int, error) {
 		}
 		n, err := t.wait(ctx)
 		if err != nil {
+			if r.tolerate(ctx, t, err) {
+				continue
+			}
 			// wait error aborts immediately, all remaining data is invalid.
 			t.free()
 			r.s.flushRemaining()
@@ -148,7 +336,9 @@ func (r *ReadStream) ReadContext(ctx context.Context, p []byte) (int, error) {
 	copy(p, r.current.data()[r.used:r.used+use])
 	r.used += use
 	if r.used == r.total {
-		if r.s.err == nil {
+		if r.s.err == nil && r.s.shrink() {
+			r.current.free()
+		} else if r.s.err == nil {
 			if err := r.current.submit(); err == nil {
 				// guaranteed to not block, len(transfers) == number of allocated transfers
 				r.s.transfers <- r.current
@@ -165,17 +355,268 @@ func (r *ReadStream) ReadContext(ctx context.Context, p []byte) (int, error) {
 	return use, nil
 }
 
+// SetDepth changes the number of transfers of the stream, as initially
+// requested by the count passed to NewStream. Growing the stream allocates
+// and submits new transfers immediately. When shrinking, the excess
+// transfers are released as their data is read. More transfers increase
+// the throughput and the tolerance to read delays, at the cost of memory
+// and of the latency of data waiting in the stream.
+// SetDepth cannot be called concurrently with Read, ReadContext or Close.
+func (r *ReadStream) SetDepth(n int) error {
+	return r.s.setDepth(n, true)
+}
+
+// Utilization returns the fraction of the transfers of the stream that hold
+// data not read yet, between 0 and 1. A utilization close to 1 means the
+// pipeline is saturated: the reader does not keep up with the device, and
+// the device will have to wait for free transfers. A utilization close to 0
+// means the pipeline is starved: all transfers are waiting for the device.
+// Utilization can be called concurrently with other ReadStream methods.
+func (r *ReadStream) Utilization() float64 {
+	return r.s.utilization(true)
+}
+
+// TransferResult is the result of a single transfer of a ReadStream,
+// delivered through the channel returned by Results.
+type TransferResult struct {
+	// Data is the data read by the transfer. Data is the transfer buffer
+	// itself, it's owned by the receiver of the result until passed to
+	// ReadStream.Release.
+	Data []byte
+	// N is the number of bytes read, len(Data).
+	N int
+	// Status is the status of the transfer.
+	Status TransferStatus
+	// Err is the error that stopped the stream, nil for successful
+	// transfers. A result with a non-nil Err carries no Data and is the
+	// last result delivered.
+	Err error
+}
+
+// Results switches the stream to delivering the completed transfers through
+// the returned channel, instead of through Read. Any number of consumers can
+// receive from the channel. The buffer of each result stays owned by its
+// receiver and the transfer is not resubmitted until the buffer is returned
+// with Release, so no data is copied.
+// After Close, the transfers still in flight are delivered and the channel
+// is closed. If a transfer fails, a result with the error is delivered last.
+// All delivered buffers must be released, including those received after
+// Close, to free the transfers. Calling Results again returns the same
+// channel. Read, ReadContext and SetDepth can't be used after Results.
+func (r *ReadStream) Results() <-chan TransferResult {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	if r.results != nil {
+		return r.results
+	}
+	r.results = make(chan TransferResult, cap(r.s.transfers))
+	r.held = make(map[*byte]transferIntf)
+	if r.s.transfers == nil {
+		close(r.results)
+		return r.results
+	}
+	go r.deliver(r.s.transfers)
+	return r.results
+}
+
+// deliver waits for the transfers of the stream in order and sends their
+// results to r.results.
+func (r *ReadStream) deliver(transfers chan transferIntf) {
+	defer close(r.results)
+	for t := range transfers {
+		n, err := t.wait(context.Background())
+		if err != nil {
+			if r.tolerated(context.Background(), err) {
+				r.rmu.Lock()
+				r.requeue(t)
+				r.rmu.Unlock()
+				continue
+			}
+			t.free()
+			r.rmu.Lock()
+			r.s.gotError(err)
+			r.s.noMore()
+			r.rmu.Unlock()
+			// all remaining data is invalid, as in ReadContext.
+			for t := range transfers {
+				t.cancel()
+				t.wait(context.Background())
+				t.free()
+			}
+			st := TransferError
+			errors.As(err, &st)
+			r.results <- TransferResult{Status: st, Err: err}
+			return
+		}
+		buf := t.data()[:n]
+		r.rmu.Lock()
+		r.held[bufferKey(buf)] = t
+		r.rmu.Unlock()
+		r.results <- TransferResult{Data: buf, N: n, Status: TransferCompleted}
+	}
+}
+
+// bufferKey identifies a transfer buffer by its first byte, which is
+// addressable even for results with no data.
+func bufferKey(b []byte) *byte {
+	if cap(b) == 0 {
+		return nil
+	}
+	return &b[:cap(b)][0]
+}
+
+// Release returns the buffer of a result received from Results to the
+// stream, which resubmits its transfer. The buffer must not be used after
+// Release. After Close or after an error, released transfers are freed.
+// Release can be called concurrently by multiple consumers and with Close.
+func (r *ReadStream) Release(buf []byte) error {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
+	k := bufferKey(buf)
+	t, ok := r.held[k]
+	if k == nil || !ok {
+		return errors.New("ReadStream.Release: the buffer was not delivered by the stream or was already released")
+	}
+	delete(r.held, k)
+	if r.s.err != nil || r.s.shrink() {
+		return t.free()
+	}
+	if err := t.submit(); err != nil {
+		t.free()
+		r.s.gotError(err)
+		r.s.noMore()
+		return err
+	}
+	// guaranteed to not block, the transfer was taken from the channel.
+	r.s.transfers <- t
+	return nil
+}
+
+// Directive tells ReadStream.Process what to do with a transfer once its
+// data was processed.
+type Directive struct {
+	stop bool
+	// size, if not 0, is the number of bytes requested by the next
+	// submission of the transfer.
+	size int
+}
+
+var (
+	// DirectiveResubmit resubmits the transfer with the same size.
+	DirectiveResubmit = Directive{}
+	// DirectiveStop stops the stream. The data of the transfers still in
+	// flight is discarded.
+	DirectiveStop = Directive{stop: true}
+)
+
+// DirectiveResize resubmits the transfer requesting size bytes, at most
+// the buffer size passed to NewStream. Only the transfer that was just
+// processed is resized, it keeps the new size for its later submissions
+// until it's resized again.
+func DirectiveResize(size int) Directive {
+	return Directive{size: size}
+}
+
+// Process delivers the data of each completed transfer of the stream, in
+// order, to fn and acts on the returned Directive before waiting for the
+// next transfer: the transfer is resubmitted, possibly with a new size, or
+// the stream is stopped. This lets a protocol state machine decide in fn
+// whether and how to continue reading, e.g. stopping at a sentinel frame.
+// data is the transfer buffer itself, it must not be used after fn
+// returns.
+// Process returns nil when fn stops the stream, or when the stream was
+// closed, see Close, and the data of the remaining transfers was
+// processed. Otherwise it returns the error that stopped the stream,
+// including the error of ctx, which aborts the wait for the next transfer.
+// After Process returns, the stream is finished and all subsequent reads
+// return io.ErrClosedPipe. Like Read, Process cannot be called
+// concurrently with other ReadStream methods, except for Utilization;
+// fn can call Close to stop the stream after the transfers in flight.
+// Process can't be used after Results, or while the data of a Read is not
+// entirely consumed.
+func (r *ReadStream) Process(ctx context.Context, fn func(data []byte) Directive) error {
+	if r.results != nil {
+		return errors.New("ReadStream.Process can't be used after ReadStream.Results")
+	}
+	if r.current != nil {
+		return errors.New("ReadStream.Process can't be used while the data of a Read is not consumed")
+	}
+	if r.s.transfers == nil {
+		return io.ErrClosedPipe
+	}
+	for {
+		t, ok := <-r.s.transfers
+		if !ok {
+			r.s.transfers = nil
+			if r.s.err == io.EOF {
+				return nil
+			}
+			return r.s.err
+		}
+		n, err := t.wait(ctx)
+		if err != nil {
+			if r.tolerate(ctx, t, err) {
+				continue
+			}
+			t.free()
+			r.s.flushRemaining()
+			r.s.transfers = nil
+			return err
+		}
+		d := fn(t.data()[:n])
+		switch {
+		case d.stop:
+			t.free()
+			r.s.gotError(io.EOF)
+			r.s.flushRemaining()
+			r.s.transfers = nil
+			return nil
+		case r.s.err != nil || r.s.shrink():
+			t.free()
+			continue
+		case d.size != 0:
+			if err := t.setLength(d.size); err != nil {
+				t.free()
+				r.s.gotError(err)
+				r.s.noMore()
+				continue
+			}
+		}
+		if err := t.submit(); err != nil {
+			t.free()
+			r.s.gotError(err)
+			r.s.noMore()
+			continue
+		}
+		// guaranteed to not block, the transfer was taken from the channel.
+		r.s.transfers <- t
+	}
+}
+
 // Close signals that the transfer should stop. After Close is called,
 // subsequent Read()s will return data from all transfers that were already
 // in progress before returning an io.EOF error, unless another error
-// was encountered earlier.
+// was encountered earlier. After the device was closed, no transfer has
+// data left and Close frees them all, except for the buffers delivered
+// through Results that were not released yet.
 // Close cannot be called concurrently with Read.
 func (r *ReadStream) Close() error {
+	r.rmu.Lock()
+	defer r.rmu.Unlock()
 	if r.s.transfers == nil {
 		return nil
 	}
 	r.s.gotError(io.EOF)
-	r.s.noMore()
+	if r.results != nil || r.s.closed == nil || !r.s.closed() {
+		r.s.noMore()
+		return nil
+	}
+	if r.current != nil {
+		r.current.free()
+		r.current = nil
+	}
+	r.s.flushRemaining()
+	r.s.transfers = nil
 	return nil
 }
 
@@ -184,6 +625,19 @@ func (r *ReadStream) Close() error {
 type WriteStream struct {
 	s     *stream
 	total int
+	// last is the most recently submitted transfer.
+	last transferIntf
+	// underruns is the number of submits that found no transfer in flight.
+	underruns int64
+	// held are the transfers whose buffers were returned by NextBuffer and
+	// not submitted yet, keyed by the start of the buffer.
+	held map[*byte]transferIntf
+	// lowWater and onLowWater are set by WithLowWaterMark, lowWater is 0
+	// if the queue depth is not monitored.
+	lowWater   int
+	onLowWater func(queued int) error
+	// primed is true once lowWater transfers were queued at the same time.
+	primed bool
 }
 
 // Write sends the data to the endpoint. Write returning a nil error doesn't
@@ -226,6 +680,30 @@ func (w *WriteStream) WriteContext(ctx context.Context, p []byte) (int, error) {
 	written := 0
 	all := len(p)
 	for written < all {
+		t, err := w.nextTransfer(ctx)
+		if err != nil {
+			return written, err
+		}
+		use := all - written
+		if max := len(t.data()); use > max {
+			use = max
+		}
+		copy(t.data(), p[written:written+use])
+		if err := w.submitTransfer(t, use); err != nil {
+			return written, err
+		}
+		written += use
+		if err := w.checkLowWater(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// nextTransfer returns the next transfer of the stream that is not in
+// flight, waiting for it to complete if needed.
+func (w *WriteStream) nextTransfer(ctx context.Context) (transferIntf, error) {
+	for {
 		t := <-w.s.transfers
 		n, err := t.wait(ctx) // unsubmitted transfers will return 0 bytes and no error
 		w.total += n
@@ -236,26 +714,128 @@ func (w *WriteStream) WriteContext(ctx context.Context, p []byte) (int, error) {
 			// That means all transfers left in the queue are in flight.
 			// They must be ignored, since this wait() failed.
 			w.s.flushRemaining()
-			return written, err
-		}
-		use := all - written
-		if max := len(t.data()); use > max {
-			use = max
+			return nil, err
 		}
-		copy(t.data(), p[written:written+use])
-		if err := t.submit(); err != nil {
+		if w.s.shrink() {
 			t.free()
-			w.s.gotError(err)
-			// Even though this submit failed, all the transfers in flight are still valid.
-			// Don't flush remaining transfers.
-			// We won't submit any more transfers.
-			w.s.noMore()
-			return written, err
+			continue
 		}
-		written += use
-		w.s.transfers <- t // guaranteed non blocking
+		return t, nil
 	}
-	return written, nil
+}
+
+// submitTransfer submits the first n bytes of a transfer returned by
+// nextTransfer and queues it back on the stream.
+func (w *WriteStream) submitTransfer(t transferIntf, n int) error {
+	err := t.setLength(n)
+	if err == nil {
+		// Transfers complete in order, if the last one is done, nothing
+		// was queued on the endpoint.
+		if w.last != nil && w.last.completed() {
+			atomic.AddInt64(&w.underruns, 1)
+		}
+		err = t.submit()
+	}
+	if err != nil {
+		t.free()
+		w.s.gotError(err)
+		// Even though this submit failed, all the transfers in flight are still valid.
+		// Don't flush remaining transfers.
+		// We won't submit any more transfers.
+		w.s.noMore()
+		return err
+	}
+	w.last = t
+	w.s.transfers <- t // guaranteed non blocking
+	return nil
+}
+
+// checkLowWater reports the queue depth to the WithLowWaterMark callback
+// after a successful submission, if the depth is below the low-water mark. Depths
+// below the mark are expected until the stream is first primed.
+func (w *WriteStream) checkLowWater() error {
+	if w.lowWater == 0 {
+		return nil
+	}
+	queued := w.QueueDepth()
+	if queued >= w.lowWater {
+		w.primed = true
+		return nil
+	}
+	if !w.primed {
+		return nil
+	}
+	if err := w.onLowWater(queued); err != nil {
+		w.s.gotError(err)
+		// The transfers in flight are still valid, as for a failed submit.
+		w.s.noMore()
+		return err
+	}
+	return nil
+}
+
+// QueueDepth returns the number of transfers of the stream queued on the
+// endpoint, i.e. submitted and waiting for the device. For isochronous
+// endpoints, each queued transfer is lead time before the device runs out
+// of data, see WithLowWaterMark and Underruns.
+// QueueDepth can be called concurrently with other WriteStream methods.
+func (w *WriteStream) QueueDepth() int {
+	n, _ := w.s.count(false)
+	return n
+}
+
+// NextBuffer returns the buffer of the next transfer of the stream that is
+// not in flight, waiting for the transfer to complete if needed. The buffer
+// has the size passed to NewStream. Together with SubmitBuffer, NextBuffer
+// is an alternative to Write that avoids copying the data: the data is
+// produced directly in the transfer buffer.
+// The returned buffer is owned by the caller until it's passed to
+// SubmitBuffer. From then on it's owned by the stream and it must not be
+// accessed, not even read, until it's returned again by NextBuffer, which
+// happens only after its transfer completed. Buffers that were not
+// submitted when Close is called are released by Close and must not be
+// used afterwards.
+// The errors of NextBuffer are the same as those of WriteContext.
+// NextBuffer cannot be called concurrently with other WriteStream methods,
+// except for Underruns and Utilization.
+func (w *WriteStream) NextBuffer(ctx context.Context) ([]byte, error) {
+	if w.s.transfers == nil || w.s.err != nil {
+		return nil, io.ErrClosedPipe
+	}
+	t, err := w.nextTransfer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if w.held == nil {
+		w.held = make(map[*byte]transferIntf)
+	}
+	buf := t.data()
+	w.held[bufferKey(buf)] = t
+	return buf, nil
+}
+
+// SubmitBuffer sends buf, a buffer returned by NextBuffer, to the endpoint,
+// handing its ownership back to the stream. buf can be shortened to the
+// length of the data, but it must start at the beginning of the buffer
+// returned by NextBuffer. As with Write, a nil error doesn't mean that the
+// data was written to the device, see Close.
+// SubmitBuffer cannot be called concurrently with other WriteStream
+// methods, except for Underruns and Utilization.
+func (w *WriteStream) SubmitBuffer(buf []byte) error {
+	k := bufferKey(buf)
+	t, ok := w.held[k]
+	if k == nil || !ok {
+		return errors.New("WriteStream.SubmitBuffer: the buffer was not returned by NextBuffer or was already submitted")
+	}
+	delete(w.held, k)
+	if w.s.transfers == nil || w.s.err != nil {
+		t.free()
+		return io.ErrClosedPipe
+	}
+	if err := w.submitTransfer(t, len(buf)); err != nil {
+		return err
+	}
+	return w.checkLowWater()
 }
 
 // Close signals end of data to write. Close blocks until all transfers
@@ -281,6 +861,10 @@ func (w *WriteStream) CloseContext(ctx context.Context) error {
 	if w.s.transfers == nil {
 		return io.ErrClosedPipe
 	}
+	for k, t := range w.held {
+		t.free()
+		delete(w.held, k)
+	}
 	w.s.noMore()
 	for t := range w.s.transfers {
 		n, err := t.wait(ctx)
@@ -296,6 +880,40 @@ func (w *WriteStream) CloseContext(ctx context.Context) error {
 	return w.s.err
 }
 
+// Underruns returns the number of times the stream ran dry: a new
+// transfer was submitted after all the previously submitted transfers had
+// already completed. For isochronous endpoints, for example audio playback,
+// each underrun means a gap in the data stream, service intervals passed
+// without any data sent to the device. An increasing number of underruns
+// signals that the data is not written fast enough, or that the stream
+// needs more or larger transfers to cover the latency of producing the data.
+// Underruns can be called concurrently with other WriteStream methods.
+func (w *WriteStream) Underruns() int {
+	return int(atomic.LoadInt64(&w.underruns))
+}
+
+// SetDepth changes the number of transfers of the stream, as initially
+// requested by the count passed to NewStream. Growing the stream allocates
+// new transfers immediately, they are used by subsequent writes. When
+// shrinking, the excess transfers are released as they complete. More
+// transfers allow more data to be queued for the device, reducing the risk
+// of underruns at the cost of memory and latency.
+// SetDepth cannot be called concurrently with Write, WriteContext or Close.
+func (w *WriteStream) SetDepth(n int) error {
+	return w.s.setDepth(n, false)
+}
+
+// Utilization returns the fraction of the transfers of the stream that
+// are queued on the endpoint, waiting for the device, between 0 and 1.
+// A utilization close to 1 means the pipeline is saturated: the device does
+// not keep up with the writer, and writes will block. A utilization close
+// to 0 means the pipeline is starved: the device has sent all the data and
+// is waiting for more, see Underruns.
+// Utilization can be called concurrently with other WriteStream methods.
+func (w *WriteStream) Utilization() float64 {
+	return w.s.utilization(false)
+}
+
 // Written returns the number of bytes successfully written by the stream.
 // Written may be called only after Close() or CloseContext()
 // has been called and returned.
@@ -306,9 +924,11 @@ func (w *WriteStream) Written() int {
 func newStream(tt []transferIntf) *stream {
 	s := &stream{
 		transfers: make(chan transferIntf, len(tt)),
+		all:       make(map[*trackedTransfer]bool),
+		depth:     len(tt),
 	}
 	for _, t := range tt {
-		s.transfers <- t
+		s.transfers <- s.track(t)
 	}
 	return s
 }
@@ -86,8 +86,10 @@ func (f *fakeStreamTransfer) free() error {
 	return nil
 }
 
-func (f *fakeStreamTransfer) cancel() error { return nil }
-func (f *fakeStreamTransfer) data() []byte  { return fakeTransferBuf }
+func (f *fakeStreamTransfer) cancel() error         { return nil }
+func (f *fakeStreamTransfer) data() []byte          { return fakeTransferBuf }
+func (f *fakeStreamTransfer) setLength(n int) error { return nil }
+func (f *fakeStreamTransfer) completed() bool       { return !f.inFlight }
 
 var errSentinel = errors.New("sentinel error")
 
@@ -355,3 +357,128 @@ func TestTransferWriteStream(t *testing.T) {
 		})
 	}
 }
+
+func TestReadStreamProcess(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+	// The device sends frames 1, 2, 3, a sentinel 0xff and more frames,
+	// which must not be processed.
+	done := make(chan struct{})
+	defer close(done)
+	sizes := make(chan int, 16)
+	go func() {
+		for i := 1; ; i++ {
+			ft := lib.waitForSubmitted(done)
+			if ft == nil {
+				return
+			}
+			ft.mu.Lock()
+			sizes <- ft.maxLength
+			ft.mu.Unlock()
+			v := byte(i)
+			if i == 4 {
+				v = 0xff
+			}
+			ft.setData([]byte{v})
+			ft.setStatus(TransferCompleted)
+		}
+	}()
+
+	s, err := ep.NewStream(512, 2)
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	var got []byte
+	err = s.Process(context.Background(), func(data []byte) Directive {
+		got = append(got, data...)
+		switch {
+		case data[0] == 0xff:
+			return DirectiveStop
+		case data[0] == 1:
+			return DirectiveResize(64)
+		}
+		return DirectiveResubmit
+	})
+	if err != nil {
+		t.Errorf("Process(): %v", err)
+	}
+	if want := []byte{1, 2, 3, 0xff}; !bytes.Equal(got, want) {
+		t.Errorf("processed data: got %v, want %v", got, want)
+	}
+	// The two initial transfers, the resized resubmission of the first
+	// one, then the second one and another full-size resubmission.
+	var gotSizes []int
+	for len(gotSizes) < 4 {
+		gotSizes = append(gotSizes, <-sizes)
+	}
+	if want := []int{512, 512, 64, 512}; !reflect.DeepEqual(gotSizes, want) {
+		t.Errorf("requested transfer sizes: got %v, want %v", gotSizes, want)
+	}
+	if _, err := s.Read(make([]byte, 512)); err != io.ErrClosedPipe {
+		t.Errorf("Read() after Process(): got error %v, want %v", err, io.ErrClosedPipe)
+	}
+	if err := s.Process(context.Background(), func([]byte) Directive { return DirectiveResubmit }); err != io.ErrClosedPipe {
+		t.Errorf("Process() after the stream stopped: got error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestReadStreamOnError(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+
+	// The device stalls, sends a frame, times out, sends another frame
+	// and then fails at the bus level, which is fatal.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		steps := []TransferStatus{TransferStall, TransferCompleted, TransferTimedOut, TransferCompleted, TransferError}
+		for i, st := range steps {
+			ft := lib.waitForSubmitted(done)
+			if ft == nil {
+				return
+			}
+			if st == TransferCompleted {
+				ft.setData([]byte{byte(i)})
+			}
+			ft.setStatus(st)
+		}
+	}()
+
+	s, err := ep.NewStream(512, 1)
+	if err != nil {
+		t.Fatalf("NewStream(): %v", err)
+	}
+	var seen []error
+	s.OnError(func(err error) bool {
+		seen = append(seen, err)
+		return !errors.Is(err, ErrTransfer)
+	})
+	buf := make([]byte, 512)
+	var got []byte
+	for {
+		n, err := s.Read(buf)
+		if err != nil {
+			if !errors.Is(err, ErrTransfer) {
+				t.Errorf("Read(): got error %v, want %v", err, ErrTransfer)
+			}
+			break
+		}
+		got = append(got, buf[:n]...)
+	}
+	if want := []byte{1, 3}; !bytes.Equal(got, want) {
+		t.Errorf("data read: got %v, want %v", got, want)
+	}
+	if want := []error{ErrStall, TransferTimedOut, ErrTransfer}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("errors passed to OnError: got %v, want %v", seen, want)
+	}
+	if _, err := s.Read(buf); err != io.ErrClosedPipe {
+		t.Errorf("Read() after the fatal error: got error %v, want %v", err, io.ErrClosedPipe)
+	}
+}
@@ -15,8 +15,16 @@
 package gousb
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 func TestNewTransfer(t *testing.T) {
@@ -80,6 +88,90 @@ func TestNewTransfer(t *testing.T) {
 	}
 }
 
+func TestNewTransferValidation(t *testing.T) {
+	t.Parallel()
+	ctx := newContextWithImpl(newFakeLibusb())
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		desc    string
+		tt      TransferType
+		maxPkt  int
+		wantErr bool
+	}{
+		{"bulk", TransferTypeBulk, 512, false},
+		{"interrupt", TransferTypeInterrupt, 64, false},
+		{"isochronous", TransferTypeIsochronous, 1024, false},
+		{"bulk without max packet size", TransferTypeBulk, 0, false},
+		{"isochronous without max packet size", TransferTypeIsochronous, 0, true},
+		{"control", TransferTypeControl, 64, true},
+		{"unknown transfer type", TransferType(7), 64, true},
+	} {
+		xfer, err := newUSBTransfer(ctx, nil, &EndpointDesc{
+			Number:        2,
+			Direction:     EndpointDirectionIn,
+			TransferType:  tc.tt,
+			MaxPacketSize: tc.maxPkt,
+		}, 1024)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: newUSBTransfer(): got error %v, want error: %v", tc.desc, err, tc.wantErr)
+		}
+		if err == nil {
+			xfer.free()
+		}
+	}
+}
+
+func TestIsoPacketCount(t *testing.T) {
+	t.Parallel()
+	f := newFakeLibusb()
+	ctx := newContextWithImpl(f)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		tt     TransferType
+		maxPkt int
+		buf    int
+		want   int
+	}{
+		{TransferTypeIsochronous, 1024, 1024, 1},
+		{TransferTypeIsochronous, 1024, 8192, 8},
+		{TransferTypeIsochronous, 1024, 8000, 7},
+		{TransferTypeIsochronous, 3 * 1024, 10000, 3},
+		{TransferTypeIsochronous, 1024, 100, 1},
+		{TransferTypeIsochronous, 1024, 0, 1},
+		{TransferTypeBulk, 512, 2048, 0},
+		{TransferTypeInterrupt, 64, 2048, 0},
+	} {
+		ei := &EndpointDesc{
+			Number:        6,
+			Direction:     EndpointDirectionIn,
+			TransferType:  tc.tt,
+			MaxPacketSize: tc.maxPkt,
+		}
+		got := ei.IsoPacketCount(tc.buf)
+		if got != tc.want {
+			t.Errorf("%s.IsoPacketCount(%d): got %d, want %d", ei, tc.buf, got, tc.want)
+		}
+		xfer, err := newUSBTransfer(ctx, nil, ei, tc.buf)
+		if err != nil {
+			t.Fatalf("newUSBTransfer(%s, %d): %v", ei, tc.buf, err)
+		}
+		if alloc := f.ts[xfer.xfer].isoPackets; got != alloc {
+			t.Errorf("%s.IsoPacketCount(%d): got %d, but newUSBTransfer allocated %d packets", ei, tc.buf, got, alloc)
+		}
+		xfer.free()
+	}
+}
+
 func TestTransferProtocol(t *testing.T) {
 	t.Parallel()
 	f := newFakeLibusb()
@@ -154,6 +246,113 @@ func TestTransferProtocol(t *testing.T) {
 	}
 }
 
+func TestTransferInFlight(t *testing.T) {
+	t.Parallel()
+	f := newFakeLibusb()
+	ctx := newContextWithImpl(f)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	ep := &endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x86,
+		Number:        6,
+		Direction:     EndpointDirectionIn,
+		TransferType:  TransferTypeBulk,
+		MaxPacketSize: 512,
+	}}
+	xfer, err := ep.newTransfer(512)
+	if err != nil {
+		t.Fatalf("newTransfer: %v", err)
+	}
+	defer xfer.Free()
+
+	if xfer.InFlight() {
+		t.Error("InFlight() before Submit(): got true, want false")
+	}
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	ft := f.waitForSubmitted(nil)
+	if !xfer.InFlight() {
+		t.Error("InFlight() after Submit(): got false, want true")
+	}
+
+	waitDone := make(chan struct{})
+	go func() {
+		xfer.Wait(context.Background())
+		close(waitDone)
+	}()
+	// InFlight must not block while Wait is in progress.
+	if !xfer.InFlight() {
+		t.Error("InFlight() during Wait(): got false, want true")
+	}
+	ft.setData([]byte{1, 2, 3})
+	ft.setStatus(TransferCompleted)
+	<-waitDone
+	if xfer.InFlight() {
+		t.Error("InFlight() after Wait(): got true, want false")
+	}
+}
+
+func TestTransferSetTimeout(t *testing.T) {
+	t.Parallel()
+	f := newFakeLibusb()
+	ctx := newContextWithImpl(f)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	ep := &endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x86,
+		Number:        6,
+		Direction:     EndpointDirectionIn,
+		TransferType:  TransferTypeBulk,
+		MaxPacketSize: 512,
+	}}
+	xfer, err := ep.newTransfer(512)
+	if err != nil {
+		t.Fatalf("newTransfer: %v", err)
+	}
+	defer xfer.Free()
+
+	if got := xfer.Timeout(); got != 0 {
+		t.Errorf("Timeout() of a new transfer: got %v, want 0", got)
+	}
+	if err := xfer.SetTimeout(-time.Second); err == nil {
+		t.Error("SetTimeout(-1s): got nil error, want non-nil")
+	}
+	for _, timeout := range []time.Duration{5 * time.Second, 100 * time.Millisecond} {
+		if err := xfer.SetTimeout(timeout); err != nil {
+			t.Fatalf("SetTimeout(%v): %v", timeout, err)
+		}
+		if got := xfer.Timeout(); got != timeout {
+			t.Errorf("Timeout(): got %v, want %v", got, timeout)
+		}
+		if err := xfer.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+		ft := f.waitForSubmitted(nil)
+		ft.mu.Lock()
+		got := ft.timeout
+		ft.mu.Unlock()
+		if got != timeout {
+			t.Errorf("libusb transfer timeout at submission: got %v, want %v", got, timeout)
+		}
+		if err := xfer.SetTimeout(time.Minute); err == nil {
+			t.Error("SetTimeout() on a submitted transfer: got nil error, want non-nil")
+		}
+		ft.setStatus(TransferTimedOut)
+		if _, err := xfer.Wait(context.Background()); err != TransferTimedOut {
+			t.Errorf("Wait(): got error %v, want %v", err, TransferTimedOut)
+		}
+	}
+}
+
 func BenchmarkSubSlice(b *testing.B) {
 	x := make([]byte, 512)
 	start, len := 50, 50
@@ -170,3 +369,701 @@ func BenchmarkSubSlice(b *testing.B) {
 		}
 	})
 }
+
+func TestTransferOnComplete(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeInterrupt,
+	}}}
+	xfer, err := ep.NewTransfer(64)
+	if err != nil {
+		t.Fatalf("NewTransfer(): %v", err)
+	}
+	defer xfer.Free()
+
+	type result struct {
+		n    int
+		err  error
+		data []byte
+	}
+	results := make(chan result, 3)
+	xfer.OnComplete(func(n int, err error) {
+		results <- result{n, err, append([]byte(nil), xfer.Data()[:n]...)}
+		if err == nil {
+			// resubmit from the callback, as an event-driven reader would.
+			if err := xfer.Submit(); err != nil {
+				t.Errorf("Submit() from OnComplete: %v", err)
+			}
+		}
+	})
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	for _, d := range [][]byte{{1, 2}, {3, 4, 5}} {
+		ft := lib.waitForSubmitted(nil)
+		ft.setData(d)
+		ft.setStatus(TransferCompleted)
+		if got := <-results; got.err != nil || !reflect.DeepEqual(got.data, d) {
+			t.Errorf("OnComplete: got %d bytes %v, error %v, want %v, nil", got.n, got.data, got.err, d)
+		}
+	}
+	// The transfer was resubmitted by the callback, Cancel aborts it
+	// while it's in flight.
+	lib.waitForSubmitted(nil)
+	if err := xfer.Cancel(); err != nil {
+		t.Errorf("Cancel(): %v", err)
+	}
+	if got := <-results; got.err != TransferCancelled {
+		t.Errorf("OnComplete after Cancel: got error %v, want %v", got.err, TransferCancelled)
+	}
+}
+
+func TestTransferOnCompleteOrder(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+
+	var (
+		mu      sync.Mutex
+		active  int
+		got     []int
+		results = make(chan error, 3)
+	)
+	byFake := make(map[*fakeTransfer]int)
+	var xfers []*Transfer
+	for i := 0; i < 3; i++ {
+		x, err := ep.NewTransfer(64)
+		if err != nil {
+			t.Fatalf("NewTransfer(): %v", err)
+		}
+		i := i
+		x.OnComplete(func(n int, err error) {
+			mu.Lock()
+			active++
+			if active > 1 {
+				t.Errorf("OnComplete functions called concurrently")
+			}
+			got = append(got, i)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			if err == TransferCancelled {
+				// The Context is closing.
+				if err := x.Free(); err != nil {
+					t.Errorf("Free(): %v", err)
+				}
+			}
+			results <- err
+		})
+		xfers = append(xfers, x)
+	}
+	for _, x := range xfers {
+		if err := x.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+		byFake[lib.waitForSubmitted(nil)] = len(byFake)
+	}
+	fts := make([]*fakeTransfer, 3)
+	for ft, i := range byFake {
+		fts[i] = ft
+	}
+	for _, i := range []int{2, 0, 1} {
+		fts[i].setStatus(TransferCompleted)
+	}
+	for range xfers {
+		if err := <-results; err != nil {
+			t.Errorf("OnComplete: got error %v, want nil", err)
+		}
+	}
+	if want := []int{2, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OnComplete order: got %v, want %v", got, want)
+	}
+
+	// Close cancels the transfers in flight and returns once their
+	// results are delivered.
+	for _, x := range xfers {
+		if err := x.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+	}
+	if err := ctx.Close(); err != nil {
+		t.Fatalf("Context.Close(): %v", err)
+	}
+	if len(results) != len(xfers) {
+		t.Fatalf("after Context.Close: got %d results, want %d", len(results), len(xfers))
+	}
+	for range xfers {
+		if err := <-results; err != TransferCancelled {
+			t.Errorf("OnComplete after Context.Close: got error %v, want %v", err, TransferCancelled)
+		}
+	}
+}
+
+func TestTransferStatusErrors(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+
+	for _, tc := range []struct {
+		status TransferStatus
+		want   error
+	}{
+		{TransferStall, ErrStall},
+		{TransferOverflow, ErrOverflow},
+		{TransferError, ErrTransfer},
+		{TransferNoDevice, TransferNoDevice},
+	} {
+		xfer, err := newUSBTransfer(ctx, nil, &ep.Desc, 512)
+		if err != nil {
+			t.Fatalf("newUSBTransfer(): %v", err)
+		}
+		go func(st TransferStatus) {
+			lib.waitForSubmitted(nil).setStatus(st)
+		}(tc.status)
+		xfer.submit()
+		_, err = xfer.wait(context.Background())
+		xfer.free()
+		if err != tc.want {
+			t.Errorf("wait() with status %d: got error %v, want %v", tc.status, err, tc.want)
+		}
+		if !errors.Is(err, tc.status) {
+			t.Errorf("errors.Is(%v, %v): got false, want true", err, tc.status)
+		}
+
+		go func(st TransferStatus) {
+			lib.waitForSubmitted(nil).setStatus(st)
+		}(tc.status)
+		_, err = ep.Read(make([]byte, 512))
+		if !errors.Is(err, tc.want) || !errors.Is(err, tc.status) {
+			t.Errorf("Read() with status %d: got error %v, want an error matching %v and %v", tc.status, err, tc.want, tc.status)
+		}
+	}
+}
+
+func TestWrapTransfer(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	if _, err := ctx.WrapTransfer(nil); err == nil {
+		t.Error("WrapTransfer(nil): got nil error, want non-nil")
+	}
+
+	raw := lib.rawTransfer(&EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}, 64)
+	xfer, err := ctx.WrapTransfer(unsafe.Pointer(raw))
+	if err != nil {
+		t.Fatalf("WrapTransfer(%p): %v", raw, err)
+	}
+	if _, err := ctx.WrapTransfer(unsafe.Pointer(raw)); err == nil {
+		t.Errorf("WrapTransfer(%p) for the second time: got nil error, want non-nil", raw)
+	}
+	if got, want := len(xfer.Data()), 64; got != want {
+		t.Errorf("len(Data()): got %d, want %d", got, want)
+	}
+
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	ft := lib.waitForSubmitted(nil)
+	ft.setData([]byte{1, 2, 3})
+	ft.setStatus(TransferCompleted)
+	n, err := xfer.Wait(context.Background())
+	if err != nil || n != 3 {
+		t.Errorf("Wait(): got %d, %v, want 3, nil", n, err)
+	}
+	if got, want := xfer.Data()[:n], []byte{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Data(): got %v, want %v", got, want)
+	}
+
+	if err := xfer.Free(); err != nil {
+		t.Errorf("Free(): %v", err)
+	}
+	// The transfer memory still belongs to the caller and can be wrapped again.
+	lib.mu.Lock()
+	_, ok := lib.ts[raw]
+	lib.mu.Unlock()
+	if !ok {
+		t.Fatalf("Free() released a transfer owned by the caller")
+	}
+	xfer, err = ctx.WrapTransfer(unsafe.Pointer(raw))
+	if err != nil {
+		t.Fatalf("WrapTransfer(%p) after Free: %v", raw, err)
+	}
+	xfer.Free()
+	// libusb_free_transfer, called by the owner.
+	lib.free(raw)
+}
+
+func TestTransferIsoPackets(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	ep := &InEndpoint{&endpoint{ctx: ctx, Desc: EndpointDesc{
+		Address:       0x83,
+		Number:        3,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 100,
+		TransferType:  TransferTypeIsochronous,
+	}}}
+	xfer, err := ep.NewTransfer(300)
+	if err != nil {
+		t.Fatalf("NewTransfer(300): %v", err)
+	}
+	defer xfer.Free()
+
+	want := []IsoPacket{
+		{Length: 100, ActualLength: 100, Status: TransferCompleted},
+		{Length: 100, ActualLength: 40, Status: TransferCompleted},
+		{Length: 100, ActualLength: 0, Status: TransferError},
+	}
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	ft := lib.waitForSubmitted(nil)
+	if got := xfer.IsoPackets(); got != nil {
+		t.Errorf("IsoPackets() while in flight: got %v, want nil", got)
+	}
+	ft.mu.Lock()
+	ft.isoResults = want
+	ft.mu.Unlock()
+	ft.setData(make([]byte, 140))
+	ft.setStatus(TransferError)
+	xfer.Wait(context.Background())
+	if got := xfer.IsoPackets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("IsoPackets(): got %+v, want %+v", got, want)
+	}
+}
+
+func TestLibusbIsoPackets(t *testing.T) {
+	var impl libusbImpl
+	ep := &EndpointDesc{
+		Number:        3,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 100,
+		TransferType:  TransferTypeIsochronous,
+	}
+	xfer, err := impl.alloc(nil, ep, 3, 300, make(chan struct{}, 1))
+	if err != nil {
+		t.Fatalf("alloc(): %v", err)
+	}
+	defer impl.free(xfer)
+	impl.setLength(xfer, 250, []int{100, 100, 50})
+	want := []IsoPacket{{Length: 100}, {Length: 100}, {Length: 50}}
+	if got := impl.isoPackets(xfer, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("isoPackets(): got %+v, want %+v", got, want)
+	}
+}
+
+func TestLibusbTransferTimeout(t *testing.T) {
+	var impl libusbImpl
+	ep := &EndpointDesc{
+		Number:        2,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 64,
+		TransferType:  TransferTypeInterrupt,
+	}
+	xfer, err := impl.alloc(nil, ep, 0, 64, make(chan struct{}, 1))
+	if err != nil {
+		t.Fatalf("alloc(): %v", err)
+	}
+	defer impl.free(xfer)
+	if got := impl.timeout(xfer); got != 0 {
+		t.Errorf("timeout() of a new transfer: got %v, want 0", got)
+	}
+	impl.setTimeout(xfer, 1500*time.Millisecond)
+	if got, want := impl.timeout(xfer), 1500*time.Millisecond; got != want {
+		t.Errorf("timeout(): got %v, want %v", got, want)
+	}
+}
+
+func TestLibusbIsoCompaction(t *testing.T) {
+	var impl libusbImpl
+	ep := &EndpointDesc{
+		Number:        3,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 4,
+		TransferType:  TransferTypeIsochronous,
+	}
+	type pkt struct {
+		actual int
+		status TransferStatus
+	}
+	for _, tc := range []struct {
+		desc       string
+		pkts       []pkt
+		wantData   []byte
+		wantStatus TransferStatus
+	}{
+		{
+			desc:       "all completed",
+			pkts:       []pkt{{4, TransferCompleted}, {2, TransferCompleted}, {4, TransferCompleted}},
+			wantData:   []byte{0, 1, 2, 3, 10, 11, 20, 21, 22, 23},
+			wantStatus: TransferCompleted,
+		},
+		{
+			desc:       "middle packet failed",
+			pkts:       []pkt{{4, TransferCompleted}, {0, TransferError}, {3, TransferCompleted}},
+			wantData:   []byte{0, 1, 2, 3, 20, 21, 22},
+			wantStatus: TransferPartial,
+		},
+		{
+			desc:       "first packet failed",
+			pkts:       []pkt{{0, TransferOverflow}, {4, TransferCompleted}, {1, TransferCompleted}},
+			wantData:   []byte{10, 11, 12, 13, 20},
+			wantStatus: TransferPartial,
+		},
+		{
+			desc:       "all failed",
+			pkts:       []pkt{{0, TransferStall}, {0, TransferError}, {0, TransferError}},
+			wantData:   []byte{},
+			wantStatus: TransferStall,
+		},
+	} {
+		xfer, err := impl.alloc(nil, ep, 3, 12, make(chan struct{}, 1))
+		if err != nil {
+			t.Fatalf("alloc(): %v", err)
+		}
+		impl.setIsoPacketLengths(xfer, 4)
+		buf := impl.buffer(xfer)
+		for i := range buf {
+			buf[i] = byte(10*(i/4) + i%4)
+		}
+		for i, p := range tc.pkts {
+			setIsoPacketResult(xfer, i, p.actual, p.status)
+		}
+		n, status := impl.data(xfer)
+		if got := buf[:n]; !reflect.DeepEqual(got, tc.wantData) || status != tc.wantStatus {
+			t.Errorf("%s: data(): got %v, %s, want %v, %s", tc.desc, got, status, tc.wantData, tc.wantStatus)
+		}
+		impl.free(xfer)
+	}
+}
+
+func TestLibusbSharedBuffer(t *testing.T) {
+	var impl libusbImpl
+	ep := &EndpointDesc{
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 64,
+		TransferType:  TransferTypeBulk,
+	}
+	mem, err := impl.allocBuffer(128)
+	if err != nil {
+		t.Fatalf("allocBuffer(128): %v", err)
+	}
+	defer impl.freeBuffer(mem)
+	for i, off := range []int{0, 64} {
+		xfer, err := impl.allocWithBuffer(nil, ep, 0, mem[off:off+64], make(chan struct{}, 1))
+		if err != nil {
+			t.Fatalf("allocWithBuffer(): %v", err)
+		}
+		if got, want := impl.buffer(xfer), mem[off:off+64]; len(got) != len(want) || &got[0] != &want[0] {
+			t.Errorf("transfer #%d: buffer at %p (%d bytes), want %p (%d bytes)", i, &got[0], len(got), &want[0], len(want))
+		}
+		impl.freeTransfer(xfer)
+	}
+}
+
+func TestSingleOwnerTransfer(t *testing.T) {
+	t.Parallel()
+	f := newFakeLibusb()
+	ctx := newContextWithImpl(f)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	defer done()
+	ep, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	xfer, err := ep.NewTransfer(512, WithSingleOwner())
+	if err != nil {
+		t.Fatalf("%s.NewTransfer(512, WithSingleOwner()): %v", ep, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := xfer.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+		ft := f.waitForSubmitted(nil)
+		ft.setData([]byte{1, 2, 3})
+		ft.setStatus(TransferCompleted)
+		if n, err := xfer.Wait(context.Background()); n != 3 || err != nil {
+			t.Errorf("Wait(): got %d, %v, want 3, nil", n, err)
+		}
+	}
+
+	// Device.Close cancels the transfer in flight, but leaves the result
+	// and the release of the transfer to its owner.
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	f.waitForSubmitted(nil)
+	if err := dev.Close(); err != nil {
+		t.Fatalf("%s.Close(): %v", dev, err)
+	}
+	if _, err := xfer.Wait(context.Background()); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Wait() after Device.Close(): got error %v, want %v", err, ErrDeviceClosed)
+	}
+	if err := xfer.Submit(); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Submit() after Device.Close(): got error %v, want %v", err, ErrDeviceClosed)
+	}
+	if err := xfer.Free(); err != nil {
+		t.Errorf("Free(): %v", err)
+	}
+}
+
+// slowCancelLib is a fakeLibusb that completes cancelled transfers after a
+// delay and records whether a device handle was closed while a cancelled
+// transfer was still in flight.
+type slowCancelLib struct {
+	*fakeLibusb
+
+	mu sync.Mutex
+	// cancelled are the transfers whose cancellation was requested.
+	cancelled []*fakeTransfer
+	// closedInFlight is set if close was called before all cancelled
+	// transfers completed.
+	closedInFlight bool
+}
+
+func (f *slowCancelLib) cancel(t *libusbTransfer) error {
+	f.fakeLibusb.mu.Lock()
+	ft := f.ts[t]
+	f.fakeLibusb.mu.Unlock()
+	f.mu.Lock()
+	f.cancelled = append(f.cancelled, ft)
+	f.mu.Unlock()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ft.setStatus(TransferCancelled)
+	}()
+	return nil
+}
+
+func (f *slowCancelLib) close(h *libusbDevHandle) {
+	f.mu.Lock()
+	for _, ft := range f.cancelled {
+		ft.mu.Lock()
+		if !ft.finished {
+			f.closedInFlight = true
+		}
+		ft.mu.Unlock()
+	}
+	f.mu.Unlock()
+	f.fakeLibusb.close(h)
+}
+
+func TestSingleOwnerTransferDeviceClose(t *testing.T) {
+	t.Parallel()
+	f := &slowCancelLib{fakeLibusb: newFakeLibusb()}
+	ctx := newContextWithImpl(f)
+	defer ctx.Close()
+	dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	intf, done, err := dev.DefaultInterface()
+	if err != nil {
+		t.Fatalf("%s.DefaultInterface(): %v", dev, err)
+	}
+	ep, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	xfer, err := ep.NewTransfer(512, WithSingleOwner())
+	if err != nil {
+		t.Fatalf("%s.NewTransfer(512, WithSingleOwner()): %v", ep, err)
+	}
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	f.waitForSubmitted(nil)
+	done()
+	if err := dev.Close(); err != nil {
+		t.Fatalf("%s.Close(): %v", dev, err)
+	}
+	f.mu.Lock()
+	if f.closedInFlight {
+		t.Error("Device.Close() closed the device handle with a single-owner transfer still in flight")
+	}
+	f.mu.Unlock()
+	if _, err := xfer.Wait(context.Background()); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("Wait() after Device.Close(): got error %v, want %v", err, ErrDeviceClosed)
+	}
+	if err := xfer.Free(); err != nil {
+		t.Errorf("Free(): %v", err)
+	}
+}
+
+// submitErrLib is a fakeLibusb that fails all submissions with err.
+type submitErrLib struct {
+	*fakeLibusb
+	err error
+}
+
+func (f *submitErrLib) submit(*libusbTransfer) error {
+	return f.err
+}
+
+func TestSubmitError(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		err      Error
+		wantHint string
+	}{
+		{ErrorNoDevice, "disconnected"},
+		{ErrorBusy, "already in flight"},
+		{ErrorNoMem, "usbfs_memory_mb"},
+		{ErrorInvalidParam, "not supported"},
+		{ErrorIO, ""},
+	} {
+		lib := &submitErrLib{newFakeLibusb(), tc.err}
+		ctx := newContextWithImpl(lib)
+		ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+		x, err := ep.NewTransfer(512)
+		if err != nil {
+			t.Fatalf("NewTransfer(): %v", err)
+		}
+		err = x.Submit()
+		var se *SubmitError
+		if !errors.As(err, &se) {
+			t.Errorf("Submit() with libusb error %v: got error %v, want a *SubmitError", tc.err, err)
+		} else {
+			if se.Endpoint != 0x81 || se.Length != 512 {
+				t.Errorf("Submit() with libusb error %v: got endpoint %s, length %d, want 0x81, 512", tc.err, se.Endpoint, se.Length)
+			}
+			if tc.wantHint != "" && !strings.Contains(se.Error(), tc.wantHint) {
+				t.Errorf("Submit() with libusb error %v: got message %q, want it to contain %q", tc.err, se.Error(), tc.wantHint)
+			}
+		}
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Submit() with libusb error %v: got error %v, want it to wrap %v", tc.err, err, tc.err)
+		}
+		// A failed submission leaves the transfer ready for another one.
+		if _, err := x.Wait(context.Background()); err != nil {
+			t.Errorf("Wait() after a failed Submit(): %v", err)
+		}
+		x.Free()
+		ctx.Close()
+	}
+}
+
+func TestTransferDebugFreed(t *testing.T) {
+	t.Parallel()
+	for _, debug := range []bool{false, true} {
+		var opts []ContextOption
+		if debug {
+			opts = append(opts, WithTransferDebug())
+		}
+		ctx := newContextWithImpl(newFakeLibusb(), opts...)
+		ep := &InEndpoint{newNullEndpoint(ctx, EndpointDirectionIn)}
+		xfer, err := ep.NewTransfer(512)
+		if err != nil {
+			t.Fatalf("NewTransfer(512): %v", err)
+		}
+		buf := xfer.Data()
+		copy(buf, "secret")
+		if err := xfer.Free(); err != nil {
+			t.Fatalf("Free(): %v", err)
+		}
+		if err := xfer.Free(); err != nil {
+			t.Errorf("second Free(): %v", err)
+		}
+		if poisoned := buf[0] == poisonByte && bytes.Count(buf, []byte{poisonByte}) == len(buf); poisoned != debug {
+			t.Errorf("debug %v: buffer of the freed transfer starts with %x, poisoned: %v, want %v", debug, buf[:6], poisoned, debug)
+		}
+		for _, op := range []struct {
+			name string
+			fn   func() error
+		}{
+			{"Submit", xfer.Submit},
+			{"Wait", func() error { _, err := xfer.Wait(context.Background()); return err }},
+		} {
+			var panicMsg string
+			err := func() error {
+				defer func() {
+					if r := recover(); r != nil {
+						panicMsg = fmt.Sprint(r)
+					}
+				}()
+				return op.fn()
+			}()
+			switch {
+			case !debug && (panicMsg != "" || err == nil):
+				t.Errorf("%s() on a freed transfer: got error %v, panic %q, want an error", op.name, err, panicMsg)
+			case debug && (!strings.Contains(panicMsg, "freed transfer") || !strings.Contains(panicMsg, "TestTransferDebugFreed")):
+				t.Errorf("%s() on a freed transfer with WithTransferDebug: got panic %q, want a panic naming the freed transfer and the caller of Free", op.name, panicMsg)
+			}
+		}
+		// The garbage collector finalizes freed transfers too.
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("debug %v: finalizer of a freed transfer panicked: %v", debug, r)
+				}
+			}()
+			finalizeTransfer(xfer.t)
+		}()
+		ctx.Close()
+	}
+}
@@ -24,3 +24,30 @@ void gousb_set_debug(libusb_context *ctx, int lvl) {
     libusb_set_debug(ctx, lvl);
 #endif
 }
+
+void gousb_interrupt_event_handler(libusb_context *ctx) {
+    // libusb_interrupt_event_handler was added in libusb 1.0.21, which sets
+    // API version 0x01000105. With older versions, the event handler
+    // returns only when its timeout expires.
+#if LIBUSB_API_VERSION >= 0x01000105
+    libusb_interrupt_event_handler(ctx);
+#endif
+}
+
+int gousb_handle_events(libusb_context *ctx, long long timeout_usec) {
+    struct timeval tv;
+    tv.tv_sec = timeout_usec / 1000000;
+    tv.tv_usec = timeout_usec % 1000000;
+    return libusb_handle_events_timeout_completed(ctx, &tv, NULL);
+}
+
+int hotplugCallback(libusb_context *ctx, libusb_device *dev, int event, void *user_data);
+
+int gousb_hotplug_register(libusb_context *ctx, int id, libusb_hotplug_callback_handle *handle) {
+    // The id of the Go callback is passed in user_data, Go pointers can't
+    // be stored in C memory.
+    return libusb_hotplug_register_callback(ctx,
+        LIBUSB_HOTPLUG_EVENT_DEVICE_ARRIVED | LIBUSB_HOTPLUG_EVENT_DEVICE_LEFT, 0,
+        LIBUSB_HOTPLUG_MATCH_ANY, LIBUSB_HOTPLUG_MATCH_ANY, LIBUSB_HOTPLUG_MATCH_ANY,
+        (libusb_hotplug_callback_fn)hotplugCallback, (void *)(intptr_t)id, handle);
+}
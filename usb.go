@@ -16,7 +16,7 @@
 /*
 Package gousb provides an low-level interface to attached USB devices.
 
-A Short Tutorial
+# A Short Tutorial
 
 A Context manages all resources necessary for communicating with USB
 devices.
@@ -117,11 +117,10 @@ standard for control commands though, and many devices implement their custom co
 
 Control commands can be issued through Device.Control().
 
-See Also
+# See Also
 
 For more information about USB protocol and handling USB devices,
 see the excellent "USB in a nutshell" guide: http://www.beyondlogic.org/usbnutshell/
-
 */
 package gousb
 
@@ -129,16 +128,52 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
+// cancelTimeout is how long Close waits for libusb to report the
+// completion of the cancelled transfers.
+const cancelTimeout = 5 * time.Second
+
 // Context manages all resources related to USB device handling.
 type Context struct {
 	ctx    *libusbContext
 	done   chan struct{}
 	libusb libusbIntf
+	// loopDone is closed when the event loop returns after done is closed.
+	loopDone chan struct{}
 
 	mu      sync.Mutex
 	devices map[*Device]bool
+	// notifiers are the active Notifications channels.
+	notifiers map[*notifier]bool
+
+	// xferMu protects xfers and closing.
+	xferMu sync.RWMutex
+	// xfers are all the allocated transfers, used by Close to cancel
+	// the transfers still in flight.
+	xfers map[*usbTransfer]bool
+	// closing is set by Close, no transfers are allocated or submitted
+	// afterwards.
+	closing bool
+	// eventLoopErr is the failure to apply the event loop options.
+	eventLoopErr error
+	// recorder, if not nil, records all transfers, see
+	// WithTransferRecorder.
+	recorder *TransferRecorder
+	// debugTransfers enables the lifecycle checks of WithTransferDebug.
+	debugTransfers bool
+	// replay, if not nil, completes the transfers instead of libusb, see
+	// WithTransferReplay.
+	replay *TransferReplay
+	// completions delivers the results of the transfers with an
+	// OnComplete function.
+	completions *completionQueue
+	// xferSignal is signalled when a transfer of the Context completes or
+	// is no longer in flight, see awaitCompletion.
+	xferSignal broadcast
 }
 
 // Debug changes the debug level. Level 0 means no debug, higher levels
@@ -149,24 +184,47 @@ func (c *Context) Debug(level int) {
 	c.libusb.setDebug(c.ctx, level)
 }
 
-func newContextWithImpl(impl libusbIntf) *Context {
+func newContextWithImpl(impl libusbIntf, opts ...ContextOption) *Context {
 	c, err := impl.init()
 	if err != nil {
 		panic(err)
 	}
 	ctx := &Context{
-		ctx:     c,
-		done:    make(chan struct{}),
-		libusb:  impl,
-		devices: make(map[*Device]bool),
+		ctx:         c,
+		done:        make(chan struct{}),
+		loopDone:    make(chan struct{}),
+		libusb:      impl,
+		devices:     make(map[*Device]bool),
+		notifiers:   make(map[*notifier]bool),
+		xfers:       make(map[*usbTransfer]bool),
+		completions: newCompletionQueue(),
 	}
-	go impl.handleEvents(ctx.ctx, ctx.done)
+	o := &contextOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	ctx.recorder = o.recorder
+	ctx.debugTransfers = o.debugTransfers
+	ctx.replay = o.replay
+	ctx.eventLoopErr = ctx.startEventLoop(o)
 	return ctx
 }
 
 // NewContext returns a new Context instance.
-func NewContext() *Context {
-	return newContextWithImpl(libusbImpl{})
+//
+// Multiple Contexts can be used at the same time, e.g. to isolate
+// independent subsystems of an application. Each Context has its own libusb
+// context and its own event handling goroutine, and it can be closed
+// independently of the others. Devices, and all configs, interfaces,
+// endpoints and streams obtained from them, belong to the Context that
+// opened them. The only state shared between Contexts is the registry of
+// RegisterDescriptorParser and the limit of SetMaxConcurrentTransfers,
+// which counts the transfers of all Contexts.
+//
+// Options can tune the event handling goroutine, see WithEventLoopCPU,
+// WithEventLoopPriority and WithEventTimeout.
+func NewContext(opts ...ContextOption) *Context {
+	return newContextWithImpl(libusbImpl{}, opts...)
 }
 
 // OpenDevices calls opener with each enumerated device.
@@ -186,21 +244,13 @@ func (c *Context) OpenDevices(opener func(desc *DeviceDesc) bool) ([]*Device, er
 	var reterr error
 	var ret []*Device
 	for _, dev := range list {
-		desc, err := c.libusb.getDeviceDesc(dev)
+		desc, err := c.deviceDesc(dev)
 		if err != nil {
 			c.libusb.dereference(dev)
 			reterr = err
 			continue
 		}
 
-		parent := c.libusb.getParent(dev)
-		if parent != nil {
-			parentDesc, err := c.libusb.getDeviceDesc(parent)
-			if err == nil {
-				desc.Parent = parentDesc
-			}
-		}
-
 		if opener(desc) {
 			handle, err := c.libusb.open(dev)
 			if err != nil {
@@ -221,6 +271,115 @@ func (c *Context) OpenDevices(opener func(desc *DeviceDesc) bool) ([]*Device, er
 	return ret, reterr
 }
 
+// deviceDesc reads the descriptor of an enumerated device, together with
+// the descriptor of its parent, if available.
+func (c *Context) deviceDesc(dev *libusbDevice) (*DeviceDesc, error) {
+	desc, err := c.libusb.getDeviceDesc(dev)
+	if err != nil {
+		return nil, err
+	}
+	if parent := c.libusb.getParent(dev); parent != nil {
+		if parentDesc, err := c.libusb.getDeviceDesc(parent); err == nil {
+			desc.Parent = parentDesc
+		}
+	}
+	return desc, nil
+}
+
+// ForEachDevice calls fn with the descriptor of each enumerated device,
+// until fn returns false. Unlike OpenDevices, no device is opened and no
+// list of devices is built, the descriptors of the devices after the one
+// that stopped the iteration are not read at all. This makes ForEachDevice
+// suitable for finding the first matching device on hosts with many
+// devices. The descriptors passed to fn can be retained, e.g. to open the
+// matching device with OpenDevices later.
+// If the descriptor of a device can't be read, the device is skipped and
+// the last such error is returned after the iteration.
+func (c *Context) ForEachDevice(fn func(desc *DeviceDesc) bool) error {
+	if c.ctx == nil {
+		return errors.New("ForEachDevice called on a closed or uninitialized Context")
+	}
+	list, err := c.libusb.getDevices(c.ctx)
+	if err != nil {
+		return err
+	}
+	var reterr error
+	for i, dev := range list {
+		desc, err := c.deviceDesc(dev)
+		c.libusb.dereference(dev)
+		if err != nil {
+			reterr = err
+			continue
+		}
+		if !fn(desc) {
+			for _, rest := range list[i+1:] {
+				c.libusb.dereference(rest)
+			}
+			break
+		}
+	}
+	return reterr
+}
+
+// IsAlive returns true if the Context was initialized and Close was not
+// called yet.
+func (c *Context) IsAlive() bool {
+	if c.ctx == nil {
+		return false
+	}
+	c.xferMu.RLock()
+	defer c.xferMu.RUnlock()
+	return !c.closing
+}
+
+// Handle returns the underlying libusb_context of the Context.
+//
+// This is an advanced and unsafe escape hatch for code that calls libusb
+// directly, e.g. to obtain a device list with libusb_get_device_list for use
+// with WrapDevice. The returned pointer should be converted to
+// *C.libusb_context. The libusb context remains owned by the Context, it must
+// not be passed to libusb_exit and it becomes invalid after Close.
+// Handle returns nil if the Context was already closed.
+func (c *Context) Handle() unsafe.Pointer {
+	return unsafe.Pointer(c.ctx)
+}
+
+// WrapDevice opens a Device from a raw libusb_device pointer, e.g. an element
+// of a list returned by libusb_get_device_list. This allows code using libusb
+// directly to migrate to gousb incrementally.
+//
+// The device must have been obtained from the libusb context of c, see
+// Handle. WrapDevice takes its own reference to the libusb device, the
+// ownership of the caller's reference does not change: the caller still
+// has to release it, e.g. by calling libusb_free_device_list with
+// unref_devices set to 1, and may do so as soon as WrapDevice returns.
+// The reference taken by gousb is released by Device.Close.
+// As with OpenDevices, the returned Device must be closed before the Context.
+func (c *Context) WrapDevice(dev unsafe.Pointer) (*Device, error) {
+	if c.ctx == nil {
+		return nil, errors.New("WrapDevice called on a closed or uninitialized Context")
+	}
+	if dev == nil {
+		return nil, errors.New("WrapDevice called with a nil device")
+	}
+	d := (*libusbDevice)(dev)
+	desc, err := c.deviceDesc(d)
+	if err != nil {
+		return nil, err
+	}
+	c.libusb.reference(d)
+	handle, err := c.libusb.open(d)
+	if err != nil {
+		c.libusb.dereference(d)
+		return nil, err
+	}
+	o := &Device{handle: handle, ctx: c, Desc: desc, dev: d}
+	c.mu.Lock()
+	c.devices[o] = true
+	c.mu.Unlock()
+	return o, nil
+}
+
 // OpenDeviceWithVIDPID opens Device from specific VendorId and ProductId.
 // If none is found, it returns nil and nil error. If there are multiple devices
 // with the same VID/PID, it will return one of them, picked arbitrarily.
@@ -245,10 +404,79 @@ func (c *Context) OpenDeviceWithVIDPID(vid, pid ID) (*Device, error) {
 	return devs[0], nil
 }
 
+// OpenDeviceWithMatcher opens the first enumerated device whose descriptor
+// satisfies match, e.g. to select a device by class or by its position on
+// the bus. match is called with the descriptors before any device is opened,
+// and enumeration stops at the first opened device. If a matching device
+// can't be opened, the following devices are tried.
+// If no device matches, or none of the matching devices can be opened,
+// OpenDeviceWithMatcher returns an error wrapping ErrDeviceNotFound. A
+// returned Device must be closed.
+func (c *Context) OpenDeviceWithMatcher(match func(desc *DeviceDesc) bool) (*Device, error) {
+	if c.ctx == nil {
+		return nil, errors.New("OpenDeviceWithMatcher called on a closed or uninitialized Context")
+	}
+	list, err := c.libusb.getDevices(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for i, dev := range list {
+		desc, err := c.deviceDesc(dev)
+		if err != nil {
+			c.libusb.dereference(dev)
+			lastErr = err
+			continue
+		}
+		if !match(desc) {
+			c.libusb.dereference(dev)
+			continue
+		}
+		handle, err := c.libusb.open(dev)
+		if err != nil {
+			c.libusb.dereference(dev)
+			lastErr = fmt.Errorf("failed to open the matching device %s: %v", desc, err)
+			continue
+		}
+		for _, rest := range list[i+1:] {
+			c.libusb.dereference(rest)
+		}
+		o := &Device{handle: handle, ctx: c, Desc: desc}
+		c.mu.Lock()
+		c.devices[o] = true
+		c.mu.Unlock()
+		return o, nil
+	}
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w, last error: %v", ErrDeviceNotFound, lastErr)
+	}
+	return nil, ErrDeviceNotFound
+}
+
+// OpenDevicesWithInterfaceClass opens all the enumerated devices with an
+// interface alternate setting of the given class, in any of their
+// configurations, e.g. ClassHID for all HID devices or ClassComm for all
+// CDC devices, without knowing their vendor and product IDs. Unlike
+// matching DeviceDesc.Class, this finds the composite devices that report
+// their class per interface, with a device class of ClassPerInterface or
+// ClassMiscellaneous. SelectConfigurationWith with MatchInterfaceClass
+// then activates the configuration with the interface.
+// As with OpenDevices, every returned Device must be closed, even if an
+// error is returned as well.
+func (c *Context) OpenDevicesWithInterfaceClass(class Class) ([]*Device, error) {
+	return c.OpenDevices(func(desc *DeviceDesc) bool {
+		return desc.hasInterface(func(s InterfaceSetting) bool { return s.Class == class })
+	})
+}
+
 func (c *Context) closeDev(d *Device) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.libusb.close(d.handle)
+	if d.dev != nil {
+		c.libusb.dereference(d.dev)
+		d.dev = nil
+	}
 	delete(c.devices, d)
 }
 
@@ -261,7 +489,181 @@ func (c *Context) checkOpenDevs() error {
 	return nil
 }
 
+func (c *Context) registerTransfer(t *usbTransfer) error {
+	c.xferMu.Lock()
+	defer c.xferMu.Unlock()
+	if c.closing {
+		return errors.New("can't allocate a transfer, the Context is closed")
+	}
+	if t.dev != nil && t.dev.closed {
+		return ErrDeviceClosed
+	}
+	c.xfers[t] = true
+	c.libusb.setCompletionHook(t.xfer, t.onCompletion)
+	return nil
+}
+
+// deviceClosed returns true if the device with the given state was closed.
+func (c *Context) deviceClosed(dev *deviceState) bool {
+	c.xferMu.RLock()
+	defer c.xferMu.RUnlock()
+	return dev != nil && dev.closed
+}
+
+// closeTransfers marks a device as closed, which stops the allocation and
+// submission of its transfers, cancels those in flight and waits until
+// libusb reports their completion. The results and the transfers are left
+// to their owners: a blocking read or write, a stream or the user of a
+// Transfer collects the result with wait and frees the transfer, the
+// device handle is no longer used by libusb. The returned errors are the
+// transfers still in flight after cancelTimeout.
+func (c *Context) closeTransfers(dev *deviceState) []error {
+	c.xferMu.Lock()
+	dev.closed = true
+	var pending []*usbTransfer
+	for t := range c.xfers {
+		if t.dev != dev || !t.isInFlight() {
+			continue
+		}
+		t.abort()
+		pending = append(pending, t)
+	}
+	c.xferMu.Unlock()
+	if n := len(c.awaitCompletion(pending)); n > 0 {
+		return []error{fmt.Errorf("%d transfers still in flight %v after they were cancelled", n, cancelTimeout)}
+	}
+	return nil
+}
+
+// cancelDeviceTransfers cancels the transfers of a device that are in
+// flight. It doesn't wait for them to finish.
+func (c *Context) cancelDeviceTransfers(dev *deviceState) []error {
+	c.xferMu.RLock()
+	defer c.xferMu.RUnlock()
+	var errs []error
+	for t := range c.xfers {
+		if t.dev != dev || !t.isInFlight() {
+			continue
+		}
+		// The transfer might have completed in the meantime.
+		if err := t.abort(); err != nil && err != ErrorNotFound {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (c *Context) unregisterTransfer(t *usbTransfer) {
+	c.xferMu.Lock()
+	defer c.xferMu.Unlock()
+	delete(c.xfers, t)
+}
+
+// cancelTransfers stops accepting new transfers, cancels all transfers in
+// flight and waits until libusb reports their completion. Unless
+// cancelTransfers returns an error, libusb no longer uses any of the
+// transfers afterwards.
+func (c *Context) cancelTransfers() error {
+	c.xferMu.Lock()
+	c.closing = true
+	var pending []*usbTransfer
+	for t := range c.xfers {
+		if !t.isInFlight() {
+			continue
+		}
+		// The transfer might have completed already, in which case
+		// cancel returns an error that can be ignored.
+		t.abort()
+		pending = append(pending, t)
+	}
+	c.xferMu.Unlock()
+	if n := len(c.awaitCompletion(pending)); n > 0 {
+		return fmt.Errorf("%d transfers still in flight %v after they were cancelled", n, cancelTimeout)
+	}
+	return nil
+}
+
+// awaitCompletion waits until libusb has reported the completion of the
+// transfers, for at most cancelTimeout, and returns the transfers still in
+// flight. The completion is signalled on the done channel of a transfer
+// and consumed by wait, which might not run at all if the owner of the
+// transfer is not waiting for it: awaitCompletion leaves the signal to the
+// owner and is woken up by xferSignal instead.
+func (c *Context) awaitCompletion(ts []*usbTransfer) []*usbTransfer {
+	var timeout <-chan time.Time
+	for {
+		// Take the signal channel before checking the transfers, a
+		// completion in between closes it.
+		signal := c.xferSignal.wait()
+		pending := ts[:0]
+		for _, t := range ts {
+			if t.isInFlight() && len(t.done) == 0 {
+				pending = append(pending, t)
+			}
+		}
+		ts = pending
+		if len(ts) == 0 {
+			return nil
+		}
+		if timeout == nil {
+			timer := time.NewTimer(cancelTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-signal:
+		case <-timeout:
+			return ts
+		}
+	}
+}
+
+// broadcast wakes up all the goroutines waiting for the next event.
+type broadcast struct {
+	mu sync.Mutex
+	ch chan struct{}
+	// armed is 1 while ch is not nil. It's read atomically by signal,
+	// which doesn't acquire mu when no one is waiting.
+	armed int32
+}
+
+// wait returns a channel closed by the next signal.
+func (b *broadcast) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ch == nil {
+		b.ch = make(chan struct{})
+		atomic.StoreInt32(&b.armed, 1)
+	}
+	return b.ch
+}
+
+// signal wakes up the goroutines waiting for the event.
+func (b *broadcast) signal() {
+	if atomic.LoadInt32(&b.armed) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ch != nil {
+		close(b.ch)
+		b.ch = nil
+		atomic.StoreInt32(&b.armed, 0)
+	}
+}
+
 // Close releases the Context and all associated resources.
+// Close returns an error if any Devices are still open. Otherwise the
+// Context is shut down in order: new transfer submissions are rejected,
+// transfers still in flight are cancelled, the event handling goroutine
+// stops once libusb has reported the completion of all cancelled transfers
+// and their OnComplete functions returned, and finally the libusb context
+// is released. If libusb doesn't report the completion of a cancelled
+// transfer within 5 seconds, Close releases the context anyway and
+// returns an error.
+// Waits for transfers in progress return TransferCancelled. Transfers
+// allocated from the Context should still be freed, but they can't be
+// submitted again.
 func (c *Context) Close() error {
 	if c.ctx == nil {
 		return nil
@@ -269,8 +671,20 @@ func (c *Context) Close() error {
 	if err := c.checkOpenDevs(); err != nil {
 		return err
 	}
-	c.done <- struct{}{}
+	cancelErr := c.cancelTransfers()
+	if cancelErr == nil {
+		// The results of transfers stuck in flight are never
+		// delivered, don't wait for them.
+		c.completions.stop()
+	}
+	c.stopNotifiers()
+	close(c.done)
+	c.libusb.interruptEvents(c.ctx)
+	<-c.loopDone
 	err := c.libusb.exit(c.ctx)
 	c.ctx = nil
+	if cancelErr != nil {
+		return cancelErr
+	}
 	return err
 }
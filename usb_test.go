@@ -15,7 +15,15 @@
 
 package gousb
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
 
 func TestOPenDevices(t *testing.T) {
 	t.Parallel()
@@ -94,3 +102,490 @@ func TestOpenDeviceWithVIDPID(t *testing.T) {
 		}
 	}
 }
+
+func TestMultipleContexts(t *testing.T) {
+	t.Parallel()
+	const numCtx = 2
+	libs := make([]*fakeLibusb, numCtx)
+	ctxs := make([]*Context, numCtx)
+	for i := range ctxs {
+		libs[i] = newFakeLibusb()
+		ctxs[i] = newContextWithImpl(libs[i])
+	}
+
+	var wg sync.WaitGroup
+	for i := range ctxs {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dev, err := ctxs[i].OpenDeviceWithVIDPID(0x9999, 0x0001)
+			if err != nil {
+				t.Errorf("context #%d: OpenDeviceWithVIDPID(0x9999, 0x0001): %v", i, err)
+				return
+			}
+			defer dev.Close()
+			intf, done, err := dev.DefaultInterface()
+			if err != nil {
+				t.Errorf("context #%d: %s.DefaultInterface(): %v", i, dev, err)
+				return
+			}
+			defer done()
+			ep, err := intf.InEndpoint(2)
+			if err != nil {
+				t.Errorf("context #%d: %s.InEndpoint(2): %v", i, intf, err)
+				return
+			}
+			// Each context responds with a different amount of data.
+			want := 10 * (i + 1)
+			for j := 0; j < 10; j++ {
+				go func() {
+					ft := libs[i].waitForSubmitted(nil)
+					ft.setData(make([]byte, want))
+					ft.setStatus(TransferCompleted)
+				}()
+				if got, err := ep.Read(make([]byte, 512)); err != nil || got != want {
+					t.Errorf("context #%d: %s.Read(): got %d, %v, want %d, nil", i, ep, got, err, want)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Close the contexts independently, the second one keeps working after
+	// the first one is closed.
+	if err := ctxs[0].Close(); err != nil {
+		t.Errorf("context #0: Close(): %v", err)
+	}
+	dev, err := ctxs[1].OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil || dev == nil {
+		t.Errorf("context #1: OpenDeviceWithVIDPID(0x9999, 0x0001) after closing context #0: %v, %v", dev, err)
+	} else {
+		dev.Close()
+	}
+	if err := ctxs[1].Close(); err != nil {
+		t.Errorf("context #1: Close(): %v", err)
+	}
+}
+
+// refCountLib is a fakeLibusb that tracks references to libusb devices.
+type refCountLib struct {
+	*fakeLibusb
+
+	refMu sync.Mutex
+	refs  map[*libusbDevice]int
+}
+
+func (f *refCountLib) reference(d *libusbDevice) {
+	f.refMu.Lock()
+	defer f.refMu.Unlock()
+	f.refs[d]++
+}
+
+func (f *refCountLib) dereference(d *libusbDevice) {
+	f.refMu.Lock()
+	defer f.refMu.Unlock()
+	f.refs[d]--
+}
+
+func (f *refCountLib) refCount(d *libusbDevice) int {
+	f.refMu.Lock()
+	defer f.refMu.Unlock()
+	return f.refs[d]
+}
+
+func TestWrapDevice(t *testing.T) {
+	t.Parallel()
+	lib := &refCountLib{fakeLibusb: newFakeLibusb(), refs: make(map[*libusbDevice]int)}
+	c := newContextWithImpl(lib)
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+	if c.Handle() == nil {
+		t.Errorf("Context.Handle(): got nil, want non-nil")
+	}
+
+	if _, err := c.WrapDevice(nil); err == nil {
+		t.Errorf("WrapDevice(nil): got nil error, want non-nil")
+	}
+
+	list, err := lib.getDevices(c.ctx)
+	if err != nil {
+		t.Fatalf("getDevices(): %v", err)
+	}
+	raw := list[0]
+	dev, err := c.WrapDevice(unsafe.Pointer(raw))
+	if err != nil {
+		t.Fatalf("WrapDevice(%p): %v", raw, err)
+	}
+	if got, want := dev.Desc, lib.fakeDevices[raw].devDesc; got != want {
+		t.Errorf("WrapDevice(%p).Desc: got %v, want %v", raw, got, want)
+	}
+	if got := lib.refCount(raw); got != 1 {
+		t.Errorf("reference count of the wrapped device: got %d, want 1", got)
+	}
+	if err := c.Close(); err == nil {
+		t.Errorf("Context.Close() with a wrapped device still open: got nil error, want non-nil")
+	}
+	if _, err := dev.Manufacturer(); err != nil {
+		t.Errorf("%s.Manufacturer(): %v", dev, err)
+	}
+	if err := dev.Close(); err != nil {
+		t.Errorf("%s.Close(): %v", dev, err)
+	}
+	if got := lib.refCount(raw); got != 0 {
+		t.Errorf("reference count of the wrapped device after Close: got %d, want 0", got)
+	}
+	// A second Close does not release the reference again.
+	dev.Close()
+	if got := lib.refCount(raw); got != 0 {
+		t.Errorf("reference count of the wrapped device after second Close: got %d, want 0", got)
+	}
+}
+
+// closeTrackingLib is a fakeLibusb that records the end of event handling
+// and tolerates transfers that were not freed before exit.
+type closeTrackingLib struct {
+	*fakeLibusb
+	eventsDone chan struct{}
+}
+
+func (f *closeTrackingLib) handleEvents(c *libusbContext, timeout time.Duration, done <-chan struct{}) {
+	f.fakeLibusb.handleEvents(c, timeout, done)
+	close(f.eventsDone)
+}
+
+func (f *closeTrackingLib) exit(*libusbContext) error {
+	close(f.submitted)
+	return nil
+}
+
+func TestContextCloseWithTransfers(t *testing.T) {
+	t.Parallel()
+	lib := &closeTrackingLib{fakeLibusb: newFakeLibusb(), eventsDone: make(chan struct{})}
+	c := newContextWithImpl(lib)
+	ep := &InEndpoint{&endpoint{ctx: c, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+
+	const numXfers = 3
+	var xfers []*Transfer
+	for i := 0; i < numXfers; i++ {
+		xfer, err := ep.NewTransfer(512)
+		if err != nil {
+			t.Fatalf("NewTransfer(): %v", err)
+		}
+		if err := xfer.Submit(); err != nil {
+			t.Fatalf("Submit(): %v", err)
+		}
+		xfers = append(xfers, xfer)
+	}
+	// Nobody waits for the last transfer.
+	errs := make(chan error, numXfers-1)
+	for _, xfer := range xfers[:numXfers-1] {
+		go func(xfer *Transfer) {
+			_, err := xfer.Wait(context.Background())
+			errs <- err
+		}(xfer)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Context.Close(): %v", err)
+	}
+	select {
+	case <-lib.eventsDone:
+	default:
+		t.Error("event handling goroutine still running after Context.Close()")
+	}
+	for i := 0; i < numXfers-1; i++ {
+		if err := <-errs; !errors.Is(err, TransferCancelled) {
+			t.Errorf("Wait() on a transfer in flight during Close: got error %v, want %v", err, TransferCancelled)
+		}
+	}
+	if _, err := xfers[numXfers-1].Wait(context.Background()); !errors.Is(err, TransferCancelled) {
+		t.Errorf("Wait() after Close: got error %v, want %v", err, TransferCancelled)
+	}
+	if err := xfers[0].Submit(); err == nil {
+		t.Error("Submit() after Close: got nil error, want non-nil")
+	}
+	if _, err := ep.NewTransfer(512); err == nil {
+		t.Error("NewTransfer() after Close: got nil error, want non-nil")
+	}
+	for _, xfer := range xfers {
+		if err := xfer.Free(); err != nil {
+			t.Errorf("Free(): %v", err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Context.Close(): %v", err)
+	}
+}
+
+// slowCancelCloseLib is a slowCancelLib that tolerates transfers that were
+// not freed before exit.
+type slowCancelCloseLib struct {
+	*slowCancelLib
+}
+
+func (f *slowCancelCloseLib) exit(*libusbContext) error {
+	close(f.submitted)
+	return nil
+}
+
+func TestContextCloseWaitsForCancelledTransfers(t *testing.T) {
+	t.Parallel()
+	lib := &slowCancelCloseLib{&slowCancelLib{fakeLibusb: newFakeLibusb()}}
+	c := newContextWithImpl(lib)
+	ep := &InEndpoint{&endpoint{ctx: c, Desc: EndpointDesc{
+		Address:       0x81,
+		Number:        1,
+		Direction:     EndpointDirectionIn,
+		MaxPacketSize: 512,
+		TransferType:  TransferTypeBulk,
+	}}}
+	xfer, err := ep.NewTransfer(512)
+	if err != nil {
+		t.Fatalf("NewTransfer(): %v", err)
+	}
+	if err := xfer.Submit(); err != nil {
+		t.Fatalf("Submit(): %v", err)
+	}
+	ft := lib.waitForSubmitted(nil)
+	// Nobody waits for the transfer, libusb reports the cancellation
+	// after a delay.
+	if err := c.Close(); err != nil {
+		t.Fatalf("Context.Close(): %v", err)
+	}
+	ft.mu.Lock()
+	finished := ft.finished
+	ft.mu.Unlock()
+	if !finished {
+		t.Error("Context.Close() returned before libusb reported the completion of the cancelled transfer")
+	}
+	if _, err := xfer.Wait(context.Background()); !errors.Is(err, TransferCancelled) {
+		t.Errorf("Wait() after Close: got error %v, want %v", err, TransferCancelled)
+	}
+	if err := xfer.Free(); err != nil {
+		t.Errorf("Free(): %v", err)
+	}
+}
+
+// countingLib records the devices whose descriptors were read and the
+// devices dereferenced.
+type countingLib struct {
+	*fakeLibusb
+	mu    sync.Mutex
+	read  map[*libusbDevice]int
+	deref map[*libusbDevice]int
+}
+
+func (c *countingLib) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
+	c.mu.Lock()
+	c.read[d]++
+	c.mu.Unlock()
+	return c.fakeLibusb.getDeviceDesc(d)
+}
+
+func (c *countingLib) dereference(d *libusbDevice) {
+	c.mu.Lock()
+	c.deref[d]++
+	c.mu.Unlock()
+}
+
+func TestForEachDevice(t *testing.T) {
+	t.Parallel()
+	lib := &countingLib{
+		fakeLibusb: newFakeLibusb(),
+		read:       make(map[*libusbDevice]int),
+		deref:      make(map[*libusbDevice]int),
+	}
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	var all []*DeviceDesc
+	if err := ctx.ForEachDevice(func(desc *DeviceDesc) bool {
+		all = append(all, desc)
+		return true
+	}); err != nil {
+		t.Fatalf("ForEachDevice(): %v", err)
+	}
+	if got, want := len(all), len(lib.fakeDevices); got != want {
+		t.Errorf("ForEachDevice() visited %d devices, want %d", got, want)
+	}
+	for d, n := range lib.deref {
+		if n != 1 {
+			t.Errorf("device %p dereferenced %d times after a full iteration, want 1", d, n)
+		}
+	}
+
+	lib.mu.Lock()
+	lib.read = make(map[*libusbDevice]int)
+	lib.deref = make(map[*libusbDevice]int)
+	lib.mu.Unlock()
+	calls := 0
+	if err := ctx.ForEachDevice(func(desc *DeviceDesc) bool {
+		calls++
+		return false
+	}); err != nil {
+		t.Fatalf("ForEachDevice(): %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("ForEachDevice() stopping at the first device: fn called %d times, want 1", calls)
+	}
+	if got := len(lib.read); got != 1 {
+		t.Errorf("ForEachDevice() stopping at the first device: read %d descriptors, want 1", got)
+	}
+	if got, want := len(lib.deref), len(lib.fakeDevices); got != want {
+		t.Errorf("ForEachDevice() stopping at the first device: dereferenced %d devices, want %d", got, want)
+	}
+	for d, n := range lib.deref {
+		if n != 1 {
+			t.Errorf("device %p dereferenced %d times, want 1", d, n)
+		}
+	}
+}
+
+// classLib reports a device class for the devices with the given VID.
+type classLib struct {
+	*fakeLibusb
+	vid   ID
+	class Class
+}
+
+func (c *classLib) getDeviceDesc(d *libusbDevice) (*DeviceDesc, error) {
+	desc, err := c.fakeLibusb.getDeviceDesc(d)
+	if err != nil || desc.Vendor != c.vid {
+		return desc, err
+	}
+	ret := *desc
+	ret.Class = c.class
+	return &ret, nil
+}
+
+func TestOpenDeviceWithMatcher(t *testing.T) {
+	t.Parallel()
+	ctx := newContextWithImpl(&classLib{fakeLibusb: newFakeLibusb(), vid: 0x8888, class: ClassMiscellaneous})
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	dev, err := ctx.OpenDeviceWithMatcher(func(desc *DeviceDesc) bool { return desc.Class == ClassMiscellaneous })
+	if err != nil {
+		t.Fatalf("OpenDeviceWithMatcher(class %s): %v", ClassMiscellaneous, err)
+	}
+	if dev.Desc.Vendor != 0x8888 || dev.Desc.Product != 0x0002 {
+		t.Errorf("OpenDeviceWithMatcher(class %s): got device %s, want 8888:0002", ClassMiscellaneous, dev)
+	}
+	if err := dev.Close(); err != nil {
+		t.Errorf("%s.Close(): %v", dev, err)
+	}
+
+	dev, err = ctx.OpenDeviceWithMatcher(func(desc *DeviceDesc) bool { return desc.Class == ClassPrinter })
+	if !errors.Is(err, ErrDeviceNotFound) || dev != nil {
+		t.Errorf("OpenDeviceWithMatcher(class %s): got %v, %v, want nil, %v", ClassPrinter, dev, err, ErrDeviceNotFound)
+	}
+}
+
+func TestOpenDevicesWithInterfaceClass(t *testing.T) {
+	t.Parallel()
+	lib := newFakeLibusb()
+	// A composite device with a HID interface next to a vendor one, and a
+	// CDC device whose communication interface is in its second config.
+	lib.fakeDevices[newDevicePointer()] = &fakeDevice{
+		devDesc: &DeviceDesc{
+			Bus:     2,
+			Address: 1,
+			Spec:    Version(2, 0),
+			Class:   ClassPerInterface,
+			Vendor:  ID(0x5555),
+			Product: ID(0x0010),
+			Configs: map[int]ConfigDesc{
+				1: {
+					Number: 1,
+					Interfaces: []InterfaceDesc{{
+						Number:      0,
+						AltSettings: []InterfaceSetting{{Number: 0, Alternate: 0, Class: ClassVendorSpec}},
+					}, {
+						Number:      1,
+						AltSettings: []InterfaceSetting{{Number: 1, Alternate: 0, Class: ClassHID}},
+					}},
+				},
+			},
+		},
+	}
+	lib.fakeDevices[newDevicePointer()] = &fakeDevice{
+		devDesc: &DeviceDesc{
+			Bus:     2,
+			Address: 2,
+			Spec:    Version(2, 0),
+			Class:   ClassMiscellaneous,
+			Vendor:  ID(0x5555),
+			Product: ID(0x0011),
+			Configs: map[int]ConfigDesc{
+				1: {
+					Number: 1,
+					Interfaces: []InterfaceDesc{{
+						Number:      0,
+						AltSettings: []InterfaceSetting{{Number: 0, Alternate: 0, Class: ClassVendorSpec}},
+					}},
+				},
+				2: {
+					Number: 2,
+					Interfaces: []InterfaceDesc{{
+						Number:      0,
+						AltSettings: []InterfaceSetting{{Number: 0, Alternate: 0, Class: ClassComm, SubClass: 0x02, Protocol: 0x01}},
+					}, {
+						Number:      1,
+						AltSettings: []InterfaceSetting{{Number: 1, Alternate: 0, Class: ClassData}},
+					}},
+				},
+			},
+		},
+	}
+	c := newContextWithImpl(lib)
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	for _, tc := range []struct {
+		class Class
+		want  []ID
+	}{
+		{ClassHID, []ID{0x0010}},
+		{ClassComm, []ID{0x0011}},
+		{ClassData, []ID{0x0011}},
+		{ClassPrinter, nil},
+	} {
+		devs, err := c.OpenDevicesWithInterfaceClass(tc.class)
+		if err != nil {
+			t.Errorf("OpenDevicesWithInterfaceClass(%s): %v", tc.class, err)
+		}
+		var got []ID
+		for _, d := range devs {
+			got = append(got, d.Desc.Product)
+			if d.Desc.Vendor != 0x5555 {
+				t.Errorf("OpenDevicesWithInterfaceClass(%s): got device %s, want only 5555:xxxx devices", tc.class, d)
+			}
+			if err := d.Close(); err != nil {
+				t.Errorf("%s.Close(): %v", d, err)
+			}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("OpenDevicesWithInterfaceClass(%s): got products %v, want %v", tc.class, got, tc.want)
+		}
+	}
+}
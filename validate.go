@@ -0,0 +1,82 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import "fmt"
+
+// Validate checks that the endpoint is ready for transfers, so that
+// configuration mistakes are reported before a stream is started, instead
+// of as failures of the stream's transfers. Validate checks that:
+//   - the device is open and the interface of the endpoint is still claimed,
+//   - the alternate setting of the endpoint is the active setting of the
+//     interface, as reported by the device in response to GET_INTERFACE,
+//   - the endpoint is not halted, as reported by the device in response to
+//     GET_STATUS. An error wrapping ErrStall is returned for a halted
+//     endpoint.
+//
+// The requests are sent on the control endpoint of the device, no data is
+// transferred on the endpoint itself: a test read could consume data meant
+// for the application and a test write would be seen by the device.
+// Validate is meant to be called once, before the endpoint is used.
+func (e *endpoint) Validate() error {
+	if e.intf == nil {
+		return fmt.Errorf("endpoint %s: not opened through an Interface", e)
+	}
+	cfg := e.intf.config
+	if cfg == nil {
+		return fmt.Errorf("endpoint %s: interface %d was released, Close was called on %s", e, e.InterfaceSetting.Number, e.intf)
+	}
+	dev := cfg.dev
+	if dev == nil || dev.handle == nil || e.ctx.deviceClosed(e.dev) {
+		return fmt.Errorf("endpoint %s: %w", e, ErrDeviceClosed)
+	}
+	cfg.mu.Lock()
+	claimed := cfg.claimed[e.InterfaceSetting.Number]
+	cfg.mu.Unlock()
+	if !claimed {
+		return fmt.Errorf("endpoint %s: interface %d of %s is not claimed", e, e.InterfaceSetting.Number, cfg)
+	}
+
+	// Devices with a single alternate setting may not implement
+	// GET_INTERFACE, and gousb doesn't select the setting in that case,
+	// see Config.Interface.
+	if desc := cfg.Desc.interfaceDesc(e.InterfaceSetting.Number); desc != nil && len(desc.AltSettings) > 1 {
+		alt := make([]byte, 1)
+		n, err := dev.Control(ControlType(ControlKindStandard, ControlRecipientInterface, EndpointDirectionIn), requestGetInterface, 0, uint16(e.InterfaceSetting.Number), alt)
+		if err != nil {
+			return fmt.Errorf("endpoint %s: GET_INTERFACE for interface %d failed: %v", e, e.InterfaceSetting.Number, err)
+		}
+		if n != len(alt) {
+			return fmt.Errorf("endpoint %s: GET_INTERFACE for interface %d returned %d bytes, want %d", e, e.InterfaceSetting.Number, n, len(alt))
+		}
+		if int(alt[0]) != e.InterfaceSetting.Alternate {
+			return fmt.Errorf("endpoint %s: belongs to alternate setting %d of interface %d, but the device reports alternate setting %d as active", e, e.InterfaceSetting.Alternate, e.InterfaceSetting.Number, alt[0])
+		}
+	}
+
+	status := make([]byte, 2)
+	n, err := dev.Control(ControlType(ControlKindStandard, ControlRecipientEndpoint, EndpointDirectionIn), requestGetStatus, 0, uint16(e.Desc.Address), status)
+	if err != nil {
+		return fmt.Errorf("endpoint %s: GET_STATUS failed: %v", e, err)
+	}
+	if n != len(status) {
+		return fmt.Errorf("endpoint %s: GET_STATUS returned %d bytes, want %d", e, n, len(status))
+	}
+	if status[0]&statusEndpointHalt != 0 {
+		return fmt.Errorf("endpoint %s: %w", e, ErrStall)
+	}
+	return nil
+}
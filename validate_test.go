@@ -0,0 +1,217 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEndpointValidate(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	var halted bool
+	activeAlt := byte(1)
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		switch req.request {
+		case requestGetStatus:
+			data[0], data[1] = 0, 0
+			if halted {
+				data[0] = statusEndpointHalt
+			}
+			return 2, nil
+		case requestGetInterface:
+			data[0] = activeAlt
+			return 1, nil
+		}
+		return 0, ErrorNotSupported
+	}
+	ctx := newContextWithImpl(lib)
+	defer func() {
+		if err := ctx.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}()
+
+	dev, err := ctx.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil || dev == nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v, %v", dev, err)
+	}
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	intf, err := cfg.Interface(0, 0)
+	if err != nil {
+		t.Fatalf("%s.Interface(0, 0): %v", cfg, err)
+	}
+	ep, err := intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	if err := ep.Validate(); err != nil {
+		t.Errorf("Validate(): %v", err)
+	}
+	reqs := lib.requests()
+	if len(reqs) != 1 || reqs[0].rType != 0x82 || reqs[0].request != requestGetStatus || reqs[0].idx != 0x82 {
+		t.Errorf("Validate() sent requests %v, want a single GET_STATUS for endpoint 0x82", reqs)
+	}
+
+	halted = true
+	if err := ep.Validate(); !errors.Is(err, ErrStall) {
+		t.Errorf("Validate() of a halted endpoint: got error %v, want %v", err, ErrStall)
+	}
+	halted = false
+
+	intf.Close()
+	if err := ep.Validate(); err == nil || !strings.Contains(err.Error(), "released") {
+		t.Errorf("Validate() after Interface.Close(): got error %v, want an error about the released interface", err)
+	}
+
+	// An endpoint of a closed device.
+	intf, err = cfg.Interface(0, 0)
+	if err != nil {
+		t.Fatalf("%s.Interface(0, 0): %v", cfg, err)
+	}
+	ep, err = intf.InEndpoint(2)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(2): %v", intf, err)
+	}
+	cfg.Close()
+	dev.Close()
+	if err := ep.Validate(); err == nil {
+		t.Error("Validate() after Device.Close(): got nil error, want non-nil")
+	}
+
+	dev, err = ctx.OpenDeviceWithVIDPID(0x8888, 0x0002)
+	if err != nil || dev == nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x8888, 0x0002): %v, %v", dev, err)
+	}
+	defer dev.Close()
+	cfg, err = dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+	intf, err = cfg.Interface(1, 1)
+	if err != nil {
+		t.Fatalf("%s.Interface(1, 1): %v", cfg, err)
+	}
+	defer intf.Close()
+	ep, err = intf.InEndpoint(6)
+	if err != nil {
+		t.Fatalf("%s.InEndpoint(6): %v", intf, err)
+	}
+	if err := ep.Validate(); err != nil {
+		t.Errorf("Validate() with the active alternate setting: %v", err)
+	}
+	activeAlt = 0
+	if err := ep.Validate(); err == nil || !strings.Contains(err.Error(), "alternate setting 0") {
+		t.Errorf("Validate() with another active alternate setting: got error %v, want an error about the alternate setting", err)
+	}
+}
+
+func TestEndpointValidateNonContiguousInterfaces(t *testing.T) {
+	t.Parallel()
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		switch req.request {
+		case requestGetStatus:
+			data[0], data[1] = 0, 0
+			return 2, nil
+		case requestGetInterface:
+			data[0] = 1
+			return 1, nil
+		}
+		return 0, ErrorNotSupported
+	}
+	setting := func(num, alt int) InterfaceSetting {
+		addr := EndpointAddress(0x80 | num)
+		return InterfaceSetting{
+			Number:    num,
+			Alternate: alt,
+			Class:     ClassVendorSpec,
+			Endpoints: map[EndpointAddress]EndpointDesc{
+				addr: {
+					Address:       addr,
+					Number:        num,
+					Direction:     EndpointDirectionIn,
+					MaxPacketSize: 512,
+					TransferType:  TransferTypeBulk,
+				},
+			},
+		}
+	}
+	// https://github.com/google/gousb/issues/65: interfaces numbered 1
+	// and 3, only the second of which has alternate settings.
+	lib.fakeDevices[newDevicePointer()] = &fakeDevice{devDesc: &DeviceDesc{
+		Bus:     2,
+		Address: 1,
+		Spec:    Version(2, 0),
+		Vendor:  0x5555,
+		Product: 0x0001,
+		Configs: map[int]ConfigDesc{1: {
+			Number: 1,
+			Interfaces: []InterfaceDesc{
+				{Number: 1, AltSettings: []InterfaceSetting{setting(1, 0)}},
+				{Number: 3, AltSettings: []InterfaceSetting{setting(3, 0), setting(3, 1)}},
+			},
+		}},
+	}}
+	ctx := newContextWithImpl(lib)
+	defer ctx.Close()
+	dev, err := ctx.OpenDeviceWithVIDPID(0x5555, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x5555, 0x0001): %v", err)
+	}
+	defer dev.Close()
+	cfg, err := dev.Config(1)
+	if err != nil {
+		t.Fatalf("%s.Config(1): %v", dev, err)
+	}
+	defer cfg.Close()
+
+	for _, tc := range []struct {
+		num, alt int
+		wantReqs []uint8
+	}{
+		{num: 1, alt: 0, wantReqs: []uint8{requestGetStatus}},
+		{num: 3, alt: 1, wantReqs: []uint8{requestGetInterface, requestGetStatus}},
+	} {
+		intf, err := cfg.Interface(tc.num, tc.alt)
+		if err != nil {
+			t.Fatalf("%s.Interface(%d, %d): %v", cfg, tc.num, tc.alt, err)
+		}
+		ep, err := intf.InEndpoint(tc.num)
+		if err != nil {
+			t.Fatalf("%s.InEndpoint(%d): %v", intf, tc.num, err)
+		}
+		before := len(lib.requests())
+		if err := ep.Validate(); err != nil {
+			t.Errorf("Validate() of %s: %v", ep, err)
+		}
+		var got []uint8
+		for _, req := range lib.requests()[before:] {
+			got = append(got, req.request)
+		}
+		if !reflect.DeepEqual(got, tc.wantReqs) {
+			t.Errorf("Validate() of %s sent requests %v, want %v", ep, got, tc.wantReqs)
+		}
+		intf.Close()
+	}
+}
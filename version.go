@@ -0,0 +1,97 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LibusbVersion is the version of the libusb library used by gousb.
+type LibusbVersion struct {
+	Major, Minor, Micro, Nano uint16
+}
+
+// String returns a dotted representation of the version.
+func (v LibusbVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", v.Major, v.Minor, v.Micro, v.Nano)
+}
+
+// less returns true if v is older than o.
+func (v LibusbVersion) less(o LibusbVersion) bool {
+	for _, p := range [][2]uint16{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Micro, o.Micro}, {v.Nano, o.Nano}} {
+		if p[0] != p[1] {
+			return p[0] < p[1]
+		}
+	}
+	return false
+}
+
+// ErrUnsupported is returned when a feature is not available in the libusb
+// library gousb is running with. Errors returned in that case can be
+// checked with errors.Is(err, ErrUnsupported), or inspected in more detail
+// with errors.As and *UnsupportedError.
+var ErrUnsupported = errors.New("operation not supported by libusb")
+
+// UnsupportedError is returned when a feature requires a newer libusb
+// version than the one in use.
+type UnsupportedError struct {
+	// Feature is the name of the unavailable feature.
+	Feature string
+	// Required is the oldest libusb version supporting the feature.
+	Required LibusbVersion
+	// Actual is the version of libusb in use.
+	Actual LibusbVersion
+}
+
+// Error implements the error interface.
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s requires libusb %s or newer, running with libusb %s", e.Feature, e.Required, e.Actual)
+}
+
+// Unwrap returns ErrUnsupported.
+func (e *UnsupportedError) Unwrap() error {
+	return ErrUnsupported
+}
+
+// libusbFeature is a libusb functionality that is not available in all
+// libusb versions supported by gousb.
+type libusbFeature struct {
+	name  string
+	since LibusbVersion
+}
+
+// LibusbVersion returns the version of the libusb library in use.
+func (c *Context) LibusbVersion() LibusbVersion {
+	return c.libusb.getVersion()
+}
+
+// checkFeature returns an UnsupportedError if the feature is not available
+// in the libusb version in use. All libusb version checks should go through
+// checkFeature.
+func (c *Context) checkFeature(f libusbFeature) error {
+	if v := c.LibusbVersion(); v.less(f.since) {
+		return &UnsupportedError{Feature: f.name, Required: f.since, Actual: v}
+	}
+	return nil
+}
+
+// CheckSpeedSuperPlus returns nil if the libusb version in use reports
+// devices connected at SuperSpeed Plus rates with SpeedSuperPlus. Otherwise
+// it returns an UnsupportedError: such devices are reported as SpeedSuper,
+// which is indistinguishable from a 5 Gbps link.
+func (c *Context) CheckSpeedSuperPlus() error {
+	return c.checkFeature(featureSpeedSuperPlus)
+}
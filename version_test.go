@@ -0,0 +1,82 @@
+// Copyright 2017 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckFeature(t *testing.T) {
+	t.Parallel()
+	feature := libusbFeature{name: "test feature", since: LibusbVersion{1, 0, 22, 0}}
+	for _, tc := range []struct {
+		version LibusbVersion
+		wantErr bool
+	}{
+		{LibusbVersion{1, 0, 21, 11156}, true},
+		{LibusbVersion{1, 0, 22, 0}, false},
+		{LibusbVersion{1, 0, 22, 11312}, false},
+		{LibusbVersion{1, 0, 26, 11724}, false},
+		{LibusbVersion{1, 1, 0, 0}, false},
+		{LibusbVersion{0, 9, 30, 0}, true},
+	} {
+		lib := newFakeLibusb()
+		lib.version = tc.version
+		c := newContextWithImpl(lib)
+		if got := c.LibusbVersion(); got != tc.version {
+			t.Errorf("LibusbVersion(): got %s, want %s", got, tc.version)
+		}
+		err := c.checkFeature(feature)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("libusb %s: checkFeature(%v): got error %v, want error: %v", tc.version, feature, err, tc.wantErr)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrUnsupported) {
+				t.Errorf("libusb %s: checkFeature(%v): errors.Is(%v, ErrUnsupported) is false, want true", tc.version, feature, err)
+			}
+			var uerr *UnsupportedError
+			if !errors.As(err, &uerr) {
+				t.Errorf("libusb %s: checkFeature(%v): error %v is not an *UnsupportedError", tc.version, feature, err)
+			} else if uerr.Required != feature.since || uerr.Actual != tc.version {
+				t.Errorf("libusb %s: checkFeature(%v): got %+v, want Required: %s, Actual: %s", tc.version, feature, uerr, feature.since, tc.version)
+			}
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}
+}
+
+func TestCheckSpeedSuperPlus(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		version LibusbVersion
+		wantErr bool
+	}{
+		{LibusbVersion{1, 0, 21, 11156}, true},
+		{LibusbVersion{1, 0, 22, 11312}, false},
+	} {
+		lib := newFakeLibusb()
+		lib.version = tc.version
+		c := newContextWithImpl(lib)
+		if err := c.CheckSpeedSuperPlus(); (err != nil) != tc.wantErr || (err != nil && !errors.Is(err, ErrUnsupported)) {
+			t.Errorf("libusb %s: CheckSpeedSuperPlus(): got error %v, want an ErrUnsupported error: %v", tc.version, err, tc.wantErr)
+		}
+		if err := c.Close(); err != nil {
+			t.Errorf("Context.Close(): %v", err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// WebUSB descriptors, see the WebUSB API specification.
+const (
+	// webUSBGetURL is the wIndex of the GET_URL vendor request.
+	webUSBGetURL = 0x0002
+	// webUSBURLDescriptorType is the bDescriptorType of a URL descriptor.
+	webUSBURLDescriptorType = 0x03
+	// webUSBURLMaxLength is the longest possible URL descriptor.
+	webUSBURLMaxLength = 0xff
+	// webUSBCapabilityLength is the length of the WebUSB platform
+	// capability data following the UUID.
+	webUSBCapabilityLength = 4
+)
+
+// webUSBSchemes maps the bScheme field of a URL descriptor to the URL
+// prefix it stands for.
+var webUSBSchemes = map[uint8]string{
+	0x00: "http://",
+	0x01: "https://",
+	0xff: "",
+}
+
+// WebUSBCapability is the WebUSB platform capability of a device.
+type WebUSBCapability struct {
+	// Version is the version of the WebUSB specification implemented.
+	Version BCD
+	// VendorCode is the bRequest of the WebUSB vendor requests.
+	VendorCode uint8
+	// LandingPage is the index of the URL descriptor of the landing page,
+	// 0 if the device has no landing page.
+	LandingPage int
+}
+
+// ParseWebUSBCapability decodes the WebUSB platform capability from p. It
+// returns an error if p is not a WebUSB capability.
+func ParseWebUSBCapability(p PlatformCapability) (WebUSBCapability, error) {
+	if p.UUID != PlatformUUIDWebUSB {
+		return WebUSBCapability{}, fmt.Errorf("%s is not a WebUSB capability", p)
+	}
+	if len(p.Data) < webUSBCapabilityLength {
+		return WebUSBCapability{}, fmt.Errorf("WebUSB capability too short: % x", p.Data)
+	}
+	return WebUSBCapability{
+		Version:     BCD(binary.LittleEndian.Uint16(p.Data)),
+		VendorCode:  p.Data[2],
+		LandingPage: int(p.Data[3]),
+	}, nil
+}
+
+// WebUSBCapability returns the WebUSB platform capability from the BOS
+// descriptor of the device, see PlatformCapabilities. An error is returned
+// if the device doesn't support WebUSB.
+func (d *Device) WebUSBCapability() (WebUSBCapability, error) {
+	caps, err := d.PlatformCapabilities()
+	if err != nil {
+		return WebUSBCapability{}, err
+	}
+	for _, p := range caps {
+		if p.UUID == PlatformUUIDWebUSB {
+			c, err := ParseWebUSBCapability(p)
+			if err != nil {
+				return WebUSBCapability{}, fmt.Errorf("device %s: %v", d, err)
+			}
+			return c, nil
+		}
+	}
+	return WebUSBCapability{}, fmt.Errorf("device %s has no WebUSB platform capability", d)
+}
+
+// GetWebUSBURL reads the URL descriptor with the given index through the
+// WebUSB GET_URL request and returns the decoded URL. vendorCode is the
+// VendorCode of the WebUSB capability of the device.
+func (d *Device) GetWebUSBURL(vendorCode uint8, index int) (string, error) {
+	buf := make([]byte, webUSBURLMaxLength)
+	rType := ControlType(ControlKindVendor, ControlRecipientDevice, EndpointDirectionIn)
+	n, err := d.Control(rType, vendorCode, uint16(index), webUSBGetURL, buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the WebUSB URL descriptor %d of %s: %w", index, d, err)
+	}
+	url, err := parseWebUSBURL(buf[:n])
+	if err != nil {
+		return "", fmt.Errorf("device %s: %v", d, err)
+	}
+	return url, nil
+}
+
+// GetWebUSBLandingPage returns the URL of the WebUSB landing page of the
+// device, the page browsers suggest to visit when the device is connected.
+// The vendor code and the index of the URL descriptor are taken from the
+// WebUSB capability of the device, see WebUSBCapability.
+func (d *Device) GetWebUSBLandingPage() (string, error) {
+	c, err := d.WebUSBCapability()
+	if err != nil {
+		return "", err
+	}
+	if c.LandingPage == 0 {
+		return "", fmt.Errorf("device %s has no WebUSB landing page", d)
+	}
+	return d.GetWebUSBURL(c.VendorCode, c.LandingPage)
+}
+
+// parseWebUSBURL decodes a WebUSB URL descriptor: bLength, bDescriptorType,
+// bScheme and the UTF-8 encoded URL without the scheme prefix.
+func parseWebUSBURL(b []byte) (string, error) {
+	if len(b) < 3 || b[1] != webUSBURLDescriptorType {
+		return "", fmt.Errorf("not a WebUSB URL descriptor: % x", b)
+	}
+	l := int(b[0])
+	if l < 3 || l > len(b) {
+		return "", fmt.Errorf("WebUSB URL descriptor length %d out of range, got %d bytes", l, len(b))
+	}
+	prefix, ok := webUSBSchemes[b[2]]
+	if !ok {
+		return "", fmt.Errorf("WebUSB URL descriptor has an unknown scheme %d", b[2])
+	}
+	return prefix + string(b[3:l]), nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2013 Google Inc.  All rights reserved.
+// Copyright 2016 the gousb Authors.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gousb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseWebUSBURL(t *testing.T) {
+	for _, tc := range []struct {
+		desc    []byte
+		want    string
+		wantErr bool
+	}{
+		{desc: append([]byte{0x0e, 0x03, 0x01}, "example.com"...), want: "https://example.com"},
+		{desc: append([]byte{0x0a, 0x03, 0x00}, "a.b/c/d"...), want: "http://a.b/c/d"},
+		{desc: append([]byte{0x0e, 0x03, 0xff}, "ftp://x.org"...), want: "ftp://x.org"},
+		// trailing bytes past bLength are ignored.
+		{desc: append([]byte{0x06, 0x03, 0x01}, "a.bcdef"...), want: "https://a.b"},
+		{desc: append([]byte{0x06, 0x03, 0x02}, "a.b"...), wantErr: true},
+		{desc: append([]byte{0x06, 0x01, 0x01}, "a.b"...), wantErr: true},
+		{desc: append([]byte{0x10, 0x03, 0x01}, "a.b"...), wantErr: true},
+		{desc: []byte{0x03}, wantErr: true},
+	} {
+		got, err := parseWebUSBURL(tc.desc)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseWebUSBURL(% x): got error %v, want error: %t", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseWebUSBURL(% x): got %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestGetWebUSBLandingPage(t *testing.T) {
+	t.Parallel()
+	urlDesc := append([]byte{0x14, 0x03, 0x01}, "example.com/setup"...)
+	lib := &fakeControlLib{fakeLibusb: newFakeLibusb()}
+	lib.reply = func(req controlRequest, data []byte) (int, error) {
+		switch {
+		case req.rType == 0x80 && req.request == 0x06 && req.val == 0x0f00:
+			return copy(data, testBOS), nil
+		case req.rType == 0xc0 && req.request == 0x01 && req.val == 1 && req.idx == 2:
+			return copy(data, urlDesc), nil
+		}
+		return 0, ErrorPipe
+	}
+	c := newContextWithImpl(lib)
+	defer c.Close()
+	dev, err := c.OpenDeviceWithVIDPID(0x9999, 0x0001)
+	if err != nil {
+		t.Fatalf("OpenDeviceWithVIDPID(0x9999, 0x0001): %v", err)
+	}
+	defer dev.Close()
+
+	wc, err := dev.WebUSBCapability()
+	if err != nil {
+		t.Fatalf("%s.WebUSBCapability(): %v", dev, err)
+	}
+	if want := (WebUSBCapability{Version: 0x0100, VendorCode: 1, LandingPage: 1}); wc != want {
+		t.Errorf("%s.WebUSBCapability(): got %+v, want %+v", dev, wc, want)
+	}
+	got, err := dev.GetWebUSBLandingPage()
+	if err != nil {
+		t.Fatalf("%s.GetWebUSBLandingPage(): %v", dev, err)
+	}
+	if want := "https://example.com/setup"; got != want {
+		t.Errorf("%s.GetWebUSBLandingPage(): got %q, want %q", dev, got, want)
+	}
+	if _, err := dev.GetWebUSBURL(1, 2); !errors.Is(err, ErrorPipe) {
+		t.Errorf("%s.GetWebUSBURL(1, 2): got error %v, want %v", dev, err, ErrorPipe)
+	}
+}